@@ -230,6 +230,9 @@ const (
 	FrontendPersistenceNamespaceMaxQPS = "frontend.persistenceNamespaceMaxQPS"
 	// FrontendEnablePersistencePriorityRateLimiting indicates if priority rate limiting is enabled in frontend persistence client
 	FrontendEnablePersistencePriorityRateLimiting = "frontend.enablePersistencePriorityRateLimiting"
+	// FrontendEnablePersistencePriorityInheritance indicates if chained calls of the frontend persistence client inherit the
+	// priority of the first call of their operation, see persistence.WithPriorityInheritance
+	FrontendEnablePersistencePriorityInheritance = "frontend.enablePersistencePriorityInheritance"
 	// FrontendPersistenceDynamicRateLimitingParams is a map that contains all adjustable dynamic rate limiting params
 	// see DefaultDynamicRateLimitingParams for available options and defaults
 	FrontendPersistenceDynamicRateLimitingParams = "frontend.persistenceDynamicRateLimitingParams"
@@ -395,6 +398,9 @@ const (
 	MatchingPersistenceNamespaceMaxQPS = "matching.persistenceNamespaceMaxQPS"
 	// MatchingEnablePersistencePriorityRateLimiting indicates if priority rate limiting is enabled in matching persistence client
 	MatchingEnablePersistencePriorityRateLimiting = "matching.enablePersistencePriorityRateLimiting"
+	// MatchingEnablePersistencePriorityInheritance indicates if chained calls of the matching persistence client inherit the
+	// priority of the first call of their operation, see persistence.WithPriorityInheritance
+	MatchingEnablePersistencePriorityInheritance = "matching.enablePersistencePriorityInheritance"
 	// MatchingPersistenceDynamicRateLimitingParams is a map that contains all adjustable dynamic rate limiting params
 	// see DefaultDynamicRateLimitingParams for available options and defaults
 	MatchingPersistenceDynamicRateLimitingParams = "matching.persistenceDynamicRateLimitingParams"
@@ -467,6 +473,9 @@ const (
 	HistoryPersistencePerShardNamespaceMaxQPS = "history.persistencePerShardNamespaceMaxQPS"
 	// HistoryEnablePersistencePriorityRateLimiting indicates if priority rate limiting is enabled in history persistence client
 	HistoryEnablePersistencePriorityRateLimiting = "history.enablePersistencePriorityRateLimiting"
+	// HistoryEnablePersistencePriorityInheritance indicates if chained calls of the history persistence client inherit the
+	// priority of the first call of their operation, see persistence.WithPriorityInheritance
+	HistoryEnablePersistencePriorityInheritance = "history.enablePersistencePriorityInheritance"
 	// HistoryPersistenceDynamicRateLimitingParams is a map that contains all adjustable dynamic rate limiting params
 	// see DefaultDynamicRateLimitingParams for available options and defaults
 	HistoryPersistenceDynamicRateLimitingParams = "history.persistenceDynamicRateLimitingParams"
@@ -803,6 +812,9 @@ const (
 	WorkerPersistenceNamespaceMaxQPS = "worker.persistenceNamespaceMaxQPS"
 	// WorkerEnablePersistencePriorityRateLimiting indicates if priority rate limiting is enabled in worker persistence client
 	WorkerEnablePersistencePriorityRateLimiting = "worker.enablePersistencePriorityRateLimiting"
+	// WorkerEnablePersistencePriorityInheritance indicates if chained calls of the worker persistence client inherit the
+	// priority of the first call of their operation, see persistence.WithPriorityInheritance
+	WorkerEnablePersistencePriorityInheritance = "worker.enablePersistencePriorityInheritance"
 	// WorkerPersistenceDynamicRateLimitingParams is a map that contains all adjustable dynamic rate limiting params
 	// see DefaultDynamicRateLimitingParams for available options and defaults
 	WorkerPersistenceDynamicRateLimitingParams = "worker.persistenceDynamicRateLimitingParams"
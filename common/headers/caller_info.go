@@ -168,10 +168,15 @@ func setIncomingMD(
 func GetCallerInfo(
 	ctx context.Context,
 ) CallerInfo {
-	values := GetValues(ctx, callerNameHeaderName, callerTypeHeaderName, callOriginHeaderName)
+	// the headers are looked up one by one rather than through GetValues,
+	// so that contexts without caller information do not allocate
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return CallerInfo{}
+	}
 	return CallerInfo{
-		CallerName: values[0],
-		CallerType: values[1],
-		CallOrigin: values[2],
+		CallerName: getSingleHeaderValue(md, callerNameHeaderName),
+		CallerType: getSingleHeaderValue(md, callerTypeHeaderName),
+		CallOrigin: getSingleHeaderValue(md, callOriginHeaderName),
 	}
 }
@@ -1616,6 +1616,28 @@ var (
 	PersistenceLatency                     = NewTimerDef("persistence_latency")
 	PersistenceShardRPS                    = NewDimensionlessHistogramDef("persistence_shard_rps")
 	PersistenceErrResourceExhaustedCounter = NewCounterDef("persistence_errors_resource_exhausted")
+	PersistenceRateLimitRejections         = NewCounterDef("persistence_ratelimit_rejections")
+	PersistenceRateLimitRejectionsTotal    = NewCounterDef("persistence_ratelimit_rejections_total")
+	PersistenceRateLimitClassRejections    = NewCounterDef("persistence_ratelimit_class_rejections")
+	PersistenceRateLimitDeadlineExceeded   = NewCounterDef("persistence_ratelimit_deadline_exceeded")
+	PersistenceRateLimitSLORejections      = NewCounterDef("persistence_ratelimit_slo_rejections")
+	PersistenceRateLimiterErrors           = NewCounterDef("persistence_ratelimiter_errors")
+	PersistenceRateLimitWaitLatency        = NewTimerDef("persistence_ratelimit_wait_latency")
+	PersistenceRateLimitReplicationBypass  = NewCounterDef("persistence_ratelimit_replication_bypass")
+	PersistenceRateLimitForcedAdmissions   = NewCounterDef("persistence_ratelimit_forced_admissions")
+	PersistenceRateLimitTokensConsumed     = NewGaugeDef("persistence_ratelimit_tokens_consumed")
+	PersistenceRateLimitUtilization        = NewGaugeDef("persistence_ratelimit_utilization")
+	PersistenceDownstreamResourceExhausted = NewCounterDef("persistence_downstream_resource_exhausted")
+	PersistenceOperationSucceeded          = NewCounterDef("persistence_operation_succeeded")
+	PersistenceOperationRejected           = NewCounterDef("persistence_operation_rejected")
+	PersistenceOperationDownstreamFailed   = NewCounterDef("persistence_operation_downstream_failed")
+	PersistenceRequestTooLarge             = NewCounterDef("persistence_request_too_large")
+	PersistenceDownstreamLatency           = NewTimerDef("persistence_downstream_latency")
+	PersistenceDeduplicatedRequests        = NewCounterDef("persistence_deduplicated_requests")
+	PersistenceOperationTimeouts           = NewCounterDef("persistence_operation_timeouts")
+	PersistenceDegradedResponses           = NewCounterDef("persistence_degraded_responses")
+	PersistenceSpilloverRequests           = NewCounterDef("persistence_spillover_requests")
+	PersistenceRateLimitStarvation         = NewTimerDef("persistence_rate_limit_starvation")
 	VisibilityPersistenceRequests          = NewCounterDef("visibility_persistence_requests")
 	VisibilityPersistenceErrorWithType     = NewCounterDef("visibility_persistence_error_with_type")
 	VisibilityPersistenceFailures          = NewCounterDef("visibility_persistence_errors")
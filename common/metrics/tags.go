@@ -44,6 +44,7 @@ const (
 
 	instance       = "instance"
 	namespace      = "namespace"
+	namespaceID    = "namespace_id"
 	namespaceState = "namespace_state"
 	targetCluster  = "target_cluster"
 	fromCluster    = "from_cluster"
@@ -54,6 +55,7 @@ const (
 	commandType    = "commandType"
 	serviceName    = "service_name"
 	actionType     = "action_type"
+	store          = "store"
 	// Generic reason tag can be used anywhere a reason is needed.
 	reason = "reason"
 	// See server.api.enums.v1.ReplicationTaskType
@@ -111,6 +113,18 @@ func NamespaceUnknownTag() Tag {
 	return namespaceUnknownTag
 }
 
+// NamespaceIDTag returns a new namespace ID tag. If a blank namespace ID is provided then
+// this converts that to an unknown namespace ID.
+func NamespaceIDTag(value string) Tag {
+	if len(value) == 0 {
+		value = unknownValue
+	}
+	return &tagImpl{
+		key:   namespaceID,
+		value: value,
+	}
+}
+
 // NamespaceStateTag returns a new namespace state tag.
 func NamespaceStateTag(value string) Tag {
 	if len(value) == 0 {
@@ -288,6 +302,14 @@ func OperationTag(value string) Tag {
 	return &tagImpl{key: OperationTagName, value: value}
 }
 
+// StoreTag returns a new persistence store tag.
+func StoreTag(value string) Tag {
+	if len(value) == 0 {
+		value = unknownValue
+	}
+	return &tagImpl{key: store, value: value}
+}
+
 func StringTag(key string, value string) Tag {
 	return &tagImpl{key: key, value: value}
 }
@@ -28,6 +28,7 @@ import (
 	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/server/common"
 	"go.temporal.io/server/common/config"
+	"go.temporal.io/server/common/dynamicconfig"
 	"go.temporal.io/server/common/log"
 	"go.temporal.io/server/common/metrics"
 	p "go.temporal.io/server/common/persistence"
@@ -69,6 +70,8 @@ type (
 		clusterName      string
 		ratelimiter      quotas.RequestRateLimiter
 		healthSignals    p.HealthSignalAggregator
+
+		enablePriorityInheritance dynamicconfig.BoolPropertyFn
 	}
 )
 
@@ -78,7 +81,10 @@ type (
 // also contains config for individual datastores themselves.
 //
 // The objects returned by this factory enforce ratelimit and maxconns according to
-// given configuration. In addition, all objects will emit metrics automatically
+// given configuration. In addition, all objects will emit metrics automatically. Chained
+// calls made under a persistence.WithPriorityInheritanceScope context inherit the priority of
+// the first call of their operation only while enablePriorityInheritance is set, see
+// persistence.WithPriorityInheritance. It is checked on every request.
 func NewFactory(
 	dataStoreFactory DataStoreFactory,
 	cfg *config.Persistence,
@@ -88,6 +94,7 @@ func NewFactory(
 	metricsHandler metrics.Handler,
 	logger log.Logger,
 	healthSignals p.HealthSignalAggregator,
+	enablePriorityInheritance dynamicconfig.BoolPropertyFn,
 ) Factory {
 	factory := &factoryImpl{
 		dataStoreFactory: dataStoreFactory,
@@ -98,6 +105,8 @@ func NewFactory(
 		clusterName:      clusterName,
 		ratelimiter:      ratelimiter,
 		healthSignals:    healthSignals,
+
+		enablePriorityInheritance: enablePriorityInheritance,
 	}
 	factory.initDependencies()
 	return factory
@@ -112,7 +121,7 @@ func (f *factoryImpl) NewTaskManager() (p.TaskManager, error) {
 
 	result := p.NewTaskManager(taskStore, f.serializer)
 	if f.ratelimiter != nil {
-		result = p.NewTaskPersistenceRateLimitedClient(result, f.ratelimiter, f.logger)
+		result = p.NewTaskPersistenceRateLimitedClient(result, f.ratelimiter, f.logger, f.rateLimitedClientOptions()...)
 	}
 	if f.metricsHandler != nil && f.healthSignals != nil {
 		result = p.NewTaskPersistenceMetricsClient(result, f.metricsHandler, f.healthSignals, f.logger)
@@ -130,7 +139,7 @@ func (f *factoryImpl) NewShardManager() (p.ShardManager, error) {
 
 	result := p.NewShardManager(shardStore, f.serializer)
 	if f.ratelimiter != nil {
-		result = p.NewShardPersistenceRateLimitedClient(result, f.ratelimiter, f.logger)
+		result = p.NewShardPersistenceRateLimitedClient(result, f.ratelimiter, f.logger, f.rateLimitedClientOptions()...)
 	}
 	if f.metricsHandler != nil && f.healthSignals != nil {
 		result = p.NewShardPersistenceMetricsClient(result, f.metricsHandler, f.healthSignals, f.logger)
@@ -148,7 +157,7 @@ func (f *factoryImpl) NewMetadataManager() (p.MetadataManager, error) {
 
 	result := p.NewMetadataManagerImpl(store, f.serializer, f.logger, f.clusterName)
 	if f.ratelimiter != nil {
-		result = p.NewMetadataPersistenceRateLimitedClient(result, f.ratelimiter, f.logger)
+		result = p.NewMetadataPersistenceRateLimitedClient(result, f.ratelimiter, f.logger, f.rateLimitedClientOptions()...)
 	}
 	if f.metricsHandler != nil && f.healthSignals != nil {
 		result = p.NewMetadataPersistenceMetricsClient(result, f.metricsHandler, f.healthSignals, f.logger)
@@ -166,7 +175,7 @@ func (f *factoryImpl) NewClusterMetadataManager() (p.ClusterMetadataManager, err
 
 	result := p.NewClusterMetadataManagerImpl(store, f.serializer, f.clusterName, f.logger)
 	if f.ratelimiter != nil {
-		result = p.NewClusterMetadataPersistenceRateLimitedClient(result, f.ratelimiter, f.logger)
+		result = p.NewClusterMetadataPersistenceRateLimitedClient(result, f.ratelimiter, f.logger, f.rateLimitedClientOptions()...)
 	}
 	if f.metricsHandler != nil && f.healthSignals != nil {
 		result = p.NewClusterMetadataPersistenceMetricsClient(result, f.metricsHandler, f.healthSignals, f.logger)
@@ -184,7 +193,7 @@ func (f *factoryImpl) NewExecutionManager() (p.ExecutionManager, error) {
 
 	result := p.NewExecutionManager(store, f.serializer, f.logger, f.config.TransactionSizeLimit)
 	if f.ratelimiter != nil {
-		result = p.NewExecutionPersistenceRateLimitedClient(result, f.ratelimiter, f.logger)
+		result = p.NewExecutionPersistenceRateLimitedClient(result, f.ratelimiter, f.logger, f.rateLimitedClientOptions()...)
 	}
 	if f.metricsHandler != nil && f.healthSignals != nil {
 		result = p.NewExecutionPersistenceMetricsClient(result, f.metricsHandler, f.healthSignals, f.logger)
@@ -200,7 +209,7 @@ func (f *factoryImpl) NewNamespaceReplicationQueue() (p.NamespaceReplicationQueu
 	}
 
 	if f.ratelimiter != nil {
-		result = p.NewQueuePersistenceRateLimitedClient(result, f.ratelimiter, f.logger)
+		result = p.NewQueuePersistenceRateLimitedClient(result, f.ratelimiter, f.logger, f.rateLimitedClientOptions()...)
 	}
 	if f.metricsHandler != nil && f.healthSignals != nil {
 		result = p.NewQueuePersistenceMetricsClient(result, f.metricsHandler, f.healthSignals, f.logger)
@@ -239,3 +248,17 @@ func (f *factoryImpl) initDependencies() {
 	}
 	f.healthSignals.Start()
 }
+
+// rateLimitedClientOptions returns the options of the rate limited clients of the factory. The
+// clients emit their rejections to the metrics handler of the factory, if any, and have chained
+// calls inherit the priority of their operation only while enabled by dynamic config.
+func (f *factoryImpl) rateLimitedClientOptions() []p.RateLimitedClientOption {
+	var opts []p.RateLimitedClientOption
+	if f.enablePriorityInheritance != nil {
+		opts = append(opts, p.WithPriorityInheritance(f.enablePriorityInheritance, RequestPriorityFn))
+	}
+	if f.metricsHandler != nil {
+		opts = append(opts, p.WithMetricsHandler(f.metricsHandler))
+	}
+	return opts
+}
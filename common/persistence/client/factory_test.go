@@ -0,0 +1,139 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"go.temporal.io/server/common/dynamicconfig"
+	"go.temporal.io/server/common/log"
+	p "go.temporal.io/server/common/persistence"
+	"go.temporal.io/server/common/persistence/mock"
+	"go.temporal.io/server/common/persistence/serialization"
+	"go.temporal.io/server/common/quotas"
+)
+
+type (
+	factorySuite struct {
+		suite.Suite
+		*require.Assertions
+
+		controller  *gomock.Controller
+		shardStore  *mock.MockShardStore
+		rateLimiter *quotas.MockRequestRateLimiter
+	}
+
+	// shardStoreFactory is a DataStoreFactory which only vends a shard store
+	shardStoreFactory struct {
+		DataStoreFactory
+		shardStore p.ShardStore
+	}
+)
+
+func TestFactorySuite(t *testing.T) {
+	s := new(factorySuite)
+	suite.Run(t, s)
+}
+
+func (s *factorySuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+
+	s.controller = gomock.NewController(s.T())
+	s.shardStore = mock.NewMockShardStore(s.controller)
+	s.shardStore.EXPECT().GetName().Return("test").AnyTimes()
+	s.shardStore.EXPECT().AssertShardOwnership(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	s.rateLimiter = quotas.NewMockRequestRateLimiter(s.controller)
+}
+
+func (s *factorySuite) TearDownTest() {
+	s.controller.Finish()
+}
+
+func (s *factorySuite) TestPriorityInheritance_Disabled() {
+	s.Equal([]bool{false, false}, s.inheritsPriority(s.newShardManager(nil)))
+	s.Equal([]bool{false, false}, s.inheritsPriority(s.newShardManager(dynamicconfig.GetBoolPropertyFn(false))))
+}
+
+func (s *factorySuite) TestPriorityInheritance_Enabled() {
+	s.Equal([]bool{false, true}, s.inheritsPriority(s.newShardManager(dynamicconfig.GetBoolPropertyFn(true))))
+}
+
+func (s *factorySuite) TestPriorityInheritance_ToggledAtRuntime() {
+	var enabled atomic.Bool
+	shardManager := s.newShardManager(enabled.Load)
+
+	s.Equal([]bool{false, false}, s.inheritsPriority(shardManager))
+	enabled.Store(true)
+	s.Equal([]bool{false, true}, s.inheritsPriority(shardManager))
+	enabled.Store(false)
+	s.Equal([]bool{false, false}, s.inheritsPriority(shardManager))
+}
+
+func (s *factorySuite) newShardManager(enablePriorityInheritance dynamicconfig.BoolPropertyFn) p.ShardManager {
+	factory := NewFactory(
+		&shardStoreFactory{shardStore: s.shardStore},
+		nil,
+		s.rateLimiter,
+		serialization.NewSerializer(),
+		"test-cluster",
+		nil,
+		log.NewNoopLogger(),
+		nil,
+		enablePriorityInheritance,
+	)
+	shardManager, err := factory.NewShardManager()
+	s.NoError(err)
+	return shardManager
+}
+
+// inheritsPriority makes two calls under the same priority inheritance scope with the shard
+// manager, and returns whether the rate limiter was asked to admit each of them with an
+// inherited priority
+func (s *factorySuite) inheritsPriority(shardManager p.ShardManager) []bool {
+	var inherits []bool
+	s.rateLimiter.EXPECT().Allow(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ time.Time, request quotas.Request) bool {
+			inherits = append(inherits, request.InheritsPriority)
+			return true
+		},
+	).Times(2)
+
+	ctx := p.WithPriorityInheritanceScope(context.Background())
+	for i := 0; i < 2; i++ {
+		s.NoError(shardManager.AssertShardOwnership(ctx, &p.AssertShardOwnershipRequest{ShardID: 1}))
+	}
+	return inherits
+}
+
+func (f *shardStoreFactory) NewShardStore() (p.ShardStore, error) {
+	return f.shardStore, nil
+}
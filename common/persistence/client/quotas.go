@@ -210,6 +210,19 @@ func NewNoopPriorityRateLimiter(
 }
 
 func RequestPriorityFn(req quotas.Request) int {
+	priority := requestPriority(req)
+	if req.InheritsPriority && req.InheritedPriority < priority {
+		// the request is a follow-up of an operation of a higher priority
+		return req.InheritedPriority
+	}
+	return priority
+}
+
+func requestPriority(req quotas.Request) int {
+	if req.Boosted {
+		// the request claimed a token of the priority boost budget
+		return RequestPrioritiesOrdered[0]
+	}
 	switch req.CallerType {
 	case headers.CallerTypeAPI:
 		if priority, ok := APITypeCallOriginPriorityOverride[req.Initiation]; ok {
@@ -31,6 +31,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"go.temporal.io/server/common/headers"
 	"go.temporal.io/server/common/quotas"
 	"golang.org/x/exp/slices"
 
@@ -103,6 +104,41 @@ func (s *quotasSuite) TestCallOriginDefined() {
 	}
 }
 
+func (s *quotasSuite) TestRequestPriorityFn_Boosted() {
+	request := quotas.NewRequest(
+		"GetWorkflowExecution",
+		1,
+		"test-namespace",
+		headers.CallerTypePreemptable,
+		-1,
+		"",
+	)
+	s.Equal(CallerTypeDefaultPriority[headers.CallerTypePreemptable], RequestPriorityFn(request))
+
+	request.Boosted = true
+	s.Equal(RequestPrioritiesOrdered[0], RequestPriorityFn(request))
+}
+
+func (s *quotasSuite) TestRequestPriorityFn_Inherited() {
+	request := quotas.NewRequest(
+		"UpdateWorkflowExecution",
+		1,
+		"test-namespace",
+		headers.CallerTypeBackground,
+		-1,
+		"",
+	)
+	s.Equal(CallerTypeDefaultPriority[headers.CallerTypeBackground], RequestPriorityFn(request))
+
+	request.InheritsPriority = true
+	request.InheritedPriority = BackgroundTypeAPIPriorityOverride["GetOrCreateShard"]
+	s.Equal(BackgroundTypeAPIPriorityOverride["GetOrCreateShard"], RequestPriorityFn(request))
+
+	// follow-ups are never admitted with a lower priority than their own
+	request.InheritedPriority = CallerTypeDefaultPriority[headers.CallerTypePreemptable]
+	s.Equal(CallerTypeDefaultPriority[headers.CallerTypeBackground], RequestPriorityFn(request))
+}
+
 func (s *quotasSuite) TestPriorityNamespaceRateLimiter_DoesLimit() {
 	var namespaceMaxRPS = func(namespace string) int { return 1 }
 	var hostMaxRPS = func() int { return 1 }
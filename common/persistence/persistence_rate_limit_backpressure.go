@@ -0,0 +1,201 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// backpressureWindowBuckets is the number of buckets the rolling window is split into
+	backpressureWindowBuckets = 10
+	// backpressureSubscriberBufferSize is the number of events buffered for each subscriber,
+	// events are dropped for subscribers which fall further behind
+	backpressureSubscriberBufferSize = 16
+)
+
+type (
+	// BackpressureEvent is published when the rolling rejection rate of a rate limited
+	// persistence client crosses one of the configured thresholds
+	BackpressureEvent struct {
+		// RejectionRate is the fraction of requests rejected in the rolling window
+		RejectionRate float64
+		// Threshold is the threshold which was crossed
+		Threshold float64
+		// Rising is true if the rejection rate rose above the threshold, false if it fell below
+		Rising bool
+		Time   time.Time
+	}
+
+	// backpressureMonitor tracks the rolling rejection rate and publishes BackpressureEvents
+	// to its subscribers
+	backpressureMonitor struct {
+		window     time.Duration
+		debounce   time.Duration
+		thresholds []float64
+
+		sync.Mutex
+		buckets []backpressureBucket
+		// level is the number of thresholds the last published rejection rate was at or above
+		level         int
+		lastEventTime time.Time
+		subscribers   []chan BackpressureEvent
+		closed        bool
+	}
+
+	backpressureBucket struct {
+		index    int64
+		allowed  int64
+		rejected int64
+	}
+)
+
+func newBackpressureMonitor(
+	window time.Duration,
+	debounce time.Duration,
+	thresholds []float64,
+) *backpressureMonitor {
+	thresholds = append([]float64(nil), thresholds...)
+	sort.Float64s(thresholds)
+	return &backpressureMonitor{
+		window:     window,
+		debounce:   debounce,
+		thresholds: thresholds,
+		buckets:    make([]backpressureBucket, backpressureWindowBuckets),
+	}
+}
+
+// subscribe returns a channel receiving all events published from now on.
+// The channel is closed when the monitor is closed.
+func (m *backpressureMonitor) subscribe() <-chan BackpressureEvent {
+	m.Lock()
+	defer m.Unlock()
+
+	events := make(chan BackpressureEvent, backpressureSubscriberBufferSize)
+	if m.closed {
+		close(events)
+		return events
+	}
+	m.subscribers = append(m.subscribers, events)
+	return events
+}
+
+// record adds the rate limiting decision of a request to the rolling window, and
+// publishes an event if the rejection rate crossed a threshold
+func (m *backpressureMonitor) record(now time.Time, rejected bool) {
+	if len(m.thresholds) == 0 || m.window <= 0 {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.closed {
+		return
+	}
+	index := now.UnixNano() / m.bucketNanos()
+	bucket := &m.buckets[index%backpressureWindowBuckets]
+	if bucket.index != index {
+		*bucket = backpressureBucket{index: index}
+	}
+	if rejected {
+		bucket.rejected++
+	} else {
+		bucket.allowed++
+	}
+
+	rejectionRate := m.rejectionRate(index)
+	level := sort.Search(len(m.thresholds), func(i int) bool {
+		return m.thresholds[i] > rejectionRate
+	})
+	if level == m.level {
+		return
+	}
+	// while debounced, the level is left as it was, so a sustained change is published
+	// once the debounce period is over
+	if !m.lastEventTime.IsZero() && now.Sub(m.lastEventTime) < m.debounce {
+		return
+	}
+
+	event := BackpressureEvent{
+		RejectionRate: rejectionRate,
+		Rising:        level > m.level,
+		Time:          now,
+	}
+	if event.Rising {
+		event.Threshold = m.thresholds[level-1]
+	} else {
+		event.Threshold = m.thresholds[level]
+	}
+	m.level = level
+	m.lastEventTime = now
+	for _, subscriber := range m.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// close closes the channels of all subscribers
+func (m *backpressureMonitor) close() {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.closed {
+		return
+	}
+	m.closed = true
+	for _, subscriber := range m.subscribers {
+		close(subscriber)
+	}
+	m.subscribers = nil
+}
+
+func (m *backpressureMonitor) bucketNanos() int64 {
+	bucketNanos := m.window.Nanoseconds() / backpressureWindowBuckets
+	if bucketNanos <= 0 {
+		return 1
+	}
+	return bucketNanos
+}
+
+// rejectionRate returns the fraction of requests rejected in the buckets of the rolling
+// window ending with the bucket of the given index
+func (m *backpressureMonitor) rejectionRate(index int64) float64 {
+	var allowed, rejected int64
+	for _, bucket := range m.buckets {
+		if bucket.index > index-backpressureWindowBuckets {
+			allowed += bucket.allowed
+			rejected += bucket.rejected
+		}
+	}
+	if allowed+rejected == 0 {
+		return 0
+	}
+	return float64(rejected) / float64(allowed+rejected)
+}
@@ -0,0 +1,89 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackpressureMonitor_Debounce(t *testing.T) {
+	monitor := newBackpressureMonitor(10*time.Second, 5*time.Second, []float64{0.5})
+	events := monitor.subscribe()
+	now := time.Now()
+
+	monitor.record(now, false)
+	monitor.record(now, true)
+	require.Equal(t, BackpressureEvent{RejectionRate: 0.5, Threshold: 0.5, Rising: true, Time: now}, <-events)
+
+	// the rejection rate drops below the threshold within the debounce period
+	for i := 0; i < 2; i++ {
+		monitor.record(now.Add(time.Second), false)
+	}
+	require.Empty(t, events)
+
+	// the drop is sustained past the debounce period
+	now = now.Add(5 * time.Second)
+	monitor.record(now, false)
+	require.Equal(t, BackpressureEvent{RejectionRate: 0.2, Threshold: 0.5, Rising: false, Time: now}, <-events)
+	require.Empty(t, events)
+}
+
+func TestBackpressureMonitor_RollingWindow(t *testing.T) {
+	monitor := newBackpressureMonitor(10*time.Second, 0, []float64{0.5, 0.9})
+	events := monitor.subscribe()
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		monitor.record(now, true)
+	}
+	event := <-events
+	require.True(t, event.Rising)
+	require.Equal(t, 0.9, event.Threshold)
+
+	// the rejections fall out of the window
+	now = now.Add(11 * time.Second)
+	monitor.record(now, false)
+	event = <-events
+	require.False(t, event.Rising)
+	require.Equal(t, 0.5, event.Threshold)
+	require.Equal(t, float64(0), event.RejectionRate)
+}
+
+func TestBackpressureMonitor_Close(t *testing.T) {
+	monitor := newBackpressureMonitor(10*time.Second, 0, []float64{0.5})
+	events := monitor.subscribe()
+
+	monitor.close()
+	_, ok := <-events
+	require.False(t, ok)
+	_, ok = <-monitor.subscribe()
+	require.False(t, ok)
+
+	// recording after close must not panic on the closed channels
+	monitor.record(time.Now(), true)
+}
@@ -0,0 +1,158 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.temporal.io/api/serviceerror"
+
+	"go.temporal.io/server/common/quotas"
+)
+
+type (
+	// BatchReservation holds tokens reserved up front by ReserveBatch, which the
+	// requests of the batch draw down instead of being rate limited one by one
+	BatchReservation interface {
+		// Use draws one token of the reservation, returning a context which admits a single
+		// request of the reserved operation without charging the rate limiter again
+		Use(ctx context.Context) (context.Context, error)
+		// Remaining returns the number of tokens not drawn yet
+		Remaining() int
+		// Release returns the tokens not drawn yet to the rate limiter, after which
+		// Use fails with ErrBatchReservationExhausted
+		Release()
+	}
+
+	batchReservation struct {
+		enforcer *rateLimitEnforcer
+		api      string
+
+		sync.Mutex
+		// reservations holds one reservation per token not drawn yet, so that they
+		// can be canceled individually on release. It is empty if rate limiting was
+		// disabled when the batch was reserved, in which case remaining is counted alone.
+		reservations []quotas.Reservation
+		reservedAt   time.Time
+		remaining    int
+	}
+
+	// batchTicketContextKey marks a request context as admitted by a batch reservation
+	batchTicketContextKey struct{}
+
+	// batchTicket admits a single request of the operation of a batch reservation
+	batchTicket struct {
+		enforcer *rateLimitEnforcer
+		api      string
+		used     atomic.Bool
+	}
+)
+
+var _ BatchReservation = (*batchReservation)(nil)
+
+// ReserveBatch reserves n tokens of the rate limiter of the operation for a batch of
+// requests known up front. The reservation is all or nothing: it fails with
+// ErrPersistenceLimitExceeded rather than waiting if the tokens are not available
+// right away, so that batches never queue up ahead of online traffic.
+func (e *rateLimitEnforcer) ReserveBatch(operation string, n int) (BatchReservation, error) {
+	if n <= 0 {
+		return nil, serviceerror.NewInvalidArgument("Batch reservation must reserve at least one token.")
+	}
+	batch := &batchReservation{
+		enforcer:  e,
+		api:       operation,
+		remaining: n,
+	}
+	if !e.enabled.Load() {
+		return batch, nil
+	}
+
+	now := e.timeSource.Now()
+	rateLimiter := e.rateLimiterFor(operation)
+	request := newRateLimitRequest(context.Background(), operation, RateLimitDefaultToken, CallerSegmentMissing)
+	batch.reservations = make([]quotas.Reservation, 0, n)
+	batch.reservedAt = now
+	for i := 0; i < n; i++ {
+		reservation := rateLimiter.Reserve(now, request)
+		if !reservation.OK() || reservation.DelayFrom(now) > 0 {
+			reservation.CancelAt(now)
+			batch.cancel()
+			e.recordRejection(operation, e.rateLimiterNameFor(operation))
+			return nil, ErrPersistenceLimitExceeded
+		}
+		batch.reservations = append(batch.reservations, reservation)
+	}
+	return batch, nil
+}
+
+func (b *batchReservation) Use(ctx context.Context) (context.Context, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.remaining == 0 {
+		return ctx, ErrBatchReservationExhausted
+	}
+	b.remaining--
+	if len(b.reservations) > 0 {
+		// the drawn token stays consumed, only its reservation is forgotten
+		b.reservations = b.reservations[:len(b.reservations)-1]
+	}
+	return context.WithValue(ctx, batchTicketContextKey{}, &batchTicket{enforcer: b.enforcer, api: b.api}), nil
+}
+
+func (b *batchReservation) Remaining() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.remaining
+}
+
+func (b *batchReservation) Release() {
+	b.Lock()
+	defer b.Unlock()
+	b.cancel()
+	b.remaining = 0
+}
+
+// cancel gives back the tokens of the reservations not drawn yet, latest first, as a
+// reservation only gives back the tokens not claimed by later reservations
+func (b *batchReservation) cancel() {
+	for i := len(b.reservations) - 1; i >= 0; i-- {
+		b.reservations[i].CancelAt(b.reservedAt)
+	}
+	b.reservations = nil
+}
+
+// useBatchTicket reports whether the context carries an unused ticket of a batch
+// reservation of the enforcer for the operation, using it up
+func (e *rateLimitEnforcer) useBatchTicket(ctx context.Context, api string) bool {
+	ticket, ok := ctx.Value(batchTicketContextKey{}).(*batchTicket)
+	if !ok || ticket.enforcer != e || ticket.api != api {
+		return false
+	}
+	return ticket.used.CompareAndSwap(false, true)
+}
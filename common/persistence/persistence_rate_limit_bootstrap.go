@@ -0,0 +1,104 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// bootstrapOperations are the operations the server cannot start without, which the
+	// bootstrap safety valve exempts from rate limiting when they keep getting rejected
+	bootstrapOperations = map[string]struct{}{
+		"InitializeSystemNamespaces": {},
+		"GetMetadata":                {},
+	}
+)
+
+type (
+	// bootstrapValve exempts the bootstrap operations from rate limiting for a while if they
+	// are rejected maxRejections times in a row within the bootstrap window following the
+	// creation of the client, so that rate limiting cannot deadlock the startup of the server
+	bootstrapValve struct {
+		window        time.Duration
+		maxRejections int
+
+		sync.Mutex
+		bootstrapEnd time.Time
+		rejections   int
+		openUntil    time.Time
+	}
+)
+
+func newBootstrapValve(
+	window time.Duration,
+	maxRejections int,
+	now time.Time,
+) *bootstrapValve {
+	if window <= 0 || maxRejections <= 0 {
+		return nil
+	}
+	return &bootstrapValve{
+		window:        window,
+		maxRejections: maxRejections,
+		bootstrapEnd:  now.Add(window),
+	}
+}
+
+// isOpen reports whether the operation is exempt from rate limiting
+func (v *bootstrapValve) isOpen(now time.Time, api string) bool {
+	if _, ok := bootstrapOperations[api]; !ok {
+		return false
+	}
+	v.Lock()
+	defer v.Unlock()
+	return now.Before(v.openUntil)
+}
+
+// record accounts the rate limiting decision of a request of the operation, and reports
+// whether it made the valve open
+func (v *bootstrapValve) record(now time.Time, api string, rejected bool) bool {
+	if _, ok := bootstrapOperations[api]; !ok {
+		return false
+	}
+	v.Lock()
+	defer v.Unlock()
+
+	if !rejected {
+		v.rejections = 0
+		return false
+	}
+	if !now.Before(v.bootstrapEnd) {
+		return false
+	}
+	v.rejections++
+	if v.rejections < v.maxRejections {
+		return false
+	}
+	v.rejections = 0
+	v.openUntil = now.Add(v.window)
+	return true
+}
@@ -0,0 +1,106 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sync"
+	"time"
+
+	"go.temporal.io/server/common/clock"
+	"go.temporal.io/server/common/quotas"
+)
+
+type (
+	// namespaceBucketEvictor forgets the namespace rate limiters of a configProviderRateLimiter
+	// left idle for idleTTL in the background, so that they do not pile up with the namespaces
+	namespaceBucketEvictor struct {
+		rateLimiter *configProviderRateLimiter
+		idleTTL     time.Duration
+		timeSource  clock.TimeSource
+
+		stopOnce sync.Once
+		stop     chan struct{}
+		stopped  chan struct{}
+	}
+)
+
+// newNamespaceBucketEvictor starts evicting the idle namespace rate limiters of the rate limiter
+// every half idleTTL. It is nil unless the rate limiter follows a RateLimitConfigProvider.
+func newNamespaceBucketEvictor(
+	rateLimiter quotas.RequestRateLimiter,
+	idleTTL time.Duration,
+	timeSource clock.TimeSource,
+) *namespaceBucketEvictor {
+	configProvider, ok := rateLimiter.(*configProviderRateLimiter)
+	if !ok || idleTTL <= 0 {
+		return nil
+	}
+	evictor := &namespaceBucketEvictor{
+		rateLimiter: configProvider,
+		idleTTL:     idleTTL,
+		timeSource:  timeSource,
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go evictor.run(idleTTL / 2)
+	return evictor
+}
+
+func (e *namespaceBucketEvictor) run(interval time.Duration) {
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.evict()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// evict forgets the namespace rate limiters idle for idleTTL as of now
+func (e *namespaceBucketEvictor) evict() int {
+	return e.rateLimiter.evictIdleNamespaces(e.timeSource.Now(), e.idleTTL)
+}
+
+// close stops the eviction and waits for it to return
+func (e *namespaceBucketEvictor) close() {
+	e.stopOnce.Do(func() { close(e.stop) })
+	<-e.stopped
+}
+
+// ActiveNamespaceBucketCount returns the number of namespaces holding a rate limiter of their own
+// in the client, zero unless it follows a RateLimitConfigProvider. Namespaces only limited by the
+// global rate limit are not counted.
+func (e *rateLimitEnforcer) ActiveNamespaceBucketCount() int {
+	configProvider, ok := e.rateLimiter.(*configProviderRateLimiter)
+	if !ok {
+		return 0
+	}
+	return configProvider.namespaceBucketCount()
+}
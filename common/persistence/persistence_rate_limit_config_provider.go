@@ -0,0 +1,339 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.temporal.io/server/common/quotas"
+)
+
+type (
+	// RateLimitConfigProvider supplies the limits of rate limited persistence clients configured
+	// WithRateLimitConfigProvider, decoupling them from any specific dynamic config system
+	RateLimitConfigProvider interface {
+		// GlobalRPS returns the rate limit of all requests of the client
+		GlobalRPS() float64
+		// NamespaceRPS returns the rate limit of the requests of the namespace, on top of the
+		// global one, or a non-positive value if the namespace is only globally limited
+		NamespaceRPS(namespace string) float64
+	}
+
+	// NamespaceWeightProvider supplies the weights of namespaces, e.g. their active execution counts,
+	// by which the global rate limit is shared among them when set WithNamespaceWeightProvider
+	NamespaceWeightProvider interface {
+		// NamespaceWeights returns the weight of every weighted namespace, namespaces left out
+		// or with a non-positive weight are limited by the RateLimitConfigProvider instead
+		NamespaceWeights() map[string]float64
+	}
+
+	// StaticRateLimitConfigProvider is a RateLimitConfigProvider of limits set in code, e.g. for tests
+	StaticRateLimitConfigProvider struct {
+		sync.RWMutex
+		globalRPS    float64
+		namespaceRPS map[string]float64
+	}
+
+	// configProviderRateLimiter is a rate limiter following the limits of a RateLimitConfigProvider,
+	// which it polls at most once per refresh interval. Requests are keyed by namespace (caller).
+	configProviderRateLimiter struct {
+		provider        RateLimitConfigProvider
+		refreshInterval time.Duration
+		// weightProvider is nil unless the namespaces share the global rate limit by weight
+		weightProvider NamespaceWeightProvider
+
+		sync.RWMutex
+		lastRefresh time.Time
+		global      *quotas.RateLimiterImpl
+		// namespaces are the namespaces limited on top of the global rate limiter
+		namespaces map[string]*namespaceBucket
+		// globalOnly are the namespaces found to be only globally limited since the last refresh
+		globalOnly map[string]struct{}
+		// weightedRPS is the share of the global rate limit of each weighted namespace
+		weightedRPS map[string]float64
+	}
+
+	// namespaceBucket is the rate limiter of a namespace limited on top of the global one
+	namespaceBucket struct {
+		rateLimiter *quotas.RateLimiterImpl
+		// combined limits the requests of the namespace by both its rate limiter and the global one,
+		// it follows the limits of both as they are updated in place on refresh
+		combined quotas.RateLimiter
+		// usedAt holds the unix nanoseconds of the last request of the namespace
+		usedAt atomic.Int64
+	}
+)
+
+var _ RateLimitConfigProvider = (*StaticRateLimitConfigProvider)(nil)
+var _ quotas.RequestRateLimiter = (*configProviderRateLimiter)(nil)
+
+// NewStaticRateLimitConfigProvider creates a RateLimitConfigProvider of the given limits
+func NewStaticRateLimitConfigProvider(globalRPS float64, namespaceRPS map[string]float64) *StaticRateLimitConfigProvider {
+	provider := &StaticRateLimitConfigProvider{
+		globalRPS:    globalRPS,
+		namespaceRPS: make(map[string]float64, len(namespaceRPS)),
+	}
+	for namespace, rps := range namespaceRPS {
+		provider.namespaceRPS[namespace] = rps
+	}
+	return provider
+}
+
+func (p *StaticRateLimitConfigProvider) GlobalRPS() float64 {
+	p.RLock()
+	defer p.RUnlock()
+	return p.globalRPS
+}
+
+func (p *StaticRateLimitConfigProvider) NamespaceRPS(namespace string) float64 {
+	p.RLock()
+	defer p.RUnlock()
+	return p.namespaceRPS[namespace]
+}
+
+// SetGlobalRPS changes the global rate limit
+func (p *StaticRateLimitConfigProvider) SetGlobalRPS(rps float64) {
+	p.Lock()
+	defer p.Unlock()
+	p.globalRPS = rps
+}
+
+// SetNamespaceRPS changes the rate limit of the namespace
+func (p *StaticRateLimitConfigProvider) SetNamespaceRPS(namespace string, rps float64) {
+	p.Lock()
+	defer p.Unlock()
+	p.namespaceRPS[namespace] = rps
+}
+
+func newConfigProviderRateLimiter(
+	provider RateLimitConfigProvider,
+	refreshInterval time.Duration,
+	weightProvider NamespaceWeightProvider,
+	now time.Time,
+) *configProviderRateLimiter {
+	rps := provider.GlobalRPS()
+	return &configProviderRateLimiter{
+		provider:        provider,
+		refreshInterval: refreshInterval,
+		weightProvider:  weightProvider,
+		lastRefresh:     now,
+		global:          quotas.NewRateLimiter(rps, configProviderBurst(rps)),
+		namespaces:      make(map[string]*namespaceBucket),
+		globalOnly:      make(map[string]struct{}),
+		weightedRPS:     weightedRPS(weightProvider, rps),
+	}
+}
+
+// weightedRPS shares the global rate limit among the weighted namespaces proportionally to their weights
+func weightedRPS(weightProvider NamespaceWeightProvider, globalRPS float64) map[string]float64 {
+	if weightProvider == nil {
+		return nil
+	}
+	weights := weightProvider.NamespaceWeights()
+	totalWeight := 0.0
+	for _, weight := range weights {
+		if weight > 0 {
+			totalWeight += weight
+		}
+	}
+	shares := make(map[string]float64, len(weights))
+	for namespace, weight := range weights {
+		if weight > 0 {
+			shares[namespace] = globalRPS * weight / totalWeight
+		}
+	}
+	return shares
+}
+
+func (r *configProviderRateLimiter) Allow(now time.Time, request quotas.Request) bool {
+	return r.rateLimiter(now, request.Caller).AllowN(now, request.Token)
+}
+
+func (r *configProviderRateLimiter) Reserve(now time.Time, request quotas.Request) quotas.Reservation {
+	return r.rateLimiter(now, request.Caller).ReserveN(now, request.Token)
+}
+
+func (r *configProviderRateLimiter) Wait(ctx context.Context, request quotas.Request) error {
+	return r.rateLimiter(time.Now(), request.Caller).WaitN(ctx, request.Token)
+}
+
+// rateLimiter returns the rate limiter of the requests of the namespace, refreshing
+// the limits from the provider first if the refresh interval is over
+func (r *configProviderRateLimiter) rateLimiter(now time.Time, namespace string) quotas.RateLimiter {
+	r.maybeRefresh(now)
+
+	r.RLock()
+	bucket, ok := r.namespaces[namespace]
+	_, globalOnly := r.globalOnly[namespace]
+	r.RUnlock()
+	switch {
+	case ok:
+		bucket.usedAt.Store(now.UnixNano())
+	case globalOnly:
+		return r.global
+	default:
+		bucket = r.namespaceBucket(now, namespace)
+	}
+	if bucket == nil {
+		return r.global
+	}
+	return bucket.combined
+}
+
+// deniedBy tells whether the namespace or the global rate limiter denied a request of the
+// given tokens, along with its rate. The namespace one is blamed if it lacks the tokens.
+func (r *configProviderRateLimiter) deniedBy(now time.Time, namespace string, token int) (string, float64) {
+	r.RLock()
+	bucket := r.namespaces[namespace]
+	r.RUnlock()
+	if bucket != nil && bucket.rateLimiter.TokensAt(now) < float64(token) {
+		return RateLimitTierNamespace, bucket.rateLimiter.Rate()
+	}
+	return RateLimitTierGlobal, r.global.Rate()
+}
+
+// warmNamespace creates the rate limiter of the namespace ahead of its first request
+func (r *configProviderRateLimiter) warmNamespace(now time.Time, namespace string) {
+	r.maybeRefresh(now)
+	r.namespaceBucket(now, namespace)
+}
+
+// namespaceBucket creates the rate limiter of the namespace, which is nil if the
+// namespace is only globally limited. It starts at its full burst, like all rate
+// limiters created on the first request of a namespace.
+func (r *configProviderRateLimiter) namespaceBucket(now time.Time, namespace string) *namespaceBucket {
+	r.Lock()
+	defer r.Unlock()
+
+	if bucket, ok := r.namespaces[namespace]; ok {
+		bucket.usedAt.Store(now.UnixNano())
+		return bucket
+	}
+	rps := r.namespaceRPSLocked(namespace)
+	if rps <= 0 {
+		r.globalOnly[namespace] = struct{}{}
+		return nil
+	}
+	bucket := r.newNamespaceBucketLocked(rps)
+	bucket.usedAt.Store(now.UnixNano())
+	r.namespaces[namespace] = bucket
+	return bucket
+}
+
+func (r *configProviderRateLimiter) newNamespaceBucketLocked(rps float64) *namespaceBucket {
+	rateLimiter := quotas.NewRateLimiter(rps, configProviderBurst(rps))
+	return &namespaceBucket{
+		rateLimiter: rateLimiter,
+		combined:    quotas.NewMultiRateLimiter([]quotas.RateLimiter{rateLimiter, r.global}),
+	}
+}
+
+// namespaceBucketCount returns the number of namespaces holding a rate limiter of their own
+func (r *configProviderRateLimiter) namespaceBucketCount() int {
+	r.RLock()
+	defer r.RUnlock()
+	return len(r.namespaces)
+}
+
+// evictIdleNamespaces forgets the namespaces without requests for at least idleTTL, returning
+// how many were forgotten. The next request of an evicted namespace starts a new rate limiter
+// at its full burst, as its first one did.
+func (r *configProviderRateLimiter) evictIdleNamespaces(now time.Time, idleTTL time.Duration) int {
+	r.Lock()
+	defer r.Unlock()
+
+	evicted := 0
+	for namespace, bucket := range r.namespaces {
+		if now.Sub(time.Unix(0, bucket.usedAt.Load())) < idleTTL {
+			continue
+		}
+		delete(r.namespaces, namespace)
+		evicted++
+	}
+	return evicted
+}
+
+// namespaceRates returns the rates of the namespaces limited on top of the global rate
+// limiter, among the namespaces which made requests so far
+func (r *configProviderRateLimiter) namespaceRates() map[string]float64 {
+	r.RLock()
+	defer r.RUnlock()
+
+	rates := make(map[string]float64, len(r.namespaces))
+	for namespace, bucket := range r.namespaces {
+		rates[namespace] = bucket.rateLimiter.Rate()
+	}
+	return rates
+}
+
+func (r *configProviderRateLimiter) maybeRefresh(now time.Time) {
+	r.RLock()
+	due := now.Sub(r.lastRefresh) >= r.refreshInterval
+	r.RUnlock()
+	if !due {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if now.Sub(r.lastRefresh) < r.refreshInterval {
+		return
+	}
+	r.lastRefresh = now
+	rps := r.provider.GlobalRPS()
+	r.global.SetRateBurstAt(now, rps, configProviderBurst(rps))
+	r.weightedRPS = weightedRPS(r.weightProvider, rps)
+	for namespace, bucket := range r.namespaces {
+		rps := r.namespaceRPSLocked(namespace)
+		if rps <= 0 {
+			delete(r.namespaces, namespace)
+			continue
+		}
+		bucket.rateLimiter.SetRateBurstAt(now, rps, configProviderBurst(rps))
+	}
+	// namespaces which became limited since get their rate limiter on their next request
+	r.globalOnly = make(map[string]struct{})
+}
+
+// namespaceRPSLocked returns the rate limit of the requests of the namespace, which is its share
+// of the global rate limit if it is weighted, or the one of the provider otherwise
+func (r *configProviderRateLimiter) namespaceRPSLocked(namespace string) float64 {
+	if rps, ok := r.weightedRPS[namespace]; ok {
+		return rps
+	}
+	return r.provider.NamespaceRPS(namespace)
+}
+
+// configProviderBurst returns the burst of a rate limiter of the given rate, one second of it
+func configProviderBurst(rps float64) int {
+	if rps <= 0 {
+		return 0
+	}
+	return int(math.Max(1, math.Ceil(rps)))
+}
@@ -0,0 +1,129 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// workflowCreateSerializer serializes the concurrent CreateWorkflowExecution requests of the same
+	// workflow, which would otherwise conflict in persistence and be retried by their callers. The
+	// requests of the same run, i.e. retries of the same create, observe the result of the first one
+	// to reach persistence rather than creating the run again.
+	workflowCreateSerializer struct {
+		sync.Mutex
+		workflows map[workflowCreateKey]*workflowCreateFlight
+	}
+
+	workflowCreateKey struct {
+		namespaceID string
+		workflowID  string
+	}
+
+	// workflowCreateFlight tracks the concurrent creates of a workflow, it is forgotten
+	// once none of them is in flight anymore
+	workflowCreateFlight struct {
+		// lock is held by the request currently creating the workflow
+		lock chan struct{}
+		// refs counts the requests holding or waiting for the lock, guarded by the serializer
+		refs int
+
+		// the result of the last request which reached persistence, guarded by lock
+		reached  bool
+		runID    string
+		response *CreateWorkflowExecutionResponse
+		err      error
+	}
+
+	// workflowCreateFn creates the workflow, reporting whether the request reached persistence
+	workflowCreateFn func(ctx context.Context, request *CreateWorkflowExecutionRequest) (*CreateWorkflowExecutionResponse, bool, error)
+)
+
+func newWorkflowCreateSerializer(enabled bool) *workflowCreateSerializer {
+	if !enabled {
+		return nil
+	}
+	return &workflowCreateSerializer{
+		workflows: make(map[workflowCreateKey]*workflowCreateFlight),
+	}
+}
+
+// create makes the request with createFn once the concurrent creates of the same workflow are
+// done, or returns the result of one of them if it was of the same run and reached persistence.
+// The request fails with the error of the context if it is done while waiting for its turn.
+func (s *workflowCreateSerializer) create(
+	ctx context.Context,
+	request *CreateWorkflowExecutionRequest,
+	createFn workflowCreateFn,
+) (*CreateWorkflowExecutionResponse, error) {
+	key := workflowCreateKey{
+		namespaceID: request.NewWorkflowSnapshot.ExecutionInfo.GetNamespaceId(),
+		workflowID:  request.NewWorkflowSnapshot.ExecutionInfo.GetWorkflowId(),
+	}
+	runID := request.NewWorkflowSnapshot.ExecutionState.GetRunId()
+	flight := s.acquire(key)
+	defer s.release(key, flight)
+
+	select {
+	case flight.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-flight.lock }()
+
+	if flight.reached && flight.runID == runID {
+		return flight.response, flight.err
+	}
+	response, reached, err := createFn(ctx, request)
+	if reached {
+		flight.reached, flight.runID, flight.response, flight.err = true, runID, response, err
+	}
+	return response, err
+}
+
+func (s *workflowCreateSerializer) acquire(key workflowCreateKey) *workflowCreateFlight {
+	s.Lock()
+	defer s.Unlock()
+
+	flight, ok := s.workflows[key]
+	if !ok {
+		flight = &workflowCreateFlight{lock: make(chan struct{}, 1)}
+		s.workflows[key] = flight
+	}
+	flight.refs++
+	return flight
+}
+
+func (s *workflowCreateSerializer) release(key workflowCreateKey, flight *workflowCreateFlight) {
+	s.Lock()
+	defer s.Unlock()
+
+	flight.refs--
+	if flight.refs == 0 {
+		delete(s.workflows, key)
+	}
+}
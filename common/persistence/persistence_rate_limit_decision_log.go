@@ -0,0 +1,83 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// AdmissionDecision is a rate limiting decision made by a rate limited persistence client
+	AdmissionDecision struct {
+		Time        time.Time
+		Operation   string
+		NamespaceID string
+		Allowed     bool
+		Tokens      int
+		// SpilledOver decisions admit reads rejected by the client to its spillover,
+		// see WithSpilloverRateLimiter
+		SpilledOver bool
+	}
+
+	// decisionLog is a ring buffer of the most recent admission decisions. Writers claim a
+	// slot with an atomic increment and publish the decision with an atomic store, so
+	// recording a decision never takes a lock.
+	decisionLog struct {
+		next  atomic.Uint64
+		slots []atomic.Pointer[AdmissionDecision]
+	}
+)
+
+func newDecisionLog(size int) *decisionLog {
+	if size <= 0 {
+		return nil
+	}
+	return &decisionLog{
+		slots: make([]atomic.Pointer[AdmissionDecision], size),
+	}
+}
+
+func (l *decisionLog) record(decision AdmissionDecision) {
+	slot := (l.next.Add(1) - 1) % uint64(len(l.slots))
+	l.slots[slot].Store(&decision)
+}
+
+// recent returns the decisions in the buffer, oldest first. Decisions recorded concurrently
+// are ordered by the slot they claimed, and the ones still being written may be missing.
+func (l *decisionLog) recent() []AdmissionDecision {
+	end := l.next.Load()
+	start := uint64(0)
+	if size := uint64(len(l.slots)); end > size {
+		start = end - size
+	}
+	decisions := make([]AdmissionDecision, 0, end-start)
+	for i := start; i < end; i++ {
+		if decision := l.slots[i%uint64(len(l.slots))].Load(); decision != nil {
+			decisions = append(decisions, *decision)
+		}
+	}
+	return decisions
+}
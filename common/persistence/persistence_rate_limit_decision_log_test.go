@@ -0,0 +1,73 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecisionLog_Ordering(t *testing.T) {
+	log := newDecisionLog(3)
+
+	require.Empty(t, log.recent())
+	log.record(AdmissionDecision{Operation: "GetWorkflowExecution", Allowed: true, Tokens: 1})
+	log.record(AdmissionDecision{Operation: "UpdateWorkflowExecution", Allowed: false, Tokens: 2})
+	require.Equal(t, []AdmissionDecision{
+		{Operation: "GetWorkflowExecution", Allowed: true, Tokens: 1},
+		{Operation: "UpdateWorkflowExecution", Allowed: false, Tokens: 2},
+	}, log.recent())
+}
+
+func TestDecisionLog_WrapAround(t *testing.T) {
+	log := newDecisionLog(3)
+
+	for tokens := 1; tokens <= 7; tokens++ {
+		log.record(AdmissionDecision{Tokens: tokens})
+	}
+	// only the last decisions are kept, oldest first
+	require.Equal(t, []AdmissionDecision{{Tokens: 5}, {Tokens: 6}, {Tokens: 7}}, log.recent())
+}
+
+func TestDecisionLog_ConcurrentWrites(t *testing.T) {
+	log := newDecisionLog(10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.record(AdmissionDecision{Allowed: true})
+		}()
+	}
+	wg.Wait()
+	require.Len(t, log.recent(), 10)
+}
+
+func TestDecisionLog_Disabled(t *testing.T) {
+	require.Nil(t, newDecisionLog(0))
+}
@@ -0,0 +1,66 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+
+	"go.temporal.io/server/common/metrics"
+)
+
+// admitPage is admit for a page of a paginated read of the given page token. If the operation
+// is set WithDegradedPages, a rejected page which is not the first one of its scan is degraded:
+// the returned bool is set and the rejection error is dropped, for the caller to return an empty
+// page continuing at the rejected one. The rejection is metered all the same.
+func (e *rateLimitEnforcer) admitPage(
+	ctx context.Context,
+	api string,
+	request any,
+	shardID int32,
+	pageToken []byte,
+) (context.Context, rateLimitAdmission, bool, error) {
+	ctx, admission, err := e.admit(ctx, api, request, shardID, namespaceIDMissing)
+	if err == nil || len(pageToken) == 0 || !e.isRejection(api, err) {
+		// an empty page without a token would end the scan, so the first page is never degraded
+		return ctx, admission, false, err
+	}
+	if _, ok := e.options.degradedPageOperations[api]; !ok {
+		return ctx, admission, false, err
+	}
+	e.metricsHandler.Counter(metrics.PersistenceDegradedResponses.GetMetricName()).Record(
+		1,
+		metrics.OperationTag(api),
+		metrics.StoreTag(e.storeName()),
+	)
+	return ctx, admission, true, nil
+}
+
+// isRejection tells whether the error is the one the requests of the operation are rejected with
+func (e *rateLimitEnforcer) isRejection(api string, err error) bool {
+	if _, ok := RejectionDetailsFromError(err); ok {
+		return true
+	}
+	return err == e.rejections.operation(api).err
+}
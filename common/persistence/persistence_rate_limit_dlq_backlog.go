@@ -0,0 +1,150 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.temporal.io/server/common/log"
+	"go.temporal.io/server/common/log/tag"
+)
+
+type (
+	// DLQBacklogSource reports the backlog of a replication DLQ, e.g. the messages enqueued
+	// past the lowest of its GetDLQAckLevels, see WithDLQBacklogFeedback
+	DLQBacklogSource interface {
+		DLQBacklog(ctx context.Context) (int64, error)
+	}
+
+	// DLQBacklogThreshold tightens the write limiter of a rate limited persistence client once
+	// the DLQ backlog reaches Backlog, by having write requests cost WriteTokenFactor times their
+	// tokens, see WithDLQBacklogFeedback
+	DLQBacklogThreshold struct {
+		Backlog          int64
+		WriteTokenFactor float64
+	}
+
+	// dlqBacklogFeedback polls the backlog of a DLQ in the background, and scales the tokens
+	// of write requests by the factor of the highest threshold the backlog reached
+	dlqBacklogFeedback struct {
+		source     DLQBacklogSource
+		thresholds []DLQBacklogThreshold
+		logger     log.Logger
+		// level is the index in thresholds of the highest threshold reached, -1 if none is
+		level atomic.Int32
+
+		stopOnce sync.Once
+		stop     chan struct{}
+		stopped  chan struct{}
+	}
+)
+
+func newDLQBacklogFeedback(
+	source DLQBacklogSource,
+	pollInterval time.Duration,
+	thresholds []DLQBacklogThreshold,
+	logger log.Logger,
+) *dlqBacklogFeedback {
+	if source == nil || pollInterval <= 0 || len(thresholds) == 0 {
+		return nil
+	}
+	feedback := &dlqBacklogFeedback{
+		source:     source,
+		thresholds: make([]DLQBacklogThreshold, len(thresholds)),
+		logger:     logger,
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	copy(feedback.thresholds, thresholds)
+	sort.Slice(feedback.thresholds, func(i, j int) bool {
+		return feedback.thresholds[i].Backlog < feedback.thresholds[j].Backlog
+	})
+	feedback.level.Store(-1)
+	go feedback.run(pollInterval)
+	return feedback
+}
+
+func (f *dlqBacklogFeedback) run(pollInterval time.Duration) {
+	defer close(f.stopped)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), pollInterval)
+			f.poll(ctx)
+			cancel()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// poll updates the threshold reached from the backlog of the source. The threshold is kept
+// if the backlog cannot be read, so that a failing DLQ does not loosen the write limiter.
+func (f *dlqBacklogFeedback) poll(ctx context.Context) {
+	backlog, err := f.source.DLQBacklog(ctx)
+	if err != nil {
+		f.logger.Warn("Unable to read the DLQ backlog, keeping the write rate limit.", tag.Error(err))
+		return
+	}
+	level := int32(-1)
+	for i, threshold := range f.thresholds {
+		if backlog >= threshold.Backlog {
+			level = int32(i)
+		}
+	}
+	if previous := f.level.Swap(level); previous != level {
+		factor := 1.0
+		if level >= 0 {
+			factor = f.thresholds[level].WriteTokenFactor
+		}
+		f.logger.Info("DLQ backlog changed the cost of persistence writes.",
+			tag.NewInt64("dlq-backlog", backlog),
+			tag.NewFloat64("write-token-factor", factor),
+		)
+	}
+}
+
+// writeTokens returns the tokens a write request of the given tokens costs at the current backlog
+func (f *dlqBacklogFeedback) writeTokens(token int) int {
+	level := f.level.Load()
+	if level < 0 {
+		return token
+	}
+	return int(math.Ceil(float64(token) * f.thresholds[level].WriteTokenFactor))
+}
+
+// close stops the polling and waits for it to return
+func (f *dlqBacklogFeedback) close() {
+	f.stopOnce.Do(func() { close(f.stop) })
+	<-f.stopped
+}
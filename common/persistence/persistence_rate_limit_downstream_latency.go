@@ -0,0 +1,52 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"go.temporal.io/server/common/metrics"
+)
+
+const (
+	// admissionTagName tags the downstream latency by how the request was admitted
+	admissionTagName = "admission"
+
+	admissionImmediate = "immediate"
+	admissionWaited    = "waited"
+)
+
+// recordDownstreamLatency emits the latency of the persistence call of the admitted request,
+// partitioned by whether it waited for a token, see WithDownstreamLatencyByAdmission
+func (e *rateLimitEnforcer) recordDownstreamLatency(admission rateLimitAdmission) {
+	partition := admissionImmediate
+	if admission.waited {
+		partition = admissionWaited
+	}
+	e.metricsHandler.Timer(metrics.PersistenceDownstreamLatency.GetMetricName()).Record(
+		e.timeSource.Now().Sub(admission.admittedAt),
+		metrics.OperationTag(admission.api),
+		metrics.StoreTag(e.storeName()),
+		metrics.StringTag(admissionTagName, partition),
+	)
+}
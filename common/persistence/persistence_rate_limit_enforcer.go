@@ -0,0 +1,1367 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+
+	"go.temporal.io/server/common/clock"
+	"go.temporal.io/server/common/headers"
+	"go.temporal.io/server/common/log"
+	"go.temporal.io/server/common/log/tag"
+	"go.temporal.io/server/common/metrics"
+	"go.temporal.io/server/common/quotas"
+)
+
+const (
+	// availabilityImpactingTagName tags rejections by whether they count against the availability SLO
+	availabilityImpactingTagName = "availability_impacting"
+	// rateLimiterTagName tags utilization metrics by the name of the rate limiter
+	rateLimiterTagName = "rate_limiter"
+	// operationClassTagName tags metrics aggregated by the class of the operation, see operationClass
+	operationClassTagName = "operation_class"
+
+	spanAttributeRateLimited     = "persistence.ratelimited"
+	spanAttributeTokensRemaining = "persistence.tokens_remaining"
+)
+
+type (
+	// rateLimitEnforcer implements the rate limiting shared by all rate limited persistence clients
+	rateLimitEnforcer struct {
+		rateLimiter quotas.RequestRateLimiter
+		// storeName identifies the store in metrics and logs
+		storeName      func() string
+		metricsHandler metrics.Handler
+		timeSource     clock.TimeSource
+		logger         log.Logger
+		options        rateLimitedClientOptions
+		enabled        atomic.Bool
+		// waiters counts the requests currently blocked waiting for a token
+		waiters atomic.Int64
+		// inFlight counts the admitted requests which did not complete yet, if concurrency aware
+		inFlight atomic.Int64
+		// lowRateWarnedAt is the time of the last low rate warning in unix nanos, zero if none
+		lowRateWarnedAt atomic.Int64
+		// pausedNamespaces maps namespace ID to the time.Time its pause expires
+		pausedNamespaces sync.Map
+		// namespaceRejections maps namespace ID to the *atomic.Int64 unix nanoseconds of its last rejected request
+		namespaceRejections sync.Map
+		// rejections holds the errors and metric tags of rejected requests
+		rejections *rejectionCache
+		// outcomes counts the requests of each operation by outcome
+		outcomes *operationOutcomes
+		// disabledOperations holds the operations turned off with SetOperationEnabled
+		disabledOperations sync.Map
+		// draining holds back the operations writing to persistence, see SetDrainMode
+		draining atomic.Bool
+		// namespaceLimits are the rate limiters of the namespaces, nil unless set with SetNamespaceLimits
+		namespaceLimits     atomic.Pointer[namespaceLimits]
+		namespaceLimitsLock sync.Mutex
+
+		statsLock sync.Mutex
+		stats     RateLimitStats
+		// limiterStats tracks the rejections of each rate limiter by name
+		limiterStats map[string]*LimiterSnapshot
+
+		backpressure *backpressureMonitor
+		// throttlingNotifier is nil unless enabled
+		throttlingNotifier *throttlingNotifier
+		// notFound is nil unless enabled
+		notFound *notFoundCache
+		// historyTasksDedup is nil unless enabled
+		historyTasksDedup *historyTasksDedup
+		// starvation is nil unless enabled
+		starvation *starvationTracker
+		// dlqBacklog is nil unless enabled
+		dlqBacklog *dlqBacklogFeedback
+		// bucketEvictor is nil unless enabled
+		bucketEvictor *namespaceBucketEvictor
+		// createSerializer is nil unless enabled
+		createSerializer *workflowCreateSerializer
+		// bootstrap is nil unless enabled
+		bootstrap *bootstrapValve
+		// utilization is nil unless enabled
+		utilization *utilizationSampler
+		// rejectionSampler is nil unless enabled
+		rejectionSampler *rejectionSampler
+		// waitLatency is nil unless enabled
+		waitLatency *waitLatencyHistogram
+		// namespaceIO is nil unless enabled
+		namespaceIO *namespaceIOAccountant
+		// namespaceQPS is nil unless enabled
+		namespaceQPS *namespaceQPSTracker
+		// hotShards is nil unless enabled
+		hotShards *hotShardTracker
+		// namespaceRejectionStats is nil unless enabled
+		namespaceRejectionStats *namespaceRejectionTracker
+		// decisions is nil unless enabled
+		decisions *decisionLog
+		// taskQueueTypeRateLimiters are the rate limiters of task queue operations by priority
+		taskQueueTypeRateLimiters map[int]admissionLimiter
+		// concurrencySlots holds a semaphore for each concurrency limited operation
+		concurrencySlots map[string]chan struct{}
+	}
+
+	// admissionLimiter is the rate limiter requests are admitted by
+	admissionLimiter struct {
+		// name identifies the rate limiter in snapshots and metrics
+		name        string
+		rateLimiter quotas.RequestRateLimiter
+	}
+
+	// rateLimitAdmission is handed out for every request admitted to persistence,
+	// and has to be completed with the result of the persistence call
+	rateLimitAdmission struct {
+		enforcer *rateLimitEnforcer
+		api      string
+		// reservation is only set if the tokens consumed by the request can be refunded
+		reservation quotas.Reservation
+		reservedAt  time.Time
+		// slots is only set if the request holds a concurrency slot
+		slots chan struct{}
+		// middlewares is only set if the client has middlewares
+		middlewares *middlewareCall
+		// cancelBudget is only set if the request has a timeout budget
+		cancelBudget context.CancelFunc
+		// timeout is only set if the operation of the request has a timeout
+		timeout *operationTimeout
+		// inFlight is only set if the request is counted in flight for concurrency aware admission
+		inFlight bool
+		// admittedAt is only set if the downstream latency is emitted by admission
+		admittedAt time.Time
+		// waited tells whether the request waited for a token before it was admitted
+		waited bool
+		// spilledOver tells whether the request was rejected and admitted to the spillover instead
+		spilledOver bool
+	}
+)
+
+func newRateLimitEnforcer(
+	rateLimiter quotas.RequestRateLimiter,
+	storeName func() string,
+	logger log.Logger,
+	opts []RateLimitedClientOption,
+) *rateLimitEnforcer {
+	options := newRateLimitedClientOptions(opts)
+	if options.storeIdentity != "" {
+		storeName = func() string { return options.storeIdentity }
+	}
+	if options.configProvider != nil {
+		rateLimiter = newConfigProviderRateLimiter(
+			options.configProvider,
+			options.configRefreshInterval,
+			options.namespaceWeightProvider,
+			options.timeSource.Now(),
+		)
+	}
+	if options.rateSchedule != nil {
+		rateLimiter = quotas.NewScheduledRequestRateLimiter(
+			*options.rateSchedule,
+			options.rateScheduleLocation,
+			options.rateScheduleWindows...,
+		)
+	}
+	if options.leakyBucketRateFn != nil {
+		rateLimiter = quotas.NewLeakyBucketRateLimiter(options.leakyBucketRateFn, options.leakyBucketMaxQueued)
+	}
+	if rateLimiter == nil {
+		logger.Warn("Persistence rate limited client created without a rate limiter, all requests will be allowed.",
+			tag.StoreType(storeName()),
+		)
+		rateLimiter = quotas.NoopRequestRateLimiter
+	} else if options.refillPhasePeriod > 0 {
+		rateLimiter = quotas.NewPhaseShiftedRequestRateLimiter(
+			rateLimiter,
+			options.refillPhasePeriod,
+			options.refillPhaseSeed,
+		)
+	}
+	enforcer := &rateLimitEnforcer{
+		rateLimiter:    rateLimiter,
+		storeName:      storeName,
+		metricsHandler: options.metricsHandler,
+		timeSource:     options.timeSource,
+		logger:         logger,
+		options:        options,
+		backpressure: newBackpressureMonitor(
+			options.backpressureWindow,
+			options.backpressureDebounce,
+			options.backpressureThresholds,
+		),
+		namespaceQPS: newNamespaceQPSTracker(options.namespaceQPSInterval, options.timeSource.Now()),
+		namespaceIO:  newNamespaceIOAccountant(options.namespaceIOMaxNamespaces),
+		hotShards:    newHotShardTracker(options.hotShardHalfLife),
+		waitLatency:  newWaitLatencyHistogram(options.waitLatencyWindow, options.timeSource.Now()),
+		notFound:     newNotFoundCache(options.notFoundCacheTTL, options.notFoundCacheSize),
+		bootstrap: newBootstrapValve(
+			options.bootstrapWindow,
+			options.bootstrapMaxRejections,
+			options.timeSource.Now(),
+		),
+		rejectionSampler: newRejectionSampler(options.rejectionSampling),
+		decisions:        newDecisionLog(options.decisionLogSize),
+	}
+	enforcer.rejections = newRejectionCache(storeName(), &enforcer.options)
+	enforcer.outcomes = newOperationOutcomes(storeName())
+	enforcer.namespaceRejectionStats = newNamespaceRejectionTracker(
+		options.namespaceRejectionHalfLife,
+		options.namespaceRejectionMaxNamespaces,
+	)
+	enforcer.bucketEvictor = newNamespaceBucketEvictor(rateLimiter, options.namespaceBucketIdleTTL, options.timeSource)
+	enforcer.starvation = newStarvationTracker(options.starvationTracking, metrics.StoreTag(storeName()))
+	enforcer.dlqBacklog = newDLQBacklogFeedback(
+		options.dlqBacklogSource,
+		options.dlqBacklogPollInterval,
+		options.dlqBacklogThresholds,
+		logger,
+	)
+	enforcer.createSerializer = newWorkflowCreateSerializer(options.createSerialization)
+	enforcer.historyTasksDedup = newHistoryTasksDedup(options.historyTasksDedupTTL, options.historyTasksDedupSize)
+	enforcer.throttlingNotifier = newThrottlingNotifier(
+		options.throttlingNotificationQueue,
+		options.throttlingNotificationWindow,
+		options.throttlingNotificationThreshold,
+		storeName,
+		logger,
+	)
+	if options.historyForkConcurrency > 0 {
+		slots := make(chan struct{}, options.historyForkConcurrency)
+		enforcer.concurrencySlots = make(map[string]chan struct{}, len(historyForkOperations))
+		for _, api := range historyForkOperations {
+			enforcer.concurrencySlots[api] = slots
+		}
+	}
+	if len(options.priorityRateLimiters) > 0 {
+		enforcer.taskQueueTypeRateLimiters = make(map[int]admissionLimiter, len(options.priorityRateLimiters))
+		for priority := range options.priorityRateLimiters {
+			priority := priority
+			enforcer.taskQueueTypeRateLimiters[priority] = admissionLimiter{
+				name: taskQueueTypeRateLimiterName + "-" + strconv.Itoa(priority),
+				rateLimiter: quotas.NewPriorityRateLimiter(
+					func(quotas.Request) int { return priority },
+					options.priorityRateLimiters,
+				),
+			}
+		}
+	}
+	if options.utilizationMetrics {
+		enforcer.utilization = newUtilizationSampler(utilizationSampleInterval, options.timeSource.Now())
+	}
+	enforcer.enabled.Store(true)
+	enforcer.ResetRateLimitStats()
+	if options.rateFn != nil {
+		enforcer.warnOnLowRate(options.rateFn())
+	}
+	return enforcer
+}
+
+// warnOnLowRate logs a warning if the configured rate is below the safe minimum, unless
+// one was logged within the quiet period. This is only a guardrail, the configured rate
+// is still enforced.
+func (e *rateLimitEnforcer) warnOnLowRate(rate float64) {
+	if e.options.rateFn == nil || rate >= e.options.minSafeRate {
+		return
+	}
+	now := e.timeSource.Now()
+	lastWarnedAt := e.lowRateWarnedAt.Load()
+	if lastWarnedAt != 0 && now.Sub(time.Unix(0, lastWarnedAt)) < e.options.lowRateWarningQuietPeriod {
+		return
+	}
+	if e.lowRateWarnedAt.CompareAndSwap(lastWarnedAt, now.UnixNano()) {
+		e.logger.Warn("Persistence rate limit is configured below the safe minimum, persistence may not be able to serve even internal traffic.",
+			tag.StoreType(e.storeName()),
+			tag.NewFloat64("rate", rate),
+			tag.NewFloat64("min-safe-rate", e.options.minSafeRate),
+		)
+	}
+}
+
+func (e *rateLimitEnforcer) SetRateLimitEnabled(enabled bool) {
+	e.enabled.Store(enabled)
+}
+
+func (e *rateLimitEnforcer) SetOperationEnabled(operation string, enabled bool) {
+	if enabled {
+		e.disabledOperations.Delete(operation)
+	} else {
+		e.disabledOperations.Store(operation, struct{}{})
+	}
+}
+
+func (e *rateLimitEnforcer) SetDrainMode(draining bool) {
+	e.draining.Store(draining)
+}
+
+func (e *rateLimitEnforcer) PauseNamespace(namespaceID string, duration time.Duration) {
+	e.pausedNamespaces.Store(namespaceID, e.timeSource.Now().Add(duration))
+}
+
+func (e *rateLimitEnforcer) ResumeNamespace(namespaceID string) {
+	e.pausedNamespaces.Delete(namespaceID)
+}
+
+// WarmNamespace creates the per namespace buckets of the namespace in the rate limiters of the
+// client at their full burst ahead of its first request, e.g. when the namespace is activated.
+// Buckets are keyed by the caller name of requests. Rate limiters of no per namespace buckets
+// are left as they are.
+func (e *rateLimitEnforcer) WarmNamespace(namespace string) {
+	now := e.timeSource.Now()
+	warmNamespace(e.rateLimiter, now, namespace)
+	for _, rateLimiter := range e.options.operationRateLimiters {
+		warmNamespace(rateLimiter, now, namespace)
+	}
+}
+
+// warmNamespace creates the bucket of the namespace in the rate limiter, if it keeps one per namespace
+func warmNamespace(rateLimiter quotas.RequestRateLimiter, now time.Time, namespace string) {
+	switch rateLimiter := rateLimiter.(type) {
+	case *configProviderRateLimiter:
+		rateLimiter.warmNamespace(now, namespace)
+	case *quotas.MapRequestRateLimiterImpl[string]:
+		rateLimiter.Warm(quotas.Request{Caller: namespace})
+	case *quotas.ShardedMapRequestRateLimiterImpl[string]:
+		rateLimiter.Warm(quotas.Request{Caller: namespace})
+	}
+}
+
+func (e *rateLimitEnforcer) isNamespacePaused(namespaceID string) bool {
+	if namespaceID == namespaceIDMissing {
+		return false
+	}
+	expiry, ok := e.pausedNamespaces.Load(namespaceID)
+	if !ok {
+		return false
+	}
+	// expired pauses are left in place until the namespace is paused again or resumed
+	return e.timeSource.Now().Before(expiry.(time.Time))
+}
+
+// IsNamespaceThrottled reports whether requests of the namespace were rejected by the rate
+// limiters of the client, including those shared by all namespaces, within the last
+// namespaceThrottledWindow
+func (e *rateLimitEnforcer) IsNamespaceThrottled(namespaceID string) bool {
+	rejectedAt, ok := e.namespaceRejections.Load(namespaceID)
+	if !ok {
+		return false
+	}
+	return e.timeSource.Now().Sub(time.Unix(0, rejectedAt.(*atomic.Int64).Load())) < namespaceThrottledWindow
+}
+
+// recordNamespaceRejection records the time of the last rejected request of the namespace,
+// only allocating on the first rejection of the namespace, and counts it in the rejection
+// stats of the namespace if enabled
+func (e *rateLimitEnforcer) recordNamespaceRejection(namespaceID string) {
+	now := e.timeSource.Now()
+	rejectedAt, ok := e.namespaceRejections.Load(namespaceID)
+	if !ok {
+		rejectedAt, _ = e.namespaceRejections.LoadOrStore(namespaceID, &atomic.Int64{})
+	}
+	rejectedAt.(*atomic.Int64).Store(now.UnixNano())
+	if e.namespaceRejectionStats != nil {
+		e.namespaceRejectionStats.record(namespaceID, now)
+	}
+}
+
+// NamespaceRejectionStats returns the recent rejections of the requests of the namespace,
+// which are zero if namespace rejection stats are not enabled
+func (e *rateLimitEnforcer) NamespaceRejectionStats(namespaceID string) RejectionStats {
+	if e.namespaceRejectionStats == nil {
+		return RejectionStats{}
+	}
+	return e.namespaceRejectionStats.stats(namespaceID, e.timeSource.Now())
+}
+
+// admit decides whether a request may proceed to persistence. The returned admission
+// must be completed with the result of the persistence call, which must be made with the
+// returned context, marked as rate limited for the tier of the client if it has one. The
+// request is only passed on to the hook set WithAdmissionHook, and is nil if there is none.
+func (e *rateLimitEnforcer) admit(
+	ctx context.Context,
+	api string,
+	request any,
+	shardID int32,
+	namespaceID string,
+) (context.Context, rateLimitAdmission, error) {
+	return e.admitN(ctx, api, request, RateLimitDefaultToken, shardID, namespaceID)
+}
+
+// admitN is admit for requests costing the given number of tokens
+func (e *rateLimitEnforcer) admitN(
+	ctx context.Context,
+	api string,
+	request any,
+	token int,
+	shardID int32,
+	namespaceID string,
+) (context.Context, rateLimitAdmission, error) {
+	if e.options.replicationBypass && isReplicationApply(ctx, api) {
+		e.metricsHandler.Counter(metrics.PersistenceRateLimitReplicationBypass.GetMetricName()).Record(
+			1,
+			metrics.OperationTag(api),
+			metrics.StoreTag(e.storeName()),
+		)
+		limiter := admissionLimiter{name: replicationRateLimiterName, rateLimiter: e.options.replicationRateLimiter}
+		if limiter.rateLimiter == nil {
+			limiter.rateLimiter = quotas.NoopRequestRateLimiter
+		}
+		return e.admitWith(ctx, api, request, token, shardID, namespaceID, limiter)
+	}
+	if e.bootstrap != nil && e.bootstrap.isOpen(e.timeSource.Now(), api) {
+		limiter := admissionLimiter{name: mainRateLimiterName, rateLimiter: quotas.NoopRequestRateLimiter}
+		return e.admitWith(ctx, api, request, token, shardID, namespaceID, limiter)
+	}
+	return e.admitWith(ctx, api, request, token, shardID, namespaceID, admissionLimiter{
+		name:        e.rateLimiterNameFor(api),
+		rateLimiter: e.rateLimiterFor(api),
+	})
+}
+
+// admitByTaskQueueType is admit for the operations of a task queue, which are throttled by the
+// rate limiter of the priority of their task queue type if WithTaskQueueTypePriority is set
+func (e *rateLimitEnforcer) admitByTaskQueueType(
+	ctx context.Context,
+	api string,
+	request any,
+	namespaceID string,
+	taskQueue string,
+	taskType enumspb.TaskQueueType,
+) (context.Context, rateLimitAdmission, error) {
+	ctx = withTaskQueue(ctx, namespaceID, taskQueue, taskType)
+	if len(e.taskQueueTypeRateLimiters) == 0 {
+		return e.admit(ctx, api, request, CallerSegmentMissing, namespaceID)
+	}
+	limiter := e.taskQueueTypeRateLimiters[e.options.taskQueueTypePriority(taskType)]
+	return e.admitWith(ctx, api, request, RateLimitDefaultToken, CallerSegmentMissing, namespaceID, limiter)
+}
+
+// admitWith is admitN charging the given rate limiter
+func (e *rateLimitEnforcer) admitWith(
+	ctx context.Context,
+	api string,
+	request any,
+	token int,
+	shardID int32,
+	namespaceID string,
+	limiter admissionLimiter,
+) (context.Context, rateLimitAdmission, error) {
+	if hook := e.options.admissionHook; hook != nil {
+		// the hook vetoes the request before it consumes a token
+		if err := hook(ctx, api, request); err != nil {
+			return ctx, rateLimitAdmission{enforcer: e, api: api}, err
+		}
+	}
+	var cancelBudget context.CancelFunc
+	if budget := e.options.requestTimeoutBudget; budget > 0 {
+		// the budget is set before waiting for a token, so that the wait is deadline aware
+		// of it and the persistence call only gets what is left of it after the wait
+		ctx, cancelBudget = context.WithTimeout(ctx, budget)
+	}
+	ctx, admission, err := e.rateLimitWith(ctx, api, token, shardID, namespaceID, limiter)
+	admission.cancelBudget = cancelBudget
+	if err == nil && e.options.waitForToken && ctx.Err() != nil {
+		// the deadline passed while waiting for a token, persistence would fail anyway
+		admission.cancel()
+		return ctx, admission, ctx.Err()
+	}
+	if err != nil {
+		if cancelBudget != nil {
+			cancelBudget()
+		}
+		return ctx, admission, err
+	}
+	ctx = e.withOperationTimeout(ctx, api, &admission)
+	if e.options.downstreamLatencyByAdmission {
+		admission.admittedAt = e.timeSource.Now()
+	}
+	if len(e.options.middlewares) == 0 {
+		return ctx, admission, nil
+	}
+	if ctx, err = e.before(ctx, api, &admission); err != nil {
+		// persistence is not called, so the request gives back what it can
+		admission.cancel()
+		return ctx, admission, err
+	}
+	return ctx, admission, nil
+}
+
+// rateLimitWith admits the request by the given rate limiter
+func (e *rateLimitEnforcer) rateLimitWith(
+	ctx context.Context,
+	api string,
+	token int,
+	shardID int32,
+	namespaceID string,
+	limiter admissionLimiter,
+) (context.Context, rateLimitAdmission, error) {
+	admission := rateLimitAdmission{enforcer: e, api: api}
+	if _, disabled := e.disabledOperations.Load(api); disabled {
+		return ctx, admission, ErrPersistenceOperationDisabled
+	}
+	if e.draining.Load() && isDrainedOperation(api) {
+		return ctx, admission, ErrPersistenceDraining
+	}
+	if e.options.tier != "" && isRateLimitedForTier(ctx, e.options.tier) {
+		// an outer client of the same tier already rate limited the request
+		return ctx, admission, nil
+	}
+	if e.isNamespacePaused(namespaceID) {
+		// checked ahead of batch tickets, so that a pause also stops the batches reserved before it
+		return ctx, admission, ErrNamespacePaused
+	}
+	if e.useBatchTicket(ctx, api) {
+		// the token of the request was reserved by ReserveBatch
+		return ctx, admission, nil
+	}
+	if e.namespaceQPS != nil {
+		e.namespaceQPS.record(namespaceID)
+	}
+	if e.hotShards != nil {
+		e.hotShards.record(shardID, e.timeSource.Now())
+	}
+	if !e.enabled.Load() {
+		return ctx, admission, nil
+	}
+	if e.dlqBacklog != nil && isWriteOperation(api) {
+		token = e.dlqBacklog.writeTokens(token)
+	}
+	token = e.clampToBurst(limiter.rateLimiter, token)
+
+	err := e.acquireSlot(api, &admission)
+	slotDenied := err != nil
+	if err == nil {
+		now := e.timeSource.Now()
+		namespaceReservation, namespaceRateLimiter, namespaceErr := e.reserveNamespaceTokens(namespaceID, token, now)
+		if err = namespaceErr; err != nil {
+			limiter = admissionLimiter{
+				name:        RateLimitTierNamespace,
+				rateLimiter: quotas.NewRequestRateLimiterAdapter(namespaceRateLimiter),
+			}
+		} else {
+			err = e.acquireTokens(ctx, api, limiter, token, shardID, &admission)
+			// low concurrency only overrides the rate limiters of the operation,
+			// the limits of the namespaces apply regardless
+			if err == ErrPersistenceLimitExceeded && e.isConcurrencyLow() {
+				err = nil
+			}
+			if err != nil && namespaceReservation != nil {
+				namespaceReservation.CancelAt(now)
+			}
+		}
+		if err != nil {
+			admission.releaseSlot()
+		}
+	}
+	if err == ErrPersistenceLimitExceeded && e.options.forcedAdmissionAllowed {
+		if reason, forced := forcedAdmissionReason(ctx); forced {
+			e.auditForcedAdmission(ctx, api, reason)
+			// the concurrency slot, if any, was already given back
+			admission.slots = nil
+			err = nil
+		}
+	}
+
+	if e.decisions != nil && (err == nil || err == ErrPersistenceLimitExceeded) {
+		e.decisions.record(AdmissionDecision{
+			Time:        e.timeSource.Now(),
+			Operation:   api,
+			NamespaceID: namespaceID,
+			Allowed:     err == nil,
+			Tokens:      token,
+		})
+	}
+
+	switch err {
+	case nil:
+		e.annotateSpan(ctx, false)
+		e.signalHeadroom(ctx)
+		e.backpressure.record(e.timeSource.Now(), false)
+		if e.throttlingNotifier != nil {
+			e.throttlingNotifier.record(e.timeSource.Now(), false)
+		}
+		if e.bootstrap != nil {
+			e.bootstrap.record(e.timeSource.Now(), api, false)
+		}
+		if e.starvation != nil {
+			e.recordStarvationEnd(e.timeSource.Now(), api)
+		}
+		if e.utilization != nil {
+			e.emitUtilization(e.utilization.record(e.timeSource.Now(), limiter.name, limiter.rateLimiter, token))
+		}
+		if e.options.tier != "" {
+			ctx = withRateLimitedForTier(ctx, e.options.tier)
+		}
+		if e.options.lowConcurrency > 0 {
+			e.inFlight.Add(1)
+			admission.inFlight = true
+		}
+	case ErrPersistenceLimitExceeded:
+		if e.starvation != nil {
+			e.starvation.rejected(e.timeSource.Now(), api)
+		}
+		e.annotateSpan(ctx, true)
+		e.recordRejection(api, limiter.name)
+		e.backpressure.record(e.timeSource.Now(), true)
+		if e.throttlingNotifier != nil {
+			e.throttlingNotifier.record(e.timeSource.Now(), true)
+		}
+		if e.bootstrap != nil && e.bootstrap.record(e.timeSource.Now(), api, true) {
+			e.logger.Error("Persistence rate limiting keeps rejecting an operation required to start the server, temporarily exempting it from rate limiting.",
+				tag.StoreType(e.storeName()),
+				tag.Operation(api),
+			)
+		}
+		rejection := e.rejections.operation(api)
+		e.recordRejectionTotal(ctx, rejection.storeTags)
+		e.metricsHandler.Counter(metrics.PersistenceOperationRejected.GetMetricName()).Record(1, rejection.operationTags...)
+		e.metricsHandler.Counter(metrics.PersistenceRateLimitClassRejections.GetMetricName()).Record(1, rejection.classTags...)
+		e.recordRejectionMetric(api, namespaceID)
+		if namespaceID != namespaceIDMissing {
+			e.recordNamespaceRejection(namespaceID)
+		}
+		e.metricsHandler.Counter(metrics.PersistenceRateLimitSLORejections.GetMetricName()).Record(1, rejection.sloTags...)
+		err = rejection.err
+		if e.options.rejectionDetails {
+			err = withRejectionDetails(err, e.rejectionDetails(ctx, api, limiter, token, slotDenied))
+		}
+		return ctx, admission, err
+	}
+	return ctx, admission, err
+}
+
+// rejectionDetails tells which limiter tier denied the request
+func (e *rateLimitEnforcer) rejectionDetails(
+	ctx context.Context,
+	api string,
+	limiter admissionLimiter,
+	token int,
+	slotDenied bool,
+) RateLimitRejectionDetails {
+	if slotDenied {
+		return RateLimitRejectionDetails{
+			Tier:  RateLimitTierConcurrency,
+			Limit: float64(cap(e.concurrencySlots[api])),
+		}
+	}
+	now := e.timeSource.Now()
+	if configProvider, ok := limiter.rateLimiter.(*configProviderRateLimiter); ok {
+		tier, rate := configProvider.deniedBy(now, headers.GetCallerInfo(ctx).CallerName, token)
+		return RateLimitRejectionDetails{Tier: tier, Limit: rate}
+	}
+	rate, _, _, _ := rateLimiterState(limiter.rateLimiter, now)
+	return RateLimitRejectionDetails{Tier: limiter.name, Limit: rate}
+}
+
+// recordRejectionTotal counts the rejection, linking it to the trace of the request by an
+// exemplar if the request is sampled for tracing and the metrics backend supports exemplars
+func (e *rateLimitEnforcer) recordRejectionTotal(ctx context.Context, tags []metrics.Tag) {
+	counter := e.metricsHandler.Counter(metrics.PersistenceRateLimitRejectionsTotal.GetMetricName())
+	exemplarCounter, ok := counter.(metrics.ExemplarCounterIface)
+	if !ok {
+		counter.Record(1, tags...)
+		return
+	}
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsSampled() {
+		counter.Record(1, tags...)
+		return
+	}
+	exemplarCounter.RecordWithExemplar(
+		1,
+		map[string]string{metrics.TraceIDExemplarKey: spanContext.TraceID().String()},
+		tags...,
+	)
+}
+
+// recordRejectionMetric emits the rejection tagged with the operation and
+// namespace, unless it is left out by the rejection sampler
+func (e *rateLimitEnforcer) recordRejectionMetric(api string, namespaceID string) {
+	rejections := int64(1)
+	if e.rejectionSampler != nil {
+		var sampled bool
+		if rejections, sampled = e.rejectionSampler.sample(api, namespaceID); !sampled {
+			return
+		}
+	}
+	e.metricsHandler.Counter(metrics.PersistenceRateLimitRejections.GetMetricName()).Record(
+		rejections,
+		e.rejections.namespaceRejectionTags(api, namespaceID)...,
+	)
+}
+
+// rateLimitTierContextKey marks a request context as rate limited for the tier
+type rateLimitTierContextKey struct {
+	tier string
+}
+
+func withRateLimitedForTier(ctx context.Context, tier string) context.Context {
+	return context.WithValue(ctx, rateLimitTierContextKey{tier: tier}, struct{}{})
+}
+
+func isRateLimitedForTier(ctx context.Context, tier string) bool {
+	return ctx.Value(rateLimitTierContextKey{tier: tier}) != nil
+}
+
+// isConcurrencyLow reports whether concurrency aware admission admits requests denied by the
+// rate limiters, as few requests are in flight or waiting for a token
+func (e *rateLimitEnforcer) isConcurrencyLow() bool {
+	lowConcurrency := e.options.lowConcurrency
+	return lowConcurrency > 0 && e.inFlight.Load()+e.waiters.Load() < int64(lowConcurrency)
+}
+
+// acquireSlot takes a concurrency slot, if the operation is concurrency limited
+func (e *rateLimitEnforcer) acquireSlot(api string, admission *rateLimitAdmission) error {
+	slots, ok := e.concurrencySlots[api]
+	if !ok {
+		return nil
+	}
+	select {
+	case slots <- struct{}{}:
+		admission.slots = slots
+		return nil
+	default:
+		return ErrPersistenceLimitExceeded
+	}
+}
+
+// acquireTokens takes the tokens of the request from the rate limiter of the operation
+func (e *rateLimitEnforcer) acquireTokens(
+	ctx context.Context,
+	api string,
+	limiter admissionLimiter,
+	token int,
+	shardID int32,
+	admission *rateLimitAdmission,
+) error {
+	rateLimiter := limiter.rateLimiter
+	request := newRateLimitRequest(ctx, api, token, shardID)
+	e.boostPriority(ctx, &request)
+	e.inheritPriority(ctx, &request)
+	switch {
+	case e.options.waitForToken:
+		return e.wait(ctx, api, rateLimiter, request, admission)
+	case len(e.options.refundableErrors) > 0:
+		// reserve rather than allow, so that the token can be given back
+		// if the persistence call fails before doing any work
+		return e.reserve(rateLimiter, request, admission)
+	default:
+		if fallible, ok := rateLimiter.(quotas.FallibleRequestRateLimiter); ok {
+			return e.tryAllow(api, fallible, request)
+		}
+		if !rateLimiter.Allow(e.timeSource.Now(), request) {
+			return ErrPersistenceLimitExceeded
+		}
+		return nil
+	}
+}
+
+// clampToBurst caps the tokens of a request to the burst of its rate limiter, as a request
+// costing more could never be admitted. Rate limiters of unknown burst are left as they are.
+func (e *rateLimitEnforcer) clampToBurst(rateLimiter quotas.RequestRateLimiter, token int) int {
+	if token <= RateLimitDefaultToken {
+		return token
+	}
+	_, burst, _, ok := rateLimiterState(rateLimiter, e.timeSource.Now())
+	if ok && burst >= RateLimitDefaultToken && token > burst {
+		return burst
+	}
+	return token
+}
+
+// tryAllow admits the request if the rate limiter allows it, or fails to decide and
+// the client is configured to fail open
+func (e *rateLimitEnforcer) tryAllow(
+	api string,
+	rateLimiter quotas.FallibleRequestRateLimiter,
+	request quotas.Request,
+) error {
+	allowed, err := rateLimiter.TryAllow(e.timeSource.Now(), request)
+	if err != nil {
+		e.metricsHandler.Counter(metrics.PersistenceRateLimiterErrors.GetMetricName()).Record(
+			1,
+			metrics.OperationTag(api),
+			metrics.StoreTag(e.storeName()),
+		)
+		if e.options.failOpen {
+			return nil
+		}
+		return ErrPersistenceLimitExceeded
+	}
+	if !allowed {
+		return ErrPersistenceLimitExceeded
+	}
+	return nil
+}
+
+// emitUtilization emits the utilization samples of the rate limiters
+func (e *rateLimitEnforcer) emitUtilization(samples []utilizationSample) {
+	for _, sample := range samples {
+		tags := []metrics.Tag{
+			metrics.StringTag(rateLimiterTagName, sample.name),
+			metrics.StoreTag(e.storeName()),
+		}
+		e.metricsHandler.Gauge(metrics.PersistenceRateLimitTokensConsumed.GetMetricName()).Record(float64(sample.consumed), tags...)
+		if sample.rateKnown {
+			e.metricsHandler.Gauge(metrics.PersistenceRateLimitUtilization.GetMetricName()).Record(sample.utilization, tags...)
+		}
+	}
+}
+
+func (e *rateLimitEnforcer) recordRejection(api string, name string) {
+	e.outcomes.operation(api).rejected.Add(1)
+
+	e.statsLock.Lock()
+	defer e.statsLock.Unlock()
+
+	now := e.timeSource.Now()
+	e.stats.Rejections++
+	e.stats.RejectionsByOperation[api]++
+	e.stats.LastRejectionTime = now
+
+	limiterStats, ok := e.limiterStats[name]
+	if !ok {
+		limiterStats = &LimiterSnapshot{}
+		e.limiterStats[name] = limiterStats
+	}
+	limiterStats.Rejections++
+	limiterStats.LastRejectionTime = now
+}
+
+func (e *rateLimitEnforcer) RateLimitStats() RateLimitStats {
+	e.statsLock.Lock()
+	defer e.statsLock.Unlock()
+
+	stats := e.stats
+	stats.RejectionsByOperation = make(map[string]int64, len(e.stats.RejectionsByOperation))
+	for api, rejections := range e.stats.RejectionsByOperation {
+		stats.RejectionsByOperation[api] = rejections
+	}
+	stats.OutcomesByOperation = e.outcomes.snapshot()
+	return stats
+}
+
+// recordCompletion counts the outcome of a request which persistence was called for
+func (e *rateLimitEnforcer) recordCompletion(api string, err error) {
+	counters := e.outcomes.operation(api)
+	metric := metrics.PersistenceOperationSucceeded
+	if err != nil {
+		metric = metrics.PersistenceOperationDownstreamFailed
+		counters.downstreamFailed.Add(1)
+	} else {
+		counters.succeeded.Add(1)
+	}
+	e.metricsHandler.Counter(metric.GetMetricName()).Record(1, counters.tags...)
+}
+
+func (e *rateLimitEnforcer) ResetRateLimitStats() {
+	e.statsLock.Lock()
+	defer e.statsLock.Unlock()
+
+	e.stats = RateLimitStats{
+		RejectionsByOperation: make(map[string]int64),
+	}
+	e.outcomes.reset()
+	e.limiterStats = make(map[string]*LimiterSnapshot)
+}
+
+// NamespaceQPS returns the QPS of each namespace over the last completed aggregation interval,
+// or nil if namespace QPS reporting is not enabled
+func (e *rateLimitEnforcer) NamespaceQPS() map[string]float64 {
+	if e.namespaceQPS == nil {
+		return nil
+	}
+	return e.namespaceQPS.namespaceQPS(e.timeSource.Now())
+}
+
+// HotShards returns the k shards of the most requests recently, with the most requested first,
+// or nil if hot shard tracking is not enabled
+func (e *rateLimitEnforcer) HotShards(k int) []ShardStat {
+	if e.hotShards == nil {
+		return nil
+	}
+	return e.hotShards.hotShards(k, e.timeSource.Now())
+}
+
+// WaitLatencyPercentile returns the given percentile, between 0 and 100, of how long requests
+// of the operation recently waited for a token, or 0 if the wait latency histogram is not enabled
+func (e *rateLimitEnforcer) WaitLatencyPercentile(operation string, percentile float64) time.Duration {
+	if e.waitLatency == nil {
+		return 0
+	}
+	return e.waitLatency.percentile(e.timeSource.Now(), operation, percentile)
+}
+
+// UpdateRateLimit changes the rate and burst of the main rate limiter in place,
+// if it is a quotas.RateLimiterImpl
+func (e *rateLimitEnforcer) UpdateRateLimit(rate float64, burst int) error {
+	var impl *quotas.RateLimiterImpl
+	if adapter, ok := e.rateLimiter.(*quotas.RequestRateLimiterAdapterImpl); ok {
+		impl, _ = adapter.RateLimiter().(*quotas.RateLimiterImpl)
+	}
+	if impl == nil {
+		return serviceerror.NewInvalidArgument("The rate limiter of the client does not support updating its rate limit.")
+	}
+	// the handoff happens at the time the rate limiter is used with, so that
+	// the accumulated tokens are neither lost nor credited twice
+	impl.SetRateBurstAt(e.timeSource.Now(), rate, burst)
+	e.warnOnLowRate(rate)
+	return nil
+}
+
+// Snapshot returns the state of the main rate limiter and of every operation rate limiter,
+// all taken at the same time and consistent with the rejection stats
+func (e *rateLimitEnforcer) Snapshot() []LimiterSnapshot {
+	e.statsLock.Lock()
+	defer e.statsLock.Unlock()
+
+	now := e.timeSource.Now()
+	snapshots := []LimiterSnapshot{e.snapshotLocked(now, mainRateLimiterName, e.rateLimiter)}
+	indexByName := map[string]int{mainRateLimiterName: 0}
+	operations := make([]string, 0, len(e.options.operationRateLimiterNames))
+	for operation := range e.options.operationRateLimiterNames {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	for _, operation := range operations {
+		rateLimiter := e.options.operationRateLimiters[operation]
+		if rateLimiter == nil {
+			continue
+		}
+		name := e.options.operationRateLimiterNames[operation]
+		index, ok := indexByName[name]
+		if !ok {
+			index = len(snapshots)
+			indexByName[name] = index
+			snapshots = append(snapshots, e.snapshotLocked(now, name, rateLimiter))
+		}
+		snapshots[index].Operations = append(snapshots[index].Operations, operation)
+	}
+	return snapshots
+}
+
+// RecentDecisions returns the most recent admission decisions, oldest first,
+// or nil unless WithDecisionLog is set
+func (e *rateLimitEnforcer) RecentDecisions() []AdmissionDecision {
+	if e.decisions == nil {
+		return nil
+	}
+	return e.decisions.recent()
+}
+
+// snapshotLocked returns the state of the rate limiter, statsLock must be held
+func (e *rateLimitEnforcer) snapshotLocked(
+	now time.Time,
+	name string,
+	rateLimiter quotas.RequestRateLimiter,
+) LimiterSnapshot {
+	snapshot := LimiterSnapshot{Name: name}
+	if limiterStats, ok := e.limiterStats[name]; ok {
+		snapshot.Rejections = limiterStats.Rejections
+		snapshot.LastRejectionTime = limiterStats.LastRejectionTime
+	}
+	snapshot.Rate, snapshot.Burst, snapshot.TokensAvailable, snapshot.StateKnown = rateLimiterState(rateLimiter, now)
+	return snapshot
+}
+
+// rateLimiterState returns the rate, burst and tokens available at the given time of the rate
+// limiter, which are only known for rate limiters adapted from a quotas.RateLimiterImpl, or
+// from a quotas.MultiRateLimiterImpl of them, and for scheduled rate limiters
+func rateLimiterState(
+	rateLimiter quotas.RequestRateLimiter,
+	now time.Time,
+) (rate float64, burst int, tokensAvailable float64, ok bool) {
+	switch rateLimiter := rateLimiter.(type) {
+	case *quotas.RequestRateLimiterAdapterImpl:
+		return adaptedRateLimiterState(rateLimiter.RateLimiter(), now)
+	case *configProviderRateLimiter:
+		// the per namespace rate limiters are left out, see LimiterName
+		return adaptedRateLimiterState(rateLimiter.global, now)
+	case *quotas.ScheduledRequestRateLimiterImpl:
+		return adaptedRateLimiterState(rateLimiter.RateLimiterAt(now), now)
+	default:
+		return 0, 0, 0, false
+	}
+}
+
+// adaptedRateLimiterState is rateLimiterState for the rate limiter of a quotas.RequestRateLimiterAdapterImpl.
+// The state of a composite rate limiter is the most restrictive rate, burst and tokens of its components,
+// as a request has to be allowed by all of them.
+func adaptedRateLimiterState(
+	rateLimiter quotas.RateLimiter,
+	now time.Time,
+) (rate float64, burst int, tokensAvailable float64, ok bool) {
+	switch rateLimiter := rateLimiter.(type) {
+	case *quotas.RateLimiterImpl:
+		return rateLimiter.Rate(), rateLimiter.Burst(), rateLimiter.TokensAt(now), true
+	case *quotas.MultiRateLimiterImpl:
+		for i, component := range rateLimiter.RateLimiters() {
+			componentRate, componentBurst, componentTokens, componentOK := adaptedRateLimiterState(component, now)
+			if !componentOK {
+				return 0, 0, 0, false
+			}
+			if i == 0 || componentRate < rate {
+				rate = componentRate
+			}
+			if i == 0 || componentBurst < burst {
+				burst = componentBurst
+			}
+			if i == 0 || componentTokens < tokensAvailable {
+				tokensAvailable = componentTokens
+			}
+		}
+		return rate, burst, tokensAvailable, true
+	default:
+		return 0, 0, 0, false
+	}
+}
+
+// LimiterName identifies the client and the rates of its rate limiters for debugging,
+// e.g. execution[main=1000rps,scan=10rps], listing the rate limiters of Snapshot
+// and the namespaces limited by a RateLimitConfigProvider, as currently configured
+func (e *rateLimitEnforcer) LimiterName() string {
+	var limiters []string
+	for _, snapshot := range e.Snapshot() {
+		rate := "unknown"
+		if snapshot.StateKnown {
+			rate = formatRPS(snapshot.Rate)
+		}
+		limiters = append(limiters, snapshot.Name+"="+rate)
+	}
+	if configProvider, ok := e.rateLimiter.(*configProviderRateLimiter); ok {
+		namespaceRates := configProvider.namespaceRates()
+		namespaces := make([]string, 0, len(namespaceRates))
+		for namespace := range namespaceRates {
+			namespaces = append(namespaces, namespace)
+		}
+		sort.Strings(namespaces)
+		for _, namespace := range namespaces {
+			limiters = append(limiters, "ns:"+namespace+"="+formatRPS(namespaceRates[namespace]))
+		}
+	}
+	return e.storeName() + "[" + strings.Join(limiters, ",") + "]"
+}
+
+func formatRPS(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', -1, 64) + "rps"
+}
+
+func (e *rateLimitEnforcer) Subscribe() <-chan BackpressureEvent {
+	return e.backpressure.subscribe()
+}
+
+// close releases the resources of the enforcer, it must not consume tokens
+func (e *rateLimitEnforcer) close() {
+	e.backpressure.close()
+	if e.bucketEvictor != nil {
+		e.bucketEvictor.close()
+	}
+	if e.dlqBacklog != nil {
+		e.dlqBacklog.close()
+	}
+}
+
+// signalHeadroom populates the RateLimitHeadroom of the context, if the caller asked for it
+func (e *rateLimitEnforcer) signalHeadroom(ctx context.Context) {
+	if !e.options.headroomSignal || e.options.tokensRemaining == nil {
+		return
+	}
+	if headroom := rateLimitHeadroomFromContext(ctx); headroom != nil {
+		headroom.set(e.options.tokensRemaining(e.timeSource.Now()))
+	}
+}
+
+// annotateSpan records the rate limiting decision on the tracing span of the request.
+// Nothing is computed unless the span is being recorded.
+func (e *rateLimitEnforcer) annotateSpan(ctx context.Context, rateLimited bool) {
+	if !e.options.spanAttributes {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	attributes := []attribute.KeyValue{attribute.Bool(spanAttributeRateLimited, rateLimited)}
+	if e.options.tokensRemaining != nil {
+		attributes = append(attributes, attribute.Float64(
+			spanAttributeTokensRemaining,
+			e.options.tokensRemaining(e.timeSource.Now()),
+		))
+	}
+	span.SetAttributes(attributes...)
+}
+
+// reserve admits the request only if a token is available right away
+func (e *rateLimitEnforcer) reserve(
+	rateLimiter quotas.RequestRateLimiter,
+	request quotas.Request,
+	admission *rateLimitAdmission,
+) error {
+	now := e.timeSource.Now()
+	reservation := rateLimiter.Reserve(now, request)
+	if !reservation.OK() || reservation.DelayFrom(now) > 0 {
+		reservation.CancelAt(now)
+		return ErrPersistenceLimitExceeded
+	}
+	admission.reservation = reservation
+	admission.reservedAt = now
+	return nil
+}
+
+// wait blocks until a token is available, unless the token would only become
+// available after the deadline of the context or the max wait, in which case
+// the request is rejected right away
+func (e *rateLimitEnforcer) wait(
+	ctx context.Context,
+	api string,
+	rateLimiter quotas.RequestRateLimiter,
+	request quotas.Request,
+	admission *rateLimitAdmission,
+) error {
+	now := e.timeSource.Now()
+	reservation := rateLimiter.Reserve(now, request)
+	if !reservation.OK() {
+		return ErrPersistenceLimitExceeded
+	}
+	delay := reservation.DelayFrom(now)
+	if e.options.maxWait > 0 && delay > e.options.maxWait {
+		reservation.CancelAt(now)
+		return ErrPersistenceLimitExceeded
+	}
+	if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+		reservation.CancelAt(now)
+		e.metricsHandler.Counter(metrics.PersistenceRateLimitDeadlineExceeded.GetMetricName()).Record(
+			1,
+			metrics.OperationTag(api),
+			metrics.StoreTag(e.storeName()),
+		)
+		return ErrPersistenceLimitExceeded
+	}
+
+	e.metricsHandler.Timer(metrics.PersistenceRateLimitWaitLatency.GetMetricName()).Record(
+		delay,
+		metrics.OperationTag(api),
+		metrics.StoreTag(e.storeName()),
+	)
+	if e.waitLatency != nil {
+		e.waitLatency.record(now, api, delay)
+	}
+	if delay > 0 {
+		if maxWaiters := e.options.maxWaiters; maxWaiters > 0 {
+			if e.waiters.Add(1) > int64(maxWaiters) {
+				// too many requests are parked already, e.g. because persistence stalls
+				e.waiters.Add(-1)
+				reservation.CancelAt(now)
+				return ErrPersistenceLimitExceeded
+			}
+			defer e.waiters.Add(-1)
+		} else if e.options.lowConcurrency > 0 {
+			// queued requests count towards the concurrency of concurrency aware admission
+			e.waiters.Add(1)
+			defer e.waiters.Add(-1)
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			reservation.CancelAt(now)
+			return ctx.Err()
+		}
+		admission.waited = true
+	}
+	admission.reservation = reservation
+	admission.reservedAt = now
+	return nil
+}
+
+// releaseSlot gives back the concurrency slot of the admission, if any
+func (a rateLimitAdmission) releaseSlot() {
+	if a.slots != nil {
+		<-a.slots
+	}
+	if a.inFlight {
+		a.enforcer.inFlight.Add(-1)
+	}
+}
+
+// cancel gives back the concurrency slot of an admission whose request did not
+// reach persistence, and its tokens if they were reserved
+func (a rateLimitAdmission) cancel() {
+	a.releaseSlot()
+	if a.cancelBudget != nil {
+		a.cancelBudget()
+	}
+	if a.timeout != nil {
+		a.timeout.cancel()
+	}
+	if a.reservation != nil {
+		a.reservation.CancelAt(a.reservedAt)
+	}
+}
+
+// done completes the admission with the result of the persistence call, returning the error
+// the request fails with, which only differs from err if the operation timeout cut the call off
+func (a rateLimitAdmission) done(err error) error {
+	if a.timeout != nil {
+		err = a.timeout.stop(a.enforcer, a.api, err)
+	}
+	a.releaseSlot()
+	if a.cancelBudget != nil {
+		a.cancelBudget()
+	}
+	if !a.spilledOver {
+		// reads served by the spillover were already counted as rejections
+		a.enforcer.recordCompletion(a.api, err)
+	}
+	if !a.admittedAt.IsZero() {
+		a.enforcer.recordDownstreamLatency(a)
+	}
+	if a.middlewares != nil {
+		a.middlewares.after(a.api, err)
+	}
+	if _, ok := err.(*serviceerror.ResourceExhausted); ok {
+		// counted apart from the rejections of the rate limiter, as the overload
+		// originates in persistence itself
+		a.enforcer.metricsHandler.Counter(metrics.PersistenceDownstreamResourceExhausted.GetMetricName()).Record(
+			1,
+			metrics.OperationTag(a.api),
+			metrics.StoreTag(a.enforcer.storeName()),
+		)
+	}
+	if err == nil || a.reservation == nil {
+		return err
+	}
+	for _, isRefundable := range a.enforcer.options.refundableErrors {
+		if isRefundable(err) {
+			// cancel at the time of the reservation, as canceling a reservation
+			// after the time it was due is a no-op
+			a.reservation.CancelAt(a.reservedAt)
+			break
+		}
+	}
+	return err
+}
+
+func (e *rateLimitEnforcer) charge(
+	ctx context.Context,
+	api string,
+	shardID int32,
+	token int,
+) {
+	if !e.enabled.Load() || token <= 0 {
+		return
+	}
+	// the tokens are reserved rather than allowed so that the charge always succeeds,
+	// pushing the limiter into debt that subsequent requests have to wait out
+	_ = e.rateLimiterFor(api).Reserve(e.timeSource.Now(), newRateLimitRequest(ctx, api, token, shardID))
+}
+
+// hasOperationRateLimiter reports whether the operation is throttled by its own rate limiter
+func (e *rateLimitEnforcer) hasOperationRateLimiter(api string) bool {
+	rateLimiter, ok := e.options.operationRateLimiters[api]
+	return ok && rateLimiter != nil
+}
+
+// rateLimiterNameFor returns the name of the rate limiter of the operation
+func (e *rateLimitEnforcer) rateLimiterNameFor(api string) string {
+	if e.hasOperationRateLimiter(api) {
+		return e.options.operationRateLimiterNames[api]
+	}
+	return mainRateLimiterName
+}
+
+// rateLimiterFor returns the rate limiter responsible for the given api
+func (e *rateLimitEnforcer) rateLimiterFor(api string) quotas.RequestRateLimiter {
+	if rateLimiter, ok := e.options.operationRateLimiters[api]; ok && rateLimiter != nil {
+		return rateLimiter
+	}
+	return e.rateLimiter
+}
+
+// WouldAllow runs the checks of rateLimitWith which do not change any state, then reads the
+// tokens of the rate limiter of the operation without taking any. Rate limiters whose tokens
+// can not be read this way, see rateLimiterState, are assumed to allow the request.
+func (e *rateLimitEnforcer) WouldAllow(ctx context.Context, operation string, namespaceID string) bool {
+	if _, disabled := e.disabledOperations.Load(operation); disabled {
+		return false
+	}
+	if e.draining.Load() && isDrainedOperation(operation) {
+		return false
+	}
+	if e.options.tier != "" && isRateLimitedForTier(ctx, e.options.tier) {
+		return true
+	}
+	if e.isNamespacePaused(namespaceID) {
+		return false
+	}
+	if !e.enabled.Load() {
+		return true
+	}
+	_, _, tokensAvailable, ok := rateLimiterState(e.rateLimiterFor(operation), e.timeSource.Now())
+	return !ok || tokensAvailable >= RateLimitDefaultToken
+}
+
+func newRateLimitRequest(
+	ctx context.Context,
+	api string,
+	token int,
+	shardID int32,
+) quotas.Request {
+	callerInfo := headers.GetCallerInfo(ctx)
+	request := quotas.NewRequest(
+		api,
+		token,
+		callerInfo.CallerName,
+		callerInfo.CallerType,
+		shardID,
+		callerInfo.CallOrigin,
+	)
+	request.TaskQueue, _ = ctx.Value(taskQueueContextKey{}).(string)
+	return request
+}
+
+// taskQueueContextKey holds the task queue a request context is scoped to
+type taskQueueContextKey struct{}
+
+// withTaskQueue scopes the request context to the task queue, identified
+// as namespaceID/name/type in the rate limit requests made for it
+func withTaskQueue(
+	ctx context.Context,
+	namespaceID string,
+	taskQueue string,
+	taskType enumspb.TaskQueueType,
+) context.Context {
+	return context.WithValue(ctx, taskQueueContextKey{}, namespaceID+"/"+taskQueue+"/"+taskType.String())
+}
@@ -0,0 +1,115 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+
+	"go.temporal.io/api/serviceerror"
+
+	persistencespb "go.temporal.io/server/api/persistence/v1"
+)
+
+type (
+	// ThrottleAwareExecutionIterator iterates over the pages of ListConcreteExecutions of a shard,
+	// stopping rather than failing when persistence is overloaded, so that the caller can keep
+	// the executions read so far, checkpoint the iteration and resume it later
+	ThrottleAwareExecutionIterator interface {
+		// Read reads up to maxPages pages, or all remaining pages if maxPages is not positive.
+		// If a page is rejected with ResourceExhausted, e.g. ErrPersistenceLimitExceeded, the
+		// pages read so far are returned with Throttled set instead of an error. Other errors
+		// are returned together with the pages read so far.
+		Read(ctx context.Context, maxPages int) (ExecutionPages, error)
+		// HasNext reports whether there are pages left to read
+		HasNext() bool
+	}
+
+	// ExecutionPages are the pages read by a ThrottleAwareExecutionIterator
+	ExecutionPages struct {
+		States []*persistencespb.WorkflowMutableState
+		// PageToken is the token of the first page not read, from which the iteration can be
+		// resumed with NewThrottleAwareExecutionIterator, even if it is empty because the first
+		// page was not read. It is empty once all pages were read.
+		PageToken []byte
+		// Throttled reports whether reading stopped early because persistence was overloaded
+		Throttled bool
+	}
+
+	throttleAwareExecutionIterator struct {
+		executionManager ExecutionManager
+		shardID          int32
+		pageSize         int
+		pageToken        []byte
+		done             bool
+	}
+)
+
+// NewThrottleAwareExecutionIterator creates a ThrottleAwareExecutionIterator over the executions
+// of the shard, typically on top of a rate limited client, starting at the given page token,
+// which is empty to start from the beginning
+func NewThrottleAwareExecutionIterator(
+	executionManager ExecutionManager,
+	shardID int32,
+	pageSize int,
+	pageToken []byte,
+) ThrottleAwareExecutionIterator {
+	return &throttleAwareExecutionIterator{
+		executionManager: executionManager,
+		shardID:          shardID,
+		pageSize:         pageSize,
+		pageToken:        pageToken,
+	}
+}
+
+func (iter *throttleAwareExecutionIterator) Read(
+	ctx context.Context,
+	maxPages int,
+) (ExecutionPages, error) {
+	var pages ExecutionPages
+	for pageCount := 0; iter.HasNext() && (maxPages <= 0 || pageCount < maxPages); pageCount++ {
+		response, err := iter.executionManager.ListConcreteExecutions(ctx, &ListConcreteExecutionsRequest{
+			ShardID:   iter.shardID,
+			PageSize:  iter.pageSize,
+			PageToken: iter.pageToken,
+		})
+		if err != nil {
+			pages.PageToken = iter.pageToken
+			if _, ok := err.(*serviceerror.ResourceExhausted); ok {
+				pages.Throttled = true
+				return pages, nil
+			}
+			return pages, err
+		}
+		pages.States = append(pages.States, response.States...)
+		iter.pageToken = response.PageToken
+		iter.done = len(response.PageToken) == 0
+	}
+	pages.PageToken = iter.pageToken
+	return pages, nil
+}
+
+func (iter *throttleAwareExecutionIterator) HasNext() bool {
+	return !iter.done
+}
@@ -0,0 +1,75 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+
+	"go.temporal.io/server/common/headers"
+	"go.temporal.io/server/common/log/tag"
+	"go.temporal.io/server/common/metrics"
+)
+
+const (
+	// callerTagName tags the audit of forced admissions by the caller of the request
+	callerTagName = "caller"
+)
+
+type (
+	forcedAdmissionContextKey struct{}
+)
+
+// WithForcedAdmission returns a context forcing the persistence requests made under it through
+// rate limiting, e.g. for an emergency admin operation which must run while persistence is
+// throttled. Requests the rate limiters deny are admitted anyway, and every such override is
+// audited. Clients only honor it if created WithForcedAdmissionAllowed. The context should be
+// scoped to the single call which has to go through.
+func WithForcedAdmission(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, forcedAdmissionContextKey{}, reason)
+}
+
+// forcedAdmissionReason returns the reason for which the request is forced through rate limiting
+func forcedAdmissionReason(ctx context.Context) (string, bool) {
+	reason, ok := ctx.Value(forcedAdmissionContextKey{}).(string)
+	return reason, ok
+}
+
+// auditForcedAdmission records that a request of the operation denied by
+// the rate limiters was forced through, in a metric and a log entry
+func (e *rateLimitEnforcer) auditForcedAdmission(ctx context.Context, api string, reason string) {
+	caller := headers.GetCallerInfo(ctx).CallerName
+	e.metricsHandler.Counter(metrics.PersistenceRateLimitForcedAdmissions.GetMetricName()).Record(
+		1,
+		metrics.OperationTag(api),
+		metrics.StringTag(callerTagName, caller),
+		metrics.StoreTag(e.storeName()),
+	)
+	e.logger.Warn("Persistence request denied by rate limiting was forced through.",
+		tag.StoreType(e.storeName()),
+		tag.Operation(api),
+		tag.NewStringTag(callerTagName, caller),
+		tag.NewStringTag("reason", reason),
+	)
+}
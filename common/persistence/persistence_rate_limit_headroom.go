@@ -0,0 +1,69 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// RateLimitHeadroom is an advisory signal, populated by rate limited persistence clients
+	// after a request is allowed, of how close the request came to the rate limit. Cooperative
+	// callers can use it to pace themselves before they are rejected.
+	RateLimitHeadroom struct {
+		sync.Mutex
+		populated       bool
+		tokensRemaining float64
+	}
+
+	rateLimitHeadroomContextKey struct{}
+)
+
+// WithRateLimitHeadroom returns a context under which rate limited persistence clients
+// configured WithHeadroomSignal populate the given headroom
+func WithRateLimitHeadroom(ctx context.Context, headroom *RateLimitHeadroom) context.Context {
+	return context.WithValue(ctx, rateLimitHeadroomContextKey{}, headroom)
+}
+
+// TokensRemaining returns the tokens remaining in the rate limiter after the last allowed
+// request, and whether the headroom was populated at all
+func (h *RateLimitHeadroom) TokensRemaining() (float64, bool) {
+	h.Lock()
+	defer h.Unlock()
+	return h.tokensRemaining, h.populated
+}
+
+func (h *RateLimitHeadroom) set(tokensRemaining float64) {
+	h.Lock()
+	defer h.Unlock()
+	h.populated = true
+	h.tokensRemaining = tokensRemaining
+}
+
+func rateLimitHeadroomFromContext(ctx context.Context) *RateLimitHeadroom {
+	headroom, _ := ctx.Value(rateLimitHeadroomContextKey{}).(*RateLimitHeadroom)
+	return headroom
+}
@@ -0,0 +1,110 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.temporal.io/server/common/cache"
+	"go.temporal.io/server/common/metrics"
+	"go.temporal.io/server/service/history/tasks"
+)
+
+type (
+	// historyTasksDedup remembers for a short time the AddHistoryTasks requests which succeeded, so
+	// that retries submitting the exact same request neither consume a token nor reach persistence.
+	// Requests are identified by a hash of all of their fields and tasks, so that distinct requests,
+	// e.g. of the same tasks with other task IDs or visibility times, are never taken for duplicates.
+	historyTasksDedup struct {
+		ttl   time.Duration
+		cache cache.Cache
+	}
+
+	historyTasksDedupKey [sha256.Size]byte
+)
+
+func newHistoryTasksDedup(
+	ttl time.Duration,
+	maxSize int,
+) *historyTasksDedup {
+	if ttl <= 0 || maxSize <= 0 {
+		return nil
+	}
+	return &historyTasksDedup{
+		ttl:   ttl,
+		cache: cache.NewLRU(maxSize),
+	}
+}
+
+// isDuplicate tells whether the same request succeeded within the TTL
+func (d *historyTasksDedup) isDuplicate(now time.Time, key historyTasksDedupKey) bool {
+	expiry, ok := d.cache.Get(key).(time.Time)
+	return ok && now.Before(expiry)
+}
+
+// put remembers the request, which succeeded
+func (d *historyTasksDedup) put(now time.Time, key historyTasksDedupKey) {
+	d.cache.Put(key, now.Add(d.ttl))
+}
+
+// isDuplicateHistoryTasks tells whether the same AddHistoryTasks request succeeded within the
+// TTL of the dedup, counting it as deduplicated if so. It returns the key of the request, which
+// is remembered with put once it succeeds.
+func (e *rateLimitEnforcer) isDuplicateHistoryTasks(request *AddHistoryTasksRequest) (historyTasksDedupKey, bool) {
+	key := newHistoryTasksDedupKey(request)
+	if !e.historyTasksDedup.isDuplicate(e.timeSource.Now(), key) {
+		return key, false
+	}
+	e.metricsHandler.Counter(metrics.PersistenceDeduplicatedRequests.GetMetricName()).Record(
+		1,
+		metrics.OperationTag("AddHistoryTasks"),
+		metrics.StoreTag(e.storeName()),
+	)
+	return key, true
+}
+
+// newHistoryTasksDedupKey hashes all the fields of the request, including every field of its
+// tasks, in the order of their categories and of the tasks within a category
+func newHistoryTasksDedupKey(request *AddHistoryTasksRequest) historyTasksDedupKey {
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(hash, "%d|%d|%q|%q|%q|", request.ShardID, request.RangeID, request.NamespaceID, request.WorkflowID, request.RunID)
+	categories := make([]tasks.Category, 0, len(request.Tasks))
+	for category := range request.Tasks {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID() < categories[j].ID() })
+	for _, category := range categories {
+		_, _ = fmt.Fprintf(hash, "%d:%d|", category.ID(), len(request.Tasks[category]))
+		for _, task := range request.Tasks[category] {
+			_, _ = fmt.Fprintf(hash, "%#v|", task)
+		}
+	}
+	var key historyTasksDedupKey
+	copy(key[:], hash.Sum(nil))
+	return key
+}
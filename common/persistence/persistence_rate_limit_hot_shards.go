@@ -0,0 +1,125 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hotShardMinScore is the score below which a shard is forgotten, as it is not hot any more
+const hotShardMinScore = 0.01
+
+type (
+	// ShardStat is the recent request count of a shard, see HotShards
+	ShardStat struct {
+		ShardID int32
+		// Score is the number of requests of the shard, each weighted down by half
+		// for every half life which passed since it was made
+		Score float64
+	}
+
+	// hotShardTracker counts the requests of each shard in exponentially decaying counters,
+	// so that the hottest shards are the ones with the most requests recently
+	hotShardTracker struct {
+		halfLife time.Duration
+
+		sync.Mutex
+		shards map[int32]*decayingCount
+	}
+
+	decayingCount struct {
+		value   float64
+		updated time.Time
+	}
+)
+
+func newHotShardTracker(halfLife time.Duration) *hotShardTracker {
+	if halfLife <= 0 {
+		return nil
+	}
+	return &hotShardTracker{
+		halfLife: halfLife,
+		shards:   make(map[int32]*decayingCount),
+	}
+}
+
+// record counts a request of the shard
+func (t *hotShardTracker) record(shardID int32, now time.Time) {
+	if shardID == CallerSegmentMissing {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	count, ok := t.shards[shardID]
+	if !ok {
+		t.shards[shardID] = &decayingCount{value: 1, updated: now}
+		return
+	}
+	count.value = decayedCount(count, t.halfLife, now) + 1
+	count.updated = now
+}
+
+// hotShards returns the k shards of the highest scores, highest first, forgetting
+// the shards whose score decayed away
+func (t *hotShardTracker) hotShards(k int, now time.Time) []ShardStat {
+	if k <= 0 {
+		return nil
+	}
+	t.Lock()
+	stats := make([]ShardStat, 0, len(t.shards))
+	for shardID, count := range t.shards {
+		score := decayedCount(count, t.halfLife, now)
+		if score < hotShardMinScore {
+			delete(t.shards, shardID)
+			continue
+		}
+		stats = append(stats, ShardStat{ShardID: shardID, Score: score})
+	}
+	t.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Score != stats[j].Score {
+			return stats[i].Score > stats[j].Score
+		}
+		return stats[i].ShardID < stats[j].ShardID
+	})
+	if len(stats) > k {
+		stats = stats[:k]
+	}
+	return stats
+}
+
+// decayedCount returns the value of the count decayed until now, halving every half life
+func decayedCount(count *decayingCount, halfLife time.Duration, now time.Time) float64 {
+	elapsed := now.Sub(count.updated)
+	if elapsed <= 0 {
+		return count.value
+	}
+	return count.value * math.Exp2(-float64(elapsed)/float64(halfLife))
+}
@@ -0,0 +1,89 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+)
+
+type (
+	// PersistenceMiddleware hooks into the persistence calls of rate limited persistence
+	// clients configured WithMiddleware, for cross-cutting concerns such as logging, tracing
+	// or circuit breaking. The hooks only run for requests admitted by the rate limiter.
+	PersistenceMiddleware interface {
+		// Before is called right before persistence is called for the operation. The returned
+		// context is passed on to the next middleware and persistence. If an error is returned,
+		// persistence is not called and the request fails with the error.
+		Before(ctx context.Context, operation string) (context.Context, error)
+		// After is called once persistence returned, or a later middleware failed the request
+		// in Before, with the error of the request
+		After(ctx context.Context, operation string, err error)
+	}
+
+	// AdmissionHook vetoes requests of rate limited persistence clients configured
+	// WithAdmissionHook by returning an error, which fails the request of the operation.
+	// The request is the one persistence would be called with, or nil for the operations
+	// which take none, such as those of the queue.
+	AdmissionHook func(ctx context.Context, operation string, request any) error
+
+	// middlewareCall tracks the middlewares entered by a request, whose After hooks
+	// are called in reverse order once the request completes
+	middlewareCall struct {
+		ctx     context.Context
+		entered []PersistenceMiddleware
+	}
+)
+
+// before calls the Before hooks of the middlewares in order, stopping at the first
+// failing one, in which case the After hooks of the middlewares entered so far are
+// called right away with its error
+func (e *rateLimitEnforcer) before(
+	ctx context.Context,
+	api string,
+	admission *rateLimitAdmission,
+) (context.Context, error) {
+	middlewares := e.options.middlewares
+	call := &middlewareCall{entered: make([]PersistenceMiddleware, 0, len(middlewares))}
+	for _, middleware := range middlewares {
+		next, err := middleware.Before(ctx, api)
+		if err != nil {
+			call.ctx = ctx
+			call.after(api, err)
+			return ctx, err
+		}
+		ctx = next
+		call.entered = append(call.entered, middleware)
+	}
+	call.ctx = ctx
+	admission.middlewares = call
+	return ctx, nil
+}
+
+// after calls the After hooks of the entered middlewares in reverse order
+func (c *middlewareCall) after(api string, err error) {
+	for i := len(c.entered) - 1; i >= 0; i-- {
+		c.entered[i].After(c.ctx, api, err)
+	}
+}
@@ -0,0 +1,144 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// NamespaceIOOverflow is the key of NamespaceIOBytes accumulating the I/O of the namespaces
+// beyond the max number of namespaces, see WithNamespaceIOAccounting
+const NamespaceIOOverflow = "_overflow"
+
+type (
+	// IOStats are the bytes a namespace wrote to and read from persistence, see NamespaceIOBytes
+	IOStats struct {
+		// Requests is the number of successful requests the bytes were accounted for
+		Requests int64
+		// RequestBytes is the size of the mutable state and history events written
+		RequestBytes int64
+		// ResponseBytes is the size of the mutable state read
+		ResponseBytes int64
+	}
+
+	// namespaceIOAccountant accumulates the I/O bytes of each namespace in atomic counters,
+	// so that the requests of a namespace already accounted for take no lock
+	namespaceIOAccountant struct {
+		maxNamespaces int
+
+		// namespaces maps namespace ID to its *namespaceIOCounters
+		namespaces sync.Map
+		// lock serializes adding namespaces, count is the number of namespaces added
+		lock  sync.Mutex
+		count int
+	}
+
+	namespaceIOCounters struct {
+		requests      atomic.Int64
+		requestBytes  atomic.Int64
+		responseBytes atomic.Int64
+	}
+)
+
+func newNamespaceIOAccountant(maxNamespaces int) *namespaceIOAccountant {
+	if maxNamespaces <= 0 {
+		return nil
+	}
+	return &namespaceIOAccountant{maxNamespaces: maxNamespaces}
+}
+
+// record adds the bytes of a successful request of the namespace
+func (a *namespaceIOAccountant) record(namespaceID string, requestBytes int, responseBytes int) {
+	if namespaceID == "" {
+		return
+	}
+	counters := a.counters(namespaceID)
+	counters.requests.Add(1)
+	counters.requestBytes.Add(int64(requestBytes))
+	counters.responseBytes.Add(int64(responseBytes))
+}
+
+// counters returns the counters of the namespace, adding them unless maxNamespaces namespaces
+// were added already, in which case the ones of NamespaceIOOverflow are returned
+func (a *namespaceIOAccountant) counters(namespaceID string) *namespaceIOCounters {
+	if counters, ok := a.namespaces.Load(namespaceID); ok {
+		return counters.(*namespaceIOCounters)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if counters, ok := a.namespaces.Load(namespaceID); ok {
+		return counters.(*namespaceIOCounters)
+	}
+	if a.count >= a.maxNamespaces {
+		namespaceID = NamespaceIOOverflow
+		if counters, ok := a.namespaces.Load(namespaceID); ok {
+			return counters.(*namespaceIOCounters)
+		}
+	} else {
+		a.count++
+	}
+	counters := &namespaceIOCounters{}
+	a.namespaces.Store(namespaceID, counters)
+	return counters
+}
+
+// stats returns the bytes accumulated by each namespace so far
+func (a *namespaceIOAccountant) stats() map[string]IOStats {
+	stats := make(map[string]IOStats)
+	a.namespaces.Range(func(namespaceID, counters any) bool {
+		c := counters.(*namespaceIOCounters)
+		stats[namespaceID.(string)] = IOStats{
+			Requests:      c.requests.Load(),
+			RequestBytes:  c.requestBytes.Load(),
+			ResponseBytes: c.responseBytes.Load(),
+		}
+		return true
+	})
+	return stats
+}
+
+// mutableStateIOBytes returns the bytes of the mutable state and of the history events
+// written or read along, as measured by persistence
+func mutableStateIOBytes(stats *MutableStateStatistics) int {
+	if stats == nil {
+		return 0
+	}
+	size := stats.TotalSize
+	if stats.HistoryStatistics != nil {
+		size += stats.HistoryStatistics.SizeDiff
+	}
+	return size
+}
+
+// NamespaceIOBytes returns the bytes each namespace wrote to and read from persistence through
+// the client since it was created, or nil unless enabled WithNamespaceIOAccounting
+func (e *rateLimitEnforcer) NamespaceIOBytes() map[string]IOStats {
+	if e.namespaceIO == nil {
+		return nil
+	}
+	return e.namespaceIO.stats()
+}
@@ -0,0 +1,108 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"time"
+
+	"go.temporal.io/server/common/quotas"
+)
+
+type (
+	// namespaceLimits are the rate limiters of the namespaces limited with SetNamespaceLimits. They
+	// are never updated in place but replaced as a whole, so that a request sees either the limits
+	// before or after a change, never a mix of both.
+	namespaceLimits struct {
+		rates        map[string]float64
+		rateLimiters map[string]*quotas.RateLimiterImpl
+	}
+)
+
+// newNamespaceLimits creates the rate limiters of the namespaces of positive rates. The rate
+// limiters of the previous limits are carried over, along with their tokens, for the namespaces
+// whose rate did not change, new ones are created at their full burst for the others.
+func newNamespaceLimits(rates map[string]float64, previous *namespaceLimits) *namespaceLimits {
+	limits := &namespaceLimits{
+		rates:        make(map[string]float64, len(rates)),
+		rateLimiters: make(map[string]*quotas.RateLimiterImpl, len(rates)),
+	}
+	for namespaceID, rate := range rates {
+		if rate <= 0 {
+			continue
+		}
+		limits.rates[namespaceID] = rate
+		if previous != nil && previous.rates[namespaceID] == rate {
+			limits.rateLimiters[namespaceID] = previous.rateLimiters[namespaceID]
+		} else {
+			limits.rateLimiters[namespaceID] = quotas.NewRateLimiter(rate, configProviderBurst(rate))
+		}
+	}
+	return limits
+}
+
+// SetNamespaceLimits replaces the rate limits of all namespaces at once, see NamespaceLimiter
+func (e *rateLimitEnforcer) SetNamespaceLimits(limits map[string]float64) {
+	e.namespaceLimitsLock.Lock()
+	defer e.namespaceLimitsLock.Unlock()
+	e.namespaceLimits.Store(newNamespaceLimits(limits, e.namespaceLimits.Load()))
+}
+
+// NamespaceLimits returns the rate limits of the namespaces set with SetNamespaceLimits
+func (e *rateLimitEnforcer) NamespaceLimits() map[string]float64 {
+	limits := e.namespaceLimits.Load()
+	if limits == nil {
+		return map[string]float64{}
+	}
+	rates := make(map[string]float64, len(limits.rates))
+	for namespaceID, rate := range limits.rates {
+		rates[namespaceID] = rate
+	}
+	return rates
+}
+
+// reserveNamespaceTokens takes the tokens of the request from the rate limiter of its namespace,
+// if it has one. The returned reservation, nil if the namespace is not limited, gives them back
+// if the request is rejected by the other limiters. The rate limiter of the namespace is returned
+// if it denied the request.
+func (e *rateLimitEnforcer) reserveNamespaceTokens(
+	namespaceID string,
+	token int,
+	now time.Time,
+) (quotas.Reservation, *quotas.RateLimiterImpl, error) {
+	limits := e.namespaceLimits.Load()
+	if limits == nil {
+		return nil, nil, nil
+	}
+	rateLimiter, ok := limits.rateLimiters[namespaceID]
+	if !ok {
+		return nil, nil, nil
+	}
+	reservation := rateLimiter.ReserveN(now, token)
+	if !reservation.OK() || reservation.DelayFrom(now) > 0 {
+		reservation.CancelAt(now)
+		return nil, rateLimiter, ErrPersistenceLimitExceeded
+	}
+	return reservation, nil, nil
+}
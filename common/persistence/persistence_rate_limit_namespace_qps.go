@@ -0,0 +1,114 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const namespaceQPSShards = 32
+
+type (
+	// namespaceQPSTracker counts the requests of each namespace in sharded counters, so that
+	// concurrent requests of different namespaces rarely contend, and aggregates the counts
+	// into per namespace QPS at most once per aggregation interval.
+	namespaceQPSTracker struct {
+		interval time.Duration
+		shards   [namespaceQPSShards]namespaceQPSShard
+
+		lock sync.Mutex
+		// intervalStart is when the counts of the current interval started accumulating
+		intervalStart time.Time
+		// qps is the per namespace QPS of the last completed interval
+		qps map[string]float64
+	}
+
+	namespaceQPSShard struct {
+		sync.Mutex
+		counts map[string]int64
+	}
+)
+
+func newNamespaceQPSTracker(
+	interval time.Duration,
+	now time.Time,
+) *namespaceQPSTracker {
+	if interval <= 0 {
+		return nil
+	}
+	tracker := &namespaceQPSTracker{
+		interval:      interval,
+		intervalStart: now,
+		qps:           make(map[string]float64),
+	}
+	for i := range tracker.shards {
+		tracker.shards[i].counts = make(map[string]int64)
+	}
+	return tracker
+}
+
+// record counts a request of the namespace
+func (t *namespaceQPSTracker) record(namespaceID string) {
+	if namespaceID == "" {
+		return
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(namespaceID))
+	shard := &t.shards[hash.Sum32()%namespaceQPSShards]
+	shard.Lock()
+	shard.counts[namespaceID]++
+	shard.Unlock()
+}
+
+// namespaceQPS returns the per namespace QPS of the last completed interval, aggregating
+// the counts of the current interval first if it is over
+func (t *namespaceQPSTracker) namespaceQPS(now time.Time) map[string]float64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if elapsed := now.Sub(t.intervalStart); elapsed >= t.interval {
+		qps := make(map[string]float64)
+		for i := range t.shards {
+			shard := &t.shards[i]
+			shard.Lock()
+			counts := shard.counts
+			shard.counts = make(map[string]int64, len(counts))
+			shard.Unlock()
+			for namespaceID, count := range counts {
+				qps[namespaceID] = float64(count) / elapsed.Seconds()
+			}
+		}
+		t.qps = qps
+		t.intervalStart = now
+	}
+
+	result := make(map[string]float64, len(t.qps))
+	for namespaceID, qps := range t.qps {
+		result[namespaceID] = qps
+	}
+	return result
+}
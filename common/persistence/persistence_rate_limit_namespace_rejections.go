@@ -0,0 +1,114 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sync"
+	"time"
+)
+
+// namespaceRejectionsMinCount is the count below which the rejections of a namespace are forgotten
+const namespaceRejectionsMinCount = 0.01
+
+type (
+	// RejectionStats are the recent rejections of the requests of a namespace, see NamespaceRejectionStats
+	RejectionStats struct {
+		// Rejections is the number of rejected requests, each weighted down by half
+		// for every half life which passed since it was rejected
+		Rejections float64
+		// LastRejectionTime is the time of the last rejected request, zero if there was none recently
+		LastRejectionTime time.Time
+	}
+
+	// namespaceRejectionTracker counts the rejected requests of each namespace in exponentially
+	// decaying counters, tracking up to maxNamespaces namespaces at a time
+	namespaceRejectionTracker struct {
+		halfLife      time.Duration
+		maxNamespaces int
+
+		sync.Mutex
+		namespaces map[string]*decayingCount
+	}
+)
+
+func newNamespaceRejectionTracker(halfLife time.Duration, maxNamespaces int) *namespaceRejectionTracker {
+	if halfLife <= 0 || maxNamespaces <= 0 {
+		return nil
+	}
+	return &namespaceRejectionTracker{
+		halfLife:      halfLife,
+		maxNamespaces: maxNamespaces,
+		namespaces:    make(map[string]*decayingCount),
+	}
+}
+
+// record counts a rejected request of the namespace. Once maxNamespaces namespaces are
+// tracked, the namespace of the fewest recent rejections is forgotten to make room for it.
+func (t *namespaceRejectionTracker) record(namespaceID string, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	count, ok := t.namespaces[namespaceID]
+	if ok {
+		count.value = decayedCount(count, t.halfLife, now) + 1
+		count.updated = now
+		return
+	}
+	if len(t.namespaces) >= t.maxNamespaces {
+		t.evictLocked(now)
+	}
+	t.namespaces[namespaceID] = &decayingCount{value: 1, updated: now}
+}
+
+// stats returns the recent rejections of the namespace, forgetting
+// the namespace if its rejections decayed away
+func (t *namespaceRejectionTracker) stats(namespaceID string, now time.Time) RejectionStats {
+	t.Lock()
+	defer t.Unlock()
+
+	count, ok := t.namespaces[namespaceID]
+	if !ok {
+		return RejectionStats{}
+	}
+	rejections := decayedCount(count, t.halfLife, now)
+	if rejections < namespaceRejectionsMinCount {
+		delete(t.namespaces, namespaceID)
+		return RejectionStats{}
+	}
+	return RejectionStats{Rejections: rejections, LastRejectionTime: count.updated}
+}
+
+// evictLocked forgets the namespace of the fewest recent rejections
+func (t *namespaceRejectionTracker) evictLocked(now time.Time) {
+	var coldest string
+	var coldestRejections float64
+	for namespaceID, count := range t.namespaces {
+		rejections := decayedCount(count, t.halfLife, now)
+		if coldest == "" || rejections < coldestRejections {
+			coldest, coldestRejections = namespaceID, rejections
+		}
+	}
+	delete(t.namespaces, coldest)
+}
@@ -0,0 +1,107 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"time"
+
+	"go.temporal.io/api/serviceerror"
+
+	"go.temporal.io/server/common/cache"
+)
+
+type (
+	// notFoundCache remembers for a short time the runs which GetWorkflowExecution found not to
+	// exist, so that callers retrying such reads in a loop neither consume tokens nor hit the
+	// store. Only reads of a specific run are cached, as a current run can be started any time.
+	notFoundCache struct {
+		ttl   time.Duration
+		cache cache.Cache
+	}
+
+	notFoundCacheKey struct {
+		namespaceID string
+		workflowID  string
+		runID       string
+	}
+
+	notFoundCacheEntry struct {
+		err    *serviceerror.NotFound
+		expiry time.Time
+	}
+)
+
+func newNotFoundCache(
+	ttl time.Duration,
+	maxSize int,
+) *notFoundCache {
+	if ttl <= 0 || maxSize <= 0 {
+		return nil
+	}
+	return &notFoundCache{
+		ttl:   ttl,
+		cache: cache.NewLRU(maxSize),
+	}
+}
+
+// get returns the cached NotFound error of the read, if any
+func (c *notFoundCache) get(now time.Time, request *GetWorkflowExecutionRequest) error {
+	key, ok := newNotFoundCacheKey(request.NamespaceID, request.WorkflowID, request.RunID)
+	if !ok {
+		return nil
+	}
+	entry, ok := c.cache.Get(key).(notFoundCacheEntry)
+	if !ok || !now.Before(entry.expiry) {
+		return nil
+	}
+	return entry.err
+}
+
+// put caches the result of the read if it is a NotFound error
+func (c *notFoundCache) put(now time.Time, request *GetWorkflowExecutionRequest, err error) {
+	notFound, ok := err.(*serviceerror.NotFound)
+	if !ok {
+		return
+	}
+	key, ok := newNotFoundCacheKey(request.NamespaceID, request.WorkflowID, request.RunID)
+	if !ok {
+		return
+	}
+	c.cache.Put(key, notFoundCacheEntry{err: notFound, expiry: now.Add(c.ttl)})
+}
+
+// invalidate forgets the run, which has just been created
+func (c *notFoundCache) invalidate(namespaceID string, workflowID string, runID string) {
+	if key, ok := newNotFoundCacheKey(namespaceID, workflowID, runID); ok {
+		c.cache.Delete(key)
+	}
+}
+
+func newNotFoundCacheKey(namespaceID string, workflowID string, runID string) (notFoundCacheKey, bool) {
+	if runID == "" {
+		return notFoundCacheKey{}, false
+	}
+	return notFoundCacheKey{namespaceID: namespaceID, workflowID: workflowID, runID: runID}, true
+}
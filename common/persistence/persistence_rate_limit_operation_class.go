@@ -0,0 +1,231 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sort"
+	"strings"
+)
+
+// The classes of persistence operations, summarizing them in metrics
+const (
+	operationClassReads   = "reads"
+	operationClassWrites  = "writes"
+	operationClassDeletes = "deletes"
+	operationClassScans   = "scans"
+	operationClassAdmin   = "admin"
+	operationClassUnknown = "unknown"
+)
+
+var (
+	// operationClasses maps the operations of the rate limited clients to their class. It is the
+	// single definition of the classes, from which both the operations throttled by a rate limiter
+	// of a class and the metrics aggregated by class are derived.
+	operationClasses = map[string]string{
+		"GetWorkflowExecution":         operationClassReads,
+		"GetCurrentExecution":          operationClassReads,
+		"ReadHistoryBranch":            operationClassReads,
+		"ReadHistoryBranchReverse":     operationClassReads,
+		"ReadHistoryBranchByBatch":     operationClassReads,
+		"ReadRawHistoryBranch":         operationClassReads,
+		"GetHistoryTree":               operationClassReads,
+		"GetHistoryTasks":              operationClassReads,
+		"GetReplicationTasksFromDLQ":   operationClassReads,
+		"IsReplicationDLQEmpty":        operationClassReads,
+		"GetOrCreateShard":             operationClassReads,
+		"AssertShardOwnership":         operationClassReads,
+		"GetTasks":                     operationClassReads,
+		"GetTaskQueue":                 operationClassReads,
+		"ListTaskQueue":                operationClassReads,
+		"GetTaskQueueUserData":         operationClassReads,
+		"ListTaskQueueUserDataEntries": operationClassReads,
+		"GetTaskQueuesByBuildId":       operationClassReads,
+		"CountTaskQueuesByBuildId":     operationClassReads,
+		"GetNamespace":                 operationClassReads,
+		"ReadMessages":                 operationClassReads,
+		"ReadMessagesFromDLQ":          operationClassReads,
+		"GetAckLevels":                 operationClassReads,
+		"GetDLQAckLevels":              operationClassReads,
+
+		"CreateWorkflowExecution":          operationClassWrites,
+		"UpdateWorkflowExecution":          operationClassWrites,
+		"ConflictResolveWorkflowExecution": operationClassWrites,
+		"SetWorkflowExecution":             operationClassWrites,
+		"AddHistoryTasks":                  operationClassWrites,
+		"PutReplicationTaskToDLQ":          operationClassWrites,
+		"AppendHistoryNodes":               operationClassWrites,
+		"AppendRawHistoryNodes":            operationClassWrites,
+		"ForkHistoryBranch":                operationClassWrites,
+		"UpdateShard":                      operationClassWrites,
+		"CreateTasks":                      operationClassWrites,
+		"CreateTaskQueue":                  operationClassWrites,
+		"UpdateTaskQueue":                  operationClassWrites,
+		"UpdateTaskQueueUserData":          operationClassWrites,
+		"EnqueueMessage":                   operationClassWrites,
+		"EnqueueMessageToDLQ":              operationClassWrites,
+		"UpdateAckLevel":                   operationClassWrites,
+		"UpdateDLQAckLevel":                operationClassWrites,
+
+		"DeleteWorkflowExecution":           operationClassDeletes,
+		"DeleteCurrentWorkflowExecution":    operationClassDeletes,
+		"CompleteHistoryTask":               operationClassDeletes,
+		"RangeCompleteHistoryTasks":         operationClassDeletes,
+		"DeleteReplicationTaskFromDLQ":      operationClassDeletes,
+		"RangeDeleteReplicationTaskFromDLQ": operationClassDeletes,
+		"DeleteHistoryBranch":               operationClassDeletes,
+		"TrimHistoryBranch":                 operationClassDeletes,
+		"CompleteTask":                      operationClassDeletes,
+		"CompleteTasksLessThan":             operationClassDeletes,
+		"DeleteTaskQueue":                   operationClassDeletes,
+		"DeleteMessagesBefore":              operationClassDeletes,
+		"DeleteMessageFromDLQ":              operationClassDeletes,
+		"RangeDeleteMessagesFromDLQ":        operationClassDeletes,
+
+		"ListConcreteExecutions":    operationClassScans,
+		"GetAllHistoryTreeBranches": operationClassScans,
+
+		"InitializeSystemNamespaces": operationClassAdmin,
+		"CreateNamespace":            operationClassAdmin,
+		"UpdateNamespace":            operationClassAdmin,
+		"RenameNamespace":            operationClassAdmin,
+		"DeleteNamespace":            operationClassAdmin,
+		"DeleteNamespaceByName":      operationClassAdmin,
+		"ListNamespaces":             operationClassAdmin,
+		"GetMetadata":                operationClassAdmin,
+		"ListClusterMetadata":        operationClassAdmin,
+		"GetCurrentClusterMetadata":  operationClassAdmin,
+		"GetClusterMetadata":         operationClassAdmin,
+		"SaveClusterMetadata":        operationClassAdmin,
+		"DeleteClusterMetadata":      operationClassAdmin,
+		"GetClusterMembers":          operationClassAdmin,
+		"UpsertClusterMembership":    operationClassAdmin,
+		"PruneClusterMembership":     operationClassAdmin,
+	}
+
+	// adminReadOperations are the operations of the admin class which do not write to persistence
+	adminReadOperations = map[string]struct{}{
+		"ListNamespaces":            {},
+		"GetMetadata":               {},
+		"ListClusterMetadata":       {},
+		"GetCurrentClusterMetadata": {},
+		"GetClusterMetadata":        {},
+		"GetClusterMembers":         {},
+	}
+
+	// readWriteOperations are the operations of the reads class which may write to persistence:
+	// GetOrCreateShard creates the shard row if it is missing
+	readWriteOperations = map[string]struct{}{
+		"GetOrCreateShard": {},
+	}
+
+	// drainExemptOperations are the writes which proceed while draining, see SetDrainMode, as
+	// holding them back would take the cluster down rather than quiesce it:
+	//   - GetOrCreateShard, which acquires shards, only writes for shards never acquired before
+	//   - UpsertClusterMembership, the membership heartbeats, without which hosts drop out of the ring
+	drainExemptOperations = map[string]struct{}{
+		"GetOrCreateShard":        {},
+		"UpsertClusterMembership": {},
+	}
+
+	// writeOperations tells for each operation of operationClasses whether it writes to persistence,
+	// which is derived once from the classes and reused by every request, see isWriteOperation
+	writeOperations = classifyWriteOperations()
+
+	// historyTaskOperations are the operations whose API is suffixed with the
+	// task category, see ConstructHistoryTaskAPI
+	historyTaskOperations = []string{
+		"GetHistoryTasks",
+		"CompleteHistoryTask",
+		"RangeCompleteHistoryTasks",
+	}
+)
+
+// operationClass returns the class of the operation
+func operationClass(api string) string {
+	if class, ok := operationClasses[api]; ok {
+		return class
+	}
+	for _, operation := range historyTaskOperations {
+		if strings.HasPrefix(api, operation) {
+			return operationClasses[operation]
+		}
+	}
+	return operationClassUnknown
+}
+
+// classifyWriteOperations tells for each operation whether it writes to persistence. Reads and
+// scans do not, except those of readWriteOperations, neither do the admin operations of
+// adminReadOperations, all others do.
+func classifyWriteOperations() map[string]bool {
+	writes := make(map[string]bool, len(operationClasses))
+	for operation, class := range operationClasses {
+		switch class {
+		case operationClassReads, operationClassScans:
+			_, write := readWriteOperations[operation]
+			writes[operation] = write
+		case operationClassAdmin:
+			_, read := adminReadOperations[operation]
+			writes[operation] = !read
+		default:
+			writes[operation] = true
+		}
+	}
+	return writes
+}
+
+// isWriteOperation tells whether the operation writes to persistence. Operations of an unknown
+// class are taken for writes, so that they are held back rather than let through while draining.
+func isWriteOperation(api string) bool {
+	if write, ok := writeOperations[api]; ok {
+		return write
+	}
+	for _, operation := range historyTaskOperations {
+		if strings.HasPrefix(api, operation) {
+			return writeOperations[operation]
+		}
+	}
+	return true
+}
+
+// isDrainedOperation tells whether the operation is held back while draining, which are
+// the writes but those of drainExemptOperations
+func isDrainedOperation(api string) bool {
+	if _, exempt := drainExemptOperations[api]; exempt {
+		return false
+	}
+	return isWriteOperation(api)
+}
+
+// operationsOfClass returns the operations of the class, sorted
+func operationsOfClass(class string) []string {
+	var operations []string
+	for operation, operationClass := range operationClasses {
+		if operationClass == class {
+			operations = append(operations, operation)
+		}
+	}
+	sort.Strings(operations)
+	return operations
+}
@@ -0,0 +1,138 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go.temporal.io/server/common/metrics"
+)
+
+type (
+	// operationOutcomeCounters counts the requests of an operation by outcome, see OperationOutcomes
+	operationOutcomeCounters struct {
+		succeeded        atomic.Int64
+		rejected         atomic.Int64
+		downstreamFailed atomic.Int64
+		// tags are the tags of the outcome metrics of the operation
+		tags []metrics.Tag
+	}
+
+	// operationOutcomes holds the operationOutcomeCounters of every operation of operationClasses,
+	// built when the client is created, and the ones of the other operations completed so far.
+	// Every request goes through them, so they are only read when the stats are snapshotted and
+	// counting an outcome takes no lock shared by all the requests of the client.
+	operationOutcomes struct {
+		storeTag metrics.Tag
+
+		operations map[string]*operationOutcomeCounters
+
+		otherOperationsLock sync.RWMutex
+		otherOperations     map[string]*operationOutcomeCounters
+	}
+)
+
+func newOperationOutcomes(storeName string) *operationOutcomes {
+	outcomes := &operationOutcomes{
+		storeTag:        metrics.StoreTag(storeName),
+		operations:      make(map[string]*operationOutcomeCounters, len(operationClasses)),
+		otherOperations: make(map[string]*operationOutcomeCounters),
+	}
+	for api := range operationClasses {
+		outcomes.operations[api] = outcomes.newOperationOutcomeCounters(api)
+	}
+	return outcomes
+}
+
+// operation returns the operationOutcomeCounters of the operation, which are built on
+// the first request of operations missing from operationClasses
+func (o *operationOutcomes) operation(api string) *operationOutcomeCounters {
+	if counters, ok := o.operations[api]; ok {
+		return counters
+	}
+	o.otherOperationsLock.RLock()
+	counters, ok := o.otherOperations[api]
+	o.otherOperationsLock.RUnlock()
+	if ok {
+		return counters
+	}
+
+	o.otherOperationsLock.Lock()
+	defer o.otherOperationsLock.Unlock()
+	if counters, ok := o.otherOperations[api]; ok {
+		return counters
+	}
+	counters = o.newOperationOutcomeCounters(api)
+	o.otherOperations[api] = counters
+	return counters
+}
+
+func (o *operationOutcomes) newOperationOutcomeCounters(api string) *operationOutcomeCounters {
+	return &operationOutcomeCounters{
+		tags: []metrics.Tag{metrics.OperationTag(api), o.storeTag},
+	}
+}
+
+// snapshot returns the outcomes of the operations which had any request
+func (o *operationOutcomes) snapshot() map[string]OperationOutcomes {
+	snapshot := make(map[string]OperationOutcomes)
+	add := func(api string, counters *operationOutcomeCounters) {
+		outcomes := OperationOutcomes{
+			Succeeded:        counters.succeeded.Load(),
+			Rejected:         counters.rejected.Load(),
+			DownstreamFailed: counters.downstreamFailed.Load(),
+		}
+		if outcomes != (OperationOutcomes{}) {
+			snapshot[api] = outcomes
+		}
+	}
+	for api, counters := range o.operations {
+		add(api, counters)
+	}
+	o.otherOperationsLock.RLock()
+	defer o.otherOperationsLock.RUnlock()
+	for api, counters := range o.otherOperations {
+		add(api, counters)
+	}
+	return snapshot
+}
+
+// reset zeroes the outcomes of all operations, requests completing meanwhile may or may not be counted
+func (o *operationOutcomes) reset() {
+	reset := func(counters *operationOutcomeCounters) {
+		counters.succeeded.Store(0)
+		counters.rejected.Store(0)
+		counters.downstreamFailed.Store(0)
+	}
+	for _, counters := range o.operations {
+		reset(counters)
+	}
+	o.otherOperationsLock.RLock()
+	defer o.otherOperationsLock.RUnlock()
+	for _, counters := range o.otherOperations {
+		reset(counters)
+	}
+}
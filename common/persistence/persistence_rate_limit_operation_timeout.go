@@ -0,0 +1,82 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.temporal.io/server/common/metrics"
+)
+
+type (
+	// operationTimeout bounds the persistence call of an admitted request, see WithOperationTimeout
+	operationTimeout struct {
+		timeout time.Duration
+		// parent is the context of the request, ctx the one derived from it with the timeout
+		parent context.Context
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+)
+
+// withOperationTimeout derives the context of the persistence call of the request from its
+// context, with the timeout of its operation if it has one
+func (e *rateLimitEnforcer) withOperationTimeout(
+	ctx context.Context,
+	api string,
+	admission *rateLimitAdmission,
+) context.Context {
+	timeout, ok := e.options.operationTimeouts[api]
+	if !ok {
+		return ctx
+	}
+	derived, cancel := context.WithTimeout(ctx, timeout)
+	admission.timeout = &operationTimeout{
+		timeout: timeout,
+		parent:  ctx,
+		ctx:     derived,
+		cancel:  cancel,
+	}
+	return derived
+}
+
+// stop releases the context of the persistence call. A failed call is reported as exceeding
+// its timeout if the timeout cut it off, rather than the context of the request, whichever
+// error persistence returned for it.
+func (t *operationTimeout) stop(e *rateLimitEnforcer, api string, err error) error {
+	timedOut := err != nil && t.ctx.Err() == context.DeadlineExceeded && t.parent.Err() == nil
+	t.cancel()
+	if !timedOut {
+		return err
+	}
+	e.metricsHandler.Counter(metrics.PersistenceOperationTimeouts.GetMetricName()).Record(
+		1,
+		metrics.OperationTag(api),
+		metrics.StoreTag(e.storeName()),
+	)
+	return fmt.Errorf("persistence operation %s exceeded its timeout of %v: %w", api, t.timeout, context.DeadlineExceeded)
+}
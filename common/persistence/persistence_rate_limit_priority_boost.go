@@ -0,0 +1,55 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+
+	"go.temporal.io/server/common/quotas"
+)
+
+type (
+	priorityBoostContextKey struct{}
+)
+
+// WithPriorityBoostHint returns a context asking rate limited persistence clients to admit the
+// requests made under it with the highest priority, e.g. for a workflow about to hit a user facing
+// timeout. The hint is ignored by clients not configured WithPriorityBoostBudget, and only honored
+// within the budget, beyond which requests are admitted with their usual priority.
+func WithPriorityBoostHint(ctx context.Context) context.Context {
+	return context.WithValue(ctx, priorityBoostContextKey{}, struct{}{})
+}
+
+// boostPriority marks the request as boosted if its context carries the priority boost
+// hint and the boost budget has a token left for it
+func (e *rateLimitEnforcer) boostPriority(ctx context.Context, request *quotas.Request) {
+	budget := e.options.priorityBoostBudget
+	if budget == nil || ctx.Value(priorityBoostContextKey{}) == nil {
+		return
+	}
+	// the token of the budget is consumed even if the request ends up rejected,
+	// as it claimed the capacity of the highest priority regardless
+	request.Boosted = budget.AllowN(e.timeSource.Now(), request.Token)
+}
@@ -0,0 +1,81 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"sync"
+
+	"go.temporal.io/server/common/quotas"
+)
+
+type (
+	priorityInheritanceContextKey struct{}
+
+	// priorityInheritanceScope holds the priority of the first request of a logical operation
+	priorityInheritanceScope struct {
+		sync.Mutex
+		started  bool
+		priority int
+	}
+)
+
+// WithPriorityInheritanceScope returns a context scoping a logical operation made of chained
+// persistence calls, e.g. a read leading to a conflict resolution. Rate limited persistence
+// clients configured WithPriorityInheritance admit the follow-up requests made under it with the
+// priority of the first one, unless theirs is higher. Scoping a context which is already scoped
+// keeps the outer scope, so nested operations share the priority of the outermost one.
+func WithPriorityInheritanceScope(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(priorityInheritanceContextKey{}).(*priorityInheritanceScope); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, priorityInheritanceContextKey{}, &priorityInheritanceScope{})
+}
+
+// inheritPriority records the priority of the request if it is the first of the scope of its
+// context, or has it inherit the recorded one otherwise. Boosts are granted per request, so the
+// priority of the first request is recorded without its boost.
+func (e *rateLimitEnforcer) inheritPriority(ctx context.Context, request *quotas.Request) {
+	priorityFn := e.options.priorityInheritanceFn
+	if priorityFn == nil || !e.options.priorityInheritanceEnabled() {
+		return
+	}
+	scope, ok := ctx.Value(priorityInheritanceContextKey{}).(*priorityInheritanceScope)
+	if !ok {
+		return
+	}
+
+	scope.Lock()
+	defer scope.Unlock()
+	if !scope.started {
+		first := *request
+		first.Boosted = false
+		scope.started = true
+		scope.priority = priorityFn(first)
+		return
+	}
+	request.InheritsPriority = true
+	request.InheritedPriority = scope.priority
+}
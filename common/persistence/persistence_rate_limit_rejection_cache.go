@@ -0,0 +1,150 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"strconv"
+	"sync"
+
+	"go.temporal.io/server/common/metrics"
+)
+
+// maxCachedNamespaceRejectionTags caps the number of operation and namespace pairs whose
+// rejection metric tags are cached, the tags of further pairs are built on every rejection
+const maxCachedNamespaceRejectionTags = 4096
+
+type (
+	// operationRejection is what rejecting a request of an operation takes: the error it fails with
+	// and the tags of the rejection metrics. Rejections pile up exactly when persistence is overloaded,
+	// so these are built once rather than on every rejection, to keep the rejection path free of
+	// allocations and spare the GC during incidents.
+	operationRejection struct {
+		err error
+		// storeTags, operationTags, classTags and sloTags are the tags of the total, per operation,
+		// per operation class and SLO rejection metrics
+		storeTags     []metrics.Tag
+		operationTags []metrics.Tag
+		classTags     []metrics.Tag
+		sloTags       []metrics.Tag
+	}
+
+	// rejectionCache holds the operationRejection of every operation of operationClasses, built
+	// when the client is created, the ones of the other operations rejected so far, e.g. the per
+	// task category history task operations, and the tags of the per namespace rejection metric
+	// of the namespaces rejected so far
+	rejectionCache struct {
+		options  *rateLimitedClientOptions
+		storeTag metrics.Tag
+
+		operations map[string]*operationRejection
+
+		otherOperationsLock sync.RWMutex
+		otherOperations     map[string]*operationRejection
+
+		namespaceTagsLock sync.RWMutex
+		namespaceTags     map[namespaceRejectionKey][]metrics.Tag
+	}
+
+	namespaceRejectionKey struct {
+		api         string
+		namespaceID string
+	}
+)
+
+func newRejectionCache(storeName string, options *rateLimitedClientOptions) *rejectionCache {
+	cache := &rejectionCache{
+		options:         options,
+		storeTag:        metrics.StoreTag(storeName),
+		operations:      make(map[string]*operationRejection, len(operationClasses)),
+		otherOperations: make(map[string]*operationRejection),
+		namespaceTags:   make(map[namespaceRejectionKey][]metrics.Tag),
+	}
+	for api := range operationClasses {
+		cache.operations[api] = cache.newOperationRejection(api)
+	}
+	return cache
+}
+
+// operation returns the operationRejection of the operation, which is built on
+// the first rejection of operations missing from operationClasses
+func (c *rejectionCache) operation(api string) *operationRejection {
+	if rejection, ok := c.operations[api]; ok {
+		return rejection
+	}
+	c.otherOperationsLock.RLock()
+	rejection, ok := c.otherOperations[api]
+	c.otherOperationsLock.RUnlock()
+	if ok {
+		return rejection
+	}
+
+	c.otherOperationsLock.Lock()
+	defer c.otherOperationsLock.Unlock()
+	if rejection, ok := c.otherOperations[api]; ok {
+		return rejection
+	}
+	rejection = c.newOperationRejection(api)
+	c.otherOperations[api] = rejection
+	return rejection
+}
+
+func (c *rejectionCache) newOperationRejection(api string) *operationRejection {
+	err := ErrPersistenceLimitExceeded
+	if c.options.errorFactory != nil {
+		if factoryErr := c.options.errorFactory(api); factoryErr != nil {
+			err = factoryErr
+		}
+	}
+	operationTag := metrics.OperationTag(api)
+	return &operationRejection{
+		err:           err,
+		storeTags:     []metrics.Tag{c.storeTag},
+		operationTags: []metrics.Tag{operationTag, c.storeTag},
+		classTags:     []metrics.Tag{metrics.StringTag(operationClassTagName, operationClass(api)), c.storeTag},
+		sloTags: []metrics.Tag{
+			operationTag,
+			metrics.StringTag(availabilityImpactingTagName, strconv.FormatBool(c.options.isAvailabilityImpacting(api))),
+		},
+	}
+}
+
+// namespaceRejectionTags returns the tags of the per namespace rejection metric of the operation
+func (c *rejectionCache) namespaceRejectionTags(api string, namespaceID string) []metrics.Tag {
+	key := namespaceRejectionKey{api: api, namespaceID: namespaceID}
+	c.namespaceTagsLock.RLock()
+	tags, ok := c.namespaceTags[key]
+	c.namespaceTagsLock.RUnlock()
+	if ok {
+		return tags
+	}
+
+	tags = []metrics.Tag{metrics.OperationTag(api), metrics.NamespaceIDTag(namespaceID), c.storeTag}
+	c.namespaceTagsLock.Lock()
+	defer c.namespaceTagsLock.Unlock()
+	if len(c.namespaceTags) < maxCachedNamespaceRejectionTags {
+		c.namespaceTags[key] = tags
+	}
+	return tags
+}
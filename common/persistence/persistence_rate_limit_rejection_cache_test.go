@@ -0,0 +1,80 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.temporal.io/server/common/log"
+	"go.temporal.io/server/common/quotas"
+	"go.temporal.io/server/service/history/tasks"
+)
+
+// TestRejectionCache_RejectWithoutAllocations checks that rejections are free of allocations, as
+// they pile up when persistence is overloaded. The request context carries no caller headers,
+// whose lookup allocates for admitted requests as much as for rejected ones.
+func TestRejectionCache_RejectWithoutAllocations(t *testing.T) {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0))
+	enforcer := newRateLimitEnforcer(rateLimiter, func() string { return "test" }, log.NewNoopLogger(), nil)
+	ctx := context.Background()
+
+	for _, api := range []string{
+		"GetWorkflowExecution",
+		// the history task operations are named by task category at runtime
+		ConstructHistoryTaskAPI("GetHistoryTasks", tasks.CategoryTransfer),
+	} {
+		reject := func() {
+			_, _, err := enforcer.admit(ctx, api, nil, 1, "ns-1")
+			require.Equal(t, ErrPersistenceLimitExceeded, err)
+		}
+		// the first rejection of the namespace caches its metric tags
+		reject()
+		require.Zero(t, testing.AllocsPerRun(100, reject), api)
+	}
+}
+
+func TestRejectionCache_ErrorFactoryOncePerOperation(t *testing.T) {
+	errUnavailable := errors.New("persistence is busy")
+	calls := make(map[string]int)
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0))
+	enforcer := newRateLimitEnforcer(rateLimiter, func() string { return "test" }, log.NewNoopLogger(), []RateLimitedClientOption{
+		WithRejectionError(func(operation string) error {
+			calls[operation]++
+			return errUnavailable
+		}),
+	})
+	api := ConstructHistoryTaskAPI("GetHistoryTasks", tasks.CategoryTransfer)
+
+	for i := 0; i < 3; i++ {
+		_, _, err := enforcer.admit(context.Background(), api, nil, 1, "ns-1")
+		require.Equal(t, errUnavailable, err)
+	}
+	require.Equal(t, 1, calls[api])
+	require.Equal(t, 1, calls["GetWorkflowExecution"])
+}
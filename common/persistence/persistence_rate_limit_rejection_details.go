@@ -0,0 +1,124 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/gogo/status"
+	"google.golang.org/grpc/codes"
+
+	"go.temporal.io/api/errordetails/v1"
+	"go.temporal.io/api/serviceerror"
+)
+
+const (
+	// RateLimitTierGlobal is the tier of the global rate limiter of a RateLimitConfigProvider
+	RateLimitTierGlobal = "global"
+	// RateLimitTierNamespace is the tier of the per namespace rate limiters of a RateLimitConfigProvider
+	RateLimitTierNamespace = "namespace"
+	// RateLimitTierConcurrency is the tier of the concurrency limits, e.g. of WithHistoryForkLimits
+	RateLimitTierConcurrency = "concurrency"
+
+	rejectionDetailsTierField  = "persistenceRateLimitTier"
+	rejectionDetailsLimitField = "persistenceRateLimitLimit"
+)
+
+type (
+	// RateLimitRejectionDetails tells which limiter tier denied a request, attached to
+	// the rejection error by clients created WithRejectionDetails
+	RateLimitRejectionDetails struct {
+		// Tier is RateLimitTierGlobal, RateLimitTierNamespace, RateLimitTierConcurrency,
+		// or the name of the rate limiter of the operation otherwise, e.g. main or scan
+		Tier string
+		// Limit is the configured limit of the tier, in requests per second for rate limiters
+		// and in requests in flight for concurrency limits, or 0 if it is not known
+		Limit float64
+	}
+)
+
+// withRejectionDetails attaches the details to the rejection error, which stays a
+// *serviceerror.ResourceExhausted of the same cause so that callers classify it as before.
+// Errors of other types, e.g. returned by the factory of WithRejectionError, are left as is.
+func withRejectionDetails(err error, details RateLimitRejectionDetails) error {
+	resourceExhausted, ok := err.(*serviceerror.ResourceExhausted)
+	if !ok {
+		return err
+	}
+	st, detailsErr := status.New(codes.ResourceExhausted, resourceExhausted.Message+" "+details.String()).WithDetails(
+		&errordetails.ResourceExhaustedFailure{
+			Cause: resourceExhausted.Cause,
+		},
+		&types.Struct{
+			Fields: map[string]*types.Value{
+				rejectionDetailsTierField:  {Kind: &types.Value_StringValue{StringValue: details.Tier}},
+				rejectionDetailsLimitField: {Kind: &types.Value_NumberValue{NumberValue: details.Limit}},
+			},
+		},
+	)
+	if detailsErr != nil {
+		return err
+	}
+	return serviceerror.FromStatus(st)
+}
+
+// RejectionDetailsFromError returns the details attached to a rejection error by a client
+// created WithRejectionDetails, which are kept when the error is sent over gRPC
+func RejectionDetailsFromError(err error) (RateLimitRejectionDetails, bool) {
+	var resourceExhausted *serviceerror.ResourceExhausted
+	if !errors.As(err, &resourceExhausted) {
+		return RateLimitRejectionDetails{}, false
+	}
+	for _, detail := range resourceExhausted.Status().Details() {
+		fields, ok := detail.(*types.Struct)
+		if !ok {
+			continue
+		}
+		tier, tierOK := fields.Fields[rejectionDetailsTierField]
+		limit, limitOK := fields.Fields[rejectionDetailsLimitField]
+		if tierOK && limitOK {
+			return RateLimitRejectionDetails{
+				Tier:  tier.GetStringValue(),
+				Limit: limit.GetNumberValue(),
+			}, true
+		}
+	}
+	return RateLimitRejectionDetails{}, false
+}
+
+// String describes the denying tier, e.g. "Denied by the namespace limiter of 2rps."
+func (d RateLimitRejectionDetails) String() string {
+	switch {
+	case d.Limit <= 0:
+		return fmt.Sprintf("Denied by the %s limiter.", d.Tier)
+	case d.Tier == RateLimitTierConcurrency:
+		return fmt.Sprintf("Denied by the %s limiter of %s requests in flight.", d.Tier, strconv.FormatFloat(d.Limit, 'f', -1, 64))
+	default:
+		return fmt.Sprintf("Denied by the %s limiter of %s.", d.Tier, formatRPS(d.Limit))
+	}
+}
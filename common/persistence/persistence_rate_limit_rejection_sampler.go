@@ -0,0 +1,72 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sync"
+)
+
+type (
+	// rejectionSampler bounds the rate at which the per operation and namespace rejection
+	// metrics are emitted, by emitting only every nth rejection of each tag set, with a
+	// value of n so that the metrics add up. The rejections since the last emission of a
+	// tag set are only accounted for by the aggregate rejection metric.
+	rejectionSampler struct {
+		every int64
+
+		sync.Mutex
+		pending map[rejectionSampleKey]int64
+	}
+
+	rejectionSampleKey struct {
+		api         string
+		namespaceID string
+	}
+)
+
+func newRejectionSampler(every int) *rejectionSampler {
+	if every <= 1 {
+		return nil
+	}
+	return &rejectionSampler{
+		every:   int64(every),
+		pending: make(map[rejectionSampleKey]int64),
+	}
+}
+
+// sample records a rejection of the tag set, returning the number of rejections
+// to emit for it, if the rejection is sampled
+func (s *rejectionSampler) sample(api string, namespaceID string) (int64, bool) {
+	key := rejectionSampleKey{api: api, namespaceID: namespaceID}
+
+	s.Lock()
+	defer s.Unlock()
+	s.pending[key]++
+	if s.pending[key] < s.every {
+		return 0, false
+	}
+	delete(s.pending, key)
+	return s.every, true
+}
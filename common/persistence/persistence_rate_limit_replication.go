@@ -0,0 +1,57 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+)
+
+type (
+	replicationApplyContextKey struct{}
+)
+
+var (
+	// replicationApplyOperations are the operations through which replication applies
+	// events, which may bypass rate limiting, see WithReplicationBypass
+	replicationApplyOperations = map[string]struct{}{
+		"AppendHistoryNodes":               {},
+		"ConflictResolveWorkflowExecution": {},
+	}
+)
+
+// WithReplicationApply returns a context marking the persistence requests made under it as
+// applying replicated events, which rate limited persistence clients configured
+// WithReplicationBypass let through
+func WithReplicationApply(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicationApplyContextKey{}, struct{}{})
+}
+
+// isReplicationApply reports whether the request of the operation applies replicated events
+func isReplicationApply(ctx context.Context, api string) bool {
+	if _, ok := replicationApplyOperations[api]; !ok {
+		return false
+	}
+	return ctx.Value(replicationApplyContextKey{}) != nil
+}
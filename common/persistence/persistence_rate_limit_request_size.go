@@ -0,0 +1,60 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/api/serviceerror"
+
+	"go.temporal.io/server/common/metrics"
+)
+
+// ErrRequestTooLarge is the error indicating the request exceeds the max size of its operation,
+// see WithMaxRequestSize
+var ErrRequestTooLarge = serviceerror.NewInvalidArgument("Persistence request exceeds the max request size of the operation.")
+
+// checkRequestSize fails with ErrRequestTooLarge if the size of the request exceeds the max
+// request size of the operation. The size is only computed if the operation has a max size.
+func (e *rateLimitEnforcer) checkRequestSize(api string, size func() int) error {
+	maxSize, ok := e.options.maxRequestSizes[api]
+	if !ok || size() <= maxSize {
+		return nil
+	}
+	e.metricsHandler.Counter(metrics.PersistenceRequestTooLarge.GetMetricName()).Record(
+		1,
+		metrics.OperationTag(api),
+		metrics.StoreTag(e.storeName()),
+	)
+	return ErrRequestTooLarge
+}
+
+// historyEventsSize returns the serialized size of the history events
+func historyEventsSize(events []*historypb.HistoryEvent) int {
+	size := 0
+	for _, event := range events {
+		size += event.Size()
+	}
+	return size
+}
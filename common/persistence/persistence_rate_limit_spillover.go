@@ -0,0 +1,154 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+
+	"go.temporal.io/server/common/log"
+	"go.temporal.io/server/common/metrics"
+	"go.temporal.io/server/common/quotas"
+)
+
+// spilloverReadOperations are the reads of the execution client served by its spillover by default,
+// see NewExecutionPersistenceRateLimitedClientWithSpillover
+var spilloverReadOperations = map[string]struct{}{
+	"GetWorkflowExecution":     {},
+	"GetCurrentExecution":      {},
+	"ReadHistoryBranch":        {},
+	"ReadHistoryBranchReverse": {},
+	"ReadHistoryBranchByBatch": {},
+	"ReadRawHistoryBranch":     {},
+	"GetHistoryTree":           {},
+}
+
+// NewExecutionPersistenceRateLimitedClientWithSpillover creates a client to manage executions whose
+// reads rejected by the rate limiter are served by the spillover, e.g. a read-only replica, rather
+// than failing, for read heavy disaster scenarios. The reads which spill over are the operations for
+// which isSpilloverRead returns true, among GetWorkflowExecution, GetCurrentExecution and the reads
+// of histories, or all of these if it is nil. Writes never spill over. Reads spilling over are
+// throttled by the rate limiter set WithSpilloverRateLimiter, if any, and fail with the rejection
+// when it denies them. They go through the same accounting as the reads served by persistence,
+// except that their response size is charged to the spillover rate limiter, and they are counted
+// as rejections of the client rather than in its outcomes, nor against its rate limiters. The
+// client closes the spillover when closed. The spillover is ignored if nil.
+func NewExecutionPersistenceRateLimitedClientWithSpillover(
+	persistence ExecutionManager,
+	spillover ExecutionManager,
+	isSpilloverRead func(operation string) bool,
+	rateLimiter quotas.RequestRateLimiter,
+	logger log.Logger,
+	opts ...RateLimitedClientOption,
+) ExecutionManager {
+	client := NewExecutionPersistenceRateLimitedClient(persistence, rateLimiter, logger, opts...).(*executionRateLimitedPersistenceClient)
+	if spillover == nil {
+		return client
+	}
+	if isSpilloverRead == nil {
+		isSpilloverRead = func(operation string) bool {
+			_, ok := spilloverReadOperations[operation]
+			return ok
+		}
+	}
+	client.spillover = spillover
+	client.isSpilloverRead = isSpilloverRead
+	return client
+}
+
+// admitRead is admit for the reads which may spill over, returning the manager to serve the
+// read with, which is the spillover if the read was rejected and spills over
+func (p *executionRateLimitedPersistenceClient) admitRead(
+	ctx context.Context,
+	api string,
+	request any,
+	shardID int32,
+	namespaceID string,
+) (context.Context, rateLimitAdmission, ExecutionManager, error) {
+	ctx, admission, err := p.admit(ctx, api, request, shardID, namespaceID)
+	if err == nil {
+		return ctx, admission, p.executionManager(), nil
+	}
+	if !p.spillsOver(api, err) || !p.admitSpillover(ctx, api, shardID, namespaceID) {
+		return ctx, admission, nil, err
+	}
+	p.metricsHandler.Counter(metrics.PersistenceSpilloverRequests.GetMetricName()).Record(
+		1,
+		metrics.OperationTag(api),
+		metrics.StoreTag(p.storeName()),
+	)
+	return ctx, rateLimitAdmission{enforcer: p.rateLimitEnforcer, api: api, spilledOver: true}, p.spillover, nil
+}
+
+// spillsOver tells whether the read failing with the error is to be served by the spillover,
+// which is only the case for the spillover reads rejected by the rate limiter
+func (p *executionRateLimitedPersistenceClient) spillsOver(api string, err error) bool {
+	return p.spillover != nil && !isWriteOperation(api) && p.isSpilloverRead(api) && p.isRejection(api, err)
+}
+
+// admitSpillover decides whether a read rejected by the rate limiter may be served by the
+// spillover, which is the case unless the spillover rate limiter denies it
+func (e *rateLimitEnforcer) admitSpillover(
+	ctx context.Context,
+	api string,
+	shardID int32,
+	namespaceID string,
+) bool {
+	allowed := e.options.spilloverRateLimiter == nil || e.options.spilloverRateLimiter.Allow(
+		e.timeSource.Now(),
+		newRateLimitRequest(ctx, api, RateLimitDefaultToken, shardID),
+	)
+	if e.decisions != nil {
+		e.decisions.record(AdmissionDecision{
+			Time:        e.timeSource.Now(),
+			Operation:   api,
+			NamespaceID: namespaceID,
+			Allowed:     allowed,
+			Tokens:      RateLimitDefaultToken,
+			SpilledOver: true,
+		})
+	}
+	return allowed
+}
+
+// chargeAdmitted is charge for an admitted request, charging the spillover rate limiter, if
+// any, rather than the rate limiter of the operation for the reads served by the spillover
+func (e *rateLimitEnforcer) chargeAdmitted(
+	ctx context.Context,
+	admission rateLimitAdmission,
+	shardID int32,
+	token int,
+) {
+	if !admission.spilledOver {
+		e.charge(ctx, admission.api, shardID, token)
+		return
+	}
+	if e.options.spilloverRateLimiter == nil || token <= 0 {
+		return
+	}
+	_ = e.options.spilloverRateLimiter.Reserve(
+		e.timeSource.Now(),
+		newRateLimitRequest(ctx, admission.api, token, shardID),
+	)
+}
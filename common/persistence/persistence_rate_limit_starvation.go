@@ -0,0 +1,132 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sync"
+	"time"
+
+	"go.temporal.io/server/common/metrics"
+)
+
+type (
+	// starvationTracker measures how long the rate limiters stayed out of tokens for the requests of
+	// each operation class. A class starves from the first of its requests to be rejected until the
+	// next one of its requests is admitted.
+	starvationTracker struct {
+		classes map[string]*classStarvation
+	}
+
+	classStarvation struct {
+		// tags are the metric tags of the class, built once so that recording them does not allocate
+		tags []metrics.Tag
+
+		sync.Mutex
+		// starvedSince is when the current starvation started, zero if the class is not starved
+		starvedSince time.Time
+		// starved is the time spent starving by the starvations which ended
+		starved time.Duration
+	}
+)
+
+func newStarvationTracker(enabled bool, storeTag metrics.Tag) *starvationTracker {
+	if !enabled {
+		return nil
+	}
+	tracker := &starvationTracker{classes: make(map[string]*classStarvation)}
+	for _, class := range []string{
+		operationClassReads,
+		operationClassWrites,
+		operationClassDeletes,
+		operationClassScans,
+		operationClassAdmin,
+		operationClassUnknown,
+	} {
+		tracker.classes[class] = &classStarvation{
+			tags: []metrics.Tag{metrics.StringTag(operationClassTagName, class), storeTag},
+		}
+	}
+	return tracker
+}
+
+// rejected starts the starvation of the class of the operation, unless it is already starved
+func (t *starvationTracker) rejected(now time.Time, api string) {
+	class := t.classes[operationClass(api)]
+	class.Lock()
+	if class.starvedSince.IsZero() {
+		class.starvedSince = now
+	}
+	class.Unlock()
+}
+
+// admitted ends the starvation of the class of the operation, returning how long it lasted
+// and the metric tags of the class, or zero if the class was not starved
+func (t *starvationTracker) admitted(now time.Time, api string) (time.Duration, []metrics.Tag) {
+	class := t.classes[operationClass(api)]
+	class.Lock()
+	defer class.Unlock()
+
+	if class.starvedSince.IsZero() {
+		return 0, nil
+	}
+	starved := now.Sub(class.starvedSince)
+	class.starved += starved
+	class.starvedSince = time.Time{}
+	return starved, class.tags
+}
+
+// durations returns the time each operation class spent starving so far, the ongoing starvations included
+func (t *starvationTracker) durations(now time.Time) map[string]time.Duration {
+	durations := make(map[string]time.Duration, len(t.classes))
+	for name, class := range t.classes {
+		class.Lock()
+		starved := class.starved
+		if !class.starvedSince.IsZero() {
+			starved += now.Sub(class.starvedSince)
+		}
+		class.Unlock()
+		if starved > 0 {
+			durations[name] = starved
+		}
+	}
+	return durations
+}
+
+// recordStarvationEnd emits how long the class of an admitted request starved before it, if it did
+func (e *rateLimitEnforcer) recordStarvationEnd(now time.Time, api string) {
+	if starved, tags := e.starvation.admitted(now, api); starved > 0 {
+		e.metricsHandler.Timer(metrics.PersistenceRateLimitStarvation.GetMetricName()).Record(starved, tags...)
+	}
+}
+
+// StarvationDurations returns the time the rate limiters spent out of tokens for the requests of
+// each operation class since the client was created, the ongoing starvations included, or nil
+// unless enabled WithStarvationTracking
+func (e *rateLimitEnforcer) StarvationDurations() map[string]time.Duration {
+	if e.starvation == nil {
+		return nil
+	}
+	return e.starvation.durations(e.timeSource.Now())
+}
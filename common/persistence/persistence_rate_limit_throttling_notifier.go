@@ -0,0 +1,171 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+
+	"go.temporal.io/server/common/log"
+	"go.temporal.io/server/common/log/tag"
+)
+
+const (
+	// throttlingNotificationTimeout bounds the enqueueing of a ThrottlingNotification
+	throttlingNotificationTimeout = 5 * time.Second
+)
+
+type (
+	// ThrottlingNotification is enqueued as JSON when the rejection rate of a rate limited
+	// persistence client stays at or above the threshold for a whole window, see WithThrottlingNotifications
+	ThrottlingNotification struct {
+		StoreName     string    `json:"storeName"`
+		RejectionRate float64   `json:"rejectionRate"`
+		Allowed       int64     `json:"allowed"`
+		Rejected      int64     `json:"rejected"`
+		WindowStart   time.Time `json:"windowStart"`
+		WindowEnd     time.Time `json:"windowEnd"`
+	}
+
+	// throttlingNotifier counts the rate limiting decisions per window, and enqueues a
+	// ThrottlingNotification for every window whose rejection rate reached the threshold
+	throttlingNotifier struct {
+		queue     Queue
+		window    time.Duration
+		threshold float64
+		storeName func() string
+		logger    log.Logger
+
+		sync.Mutex
+		windowStart time.Time
+		allowed     int64
+		rejected    int64
+		// enqueuing is set while a notification is enqueued, further notifications are dropped meanwhile
+		enqueuing atomic.Bool
+	}
+)
+
+// ThrottlingNotificationFromBlob decodes a message enqueued by WithThrottlingNotifications
+func ThrottlingNotificationFromBlob(blob *commonpb.DataBlob) (ThrottlingNotification, error) {
+	var notification ThrottlingNotification
+	err := json.Unmarshal(blob.GetData(), &notification)
+	return notification, err
+}
+
+func newThrottlingNotifier(
+	queue Queue,
+	window time.Duration,
+	threshold float64,
+	storeName func() string,
+	logger log.Logger,
+) *throttlingNotifier {
+	if queue == nil || window <= 0 {
+		return nil
+	}
+	return &throttlingNotifier{
+		queue:     queue,
+		window:    window,
+		threshold: threshold,
+		storeName: storeName,
+		logger:    logger,
+	}
+}
+
+// record counts the rate limiting decision of a request in the current window. The first
+// decision after the window is over closes it, enqueueing a notification if it was throttled.
+func (n *throttlingNotifier) record(now time.Time, rejected bool) {
+	n.Lock()
+	var notification *ThrottlingNotification
+	if n.windowStart.IsZero() {
+		n.windowStart = now
+	} else if now.Sub(n.windowStart) >= n.window {
+		notification = n.closeWindowLocked(now)
+	}
+	if rejected {
+		n.rejected++
+	} else {
+		n.allowed++
+	}
+	n.Unlock()
+
+	if notification != nil {
+		n.enqueue(*notification)
+	}
+}
+
+// closeWindowLocked starts a new window, and returns the notification of the closed one
+// if its rejection rate reached the threshold
+func (n *throttlingNotifier) closeWindowLocked(now time.Time) *ThrottlingNotification {
+	notification := ThrottlingNotification{
+		Allowed:     n.allowed,
+		Rejected:    n.rejected,
+		WindowStart: n.windowStart,
+		WindowEnd:   now,
+	}
+	n.windowStart = now
+	n.allowed = 0
+	n.rejected = 0
+	if notification.Rejected == 0 {
+		return nil
+	}
+	notification.RejectionRate = float64(notification.Rejected) / float64(notification.Allowed+notification.Rejected)
+	if notification.RejectionRate < n.threshold {
+		return nil
+	}
+	return &notification
+}
+
+// enqueue enqueues the notification in the background, so that requests are not held up
+// by the queue. It is dropped if the previous notification is still being enqueued.
+func (n *throttlingNotifier) enqueue(notification ThrottlingNotification) {
+	if !n.enqueuing.CompareAndSwap(false, true) {
+		return
+	}
+	notification.StoreName = n.storeName()
+	data, err := json.Marshal(notification)
+	if err != nil {
+		n.enqueuing.Store(false)
+		n.logger.Warn("Unable to encode persistence throttling notification.", tag.Error(err))
+		return
+	}
+	go func() {
+		defer n.enqueuing.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), throttlingNotificationTimeout)
+		defer cancel()
+		blob := commonpb.DataBlob{EncodingType: enumspb.ENCODING_TYPE_JSON, Data: data}
+		if err := n.queue.EnqueueMessage(ctx, blob); err != nil {
+			n.logger.Warn("Unable to enqueue persistence throttling notification.",
+				tag.StoreType(notification.StoreName),
+				tag.Error(err),
+			)
+		}
+	}()
+}
@@ -0,0 +1,111 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"sync"
+	"time"
+
+	"go.temporal.io/server/common/quotas"
+)
+
+const (
+	// utilizationSampleInterval is the interval over which rate limiter utilization is sampled
+	utilizationSampleInterval = time.Minute
+)
+
+type (
+	// utilizationSampler accumulates the tokens consumed from each rate limiter, and turns
+	// them into utilization samples once per sampling interval
+	utilizationSampler struct {
+		interval time.Duration
+
+		sync.Mutex
+		sampleStart time.Time
+		limiters    map[string]*utilizationLimiter
+	}
+
+	utilizationLimiter struct {
+		rateLimiter quotas.RequestRateLimiter
+		consumed    int64
+	}
+
+	// utilizationSample is the utilization of a rate limiter over a sampling interval
+	utilizationSample struct {
+		name     string
+		consumed int64
+		// utilization is the fraction of the tokens made available by the rate limiter
+		// over the interval which were consumed, only set if rateKnown
+		utilization float64
+		rateKnown   bool
+	}
+)
+
+func newUtilizationSampler(
+	interval time.Duration,
+	now time.Time,
+) *utilizationSampler {
+	return &utilizationSampler{
+		interval:    interval,
+		sampleStart: now,
+		limiters:    make(map[string]*utilizationLimiter),
+	}
+}
+
+// record accounts the tokens consumed from the rate limiter. If the sampling interval
+// is over, it returns the samples of all rate limiters seen so far for the interval,
+// before accounting the tokens in the next one.
+func (u *utilizationSampler) record(
+	now time.Time,
+	name string,
+	rateLimiter quotas.RequestRateLimiter,
+	tokens int,
+) []utilizationSample {
+	u.Lock()
+	defer u.Unlock()
+
+	var samples []utilizationSample
+	if elapsed := now.Sub(u.sampleStart); elapsed >= u.interval {
+		samples = make([]utilizationSample, 0, len(u.limiters))
+		for limiterName, limiter := range u.limiters {
+			sample := utilizationSample{name: limiterName, consumed: limiter.consumed}
+			if rate, _, _, ok := rateLimiterState(limiter.rateLimiter, now); ok && rate > 0 {
+				sample.utilization = float64(limiter.consumed) / (rate * elapsed.Seconds())
+				sample.rateKnown = true
+			}
+			samples = append(samples, sample)
+			limiter.consumed = 0
+		}
+		u.sampleStart = now
+	}
+
+	limiter, ok := u.limiters[name]
+	if !ok {
+		limiter = &utilizationLimiter{rateLimiter: rateLimiter}
+		u.limiters[name] = limiter
+	}
+	limiter.consumed += int64(tokens)
+	return samples
+}
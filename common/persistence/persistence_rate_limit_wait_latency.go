@@ -0,0 +1,160 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// waitLatencyBuckets are the upper bounds of the buckets of the wait latency histogram,
+	// waits longer than the last bound fall into an overflow bucket
+	waitLatencyBuckets = []time.Duration{
+		time.Millisecond,
+		2 * time.Millisecond,
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		500 * time.Millisecond,
+		time.Second,
+		2 * time.Second,
+		5 * time.Second,
+		10 * time.Second,
+	}
+)
+
+type (
+	// waitLatencyHistogram tracks the distribution of how long requests of each operation
+	// waited for a token before being admitted. Durations are recorded into the current
+	// generation of buckets, which replaces the previous one every window, and percentiles
+	// are computed over both, so they cover between one and two windows of history.
+	waitLatencyHistogram struct {
+		window time.Duration
+
+		sync.Mutex
+		generationStart time.Time
+		current         map[string][]int64
+		previous        map[string][]int64
+	}
+)
+
+func newWaitLatencyHistogram(
+	window time.Duration,
+	now time.Time,
+) *waitLatencyHistogram {
+	if window <= 0 {
+		return nil
+	}
+	return &waitLatencyHistogram{
+		window:          window,
+		generationStart: now,
+		current:         make(map[string][]int64),
+		previous:        make(map[string][]int64),
+	}
+}
+
+// record adds the wait duration of a request of the operation to the histogram
+func (h *waitLatencyHistogram) record(now time.Time, api string, wait time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.rotate(now)
+	counts, ok := h.current[api]
+	if !ok {
+		counts = make([]int64, len(waitLatencyBuckets)+1)
+		h.current[api] = counts
+	}
+	counts[waitLatencyBucket(wait)]++
+}
+
+// percentile returns the upper bound of the bucket holding the given percentile, between
+// 0 and 100, of the wait durations of the operation, or 0 if none were recorded. Waits in
+// the overflow bucket are reported as the last bucket bound.
+func (h *waitLatencyHistogram) percentile(now time.Time, api string, percentile float64) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+
+	h.rotate(now)
+	counts := make([]int64, len(waitLatencyBuckets)+1)
+	total := int64(0)
+	for _, generation := range []map[string][]int64{h.previous, h.current} {
+		for bucket, count := range generation[api] {
+			counts[bucket] += count
+			total += count
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+
+	// the nearest rank, indexed from 0, of the percentile
+	rank := int64(math.Ceil(percentile/100*float64(total))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= total {
+		rank = total - 1
+	}
+	seen := int64(0)
+	for bucket, count := range counts {
+		seen += count
+		if seen > rank {
+			if bucket == len(waitLatencyBuckets) {
+				bucket--
+			}
+			return waitLatencyBuckets[bucket]
+		}
+	}
+	return waitLatencyBuckets[len(waitLatencyBuckets)-1]
+}
+
+// rotate starts a new generation of buckets if the window of the current one is over
+func (h *waitLatencyHistogram) rotate(now time.Time) {
+	elapsed := now.Sub(h.generationStart)
+	if elapsed < h.window {
+		return
+	}
+	if elapsed < 2*h.window {
+		h.previous = h.current
+	} else {
+		// nothing was recorded in the window preceding now
+		h.previous = make(map[string][]int64)
+	}
+	h.current = make(map[string][]int64)
+	h.generationStart = now
+}
+
+// waitLatencyBucket returns the index of the bucket the wait duration falls into
+func waitLatencyBucket(wait time.Duration) int {
+	return sort.Search(len(waitLatencyBuckets), func(i int) bool {
+		return wait <= waitLatencyBuckets[i]
+	})
+}
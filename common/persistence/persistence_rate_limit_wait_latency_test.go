@@ -0,0 +1,84 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitLatencyHistogram_Bucketing(t *testing.T) {
+	histogram := newWaitLatencyHistogram(time.Minute, time.Now())
+	now := time.Now()
+
+	for _, wait := range []time.Duration{0, time.Millisecond, 3 * time.Millisecond, 5 * time.Millisecond, time.Minute} {
+		histogram.record(now, "GetWorkflowExecution", wait)
+	}
+	counts := histogram.current["GetWorkflowExecution"]
+	require.Len(t, counts, len(waitLatencyBuckets)+1)
+	// bucket bounds are inclusive
+	require.Equal(t, int64(2), counts[0])
+	require.Equal(t, int64(2), counts[2])
+	// waits beyond the last bound land in the overflow bucket
+	require.Equal(t, int64(1), counts[len(waitLatencyBuckets)])
+}
+
+func TestWaitLatencyHistogram_Percentile(t *testing.T) {
+	now := time.Now()
+	histogram := newWaitLatencyHistogram(time.Minute, now)
+
+	require.Zero(t, histogram.percentile(now, "GetWorkflowExecution", 99))
+	for i := 0; i < 98; i++ {
+		histogram.record(now, "GetWorkflowExecution", 0)
+	}
+	histogram.record(now, "GetWorkflowExecution", 150*time.Millisecond)
+	histogram.record(now, "GetWorkflowExecution", 30*time.Second)
+	histogram.record(now, "UpdateWorkflowExecution", 3*time.Second)
+
+	require.Equal(t, time.Millisecond, histogram.percentile(now, "GetWorkflowExecution", 50))
+	require.Equal(t, 200*time.Millisecond, histogram.percentile(now, "GetWorkflowExecution", 99))
+	require.Equal(t, 10*time.Second, histogram.percentile(now, "GetWorkflowExecution", 100))
+	require.Equal(t, 5*time.Second, histogram.percentile(now, "UpdateWorkflowExecution", 99))
+}
+
+func TestWaitLatencyHistogram_RollingWindow(t *testing.T) {
+	now := time.Now()
+	histogram := newWaitLatencyHistogram(time.Minute, now)
+
+	histogram.record(now, "GetWorkflowExecution", time.Second)
+	// the waits of the previous window are still accounted for
+	now = now.Add(time.Minute)
+	histogram.record(now, "GetWorkflowExecution", 0)
+	require.Equal(t, time.Second, histogram.percentile(now, "GetWorkflowExecution", 99))
+
+	// until they fall out of the history
+	now = now.Add(time.Minute)
+	histogram.record(now, "GetWorkflowExecution", 0)
+	require.Equal(t, time.Millisecond, histogram.percentile(now, "GetWorkflowExecution", 99))
+	now = now.Add(2 * time.Minute)
+	require.Zero(t, histogram.percentile(now, "GetWorkflowExecution", 99))
+}
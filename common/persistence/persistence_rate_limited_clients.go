@@ -26,12 +26,12 @@ package persistence
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	commonpb "go.temporal.io/api/common/v1"
 	enumspb "go.temporal.io/api/enums/v1"
 	"go.temporal.io/api/serviceerror"
-	"go.temporal.io/server/common/headers"
 	"go.temporal.io/server/common/log"
 	"go.temporal.io/server/common/quotas"
 	"go.temporal.io/server/service/history/tasks"
@@ -40,48 +40,239 @@ import (
 const (
 	RateLimitDefaultToken = 1
 	CallerSegmentMissing  = -1
+
+	// namespaceIDMissing is used for requests which are not scoped to a namespace
+	namespaceIDMissing = ""
+
+	// queueStoreName identifies the queue store, which has no GetName method
+	queueStoreName = "queue"
 )
 
 var (
 	// ErrPersistenceLimitExceeded is the error indicating QPS limit reached.
 	ErrPersistenceLimitExceeded = serviceerror.NewResourceExhausted(enumspb.RESOURCE_EXHAUSTED_CAUSE_PERSISTENCE_LIMIT, "Persistence Max QPS Reached.")
+	// ErrNamespacePaused is the error indicating persistence access of the namespace is paused.
+	ErrNamespacePaused = serviceerror.NewResourceExhausted(enumspb.RESOURCE_EXHAUSTED_CAUSE_PERSISTENCE_LIMIT, "Namespace persistence access is paused.")
+	// ErrPersistenceOperationDisabled is the error indicating the persistence operation is disabled.
+	ErrPersistenceOperationDisabled = serviceerror.NewUnavailable("Persistence operation is disabled.")
+	// ErrPersistenceDraining is the error indicating persistence writes are held back while draining, see SetDrainMode.
+	ErrPersistenceDraining = serviceerror.NewUnavailable("Persistence is draining, writes are not accepted.")
+	// ErrBatchReservationExhausted is the error indicating all tokens of a BatchReservation are used or released.
+	ErrBatchReservationExhausted = serviceerror.NewResourceExhausted(enumspb.RESOURCE_EXHAUSTED_CAUSE_PERSISTENCE_LIMIT, "Batch reservation exhausted.")
 )
 
 type (
+	// RateLimitedClient exposes the controls shared by all rate limited persistence clients
+	RateLimitedClient interface {
+		// WouldAllow reports whether a request for the given operation of the namespace would
+		// currently be admitted, without consuming a token or calling persistence. Disabled
+		// operations, drain mode and paused namespaces are taken into account as on admission.
+		WouldAllow(ctx context.Context, operation string, namespaceID string) bool
+		// SetRateLimitEnabled turns rate limiting on or off for all operations
+		// without removing the client from the persistence stack
+		SetRateLimitEnabled(enabled bool)
+		// SetOperationEnabled turns a single operation on or off, requests of a disabled
+		// operation fail with ErrPersistenceOperationDisabled without reaching persistence
+		SetOperationEnabled(operation string, enabled bool)
+		// SetDrainMode turns drain mode on or off, e.g. for database maintenance. While draining,
+		// requests of the operations writing to persistence fail with ErrPersistenceDraining without
+		// reaching persistence, and reads proceed subject to the rate limits as usual. Shard
+		// acquisition and membership heartbeats proceed as well, as the cluster cannot do without.
+		SetDrainMode(draining bool)
+		// RateLimitStats returns the rejections of the client since it was created or last reset
+		RateLimitStats() RateLimitStats
+		// ResetRateLimitStats zeroes the rejection stats of the client
+		ResetRateLimitStats()
+		// Snapshot returns the state of every rate limiter of the client
+		Snapshot() []LimiterSnapshot
+		// LimiterName identifies the client and the current rates of its rate limiters, for debugging
+		LimiterName() string
+		// UpdateRateLimit changes the rate and burst of the main rate limiter in place, carrying
+		// its tokens over as documented by quotas.RateLimiterImpl.SetRateBurstAt. It fails with
+		// an InvalidArgument error unless the main rate limiter is a quotas.RateLimiterImpl.
+		UpdateRateLimit(rate float64, burst int) error
+		// ReserveBatch reserves tokens for n requests of the operation up front, see BatchReservation
+		ReserveBatch(operation string, n int) (BatchReservation, error)
+		// Subscribe returns a channel receiving the BackpressureEvents of the client,
+		// which is closed when the client is closed
+		Subscribe() <-chan BackpressureEvent
+	}
+
+	// RateLimitStats summarizes the requests rejected by a rate limited persistence client
+	RateLimitStats struct {
+		Rejections            int64
+		RejectionsByOperation map[string]int64
+		LastRejectionTime     time.Time
+		// OutcomesByOperation splits the requests of each operation by their outcome
+		OutcomesByOperation map[string]OperationOutcomes
+	}
+
+	// OperationOutcomes counts the requests of an operation by outcome, to tell
+	// throttling by the client apart from errors returned by persistence
+	OperationOutcomes struct {
+		// Succeeded counts the requests persistence served without an error
+		Succeeded int64
+		// Rejected counts the requests rejected by the rate limiters of the client
+		Rejected int64
+		// DownstreamFailed counts the requests persistence returned an error for,
+		// including expected ones such as NotFound
+		DownstreamFailed int64
+	}
+
+	// LimiterSnapshot is the state of one of the rate limiters of a client
+	LimiterSnapshot struct {
+		// Name identifies the rate limiter, e.g. main or scan
+		Name string
+		// Operations lists the operations throttled by the rate limiter, empty for the main one
+		Operations []string
+		// StateKnown reports whether Rate, Burst and TokensAvailable are set, which requires a rate
+		// limiter adapted from a quotas.RateLimiterImpl, or from a quotas.MultiRateLimiterImpl of them,
+		// whose state is the most restrictive of its components
+		StateKnown        bool
+		Rate              float64
+		Burst             int
+		TokensAvailable   float64
+		Rejections        int64
+		LastRejectionTime time.Time
+	}
+
+	// NamespaceQPSReporter reports the per namespace QPS of a rate limited persistence client,
+	// see WithNamespaceQPSReporting
+	NamespaceQPSReporter interface {
+		NamespaceQPS() map[string]float64
+	}
+
+	// HotShardReporter reports the shards of the most requests recently to a rate limited
+	// persistence client, see WithHotShardTracking
+	HotShardReporter interface {
+		HotShards(k int) []ShardStat
+	}
+
+	// NamespaceRejectionReporter reports the recent rejections of the requests of each namespace
+	// to a rate limited persistence client, see WithNamespaceRejectionStats
+	NamespaceRejectionReporter interface {
+		NamespaceRejectionStats(namespaceID string) RejectionStats
+	}
+
+	// NamespaceLimiter limits the requests of each namespace to a rate limited persistence client on
+	// top of its other rate limiters. A request denied by the other rate limiters gives the token it
+	// took from the rate limiter of its namespace back.
+	NamespaceLimiter interface {
+		// SetNamespaceLimits replaces the rate limits of all namespaces at once, e.g. with the map
+		// pushed by dynamic config. Every request sees either the limits before or after the call,
+		// never a mix of both. The namespaces left out, or of a rate which is not positive, are no
+		// longer limited, new ones start at their full burst of one second of their rate, and the
+		// ones of an unchanged rate keep their tokens.
+		SetNamespaceLimits(limits map[string]float64)
+		// NamespaceLimits returns the rate limits of the namespaces set with SetNamespaceLimits
+		NamespaceLimits() map[string]float64
+	}
+
+	// NamespaceBucketReporter reports the per namespace rate limiters held by a rate limited
+	// persistence client, see WithNamespaceBucketEviction
+	NamespaceBucketReporter interface {
+		ActiveNamespaceBucketCount() int
+	}
+
+	// StarvationReporter reports how long the rate limiters of a rate limited persistence client
+	// stayed out of tokens for each operation class, see WithStarvationTracking
+	StarvationReporter interface {
+		StarvationDurations() map[string]time.Duration
+	}
+
+	// NamespaceIOReporter reports the bytes each namespace wrote to and read from persistence
+	// through a rate limited persistence client, see WithNamespaceIOAccounting
+	NamespaceIOReporter interface {
+		NamespaceIOBytes() map[string]IOStats
+	}
+
+	// WaitLatencyReporter reports how long requests of a rate limited persistence client
+	// waited for a token, see WithWaitLatencyHistogram
+	WaitLatencyReporter interface {
+		WaitLatencyPercentile(operation string, percentile float64) time.Duration
+	}
+
+	// AdmissionDecisionReporter reports the most recent admission decisions of a rate limited
+	// persistence client, e.g. for a debug endpoint, see WithDecisionLog
+	AdmissionDecisionReporter interface {
+		// RecentDecisions returns the most recent admission decisions, oldest first
+		RecentDecisions() []AdmissionDecision
+	}
+
+	// PersistenceSwapper is implemented by the rate limited execution client, see SwapPersistence
+	PersistenceSwapper interface {
+		// SwapPersistence replaces the ExecutionManager behind the client, keeping its rate
+		// limiters and stats. Requests in flight complete against the previous manager, which
+		// is not closed, all requests admitted afterwards use the new one.
+		SwapPersistence(newManager ExecutionManager)
+	}
+
+	// NamespaceRateLimitedClient exposes the controls of rate limited persistence clients
+	// serving namespace scoped requests
+	NamespaceRateLimitedClient interface {
+		RateLimitedClient
+		// PauseNamespace rejects all persistence requests of the namespace with
+		// ErrNamespacePaused for the given duration
+		PauseNamespace(namespaceID string, duration time.Duration)
+		// ResumeNamespace lifts a pause of the namespace before it expires
+		ResumeNamespace(namespaceID string)
+		// IsNamespaceThrottled reports whether requests of the namespace were recently
+		// rejected by the rate limiters of the client, i.e. within the last 10 seconds
+		IsNamespaceThrottled(namespaceID string) bool
+		// WarmNamespace creates the per namespace buckets of the namespace at their full burst
+		// ahead of its first request, e.g. when the namespace is activated. Buckets of namespaces
+		// are otherwise created on their first request, also at their full burst.
+		WarmNamespace(namespace string)
+	}
+
+	// GetName and Close of the rate limited clients must never consume tokens, so that
+	// stores can still be identified and shut down while persistence is overloaded.
+	// GetName returns the name of the store cached at construction, so that it does
+	// not stall either when the store hangs, e.g. in health checks.
+
 	shardRateLimitedPersistenceClient struct {
-		rateLimiter quotas.RequestRateLimiter
+		*rateLimitEnforcer
 		persistence ShardManager
-		logger      log.Logger
+		// name is the name of the store, empty if it is looked up on every GetName
+		name string
 	}
 
 	executionRateLimitedPersistenceClient struct {
-		rateLimiter quotas.RequestRateLimiter
-		persistence ExecutionManager
-		logger      log.Logger
+		*rateLimitEnforcer
+
+		persistenceLock sync.RWMutex
+		persistence     ExecutionManager
+		// name is the name of the store, empty if it is looked up on every GetName
+		name string
+		// spillover serves the rejected reads of isSpilloverRead, if set
+		spillover       ExecutionManager
+		isSpilloverRead func(operation string) bool
 	}
 
 	taskRateLimitedPersistenceClient struct {
-		rateLimiter quotas.RequestRateLimiter
+		*rateLimitEnforcer
 		persistence TaskManager
-		logger      log.Logger
+		// name is the name of the store, empty if it is looked up on every GetName
+		name string
 	}
 
 	metadataRateLimitedPersistenceClient struct {
-		rateLimiter quotas.RequestRateLimiter
+		*rateLimitEnforcer
 		persistence MetadataManager
-		logger      log.Logger
+		// name is the name of the store, empty if it is looked up on every GetName
+		name string
 	}
 
 	clusterMetadataRateLimitedPersistenceClient struct {
-		rateLimiter quotas.RequestRateLimiter
+		*rateLimitEnforcer
 		persistence ClusterMetadataManager
-		logger      log.Logger
+		// name is the name of the store, empty if it is looked up on every GetName
+		name string
 	}
 
 	queueRateLimitedPersistenceClient struct {
-		rateLimiter quotas.RequestRateLimiter
+		*rateLimitEnforcer
 		persistence Queue
-		logger      log.Logger
 	}
 )
 
@@ -92,73 +283,109 @@ var _ MetadataManager = (*metadataRateLimitedPersistenceClient)(nil)
 var _ ClusterMetadataManager = (*clusterMetadataRateLimitedPersistenceClient)(nil)
 var _ Queue = (*queueRateLimitedPersistenceClient)(nil)
 
+var _ RateLimitedClient = (*shardRateLimitedPersistenceClient)(nil)
+var _ RateLimitedClient = (*executionRateLimitedPersistenceClient)(nil)
+var _ RateLimitedClient = (*taskRateLimitedPersistenceClient)(nil)
+var _ RateLimitedClient = (*metadataRateLimitedPersistenceClient)(nil)
+var _ RateLimitedClient = (*clusterMetadataRateLimitedPersistenceClient)(nil)
+var _ RateLimitedClient = (*queueRateLimitedPersistenceClient)(nil)
+
+var _ NamespaceRateLimitedClient = (*executionRateLimitedPersistenceClient)(nil)
+var _ NamespaceQPSReporter = (*executionRateLimitedPersistenceClient)(nil)
+var _ WaitLatencyReporter = (*executionRateLimitedPersistenceClient)(nil)
+var _ HotShardReporter = (*executionRateLimitedPersistenceClient)(nil)
+var _ NamespaceRejectionReporter = (*executionRateLimitedPersistenceClient)(nil)
+var _ NamespaceLimiter = (*executionRateLimitedPersistenceClient)(nil)
+var _ NamespaceBucketReporter = (*executionRateLimitedPersistenceClient)(nil)
+var _ NamespaceIOReporter = (*executionRateLimitedPersistenceClient)(nil)
+var _ StarvationReporter = (*executionRateLimitedPersistenceClient)(nil)
+var _ HotShardReporter = (*shardRateLimitedPersistenceClient)(nil)
+var _ AdmissionDecisionReporter = (*executionRateLimitedPersistenceClient)(nil)
+var _ PersistenceSwapper = (*executionRateLimitedPersistenceClient)(nil)
+var _ NamespaceRateLimitedClient = (*taskRateLimitedPersistenceClient)(nil)
+
+// cachedStoreName returns the name of the store cached at construction, falling back
+// to looking it up from the store only if the store had no name then
+func cachedStoreName(name string, lookup func() string) string {
+	if name != "" {
+		return name
+	}
+	return lookup()
+}
+
 // NewShardPersistenceRateLimitedClient creates a client to manage shards
-func NewShardPersistenceRateLimitedClient(persistence ShardManager, rateLimiter quotas.RequestRateLimiter, logger log.Logger) ShardManager {
-	return &shardRateLimitedPersistenceClient{
+func NewShardPersistenceRateLimitedClient(persistence ShardManager, rateLimiter quotas.RequestRateLimiter, logger log.Logger, opts ...RateLimitedClientOption) ShardManager {
+	client := &shardRateLimitedPersistenceClient{
 		persistence: persistence,
-		rateLimiter: rateLimiter,
-		logger:      logger,
+		name:        persistence.GetName(),
 	}
+	client.rateLimitEnforcer = newRateLimitEnforcer(rateLimiter, client.GetName, logger, opts)
+	return client
 }
 
 // NewExecutionPersistenceRateLimitedClient creates a client to manage executions
-func NewExecutionPersistenceRateLimitedClient(persistence ExecutionManager, rateLimiter quotas.RequestRateLimiter, logger log.Logger) ExecutionManager {
-	return &executionRateLimitedPersistenceClient{
+func NewExecutionPersistenceRateLimitedClient(persistence ExecutionManager, rateLimiter quotas.RequestRateLimiter, logger log.Logger, opts ...RateLimitedClientOption) ExecutionManager {
+	client := &executionRateLimitedPersistenceClient{
 		persistence: persistence,
-		rateLimiter: rateLimiter,
-		logger:      logger,
+		name:        persistence.GetName(),
 	}
+	client.rateLimitEnforcer = newRateLimitEnforcer(rateLimiter, client.GetName, logger, opts)
+	return client
 }
 
 // NewTaskPersistenceRateLimitedClient creates a client to manage tasks
-func NewTaskPersistenceRateLimitedClient(persistence TaskManager, rateLimiter quotas.RequestRateLimiter, logger log.Logger) TaskManager {
-	return &taskRateLimitedPersistenceClient{
+func NewTaskPersistenceRateLimitedClient(persistence TaskManager, rateLimiter quotas.RequestRateLimiter, logger log.Logger, opts ...RateLimitedClientOption) TaskManager {
+	client := &taskRateLimitedPersistenceClient{
 		persistence: persistence,
-		rateLimiter: rateLimiter,
-		logger:      logger,
+		name:        persistence.GetName(),
 	}
+	client.rateLimitEnforcer = newRateLimitEnforcer(rateLimiter, client.GetName, logger, opts)
+	return client
 }
 
 // NewMetadataPersistenceRateLimitedClient creates a MetadataManager client to manage metadata
-func NewMetadataPersistenceRateLimitedClient(persistence MetadataManager, rateLimiter quotas.RequestRateLimiter, logger log.Logger) MetadataManager {
-	return &metadataRateLimitedPersistenceClient{
+func NewMetadataPersistenceRateLimitedClient(persistence MetadataManager, rateLimiter quotas.RequestRateLimiter, logger log.Logger, opts ...RateLimitedClientOption) MetadataManager {
+	client := &metadataRateLimitedPersistenceClient{
 		persistence: persistence,
-		rateLimiter: rateLimiter,
-		logger:      logger,
+		name:        persistence.GetName(),
 	}
+	client.rateLimitEnforcer = newRateLimitEnforcer(rateLimiter, client.GetName, logger, opts)
+	return client
 }
 
 // NewClusterMetadataPersistenceRateLimitedClient creates a MetadataManager client to manage metadata
-func NewClusterMetadataPersistenceRateLimitedClient(persistence ClusterMetadataManager, rateLimiter quotas.RequestRateLimiter, logger log.Logger) ClusterMetadataManager {
-	return &clusterMetadataRateLimitedPersistenceClient{
+func NewClusterMetadataPersistenceRateLimitedClient(persistence ClusterMetadataManager, rateLimiter quotas.RequestRateLimiter, logger log.Logger, opts ...RateLimitedClientOption) ClusterMetadataManager {
+	client := &clusterMetadataRateLimitedPersistenceClient{
 		persistence: persistence,
-		rateLimiter: rateLimiter,
-		logger:      logger,
+		name:        persistence.GetName(),
 	}
+	client.rateLimitEnforcer = newRateLimitEnforcer(rateLimiter, client.GetName, logger, opts)
+	return client
 }
 
 // NewQueuePersistenceRateLimitedClient creates a client to manage queue
-func NewQueuePersistenceRateLimitedClient(persistence Queue, rateLimiter quotas.RequestRateLimiter, logger log.Logger) Queue {
+func NewQueuePersistenceRateLimitedClient(persistence Queue, rateLimiter quotas.RequestRateLimiter, logger log.Logger, opts ...RateLimitedClientOption) Queue {
 	return &queueRateLimitedPersistenceClient{
-		persistence: persistence,
-		rateLimiter: rateLimiter,
-		logger:      logger,
+		rateLimitEnforcer: newRateLimitEnforcer(rateLimiter, func() string { return queueStoreName }, logger, opts),
+		persistence:       persistence,
 	}
 }
 
 func (p *shardRateLimitedPersistenceClient) GetName() string {
-	return p.persistence.GetName()
+	return cachedStoreName(p.name, p.persistence.GetName)
 }
 
 func (p *shardRateLimitedPersistenceClient) GetOrCreateShard(
 	ctx context.Context,
 	request *GetOrCreateShardRequest,
 ) (*GetOrCreateShardResponse, error) {
-	if ok := allow(ctx, "GetOrCreateShard", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "GetOrCreateShard", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
 
 	response, err := p.persistence.GetOrCreateShard(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -166,57 +393,108 @@ func (p *shardRateLimitedPersistenceClient) UpdateShard(
 	ctx context.Context,
 	request *UpdateShardRequest,
 ) error {
-	if ok := allow(ctx, "UpdateShard", request.ShardInfo.ShardId, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "UpdateShard", request, request.ShardInfo.ShardId, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.UpdateShard(ctx, request)
+	err = p.persistence.UpdateShard(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *shardRateLimitedPersistenceClient) AssertShardOwnership(
 	ctx context.Context,
 	request *AssertShardOwnershipRequest,
 ) error {
-	if ok := allow(ctx, "AssertShardOwnership", request.ShardID, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "AssertShardOwnership", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.AssertShardOwnership(ctx, request)
+	err = p.persistence.AssertShardOwnership(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *shardRateLimitedPersistenceClient) Close() {
+	p.close()
 	p.persistence.Close()
 }
 
 func (p *executionRateLimitedPersistenceClient) GetName() string {
-	return p.persistence.GetName()
+	p.persistenceLock.RLock()
+	name, persistence := p.name, p.persistence
+	p.persistenceLock.RUnlock()
+	return cachedStoreName(name, persistence.GetName)
 }
 
 func (p *executionRateLimitedPersistenceClient) GetHistoryBranchUtil() HistoryBranchUtil {
-	return p.persistence.GetHistoryBranchUtil()
+	return p.executionManager().GetHistoryBranchUtil()
 }
 
 func (p *executionRateLimitedPersistenceClient) CreateWorkflowExecution(
 	ctx context.Context,
 	request *CreateWorkflowExecutionRequest,
 ) (*CreateWorkflowExecutionResponse, error) {
-	if ok := allow(ctx, "CreateWorkflowExecution", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	if p.createSerializer != nil {
+		return p.createSerializer.create(ctx, request, p.createWorkflowExecution)
 	}
-
-	response, err := p.persistence.CreateWorkflowExecution(ctx, request)
+	response, _, err := p.createWorkflowExecution(ctx, request)
 	return response, err
 }
 
+// createWorkflowExecution creates the workflow, reporting whether the request reached persistence
+func (p *executionRateLimitedPersistenceClient) createWorkflowExecution(
+	ctx context.Context,
+	request *CreateWorkflowExecutionRequest,
+) (*CreateWorkflowExecutionResponse, bool, error) {
+	ctx, admission, err := p.admit(ctx, "CreateWorkflowExecution", request, request.ShardID, request.NewWorkflowSnapshot.ExecutionInfo.GetNamespaceId())
+	if err != nil {
+		return nil, false, err
+	}
+
+	response, err := p.executionManager().CreateWorkflowExecution(ctx, request)
+	err = admission.done(err)
+	if err == nil && p.namespaceIO != nil {
+		p.namespaceIO.record(
+			request.NewWorkflowSnapshot.ExecutionInfo.GetNamespaceId(),
+			mutableStateIOBytes(&response.NewMutableStateStats),
+			0,
+		)
+	}
+	if err == nil && p.notFound != nil {
+		p.notFound.invalidate(
+			request.NewWorkflowSnapshot.ExecutionInfo.GetNamespaceId(),
+			request.NewWorkflowSnapshot.ExecutionInfo.GetWorkflowId(),
+			request.NewWorkflowSnapshot.ExecutionState.GetRunId(),
+		)
+	}
+	return response, true, err
+}
+
 func (p *executionRateLimitedPersistenceClient) GetWorkflowExecution(
 	ctx context.Context,
 	request *GetWorkflowExecutionRequest,
 ) (*GetWorkflowExecutionResponse, error) {
-	if ok := allow(ctx, "GetWorkflowExecution", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	if p.notFound != nil {
+		if err := p.notFound.get(p.timeSource.Now(), request); err != nil {
+			return nil, err
+		}
+	}
+	ctx, admission, manager, err := p.admitRead(ctx, "GetWorkflowExecution", request, request.ShardID, request.NamespaceID)
+	if err != nil {
+		return nil, err
 	}
 
-	response, err := p.persistence.GetWorkflowExecution(ctx, request)
+	response, err := manager.GetWorkflowExecution(ctx, request)
+	err = admission.done(err)
+	if err == nil && p.namespaceIO != nil {
+		p.namespaceIO.record(request.NamespaceID, 0, mutableStateIOBytes(&response.MutableStateStats))
+	}
+	if p.notFound != nil {
+		p.notFound.put(p.timeSource.Now(), request, err)
+	}
 	return response, err
 }
 
@@ -224,11 +502,13 @@ func (p *executionRateLimitedPersistenceClient) SetWorkflowExecution(
 	ctx context.Context,
 	request *SetWorkflowExecutionRequest,
 ) (*SetWorkflowExecutionResponse, error) {
-	if ok := allow(ctx, "SetWorkflowExecution", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "SetWorkflowExecution", request, request.ShardID, request.SetWorkflowSnapshot.ExecutionInfo.GetNamespaceId())
+	if err != nil {
+		return nil, err
 	}
 
-	response, err := p.persistence.SetWorkflowExecution(ctx, request)
+	response, err := p.executionManager().SetWorkflowExecution(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -236,11 +516,27 @@ func (p *executionRateLimitedPersistenceClient) UpdateWorkflowExecution(
 	ctx context.Context,
 	request *UpdateWorkflowExecutionRequest,
 ) (*UpdateWorkflowExecutionResponse, error) {
-	if ok := allow(ctx, "UpdateWorkflowExecution", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admitN(
+		ctx,
+		"UpdateWorkflowExecution",
+		request,
+		p.options.updateWorkflowTokens(request),
+		request.ShardID,
+		request.UpdateWorkflowMutation.ExecutionInfo.GetNamespaceId(),
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	resp, err := p.persistence.UpdateWorkflowExecution(ctx, request)
+	resp, err := p.executionManager().UpdateWorkflowExecution(ctx, request)
+	err = admission.done(err)
+	if err == nil && p.namespaceIO != nil {
+		p.namespaceIO.record(
+			request.UpdateWorkflowMutation.ExecutionInfo.GetNamespaceId(),
+			mutableStateIOBytes(&resp.UpdateMutableStateStats)+mutableStateIOBytes(resp.NewMutableStateStats),
+			0,
+		)
+	}
 	return resp, err
 }
 
@@ -248,11 +544,29 @@ func (p *executionRateLimitedPersistenceClient) ConflictResolveWorkflowExecution
 	ctx context.Context,
 	request *ConflictResolveWorkflowExecutionRequest,
 ) (*ConflictResolveWorkflowExecutionResponse, error) {
-	if ok := allow(ctx, "ConflictResolveWorkflowExecution", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admitN(
+		ctx,
+		"ConflictResolveWorkflowExecution",
+		request,
+		p.options.conflictResolveTokens(request),
+		request.ShardID,
+		request.ResetWorkflowSnapshot.ExecutionInfo.GetNamespaceId(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := p.executionManager().ConflictResolveWorkflowExecution(ctx, request)
+	err = admission.done(err)
+	if err == nil && p.namespaceIO != nil {
+		p.namespaceIO.record(
+			request.ResetWorkflowSnapshot.ExecutionInfo.GetNamespaceId(),
+			mutableStateIOBytes(&response.ResetMutableStateStats)+
+				mutableStateIOBytes(response.NewMutableStateStats)+
+				mutableStateIOBytes(response.CurrentMutableStateStats),
+			0,
+		)
 	}
-
-	response, err := p.persistence.ConflictResolveWorkflowExecution(ctx, request)
 	return response, err
 }
 
@@ -260,33 +574,41 @@ func (p *executionRateLimitedPersistenceClient) DeleteWorkflowExecution(
 	ctx context.Context,
 	request *DeleteWorkflowExecutionRequest,
 ) error {
-	if ok := allow(ctx, "DeleteWorkflowExecution", request.ShardID, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "DeleteWorkflowExecution", request, request.ShardID, request.NamespaceID)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.DeleteWorkflowExecution(ctx, request)
+	err = p.executionManager().DeleteWorkflowExecution(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *executionRateLimitedPersistenceClient) DeleteCurrentWorkflowExecution(
 	ctx context.Context,
 	request *DeleteCurrentWorkflowExecutionRequest,
 ) error {
-	if ok := allow(ctx, "DeleteCurrentWorkflowExecution", request.ShardID, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "DeleteCurrentWorkflowExecution", request, request.ShardID, request.NamespaceID)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.DeleteCurrentWorkflowExecution(ctx, request)
+	err = p.executionManager().DeleteCurrentWorkflowExecution(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *executionRateLimitedPersistenceClient) GetCurrentExecution(
 	ctx context.Context,
 	request *GetCurrentExecutionRequest,
 ) (*GetCurrentExecutionResponse, error) {
-	if ok := allow(ctx, "GetCurrentExecution", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, manager, err := p.admitRead(ctx, "GetCurrentExecution", request, request.ShardID, request.NamespaceID)
+	if err != nil {
+		return nil, err
 	}
 
-	response, err := p.persistence.GetCurrentExecution(ctx, request)
+	response, err := manager.GetCurrentExecution(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -294,11 +616,16 @@ func (p *executionRateLimitedPersistenceClient) ListConcreteExecutions(
 	ctx context.Context,
 	request *ListConcreteExecutionsRequest,
 ) (*ListConcreteExecutionsResponse, error) {
-	if ok := allow(ctx, "ListConcreteExecutions", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, degraded, err := p.admitPage(ctx, "ListConcreteExecutions", request, request.ShardID, request.PageToken)
+	if degraded {
+		return &ListConcreteExecutionsResponse{PageToken: request.PageToken}, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	response, err := p.persistence.ListConcreteExecutions(ctx, request)
+	response, err := p.executionManager().ListConcreteExecutions(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -307,7 +634,7 @@ func (p *executionRateLimitedPersistenceClient) RegisterHistoryTaskReader(
 	request *RegisterHistoryTaskReaderRequest,
 ) error {
 	// hint methods don't actually hint DB, so don't go through persistence rate limiter
-	return p.persistence.RegisterHistoryTaskReader(ctx, request)
+	return p.executionManager().RegisterHistoryTaskReader(ctx, request)
 }
 
 func (p *executionRateLimitedPersistenceClient) UnregisterHistoryTaskReader(
@@ -315,7 +642,7 @@ func (p *executionRateLimitedPersistenceClient) UnregisterHistoryTaskReader(
 	request *UnregisterHistoryTaskReaderRequest,
 ) {
 	// hint methods don't actually hint DB, so don't go through persistence rate limiter
-	p.persistence.UnregisterHistoryTaskReader(ctx, request)
+	p.executionManager().UnregisterHistoryTaskReader(ctx, request)
 }
 
 func (p *executionRateLimitedPersistenceClient) UpdateHistoryTaskReaderProgress(
@@ -323,34 +650,51 @@ func (p *executionRateLimitedPersistenceClient) UpdateHistoryTaskReaderProgress(
 	request *UpdateHistoryTaskReaderProgressRequest,
 ) {
 	// hint methods don't actually hint DB, so don't go through persistence rate limiter
-	p.persistence.UpdateHistoryTaskReaderProgress(ctx, request)
+	p.executionManager().UpdateHistoryTaskReaderProgress(ctx, request)
 }
 
 func (p *executionRateLimitedPersistenceClient) AddHistoryTasks(
 	ctx context.Context,
 	request *AddHistoryTasksRequest,
 ) error {
-	if ok := allow(ctx, "AddHistoryTasks", request.ShardID, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	var dedupKey historyTasksDedupKey
+	if p.historyTasksDedup != nil {
+		var duplicate bool
+		if dedupKey, duplicate = p.isDuplicateHistoryTasks(request); duplicate {
+			return nil
+		}
+	}
+	ctx, admission, err := p.admit(ctx, "AddHistoryTasks", request, request.ShardID, request.NamespaceID)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.AddHistoryTasks(ctx, request)
+	err = p.executionManager().AddHistoryTasks(ctx, request)
+	err = admission.done(err)
+	if err == nil && p.historyTasksDedup != nil {
+		p.historyTasksDedup.put(p.timeSource.Now(), dedupKey)
+	}
+	return err
 }
 
 func (p *executionRateLimitedPersistenceClient) GetHistoryTasks(
 	ctx context.Context,
 	request *GetHistoryTasksRequest,
 ) (*GetHistoryTasksResponse, error) {
-	if ok := allow(
+	ctx, admission, err := p.admitN(
 		ctx,
 		ConstructHistoryTaskAPI("GetHistoryTasks", request.TaskCategory),
+		request,
+		p.options.historyTaskRangeTokens(request),
 		request.ShardID,
-		p.rateLimiter,
-	); !ok {
-		return nil, ErrPersistenceLimitExceeded
+		namespaceIDMissing,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	response, err := p.persistence.GetHistoryTasks(ctx, request)
+	response, err := p.executionManager().GetHistoryTasks(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -358,106 +702,164 @@ func (p *executionRateLimitedPersistenceClient) CompleteHistoryTask(
 	ctx context.Context,
 	request *CompleteHistoryTaskRequest,
 ) error {
-	if ok := allow(
+	ctx, admission, err := p.admit(
 		ctx,
 		ConstructHistoryTaskAPI("CompleteHistoryTask", request.TaskCategory),
+		request,
 		request.ShardID,
-		p.rateLimiter,
-	); !ok {
-		return ErrPersistenceLimitExceeded
+		namespaceIDMissing,
+	)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.CompleteHistoryTask(ctx, request)
+	err = p.executionManager().CompleteHistoryTask(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *executionRateLimitedPersistenceClient) RangeCompleteHistoryTasks(
 	ctx context.Context,
 	request *RangeCompleteHistoryTasksRequest,
 ) error {
-	if ok := allow(
+	ctx, admission, err := p.admit(
 		ctx,
 		ConstructHistoryTaskAPI("RangeCompleteHistoryTasks", request.TaskCategory),
+		request,
 		request.ShardID,
-		p.rateLimiter,
-	); !ok {
-		return ErrPersistenceLimitExceeded
+		namespaceIDMissing,
+	)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.RangeCompleteHistoryTasks(ctx, request)
+	err = p.executionManager().RangeCompleteHistoryTasks(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *executionRateLimitedPersistenceClient) PutReplicationTaskToDLQ(
 	ctx context.Context,
 	request *PutReplicationTaskToDLQRequest,
 ) error {
-	if ok := allow(ctx, "PutReplicationTaskToDLQ", request.ShardID, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "PutReplicationTaskToDLQ", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.PutReplicationTaskToDLQ(ctx, request)
+	err = p.executionManager().PutReplicationTaskToDLQ(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *executionRateLimitedPersistenceClient) GetReplicationTasksFromDLQ(
 	ctx context.Context,
 	request *GetReplicationTasksFromDLQRequest,
 ) (*GetHistoryTasksResponse, error) {
-	if ok := allow(ctx, "GetReplicationTasksFromDLQ", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admitN(
+		ctx,
+		"GetReplicationTasksFromDLQ",
+		request,
+		p.options.replicationDLQPageTokens(request),
+		request.ShardID,
+		namespaceIDMissing,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	return p.persistence.GetReplicationTasksFromDLQ(ctx, request)
+	response, err := p.executionManager().GetReplicationTasksFromDLQ(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
 func (p *executionRateLimitedPersistenceClient) DeleteReplicationTaskFromDLQ(
 	ctx context.Context,
 	request *DeleteReplicationTaskFromDLQRequest,
 ) error {
-	if ok := allow(ctx, "DeleteReplicationTaskFromDLQ", request.ShardID, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "DeleteReplicationTaskFromDLQ", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.DeleteReplicationTaskFromDLQ(ctx, request)
+	err = p.executionManager().DeleteReplicationTaskFromDLQ(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *executionRateLimitedPersistenceClient) RangeDeleteReplicationTaskFromDLQ(
 	ctx context.Context,
 	request *RangeDeleteReplicationTaskFromDLQRequest,
 ) error {
-	if ok := allow(ctx, "RangeDeleteReplicationTaskFromDLQ", request.ShardID, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "RangeDeleteReplicationTaskFromDLQ", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.RangeDeleteReplicationTaskFromDLQ(ctx, request)
+	err = p.executionManager().RangeDeleteReplicationTaskFromDLQ(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *executionRateLimitedPersistenceClient) IsReplicationDLQEmpty(
 	ctx context.Context,
 	request *GetReplicationTasksFromDLQRequest,
 ) (bool, error) {
-	if ok := allow(ctx, "IsReplicationDLQEmpty", request.ShardID, p.rateLimiter); !ok {
-		return true, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "IsReplicationDLQEmpty", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return true, err
 	}
 
-	return p.persistence.IsReplicationDLQEmpty(ctx, request)
+	isEmpty, err := p.executionManager().IsReplicationDLQEmpty(ctx, request)
+	err = admission.done(err)
+	return isEmpty, err
 }
 
 func (p *executionRateLimitedPersistenceClient) Close() {
-	p.persistence.Close()
+	p.close()
+	p.executionManager().Close()
+	if p.spillover != nil {
+		p.spillover.Close()
+	}
+}
+
+func (p *executionRateLimitedPersistenceClient) SwapPersistence(newManager ExecutionManager) {
+	name := newManager.GetName()
+	p.persistenceLock.Lock()
+	defer p.persistenceLock.Unlock()
+	p.persistence = newManager
+	p.name = name
+}
+
+// executionManager returns the ExecutionManager new requests are sent to
+func (p *executionRateLimitedPersistenceClient) executionManager() ExecutionManager {
+	p.persistenceLock.RLock()
+	defer p.persistenceLock.RUnlock()
+	return p.persistence
 }
 
 func (p *taskRateLimitedPersistenceClient) GetName() string {
-	return p.persistence.GetName()
+	return cachedStoreName(p.name, p.persistence.GetName)
 }
 
 func (p *taskRateLimitedPersistenceClient) CreateTasks(
 	ctx context.Context,
 	request *CreateTasksRequest,
 ) (*CreateTasksResponse, error) {
-	if ok := allow(ctx, "CreateTasks", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admitByTaskQueueType(
+		ctx,
+		"CreateTasks",
+		request,
+		request.TaskQueueInfo.Data.GetNamespaceId(),
+		request.TaskQueueInfo.Data.GetName(),
+		request.TaskQueueInfo.Data.GetTaskType(),
+	)
+	if err != nil {
+		return nil, err
 	}
 
 	response, err := p.persistence.CreateTasks(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -465,11 +867,13 @@ func (p *taskRateLimitedPersistenceClient) GetTasks(
 	ctx context.Context,
 	request *GetTasksRequest,
 ) (*GetTasksResponse, error) {
-	if ok := allow(ctx, "GetTasks", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admitByTaskQueueType(ctx, "GetTasks", request, request.NamespaceID, request.TaskQueue, request.TaskType)
+	if err != nil {
+		return nil, err
 	}
 
 	response, err := p.persistence.GetTasks(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -477,134 +881,173 @@ func (p *taskRateLimitedPersistenceClient) CompleteTask(
 	ctx context.Context,
 	request *CompleteTaskRequest,
 ) error {
-	if ok := allow(ctx, "CompleteTask", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "CompleteTask", request, CallerSegmentMissing, request.TaskQueue.NamespaceID)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.CompleteTask(ctx, request)
+	err = p.persistence.CompleteTask(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *taskRateLimitedPersistenceClient) CompleteTasksLessThan(
 	ctx context.Context,
 	request *CompleteTasksLessThanRequest,
 ) (int, error) {
-	if ok := allow(ctx, "CompleteTasksLessThan", CallerSegmentMissing, p.rateLimiter); !ok {
-		return 0, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "CompleteTasksLessThan", request, CallerSegmentMissing, request.NamespaceID)
+	if err != nil {
+		return 0, err
 	}
-	return p.persistence.CompleteTasksLessThan(ctx, request)
+	completed, err := p.persistence.CompleteTasksLessThan(ctx, request)
+	err = admission.done(err)
+	return completed, err
 }
 
 func (p *taskRateLimitedPersistenceClient) CreateTaskQueue(
 	ctx context.Context,
 	request *CreateTaskQueueRequest,
 ) (*CreateTaskQueueResponse, error) {
-	if ok := allow(ctx, "CreateTaskQueue", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "CreateTaskQueue", request, CallerSegmentMissing, request.TaskQueueInfo.GetNamespaceId())
+	if err != nil {
+		return nil, err
 	}
-	return p.persistence.CreateTaskQueue(ctx, request)
+	response, err := p.persistence.CreateTaskQueue(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
 func (p *taskRateLimitedPersistenceClient) UpdateTaskQueue(
 	ctx context.Context,
 	request *UpdateTaskQueueRequest,
 ) (*UpdateTaskQueueResponse, error) {
-	if ok := allow(ctx, "UpdateTaskQueue", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "UpdateTaskQueue", request, CallerSegmentMissing, request.TaskQueueInfo.GetNamespaceId())
+	if err != nil {
+		return nil, err
 	}
-	return p.persistence.UpdateTaskQueue(ctx, request)
+	response, err := p.persistence.UpdateTaskQueue(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
 func (p *taskRateLimitedPersistenceClient) GetTaskQueue(
 	ctx context.Context,
 	request *GetTaskQueueRequest,
 ) (*GetTaskQueueResponse, error) {
-	if ok := allow(ctx, "GetTaskQueue", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "GetTaskQueue", request, CallerSegmentMissing, request.NamespaceID)
+	if err != nil {
+		return nil, err
 	}
-	return p.persistence.GetTaskQueue(ctx, request)
+	response, err := p.persistence.GetTaskQueue(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
 func (p *taskRateLimitedPersistenceClient) ListTaskQueue(
 	ctx context.Context,
 	request *ListTaskQueueRequest,
 ) (*ListTaskQueueResponse, error) {
-	if ok := allow(ctx, "ListTaskQueue", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "ListTaskQueue", request, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
-	return p.persistence.ListTaskQueue(ctx, request)
+	response, err := p.persistence.ListTaskQueue(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
 func (p *taskRateLimitedPersistenceClient) DeleteTaskQueue(
 	ctx context.Context,
 	request *DeleteTaskQueueRequest,
 ) error {
-	if ok := allow(ctx, "DeleteTaskQueue", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "DeleteTaskQueue", request, CallerSegmentMissing, request.TaskQueue.NamespaceID)
+	if err != nil {
+		return err
 	}
-	return p.persistence.DeleteTaskQueue(ctx, request)
+	err = p.persistence.DeleteTaskQueue(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
-func (p taskRateLimitedPersistenceClient) GetTaskQueueUserData(
+func (p *taskRateLimitedPersistenceClient) GetTaskQueueUserData(
 	ctx context.Context,
 	request *GetTaskQueueUserDataRequest,
 ) (*GetTaskQueueUserDataResponse, error) {
-	if ok := allow(ctx, "GetTaskQueueUserData", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "GetTaskQueueUserData", request, CallerSegmentMissing, request.NamespaceID)
+	if err != nil {
+		return nil, err
 	}
-	return p.persistence.GetTaskQueueUserData(ctx, request)
+	response, err := p.persistence.GetTaskQueueUserData(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
-func (p taskRateLimitedPersistenceClient) UpdateTaskQueueUserData(
+func (p *taskRateLimitedPersistenceClient) UpdateTaskQueueUserData(
 	ctx context.Context,
 	request *UpdateTaskQueueUserDataRequest,
 ) error {
-	if ok := allow(ctx, "UpdateTaskQueueUserData", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "UpdateTaskQueueUserData", request, CallerSegmentMissing, request.NamespaceID)
+	if err != nil {
+		return err
 	}
-	return p.persistence.UpdateTaskQueueUserData(ctx, request)
+	err = p.persistence.UpdateTaskQueueUserData(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
-func (p taskRateLimitedPersistenceClient) ListTaskQueueUserDataEntries(
+func (p *taskRateLimitedPersistenceClient) ListTaskQueueUserDataEntries(
 	ctx context.Context,
 	request *ListTaskQueueUserDataEntriesRequest,
 ) (*ListTaskQueueUserDataEntriesResponse, error) {
-	if ok := allow(ctx, "ListTaskQueueUserDataEntries", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "ListTaskQueueUserDataEntries", request, CallerSegmentMissing, request.NamespaceID)
+	if err != nil {
+		return nil, err
 	}
-	return p.persistence.ListTaskQueueUserDataEntries(ctx, request)
+	response, err := p.persistence.ListTaskQueueUserDataEntries(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
-func (p taskRateLimitedPersistenceClient) GetTaskQueuesByBuildId(ctx context.Context, request *GetTaskQueuesByBuildIdRequest) ([]string, error) {
-	if ok := allow(ctx, "GetTaskQueuesByBuildId", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+func (p *taskRateLimitedPersistenceClient) GetTaskQueuesByBuildId(ctx context.Context, request *GetTaskQueuesByBuildIdRequest) ([]string, error) {
+	ctx, admission, err := p.admit(ctx, "GetTaskQueuesByBuildId", request, CallerSegmentMissing, request.NamespaceID)
+	if err != nil {
+		return nil, err
 	}
-	return p.persistence.GetTaskQueuesByBuildId(ctx, request)
+	taskQueues, err := p.persistence.GetTaskQueuesByBuildId(ctx, request)
+	err = admission.done(err)
+	return taskQueues, err
 }
 
-func (p taskRateLimitedPersistenceClient) CountTaskQueuesByBuildId(ctx context.Context, request *CountTaskQueuesByBuildIdRequest) (int, error) {
-	if ok := allow(ctx, "CountTaskQueuesByBuildId", CallerSegmentMissing, p.rateLimiter); !ok {
-		return 0, ErrPersistenceLimitExceeded
+func (p *taskRateLimitedPersistenceClient) CountTaskQueuesByBuildId(ctx context.Context, request *CountTaskQueuesByBuildIdRequest) (int, error) {
+	ctx, admission, err := p.admit(ctx, "CountTaskQueuesByBuildId", request, CallerSegmentMissing, request.NamespaceID)
+	if err != nil {
+		return 0, err
 	}
-	return p.persistence.CountTaskQueuesByBuildId(ctx, request)
+	count, err := p.persistence.CountTaskQueuesByBuildId(ctx, request)
+	err = admission.done(err)
+	return count, err
 }
 
 func (p *taskRateLimitedPersistenceClient) Close() {
+	p.close()
 	p.persistence.Close()
 }
 
 func (p *metadataRateLimitedPersistenceClient) GetName() string {
-	return p.persistence.GetName()
+	return cachedStoreName(p.name, p.persistence.GetName)
 }
 
 func (p *metadataRateLimitedPersistenceClient) CreateNamespace(
 	ctx context.Context,
 	request *CreateNamespaceRequest,
 ) (*CreateNamespaceResponse, error) {
-	if ok := allow(ctx, "CreateNamespace", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "CreateNamespace", request, CallerSegmentMissing, request.Namespace.GetInfo().GetId())
+	if err != nil {
+		return nil, err
 	}
 
 	response, err := p.persistence.CreateNamespace(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -612,11 +1055,13 @@ func (p *metadataRateLimitedPersistenceClient) GetNamespace(
 	ctx context.Context,
 	request *GetNamespaceRequest,
 ) (*GetNamespaceResponse, error) {
-	if ok := allow(ctx, "GetNamespace", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "GetNamespace", request, CallerSegmentMissing, request.ID)
+	if err != nil {
+		return nil, err
 	}
 
 	response, err := p.persistence.GetNamespace(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -624,66 +1069,82 @@ func (p *metadataRateLimitedPersistenceClient) UpdateNamespace(
 	ctx context.Context,
 	request *UpdateNamespaceRequest,
 ) error {
-	if ok := allow(ctx, "UpdateNamespace", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "UpdateNamespace", request, CallerSegmentMissing, request.Namespace.GetInfo().GetId())
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.UpdateNamespace(ctx, request)
+	err = p.persistence.UpdateNamespace(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *metadataRateLimitedPersistenceClient) RenameNamespace(
 	ctx context.Context,
 	request *RenameNamespaceRequest,
 ) error {
-	if ok := allow(ctx, "RenameNamespace", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "RenameNamespace", request, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.RenameNamespace(ctx, request)
+	err = p.persistence.RenameNamespace(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *metadataRateLimitedPersistenceClient) DeleteNamespace(
 	ctx context.Context,
 	request *DeleteNamespaceRequest,
 ) error {
-	if ok := allow(ctx, "DeleteNamespace", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "DeleteNamespace", request, CallerSegmentMissing, request.ID)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.DeleteNamespace(ctx, request)
+	err = p.persistence.DeleteNamespace(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *metadataRateLimitedPersistenceClient) DeleteNamespaceByName(
 	ctx context.Context,
 	request *DeleteNamespaceByNameRequest,
 ) error {
-	if ok := allow(ctx, "DeleteNamespaceByName", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "DeleteNamespaceByName", request, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.DeleteNamespaceByName(ctx, request)
+	err = p.persistence.DeleteNamespaceByName(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (p *metadataRateLimitedPersistenceClient) ListNamespaces(
 	ctx context.Context,
 	request *ListNamespacesRequest,
 ) (*ListNamespacesResponse, error) {
-	if ok := allow(ctx, "ListNamespaces", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "ListNamespaces", request, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
 
 	response, err := p.persistence.ListNamespaces(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
 func (p *metadataRateLimitedPersistenceClient) GetMetadata(
 	ctx context.Context,
 ) (*GetMetadataResponse, error) {
-	if ok := allow(ctx, "GetMetadata", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "GetMetadata", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
 
 	response, err := p.persistence.GetMetadata(ctx)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -691,13 +1152,17 @@ func (p *metadataRateLimitedPersistenceClient) InitializeSystemNamespaces(
 	ctx context.Context,
 	currentClusterName string,
 ) error {
-	if ok := allow(ctx, "InitializeSystemNamespaces", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "InitializeSystemNamespaces", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
-	return p.persistence.InitializeSystemNamespaces(ctx, currentClusterName)
+	err = p.persistence.InitializeSystemNamespaces(ctx, currentClusterName)
+	err = admission.done(err)
+	return err
 }
 
 func (p *metadataRateLimitedPersistenceClient) Close() {
+	p.close()
 	p.persistence.Close()
 }
 
@@ -706,10 +1171,23 @@ func (p *executionRateLimitedPersistenceClient) AppendHistoryNodes(
 	ctx context.Context,
 	request *AppendHistoryNodesRequest,
 ) (*AppendHistoryNodesResponse, error) {
-	if ok := allow(ctx, "AppendHistoryNodes", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	if err := p.checkRequestSize("AppendHistoryNodes", func() int { return historyEventsSize(request.Events) }); err != nil {
+		return nil, err
+	}
+	ctx, admission, err := p.admitN(
+		ctx,
+		"AppendHistoryNodes",
+		request,
+		p.options.historyNodeTokens(request),
+		request.ShardID,
+		namespaceIDMissing,
+	)
+	if err != nil {
+		return nil, err
 	}
-	return p.persistence.AppendHistoryNodes(ctx, request)
+	response, err := p.executionManager().AppendHistoryNodes(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
 // AppendRawHistoryNodes add a node to history node table
@@ -717,10 +1195,16 @@ func (p *executionRateLimitedPersistenceClient) AppendRawHistoryNodes(
 	ctx context.Context,
 	request *AppendRawHistoryNodesRequest,
 ) (*AppendHistoryNodesResponse, error) {
-	if ok := allow(ctx, "AppendRawHistoryNodes", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	if err := p.checkRequestSize("AppendRawHistoryNodes", func() int { return len(request.History.GetData()) }); err != nil {
+		return nil, err
+	}
+	ctx, admission, err := p.admit(ctx, "AppendRawHistoryNodes", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
-	return p.persistence.AppendRawHistoryNodes(ctx, request)
+	response, err := p.executionManager().AppendRawHistoryNodes(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
 // ReadHistoryBranch returns history node data for a branch
@@ -728,10 +1212,12 @@ func (p *executionRateLimitedPersistenceClient) ReadHistoryBranch(
 	ctx context.Context,
 	request *ReadHistoryBranchRequest,
 ) (*ReadHistoryBranchResponse, error) {
-	if ok := allow(ctx, "ReadHistoryBranch", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, manager, err := p.admitRead(ctx, "ReadHistoryBranch", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
-	response, err := p.persistence.ReadHistoryBranch(ctx, request)
+	response, err := manager.ReadHistoryBranch(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -740,10 +1226,12 @@ func (p *executionRateLimitedPersistenceClient) ReadHistoryBranchReverse(
 	ctx context.Context,
 	request *ReadHistoryBranchReverseRequest,
 ) (*ReadHistoryBranchReverseResponse, error) {
-	if ok := allow(ctx, "ReadHistoryBranchReverse", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, manager, err := p.admitRead(ctx, "ReadHistoryBranchReverse", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
-	response, err := p.persistence.ReadHistoryBranchReverse(ctx, request)
+	response, err := manager.ReadHistoryBranchReverse(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -752,10 +1240,15 @@ func (p *executionRateLimitedPersistenceClient) ReadHistoryBranchByBatch(
 	ctx context.Context,
 	request *ReadHistoryBranchRequest,
 ) (*ReadHistoryBranchByBatchResponse, error) {
-	if ok := allow(ctx, "ReadHistoryBranchByBatch", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, manager, err := p.admitRead(ctx, "ReadHistoryBranchByBatch", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return nil, err
+	}
+	response, err := manager.ReadHistoryBranchByBatch(ctx, request)
+	err = admission.done(err)
+	if err == nil {
+		p.chargeAdmitted(ctx, admission, request.ShardID, p.options.responseSizeTokens(response.Size))
 	}
-	response, err := p.persistence.ReadHistoryBranchByBatch(ctx, request)
 	return response, err
 }
 
@@ -764,10 +1257,15 @@ func (p *executionRateLimitedPersistenceClient) ReadRawHistoryBranch(
 	ctx context.Context,
 	request *ReadHistoryBranchRequest,
 ) (*ReadRawHistoryBranchResponse, error) {
-	if ok := allow(ctx, "ReadRawHistoryBranch", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, manager, err := p.admitRead(ctx, "ReadRawHistoryBranch", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return nil, err
+	}
+	response, err := manager.ReadRawHistoryBranch(ctx, request)
+	err = admission.done(err)
+	if err == nil {
+		p.chargeAdmitted(ctx, admission, request.ShardID, p.options.rawHistoryTokens(response))
 	}
-	response, err := p.persistence.ReadRawHistoryBranch(ctx, request)
 	return response, err
 }
 
@@ -776,10 +1274,12 @@ func (p *executionRateLimitedPersistenceClient) ForkHistoryBranch(
 	ctx context.Context,
 	request *ForkHistoryBranchRequest,
 ) (*ForkHistoryBranchResponse, error) {
-	if ok := allow(ctx, "ForkHistoryBranch", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "ForkHistoryBranch", request, request.ShardID, request.NamespaceID)
+	if err != nil {
+		return nil, err
 	}
-	response, err := p.persistence.ForkHistoryBranch(ctx, request)
+	response, err := p.executionManager().ForkHistoryBranch(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -788,10 +1288,13 @@ func (p *executionRateLimitedPersistenceClient) DeleteHistoryBranch(
 	ctx context.Context,
 	request *DeleteHistoryBranchRequest,
 ) error {
-	if ok := allow(ctx, "DeleteHistoryBranch", request.ShardID, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "DeleteHistoryBranch", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
-	return p.persistence.DeleteHistoryBranch(ctx, request)
+	err = p.executionManager().DeleteHistoryBranch(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 // TrimHistoryBranch trims a branch
@@ -799,10 +1302,12 @@ func (p *executionRateLimitedPersistenceClient) TrimHistoryBranch(
 	ctx context.Context,
 	request *TrimHistoryBranchRequest,
 ) (*TrimHistoryBranchResponse, error) {
-	if ok := allow(ctx, "TrimHistoryBranch", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "TrimHistoryBranch", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
-	resp, err := p.persistence.TrimHistoryBranch(ctx, request)
+	resp, err := p.executionManager().TrimHistoryBranch(ctx, request)
+	err = admission.done(err)
 	return resp, err
 }
 
@@ -811,10 +1316,12 @@ func (p *executionRateLimitedPersistenceClient) GetHistoryTree(
 	ctx context.Context,
 	request *GetHistoryTreeRequest,
 ) (*GetHistoryTreeResponse, error) {
-	if ok := allow(ctx, "GetHistoryTree", request.ShardID, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, manager, err := p.admitRead(ctx, "GetHistoryTree", request, request.ShardID, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
-	response, err := p.persistence.GetHistoryTree(ctx, request)
+	response, err := manager.GetHistoryTree(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -822,10 +1329,15 @@ func (p *executionRateLimitedPersistenceClient) GetAllHistoryTreeBranches(
 	ctx context.Context,
 	request *GetAllHistoryTreeBranchesRequest,
 ) (*GetAllHistoryTreeBranchesResponse, error) {
-	if ok := allow(ctx, "GetAllHistoryTreeBranches", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, degraded, err := p.admitPage(ctx, "GetAllHistoryTreeBranches", request, CallerSegmentMissing, request.NextPageToken)
+	if degraded {
+		return &GetAllHistoryTreeBranchesResponse{NextPageToken: request.NextPageToken}, nil
+	}
+	if err != nil {
+		return nil, err
 	}
-	response, err := p.persistence.GetAllHistoryTreeBranches(ctx, request)
+	response, err := p.executionManager().GetAllHistoryTreeBranches(ctx, request)
+	err = admission.done(err)
 	return response, err
 }
 
@@ -833,11 +1345,17 @@ func (p *queueRateLimitedPersistenceClient) EnqueueMessage(
 	ctx context.Context,
 	blob commonpb.DataBlob,
 ) error {
-	if ok := allow(ctx, "EnqueueMessage", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	if err := p.checkRequestSize("EnqueueMessage", func() int { return len(blob.Data) }); err != nil {
+		return err
+	}
+	ctx, admission, err := p.admit(ctx, "EnqueueMessage", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.EnqueueMessage(ctx, blob)
+	err = p.persistence.EnqueueMessage(ctx, blob)
+	err = admission.done(err)
+	return err
 }
 
 func (p *queueRateLimitedPersistenceClient) ReadMessages(
@@ -845,54 +1363,72 @@ func (p *queueRateLimitedPersistenceClient) ReadMessages(
 	lastMessageID int64,
 	maxCount int,
 ) ([]*QueueMessage, error) {
-	if ok := allow(ctx, "ReadMessages", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "ReadMessages", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
 
-	return p.persistence.ReadMessages(ctx, lastMessageID, maxCount)
+	messages, err := p.persistence.ReadMessages(ctx, lastMessageID, maxCount)
+	err = admission.done(err)
+	return messages, err
 }
 
 func (p *queueRateLimitedPersistenceClient) UpdateAckLevel(
 	ctx context.Context,
 	metadata *InternalQueueMetadata,
 ) error {
-	if ok := allow(ctx, "UpdateAckLevel", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "UpdateAckLevel", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.UpdateAckLevel(ctx, metadata)
+	err = p.persistence.UpdateAckLevel(ctx, metadata)
+	err = admission.done(err)
+	return err
 }
 
 func (p *queueRateLimitedPersistenceClient) GetAckLevels(
 	ctx context.Context,
 ) (*InternalQueueMetadata, error) {
-	if ok := allow(ctx, "GetAckLevels", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "GetAckLevels", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
 
-	return p.persistence.GetAckLevels(ctx)
+	response, err := p.persistence.GetAckLevels(ctx)
+	err = admission.done(err)
+	return response, err
 }
 
 func (p *queueRateLimitedPersistenceClient) DeleteMessagesBefore(
 	ctx context.Context,
 	messageID int64,
 ) error {
-	if ok := allow(ctx, "DeleteMessagesBefore", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "DeleteMessagesBefore", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.DeleteMessagesBefore(ctx, messageID)
+	err = p.persistence.DeleteMessagesBefore(ctx, messageID)
+	err = admission.done(err)
+	return err
 }
 
 func (p *queueRateLimitedPersistenceClient) EnqueueMessageToDLQ(
 	ctx context.Context,
 	blob commonpb.DataBlob,
 ) (int64, error) {
-	if ok := allow(ctx, "EnqueueMessageToDLQ", CallerSegmentMissing, p.rateLimiter); !ok {
-		return EmptyQueueMessageID, ErrPersistenceLimitExceeded
+	if err := p.checkRequestSize("EnqueueMessageToDLQ", func() int { return len(blob.Data) }); err != nil {
+		return EmptyQueueMessageID, err
+	}
+	ctx, admission, err := p.admit(ctx, "EnqueueMessageToDLQ", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return EmptyQueueMessageID, err
 	}
 
-	return p.persistence.EnqueueMessageToDLQ(ctx, blob)
+	messageID, err := p.persistence.EnqueueMessageToDLQ(ctx, blob)
+	err = admission.done(err)
+	return messageID, err
 }
 
 func (p *queueRateLimitedPersistenceClient) ReadMessagesFromDLQ(
@@ -902,11 +1438,14 @@ func (p *queueRateLimitedPersistenceClient) ReadMessagesFromDLQ(
 	pageSize int,
 	pageToken []byte,
 ) ([]*QueueMessage, []byte, error) {
-	if ok := allow(ctx, "ReadMessagesFromDLQ", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "ReadMessagesFromDLQ", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return p.persistence.ReadMessagesFromDLQ(ctx, firstMessageID, lastMessageID, pageSize, pageToken)
+	messages, pageToken, err := p.persistence.ReadMessagesFromDLQ(ctx, firstMessageID, lastMessageID, pageSize, pageToken)
+	err = admission.done(err)
+	return messages, pageToken, err
 }
 
 func (p *queueRateLimitedPersistenceClient) RangeDeleteMessagesFromDLQ(
@@ -914,45 +1453,58 @@ func (p *queueRateLimitedPersistenceClient) RangeDeleteMessagesFromDLQ(
 	firstMessageID int64,
 	lastMessageID int64,
 ) error {
-	if ok := allow(ctx, "RangeDeleteMessagesFromDLQ", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "RangeDeleteMessagesFromDLQ", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.RangeDeleteMessagesFromDLQ(ctx, firstMessageID, lastMessageID)
+	err = p.persistence.RangeDeleteMessagesFromDLQ(ctx, firstMessageID, lastMessageID)
+	err = admission.done(err)
+	return err
 }
 func (p *queueRateLimitedPersistenceClient) UpdateDLQAckLevel(
 	ctx context.Context,
 	metadata *InternalQueueMetadata,
 ) error {
-	if ok := allow(ctx, "UpdateDLQAckLevel", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "UpdateDLQAckLevel", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.UpdateDLQAckLevel(ctx, metadata)
+	err = p.persistence.UpdateDLQAckLevel(ctx, metadata)
+	err = admission.done(err)
+	return err
 }
 
 func (p *queueRateLimitedPersistenceClient) GetDLQAckLevels(
 	ctx context.Context,
 ) (*InternalQueueMetadata, error) {
-	if ok := allow(ctx, "GetDLQAckLevels", CallerSegmentMissing, p.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "GetDLQAckLevels", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
 
-	return p.persistence.GetDLQAckLevels(ctx)
+	response, err := p.persistence.GetDLQAckLevels(ctx)
+	err = admission.done(err)
+	return response, err
 }
 
 func (p *queueRateLimitedPersistenceClient) DeleteMessageFromDLQ(
 	ctx context.Context,
 	messageID int64,
 ) error {
-	if ok := allow(ctx, "DeleteMessageFromDLQ", CallerSegmentMissing, p.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := p.admit(ctx, "DeleteMessageFromDLQ", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
 
-	return p.persistence.DeleteMessageFromDLQ(ctx, messageID)
+	err = p.persistence.DeleteMessageFromDLQ(ctx, messageID)
+	err = admission.done(err)
+	return err
 }
 
 func (p *queueRateLimitedPersistenceClient) Close() {
+	p.close()
 	p.persistence.Close()
 }
 
@@ -964,107 +1516,115 @@ func (p *queueRateLimitedPersistenceClient) Init(
 }
 
 func (c *clusterMetadataRateLimitedPersistenceClient) Close() {
+	c.close()
 	c.persistence.Close()
 }
 
 func (c *clusterMetadataRateLimitedPersistenceClient) GetName() string {
-	return c.persistence.GetName()
+	return cachedStoreName(c.name, c.persistence.GetName)
 }
 
 func (c *clusterMetadataRateLimitedPersistenceClient) GetClusterMembers(
 	ctx context.Context,
 	request *GetClusterMembersRequest,
 ) (*GetClusterMembersResponse, error) {
-	if ok := allow(ctx, "GetClusterMembers", CallerSegmentMissing, c.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := c.admit(ctx, "GetClusterMembers", request, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
-	return c.persistence.GetClusterMembers(ctx, request)
+	response, err := c.persistence.GetClusterMembers(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
 func (c *clusterMetadataRateLimitedPersistenceClient) UpsertClusterMembership(
 	ctx context.Context,
 	request *UpsertClusterMembershipRequest,
 ) error {
-	if ok := allow(ctx, "UpsertClusterMembership", CallerSegmentMissing, c.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := c.admit(ctx, "UpsertClusterMembership", request, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
-	return c.persistence.UpsertClusterMembership(ctx, request)
+	err = c.persistence.UpsertClusterMembership(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (c *clusterMetadataRateLimitedPersistenceClient) PruneClusterMembership(
 	ctx context.Context,
 	request *PruneClusterMembershipRequest,
 ) error {
-	if ok := allow(ctx, "PruneClusterMembership", CallerSegmentMissing, c.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
+	ctx, admission, err := c.admit(ctx, "PruneClusterMembership", request, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
 	}
-	return c.persistence.PruneClusterMembership(ctx, request)
+	err = c.persistence.PruneClusterMembership(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 func (c *clusterMetadataRateLimitedPersistenceClient) ListClusterMetadata(
 	ctx context.Context,
 	request *ListClusterMetadataRequest,
 ) (*ListClusterMetadataResponse, error) {
-	if ok := allow(ctx, "ListClusterMetadata", CallerSegmentMissing, c.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := c.admit(ctx, "ListClusterMetadata", request, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
-	return c.persistence.ListClusterMetadata(ctx, request)
+	response, err := c.persistence.ListClusterMetadata(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
 func (c *clusterMetadataRateLimitedPersistenceClient) GetCurrentClusterMetadata(
 	ctx context.Context,
 ) (*GetClusterMetadataResponse, error) {
-	if ok := allow(ctx, "GetCurrentClusterMetadata", CallerSegmentMissing, c.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := c.admit(ctx, "GetCurrentClusterMetadata", nil, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
-	return c.persistence.GetCurrentClusterMetadata(ctx)
+	response, err := c.persistence.GetCurrentClusterMetadata(ctx)
+	err = admission.done(err)
+	return response, err
 }
 
 func (c *clusterMetadataRateLimitedPersistenceClient) GetClusterMetadata(
 	ctx context.Context,
 	request *GetClusterMetadataRequest,
 ) (*GetClusterMetadataResponse, error) {
-	if ok := allow(ctx, "GetClusterMetadata", CallerSegmentMissing, c.rateLimiter); !ok {
-		return nil, ErrPersistenceLimitExceeded
+	ctx, admission, err := c.admit(ctx, "GetClusterMetadata", request, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return nil, err
 	}
-	return c.persistence.GetClusterMetadata(ctx, request)
+	response, err := c.persistence.GetClusterMetadata(ctx, request)
+	err = admission.done(err)
+	return response, err
 }
 
 func (c *clusterMetadataRateLimitedPersistenceClient) SaveClusterMetadata(
 	ctx context.Context,
 	request *SaveClusterMetadataRequest,
 ) (bool, error) {
-	if ok := allow(ctx, "SaveClusterMetadata", CallerSegmentMissing, c.rateLimiter); !ok {
-		return false, ErrPersistenceLimitExceeded
+	ctx, admission, err := c.admit(ctx, "SaveClusterMetadata", request, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return false, err
 	}
-	return c.persistence.SaveClusterMetadata(ctx, request)
+	applied, err := c.persistence.SaveClusterMetadata(ctx, request)
+	err = admission.done(err)
+	return applied, err
 }
 
 func (c *clusterMetadataRateLimitedPersistenceClient) DeleteClusterMetadata(
 	ctx context.Context,
 	request *DeleteClusterMetadataRequest,
 ) error {
-	if ok := allow(ctx, "DeleteClusterMetadata", CallerSegmentMissing, c.rateLimiter); !ok {
-		return ErrPersistenceLimitExceeded
-	}
-	return c.persistence.DeleteClusterMetadata(ctx, request)
-}
-
-func allow(
-	ctx context.Context,
-	api string,
-	shardID int32,
-	rateLimiter quotas.RequestRateLimiter,
-) bool {
-	callerInfo := headers.GetCallerInfo(ctx)
-	return rateLimiter.Allow(time.Now().UTC(), quotas.NewRequest(
-		api,
-		RateLimitDefaultToken,
-		callerInfo.CallerName,
-		callerInfo.CallerType,
-		shardID,
-		callerInfo.CallOrigin,
-	))
+	ctx, admission, err := c.admit(ctx, "DeleteClusterMetadata", request, CallerSegmentMissing, namespaceIDMissing)
+	if err != nil {
+		return err
+	}
+	err = c.persistence.DeleteClusterMetadata(ctx, request)
+	err = admission.done(err)
+	return err
 }
 
 // TODO: change the value returned so it can also be used by
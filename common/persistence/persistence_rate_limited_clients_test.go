@@ -0,0 +1,5409 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/api/serviceerror"
+
+	persistencespb "go.temporal.io/server/api/persistence/v1"
+	"go.temporal.io/server/common/clock"
+	"go.temporal.io/server/common/definition"
+	"go.temporal.io/server/common/headers"
+	"go.temporal.io/server/common/log"
+	"go.temporal.io/server/common/log/tag"
+	"go.temporal.io/server/common/metrics"
+	"go.temporal.io/server/common/quotas"
+	"go.temporal.io/server/common/quotas/quotastest"
+	"go.temporal.io/server/service/history/tasks"
+)
+
+type (
+	rateLimitedClientSuite struct {
+		suite.Suite
+		*require.Assertions
+
+		controller         *gomock.Controller
+		mockExecutionStore *MockExecutionManager
+		mockTaskStore      *MockTaskManager
+		mockMetadataStore  *MockMetadataManager
+		metricsHandler     *capturingMetricsHandler
+	}
+
+	// capturingMetricsHandler records the sum of all counter values and the recorded timer values
+	// by metric name and tags, and the exemplars attached to counters by metric name
+	capturingMetricsHandler struct {
+		sync.Mutex
+		tags      []metrics.Tag
+		counters  map[string]int64
+		gauges    map[string]float64
+		timers    map[string][]time.Duration
+		exemplars map[string][]map[string]string
+	}
+
+	capturingCounter struct {
+		handler *capturingMetricsHandler
+		name    string
+	}
+)
+
+const (
+	testRateLimitedClientRate  = 0.001
+	testRateLimitedClientBurst = 20
+)
+
+func TestRateLimitedClientSuite(t *testing.T) {
+	s := new(rateLimitedClientSuite)
+	suite.Run(t, s)
+}
+
+func (s *rateLimitedClientSuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+	s.controller = gomock.NewController(s.T())
+	s.mockExecutionStore = NewMockExecutionManager(s.controller)
+	s.mockTaskStore = NewMockTaskManager(s.controller)
+	s.mockMetadataStore = NewMockMetadataManager(s.controller)
+	s.mockExecutionStore.EXPECT().GetName().Return("execution").AnyTimes()
+	s.mockTaskStore.EXPECT().GetName().Return("task").AnyTimes()
+	s.mockMetadataStore.EXPECT().GetName().Return("metadata").AnyTimes()
+	s.metricsHandler = newCapturingMetricsHandler()
+}
+
+func (s *rateLimitedClientSuite) TearDownTest() {
+	s.controller.Finish()
+}
+
+func (s *rateLimitedClientSuite) TestReadHistoryBranchByBatch_ResponseSizeCharging() {
+	testCases := []struct {
+		name           string
+		responseSize   int
+		expectedTokens int
+	}{
+		{name: "small payload", responseSize: 100, expectedTokens: 1},
+		{name: "large payload", responseSize: 10 * 1024, expectedTokens: 11},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+			client := NewExecutionPersistenceRateLimitedClient(
+				s.mockExecutionStore,
+				quotas.NewRequestRateLimiterAdapter(rateLimiter),
+				log.NewNoopLogger(),
+				WithResponseSizeCharging(1024),
+			)
+			s.mockExecutionStore.EXPECT().ReadHistoryBranchByBatch(gomock.Any(), gomock.Any()).
+				Return(&ReadHistoryBranchByBatchResponse{Size: tc.responseSize}, nil)
+
+			_, err := client.ReadHistoryBranchByBatch(context.Background(), &ReadHistoryBranchRequest{ShardID: 1})
+			s.NoError(err)
+			s.Equal(testRateLimitedClientBurst-tc.expectedTokens, drainTokens(rateLimiter))
+		})
+	}
+}
+
+func (s *rateLimitedClientSuite) TestReadHistoryBranchByBatch_ResponseSizeChargingDisabled() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+	)
+	s.mockExecutionStore.EXPECT().ReadHistoryBranchByBatch(gomock.Any(), gomock.Any()).
+		Return(&ReadHistoryBranchByBatchResponse{Size: 10 * 1024}, nil)
+
+	_, err := client.ReadHistoryBranchByBatch(context.Background(), &ReadHistoryBranchRequest{ShardID: 1})
+	s.NoError(err)
+	s.Equal(testRateLimitedClientBurst-1, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestWouldAllow_DoesNotConsumeToken() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+	).(RateLimitedClient)
+
+	for i := 0; i < 2*testRateLimitedClientBurst; i++ {
+		s.True(client.WouldAllow(context.Background(), "GetWorkflowExecution", "ns-1"))
+	}
+	s.Equal(testRateLimitedClientBurst, drainTokens(rateLimiter))
+	s.False(client.WouldAllow(context.Background(), "GetWorkflowExecution", "ns-1"))
+	s.Equal(0, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestWouldAllow_MatchesAdmission() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+	)
+	rateLimitedClient := client.(RateLimitedClient)
+	ctx := context.Background()
+
+	rateLimitedClient.SetOperationEnabled("GetWorkflowExecution", false)
+	s.False(rateLimitedClient.WouldAllow(ctx, "GetWorkflowExecution", "ns-1"))
+	rateLimitedClient.SetOperationEnabled("GetWorkflowExecution", true)
+
+	rateLimitedClient.SetDrainMode(true)
+	s.False(rateLimitedClient.WouldAllow(ctx, "UpdateWorkflowExecution", "ns-1"))
+	s.True(rateLimitedClient.WouldAllow(ctx, "GetWorkflowExecution", "ns-1"))
+	rateLimitedClient.SetDrainMode(false)
+
+	client.(NamespaceRateLimitedClient).PauseNamespace("ns-1", time.Hour)
+	s.False(rateLimitedClient.WouldAllow(ctx, "GetWorkflowExecution", "ns-1"))
+	s.True(rateLimitedClient.WouldAllow(ctx, "GetWorkflowExecution", "ns-2"))
+
+	// none of the probes took a token
+	s.Equal(testRateLimitedClientBurst, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestScanRateLimiter() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	scanRateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 2)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithScanRateLimiter(quotas.NewRequestRateLimiterAdapter(scanRateLimiter)),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().ListConcreteExecutions(gomock.Any(), gomock.Any()).
+		Return(&ListConcreteExecutionsResponse{}, nil).Times(2)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&GetWorkflowExecutionResponse{}, nil).Times(3)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.ListConcreteExecutions(ctx, &ListConcreteExecutionsRequest{ShardID: 1})
+		s.NoError(err)
+	}
+	_, err := client.ListConcreteExecutions(ctx, &ListConcreteExecutionsRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = client.GetAllHistoryTreeBranches(ctx, &GetAllHistoryTreeBranchesRequest{})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+		s.NoError(err)
+	}
+	s.Equal(testRateLimitedClientBurst-3, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestSetRateLimitEnabled() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 2)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+	)
+	ctx := context.Background()
+	request := &GetWorkflowExecutionRequest{ShardID: 1}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&GetWorkflowExecutionResponse{}, nil).Times(5)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetWorkflowExecution(ctx, request)
+		s.NoError(err)
+	}
+	_, err := client.GetWorkflowExecution(ctx, request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	client.(RateLimitedClient).SetRateLimitEnabled(false)
+	for i := 0; i < 3; i++ {
+		_, err = client.GetWorkflowExecution(ctx, request)
+		s.NoError(err)
+	}
+
+	client.(RateLimitedClient).SetRateLimitEnabled(true)
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+}
+
+func (s *rateLimitedClientSuite) TestLowRateWarning() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst))
+
+	logger := log.NewMockLogger(s.controller)
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).Times(1)
+	NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, rateLimiter, logger,
+		WithLowRateWarning(func() float64 { return 1 }, 100),
+	)
+
+	// no warning is expected above the floor
+	NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, rateLimiter, log.NewMockLogger(s.controller),
+		WithLowRateWarning(func() float64 { return 1000 }, 100),
+	)
+}
+
+func (s *rateLimitedClientSuite) TestLowRateWarning_QuietPeriod() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	logger := log.NewMockLogger(s.controller)
+	client := NewQueuePersistenceRateLimitedClient(
+		noopQueue{},
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1000, 1000)),
+		logger,
+		WithTimeSource(timeSource),
+		WithLowRateWarning(func() float64 { return 1000 }, 100),
+		WithLowRateWarningQuietPeriod(time.Minute),
+	)
+
+	// nudging the rate down repeatedly warns once per quiet period
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).Times(1)
+	for rate := 90; rate > 0; rate -= 10 {
+		s.NoError(client.(RateLimitedClient).UpdateRateLimit(float64(rate), rate))
+		timeSource.Update(timeSource.Now().Add(5 * time.Second))
+	}
+
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).Times(1)
+	timeSource.Update(now.Add(time.Minute))
+	s.NoError(client.(RateLimitedClient).UpdateRateLimit(5, 5))
+	s.NoError(client.(RateLimitedClient).UpdateRateLimit(1, 1))
+}
+
+func (s *rateLimitedClientSuite) TestRejectionMetrics_NamespaceTag() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0))
+	logger := log.NewNoopLogger()
+	executionClient := NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, rateLimiter, logger, WithMetricsHandler(s.metricsHandler))
+	taskClient := NewTaskPersistenceRateLimitedClient(s.mockTaskStore, rateLimiter, logger, WithMetricsHandler(s.metricsHandler))
+	metadataClient := NewMetadataPersistenceRateLimitedClient(s.mockMetadataStore, rateLimiter, logger, WithMetricsHandler(s.metricsHandler))
+	ctx := context.Background()
+
+	_, err := executionClient.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = executionClient.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{
+		ShardID: 1,
+		UpdateWorkflowMutation: WorkflowMutation{
+			ExecutionInfo: &persistencespb.WorkflowExecutionInfo{NamespaceId: "ns-2"},
+		},
+	})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = taskClient.GetTasks(ctx, &GetTasksRequest{NamespaceID: "ns-1"})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = metadataClient.GetNamespace(ctx, &GetNamespaceRequest{ID: "ns-3"})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = executionClient.GetHistoryTree(ctx, &GetHistoryTreeRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	rejections := metrics.PersistenceRateLimitRejections.GetMetricName()
+	s.Equal(int64(1), s.metricsHandler.counter(rejections, metrics.OperationTag("GetWorkflowExecution"), metrics.NamespaceIDTag("ns-1")))
+	s.Equal(int64(1), s.metricsHandler.counter(rejections, metrics.OperationTag("UpdateWorkflowExecution"), metrics.NamespaceIDTag("ns-2")))
+	s.Equal(int64(1), s.metricsHandler.counter(rejections, metrics.OperationTag("GetTasks"), metrics.NamespaceIDTag("ns-1")))
+	s.Equal(int64(1), s.metricsHandler.counter(rejections, metrics.OperationTag("GetNamespace"), metrics.NamespaceIDTag("ns-3")))
+	s.Equal(int64(1), s.metricsHandler.counter(rejections, metrics.OperationTag("GetHistoryTree"), metrics.NamespaceIDTag("")))
+}
+
+func (s *rateLimitedClientSuite) TestTokenRefund() {
+	testCases := []struct {
+		name           string
+		err            error
+		expectedTokens int
+	}{
+		{name: "refund on connection error", err: serviceerror.NewUnavailable("connection refused"), expectedTokens: testRateLimitedClientBurst},
+		{name: "no refund on success", err: nil, expectedTokens: testRateLimitedClientBurst - 1},
+		{name: "no refund on business error", err: &ConditionFailedError{Msg: "condition failed"}, expectedTokens: testRateLimitedClientBurst - 1},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+			client := NewExecutionPersistenceRateLimitedClient(
+				s.mockExecutionStore,
+				quotas.NewRequestRateLimiterAdapter(rateLimiter),
+				log.NewNoopLogger(),
+				WithTokenRefund(func(err error) bool {
+					_, ok := err.(*serviceerror.Unavailable)
+					return ok
+				}),
+			)
+			s.mockExecutionStore.EXPECT().UpdateWorkflowExecution(gomock.Any(), gomock.Any()).
+				Return(&UpdateWorkflowExecutionResponse{}, tc.err)
+
+			_, err := client.UpdateWorkflowExecution(context.Background(), &UpdateWorkflowExecutionRequest{ShardID: 1})
+			s.Equal(tc.err, err)
+			s.Equal(tc.expectedTokens, drainTokens(rateLimiter))
+		})
+	}
+}
+
+func (s *rateLimitedClientSuite) TestContextErrorRefund() {
+	testCases := []struct {
+		name           string
+		err            error
+		expectedTokens int
+	}{
+		{name: "refund on cancellation", err: context.Canceled, expectedTokens: testRateLimitedClientBurst},
+		{name: "refund on deadline", err: fmt.Errorf("get workflow execution: %w", context.DeadlineExceeded), expectedTokens: testRateLimitedClientBurst},
+		{name: "no refund on success", err: nil, expectedTokens: testRateLimitedClientBurst - 1},
+		{name: "no refund on backend failure", err: serviceerror.NewUnavailable("connection refused"), expectedTokens: testRateLimitedClientBurst - 1},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+			client := NewExecutionPersistenceRateLimitedClient(
+				s.mockExecutionStore,
+				quotas.NewRequestRateLimiterAdapter(rateLimiter),
+				log.NewNoopLogger(),
+				WithContextErrorRefund(),
+			)
+			s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).
+				Return(&GetWorkflowExecutionResponse{}, tc.err)
+
+			_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+			s.Equal(tc.err, err)
+			s.Equal(tc.expectedTokens, drainTokens(rateLimiter))
+		})
+	}
+}
+
+func (s *rateLimitedClientSuite) TestPauseNamespace() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst))
+	client := NewTaskPersistenceRateLimitedClient(s.mockTaskStore, rateLimiter, log.NewNoopLogger())
+	ctx := context.Background()
+	s.mockTaskStore.EXPECT().GetTasks(gomock.Any(), gomock.Any()).Return(&GetTasksResponse{}, nil).AnyTimes()
+
+	client.(NamespaceRateLimitedClient).PauseNamespace("paused-ns", time.Hour)
+	_, err := client.GetTasks(ctx, &GetTasksRequest{NamespaceID: "paused-ns"})
+	s.Equal(ErrNamespacePaused, err)
+	_, err = client.GetTasks(ctx, &GetTasksRequest{NamespaceID: "other-ns"})
+	s.NoError(err)
+
+	client.(NamespaceRateLimitedClient).ResumeNamespace("paused-ns")
+	_, err = client.GetTasks(ctx, &GetTasksRequest{NamespaceID: "paused-ns"})
+	s.NoError(err)
+}
+
+func (s *rateLimitedClientSuite) TestPauseNamespace_Expiry() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst))
+	client := NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, rateLimiter, log.NewNoopLogger())
+	ctx := context.Background()
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "paused-ns"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil)
+
+	client.(NamespaceRateLimitedClient).PauseNamespace("paused-ns", 50*time.Millisecond)
+	_, err := client.GetWorkflowExecution(ctx, request)
+	s.Equal(ErrNamespacePaused, err)
+
+	s.Eventually(func() bool {
+		_, err := client.GetWorkflowExecution(ctx, request)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (s *rateLimitedClientSuite) TestBurstyWriteAndSteadyReadRateLimiters() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0))
+	writeRateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 10))
+	readRateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 2))
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithBurstyWriteRateLimiter(writeRateLimiter),
+		WithSteadyReadRateLimiter(readRateLimiter),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().CreateWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&CreateWorkflowExecutionResponse{}, nil).Times(10)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+
+	for i := 0; i < 10; i++ {
+		_, err := client.CreateWorkflowExecution(ctx, &CreateWorkflowExecutionRequest{ShardID: 1})
+		s.NoError(err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+		s.NoError(err)
+	}
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	// other operations are still throttled by the main rate limiter
+	_, err = client.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+}
+
+func (s *rateLimitedClientSuite) TestDeadlineAwareWait_DeadlineExceeded() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 1))
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithDeadlineAwareWait(),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+
+	// the next token only becomes available long after the deadline
+	start := time.Now()
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Less(time.Since(start), time.Second)
+
+	s.Equal(int64(1), s.metricsHandler.counter(
+		metrics.PersistenceRateLimitDeadlineExceeded.GetMetricName(),
+		metrics.OperationTag("GetWorkflowExecution"),
+	))
+	s.Equal(int64(1), s.metricsHandler.counter(
+		metrics.PersistenceRateLimitRejections.GetMetricName(),
+		metrics.OperationTag("GetWorkflowExecution"),
+		metrics.NamespaceIDTag("ns-1"),
+	))
+}
+
+func (s *rateLimitedClientSuite) TestDeadlineAwareWait_WaitsForToken() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(100, 1))
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithDeadlineAwareWait(),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+
+	s.Equal(int64(0), s.metricsHandler.counter(
+		metrics.PersistenceRateLimitDeadlineExceeded.GetMetricName(),
+		metrics.OperationTag("GetWorkflowExecution"),
+	))
+}
+
+func (s *rateLimitedClientSuite) TestDownstreamLatencyByAdmission_Immediate() {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	timeSource := clock.NewEventTimeSource().Update(now)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 1)),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithTimeSource(timeSource),
+		WithDownstreamLatencyByAdmission(),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).
+		DoAndReturn(func(context.Context, *GetWorkflowExecutionRequest) (*GetWorkflowExecutionResponse, error) {
+			timeSource.Update(now.Add(5 * time.Millisecond))
+			return &GetWorkflowExecutionResponse{}, nil
+		})
+
+	_, err := client.GetWorkflowExecution(context.Background(), request)
+	s.NoError(err)
+	// rejected requests never reach persistence, so they have no downstream latency
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	latency := metrics.PersistenceDownstreamLatency.GetMetricName()
+	s.Equal([]time.Duration{5 * time.Millisecond}, s.metricsHandler.timer(
+		latency,
+		metrics.OperationTag("GetWorkflowExecution"),
+		metrics.StringTag(admissionTagName, admissionImmediate),
+	))
+	s.Empty(s.metricsHandler.timer(latency, metrics.StringTag(admissionTagName, admissionWaited)))
+}
+
+func (s *rateLimitedClientSuite) TestDownstreamLatencyByAdmission_Waited() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(100, 1)),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithDeadlineAwareWait(),
+		WithDownstreamLatencyByAdmission(),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+	// the second request waits for the next token
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+
+	latency := metrics.PersistenceDownstreamLatency.GetMetricName()
+	s.Len(s.metricsHandler.timer(latency, metrics.StringTag(admissionTagName, admissionImmediate)), 1)
+	waited := s.metricsHandler.timer(latency, metrics.StringTag(admissionTagName, admissionWaited))
+	s.Len(waited, 1)
+	// the wait for the token is not part of the downstream latency
+	s.Less(waited[0], 5*time.Millisecond)
+}
+
+func (s *rateLimitedClientSuite) TestDownstreamLatencyByAdmission_Disabled() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil)
+
+	_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	s.Empty(s.metricsHandler.timer(metrics.PersistenceDownstreamLatency.GetMetricName()))
+}
+
+func (s *rateLimitedClientSuite) TestMaxWait() {
+	testCases := []struct {
+		name    string
+		maxWait time.Duration
+		// expectedErr is the error of the request arriving after the burst, which
+		// waits 100ms for a token
+		expectedErr error
+	}{
+		{name: "delay below max wait", maxWait: time.Second, expectedErr: nil},
+		{name: "delay above max wait", maxWait: 10 * time.Millisecond, expectedErr: ErrPersistenceLimitExceeded},
+	}
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			rateLimiter := quotas.NewRateLimiter(10, 1)
+			client := NewExecutionPersistenceRateLimitedClient(
+				s.mockExecutionStore,
+				quotas.NewRequestRateLimiterAdapter(rateLimiter),
+				log.NewNoopLogger(),
+				WithMaxWait(tc.maxWait),
+			)
+			request := &GetWorkflowExecutionRequest{ShardID: 1}
+			calls := 1
+			if tc.expectedErr == nil {
+				calls = 2
+			}
+			s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil).Times(calls)
+
+			_, err := client.GetWorkflowExecution(context.Background(), request)
+			s.NoError(err)
+			start := time.Now()
+			_, err = client.GetWorkflowExecution(context.Background(), request)
+			s.Equal(tc.expectedErr, err)
+			if tc.expectedErr == nil {
+				s.GreaterOrEqual(time.Since(start), 50*time.Millisecond)
+			} else {
+				s.Less(time.Since(start), 50*time.Millisecond)
+				// the reservation of the rejected request was canceled
+				s.Equal(0, drainTokens(rateLimiter))
+				time.Sleep(100 * time.Millisecond)
+				s.Equal(1, drainTokens(rateLimiter))
+			}
+		})
+	}
+}
+
+func (s *rateLimitedClientSuite) TestMaxWaiters() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(10, 1)),
+		log.NewNoopLogger(),
+		WithDeadlineAwareWait(),
+		WithMaxWaiters(2),
+	)
+	enforcer := client.(*executionRateLimitedPersistenceClient).rateLimitEnforcer
+	request := &GetWorkflowExecutionRequest{ShardID: 1}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil).Times(3)
+
+	_, err := client.GetWorkflowExecution(context.Background(), request)
+	s.NoError(err)
+
+	// saturate the waiters
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GetWorkflowExecution(context.Background(), request)
+			s.NoError(err)
+		}()
+	}
+	s.Eventually(func() bool { return enforcer.waiters.Load() == 2 }, time.Second, time.Millisecond)
+
+	// further requests are rejected right away instead of parking
+	start := time.Now()
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Less(time.Since(start), 50*time.Millisecond)
+
+	wg.Wait()
+	s.Equal(int64(0), enforcer.waiters.Load())
+}
+
+func (s *rateLimitedClientSuite) TestDeadlineAwareWait_WaitLatencyHistogram() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(100, 1)),
+		log.NewNoopLogger(),
+		WithDeadlineAwareWait(),
+		WithWaitLatencyHistogram(time.Minute),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+	// the second request waits for the next token, 10ms later
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+
+	reporter := client.(WaitLatencyReporter)
+	s.Equal(time.Millisecond, reporter.WaitLatencyPercentile("GetWorkflowExecution", 50))
+	s.Equal(10*time.Millisecond, reporter.WaitLatencyPercentile("GetWorkflowExecution", 99))
+	s.Zero(reporter.WaitLatencyPercentile("UpdateWorkflowExecution", 99))
+}
+
+func (s *rateLimitedClientSuite) TestFailFast_NoDeadlineExceededMetric() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0))
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	s.Equal(int64(0), s.metricsHandler.counter(
+		metrics.PersistenceRateLimitDeadlineExceeded.GetMetricName(),
+		metrics.OperationTag("GetWorkflowExecution"),
+	))
+	s.Equal(int64(1), s.metricsHandler.counter(
+		metrics.PersistenceRateLimitRejections.GetMetricName(),
+		metrics.OperationTag("GetWorkflowExecution"),
+		metrics.NamespaceIDTag("ns-1"),
+	))
+}
+
+func (s *rateLimitedClientSuite) TestNilRateLimiter() {
+	mockShardStore := NewMockShardManager(s.controller)
+	mockClusterMetadataStore := NewMockClusterMetadataManager(s.controller)
+	s.mockExecutionStore.EXPECT().ReadHistoryBranchByBatch(gomock.Any(), gomock.Any()).
+		Return(&ReadHistoryBranchByBatchResponse{}, nil).AnyTimes()
+	for _, mock := range []interface{}{
+		mockShardStore,
+		s.mockExecutionStore,
+		s.mockTaskStore,
+		s.mockMetadataStore,
+		mockClusterMetadataStore,
+	} {
+		expectAnyCalls(mock)
+	}
+	logger := log.NewMockLogger(s.controller)
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).Times(6)
+
+	clients := map[interface{}]interface{}{
+		(*ShardManager)(nil):           NewShardPersistenceRateLimitedClient(mockShardStore, nil, logger),
+		(*ExecutionManager)(nil):       NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, nil, logger),
+		(*TaskManager)(nil):            NewTaskPersistenceRateLimitedClient(s.mockTaskStore, nil, logger),
+		(*MetadataManager)(nil):        NewMetadataPersistenceRateLimitedClient(s.mockMetadataStore, nil, logger),
+		(*ClusterMetadataManager)(nil): NewClusterMetadataPersistenceRateLimitedClient(mockClusterMetadataStore, nil, logger),
+		(*Queue)(nil):                  NewQueuePersistenceRateLimitedClient(noopQueue{}, nil, logger),
+	}
+	for iface, client := range clients {
+		quotastest.CallAllMethods(s.T(), context.Background(), iface, client)
+	}
+}
+
+func (s *rateLimitedClientSuite) TestAllOperationsRateLimited() {
+	mockShardStore := NewMockShardManager(s.controller)
+	mockClusterMetadataStore := NewMockClusterMetadataManager(s.controller)
+	mockShardStore.EXPECT().GetName().Return("shard").AnyTimes()
+	mockClusterMetadataStore.EXPECT().GetName().Return("cluster-metadata").AnyTimes()
+	s.mockExecutionStore.EXPECT().ReadHistoryBranchByBatch(gomock.Any(), gomock.Any()).
+		Return(&ReadHistoryBranchByBatchResponse{}, nil).AnyTimes()
+	for _, mock := range []interface{}{
+		mockShardStore,
+		s.mockExecutionStore,
+		s.mockTaskStore,
+		s.mockMetadataStore,
+		mockClusterMetadataStore,
+	} {
+		expectAnyCalls(mock)
+	}
+	rateLimiter := quotastest.NewCountingRateLimiter(quotastest.Unlimited)
+	logger := log.NewNoopLogger()
+
+	clients := map[interface{}]interface{}{
+		(*ShardManager)(nil):           NewShardPersistenceRateLimitedClient(mockShardStore, rateLimiter, logger),
+		(*ExecutionManager)(nil):       NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, rateLimiter, logger),
+		(*TaskManager)(nil):            NewTaskPersistenceRateLimitedClient(s.mockTaskStore, rateLimiter, logger),
+		(*MetadataManager)(nil):        NewMetadataPersistenceRateLimitedClient(s.mockMetadataStore, rateLimiter, logger),
+		(*ClusterMetadataManager)(nil): NewClusterMetadataPersistenceRateLimitedClient(mockClusterMetadataStore, rateLimiter, logger),
+		(*Queue)(nil):                  NewQueuePersistenceRateLimitedClient(noopQueue{}, rateLimiter, logger),
+	}
+	// the operations which do not reach the store, must not consume tokens, or set it up
+	notRateLimited := map[string]struct{}{
+		"GetName":                         {},
+		"Close":                           {},
+		"Init":                            {},
+		"GetHistoryBranchUtil":            {},
+		"RegisterHistoryTaskReader":       {},
+		"UnregisterHistoryTaskReader":     {},
+		"UpdateHistoryTaskReaderProgress": {},
+	}
+	for iface, client := range clients {
+		for _, method := range quotastest.CallAllMethods(s.T(), context.Background(), iface, client) {
+			if _, ok := notRateLimited[method]; ok {
+				s.Zero(rateLimiter.CallsOf(method), method)
+			} else {
+				s.NotZero(rateLimiter.CallsOf(method), method)
+			}
+		}
+	}
+}
+
+func (s *rateLimitedClientSuite) TestSpanAttributes() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 1)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithSpanAttributes(rateLimiter.TokensAt),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil)
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	for i := 0; i < 2; i++ {
+		ctx, span := tracer.Start(context.Background(), "GetWorkflowExecution")
+		_, _ = client.GetWorkflowExecution(ctx, request)
+		span.End()
+	}
+
+	spans := recorder.Ended()
+	s.Len(spans, 2)
+	allowedAttributes := attributesByKey(spans[0].Attributes())
+	s.False(allowedAttributes[spanAttributeRateLimited].AsBool())
+	s.InDelta(0, allowedAttributes[spanAttributeTokensRemaining].AsFloat64(), 0.1)
+	rejectedAttributes := attributesByKey(spans[1].Attributes())
+	s.True(rejectedAttributes[spanAttributeRateLimited].AsBool())
+	s.Contains(rejectedAttributes, attribute.Key(spanAttributeTokensRemaining))
+}
+
+func (s *rateLimitedClientSuite) TestRejectionExemplars() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotastest.NewCountingRateLimiter(0),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	rejectionsTotal := metrics.PersistenceRateLimitRejectionsTotal.GetMetricName()
+
+	// rejections of requests not sampled for tracing carry no exemplar
+	_, err := client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.NeverSample())).Tracer("test")
+	ctx, span := tracer.Start(context.Background(), "GetWorkflowExecution")
+	_, err = client.GetWorkflowExecution(ctx, request)
+	span.End()
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Empty(s.metricsHandler.exemplarsOf(rejectionsTotal))
+
+	// while rejections of sampled requests link to their trace
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample())).Tracer("test")
+	ctx, span = tracer.Start(context.Background(), "GetWorkflowExecution")
+	_, err = client.GetWorkflowExecution(ctx, request)
+	span.End()
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Equal(
+		[]map[string]string{{metrics.TraceIDExemplarKey: span.SpanContext().TraceID().String()}},
+		s.metricsHandler.exemplarsOf(rejectionsTotal),
+	)
+	s.Equal(int64(3), s.metricsHandler.counter(rejectionsTotal))
+}
+
+func (s *rateLimitedClientSuite) TestRejectionExemplars_Unsupported() {
+	counter := metrics.NewMockCounterIface(s.controller)
+	counter.EXPECT().Record(int64(1), gomock.Any()).Times(1)
+	metricsHandler := metrics.NewMockHandler(s.controller)
+	metricsHandler.EXPECT().Counter(metrics.PersistenceRateLimitRejectionsTotal.GetMetricName()).Return(counter)
+	metricsHandler.EXPECT().Counter(gomock.Any()).Return(metrics.NoopCounterMetricFunc).AnyTimes()
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotastest.NewCountingRateLimiter(0),
+		log.NewNoopLogger(),
+		WithMetricsHandler(metricsHandler),
+	)
+
+	// the rejection is counted without its exemplar
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample())).Tracer("test")
+	ctx, span := tracer.Start(context.Background(), "GetWorkflowExecution")
+	defer span.End()
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+}
+
+func (s *rateLimitedClientSuite) TestSpanAttributes_NoSpan() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 1)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithSpanAttributes(func(now time.Time) float64 {
+			s.Fail("tokens remaining should not be computed without a recording span")
+			return 0
+		}),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil)
+
+	_, err := client.GetWorkflowExecution(context.Background(), request)
+	s.NoError(err)
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+}
+
+func (s *rateLimitedClientSuite) TestResetRateLimitStats() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0))
+	client := NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, rateLimiter, log.NewNoopLogger())
+	ctx := context.Background()
+
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = client.GetHistoryTree(ctx, &GetHistoryTreeRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	stats := client.(RateLimitedClient).RateLimitStats()
+	s.Equal(int64(3), stats.Rejections)
+	s.Equal(map[string]int64{"GetWorkflowExecution": 2, "GetHistoryTree": 1}, stats.RejectionsByOperation)
+	s.False(stats.LastRejectionTime.IsZero())
+
+	client.(RateLimitedClient).ResetRateLimitStats()
+	stats = client.(RateLimitedClient).RateLimitStats()
+	s.Zero(stats.Rejections)
+	s.Empty(stats.RejectionsByOperation)
+	s.True(stats.LastRejectionTime.IsZero())
+}
+
+func (s *rateLimitedClientSuite) TestResetRateLimitStats_Concurrent() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0))
+	client := NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, rateLimiter, log.NewNoopLogger())
+	rateLimitedClient := client.(RateLimitedClient)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rateLimitedClient.ResetRateLimitStats()
+				stats := rateLimitedClient.RateLimitStats()
+				s.Equal(stats.Rejections, stats.RejectionsByOperation["GetWorkflowExecution"])
+			}
+		}()
+	}
+	wg.Wait()
+
+	rateLimitedClient.ResetRateLimitStats()
+	s.Zero(rateLimitedClient.RateLimitStats().Rejections)
+}
+
+func (s *rateLimitedClientSuite) TestCleanupRateLimiter() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	cleanupRateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 2)
+	taskClient := NewTaskPersistenceRateLimitedClient(
+		s.mockTaskStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithCleanupRateLimiter(quotas.NewRequestRateLimiterAdapter(cleanupRateLimiter)),
+	)
+	queueClient := NewQueuePersistenceRateLimitedClient(
+		noopQueue{},
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithCleanupRateLimiter(quotas.NewRequestRateLimiterAdapter(cleanupRateLimiter)),
+	)
+	ctx := context.Background()
+	s.mockTaskStore.EXPECT().CompleteTasksLessThan(gomock.Any(), gomock.Any()).Return(0, nil)
+	s.mockTaskStore.EXPECT().CompleteTask(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+
+	_, err := taskClient.CompleteTasksLessThan(ctx, &CompleteTasksLessThanRequest{NamespaceID: "ns-1"})
+	s.NoError(err)
+	s.NoError(queueClient.DeleteMessagesBefore(ctx, 1))
+	_, err = taskClient.CompleteTasksLessThan(ctx, &CompleteTasksLessThanRequest{NamespaceID: "ns-1"})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Equal(ErrPersistenceLimitExceeded, queueClient.RangeDeleteMessagesFromDLQ(ctx, 1, 2))
+
+	// per task completes are still served by the main rate limiter
+	for i := 0; i < 3; i++ {
+		s.NoError(taskClient.CompleteTask(ctx, &CompleteTaskRequest{TaskQueue: &TaskQueueKey{NamespaceID: "ns-1"}}))
+	}
+	s.Equal(testRateLimitedClientBurst-3, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestRetentionDeleteRateLimiter() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	retentionDeleteRateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 2)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithRetentionDeleteRateLimiter(quotas.NewRequestRateLimiterAdapter(retentionDeleteRateLimiter)),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().DeleteWorkflowExecution(gomock.Any(), gomock.Any()).Return(nil)
+	s.mockExecutionStore.EXPECT().DeleteCurrentWorkflowExecution(gomock.Any(), gomock.Any()).Return(nil)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).Times(3)
+
+	s.NoError(client.DeleteWorkflowExecution(ctx, &DeleteWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}))
+	s.NoError(client.DeleteCurrentWorkflowExecution(ctx, &DeleteCurrentWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}))
+	s.Equal(ErrPersistenceLimitExceeded, client.DeleteWorkflowExecution(ctx, &DeleteWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}))
+
+	// other operations keep flowing through the main rate limiter
+	for i := 0; i < 3; i++ {
+		_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"})
+		s.NoError(err)
+	}
+	s.Equal(testRateLimitedClientBurst-3, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestTimeSource_Refill() {
+	timeSource := clock.NewEventTimeSource().Update(time.Now())
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1, 1)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+	ctx := context.Background()
+
+	_, err := client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Equal(timeSource.Now(), client.(RateLimitedClient).RateLimitStats().LastRejectionTime)
+
+	timeSource.Update(timeSource.Now().Add(999 * time.Millisecond))
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	timeSource.Update(timeSource.Now().Add(time.Millisecond))
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+}
+
+func (s *rateLimitedClientSuite) TestTimeSource_RetryAfter() {
+	timeSource := clock.NewEventTimeSource().Update(time.Now())
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1, 1)),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithTimeSource(timeSource),
+		WithDeadlineAwareWait(),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+
+	_, err := client.GetWorkflowExecution(context.Background(), request)
+	s.NoError(err)
+
+	// the next token is available 1s after the last one was taken, past the deadline
+	ctx, cancel := context.WithDeadline(context.Background(), timeSource.Now().Add(500*time.Millisecond))
+	defer cancel()
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Equal(int64(1), s.metricsHandler.counter(
+		metrics.PersistenceRateLimitDeadlineExceeded.GetMetricName(),
+		metrics.OperationTag("GetWorkflowExecution"),
+	))
+
+	// once the clock passes the retry-after point, the request goes through without waiting
+	timeSource.Update(timeSource.Now().Add(time.Second))
+	ctx, cancel = context.WithDeadline(context.Background(), timeSource.Now().Add(500*time.Millisecond))
+	defer cancel()
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+}
+
+func (s *rateLimitedClientSuite) TestTimeSource_PauseExpiry() {
+	timeSource := clock.NewEventTimeSource().Update(time.Now())
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil)
+
+	client.(NamespaceRateLimitedClient).PauseNamespace("ns-1", time.Minute)
+	_, err := client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrNamespacePaused, err)
+
+	timeSource.Update(timeSource.Now().Add(time.Minute))
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.NoError(err)
+}
+
+func (s *rateLimitedClientSuite) TestHeadroomSignal() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 3)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithHeadroomSignal(rateLimiter.TokensAt),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+
+	headroom := &RateLimitHeadroom{}
+	ctx := WithRateLimitHeadroom(context.Background(), headroom)
+	_, populated := headroom.TokensRemaining()
+	s.False(populated)
+
+	_, err := client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+	tokensRemaining, populated := headroom.TokensRemaining()
+	s.True(populated)
+	s.InDelta(2, tokensRemaining, 0.1)
+
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.NoError(err)
+	tokensRemaining, _ = headroom.TokensRemaining()
+	s.InDelta(1, tokensRemaining, 0.1)
+}
+
+func (s *rateLimitedClientSuite) TestHeadroomSignal_Disabled() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 3)),
+		log.NewNoopLogger(),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil)
+
+	headroom := &RateLimitHeadroom{}
+	_, err := client.GetWorkflowExecution(WithRateLimitHeadroom(context.Background(), headroom), request)
+	s.NoError(err)
+	_, populated := headroom.TokensRemaining()
+	s.False(populated)
+}
+
+func (s *rateLimitedClientSuite) TestGetNameAndClose_NeverRateLimited() {
+	// the mock rate limiter fails the test on any call
+	rateLimiter := quotas.NewMockRequestRateLimiter(s.controller)
+	logger := log.NewNoopLogger()
+	mockShardStore := NewMockShardManager(s.controller)
+	mockExecutionStore := NewMockExecutionManager(s.controller)
+	mockTaskStore := NewMockTaskManager(s.controller)
+	mockMetadataStore := NewMockMetadataManager(s.controller)
+	mockClusterMetadataStore := NewMockClusterMetadataManager(s.controller)
+
+	type namedCloseable interface {
+		GetName() string
+		Close()
+	}
+	// the names are looked up once, when the clients are created
+	mockShardStore.EXPECT().GetName().Return("shard")
+	mockShardStore.EXPECT().Close()
+	mockExecutionStore.EXPECT().GetName().Return("execution")
+	mockExecutionStore.EXPECT().Close()
+	mockTaskStore.EXPECT().GetName().Return("task")
+	mockTaskStore.EXPECT().Close()
+	mockMetadataStore.EXPECT().GetName().Return("metadata")
+	mockMetadataStore.EXPECT().Close()
+	mockClusterMetadataStore.EXPECT().GetName().Return("cluster metadata")
+	mockClusterMetadataStore.EXPECT().Close()
+	clients := map[string]namedCloseable{
+		"shard":            NewShardPersistenceRateLimitedClient(mockShardStore, rateLimiter, logger),
+		"execution":        NewExecutionPersistenceRateLimitedClient(mockExecutionStore, rateLimiter, logger),
+		"task":             NewTaskPersistenceRateLimitedClient(mockTaskStore, rateLimiter, logger),
+		"metadata":         NewMetadataPersistenceRateLimitedClient(mockMetadataStore, rateLimiter, logger),
+		"cluster metadata": NewClusterMetadataPersistenceRateLimitedClient(mockClusterMetadataStore, rateLimiter, logger),
+	}
+
+	for name, client := range clients {
+		s.Equal(name, client.GetName())
+		client.Close()
+	}
+
+	// the queue client has no GetName
+	queueClient := NewQueuePersistenceRateLimitedClient(noopQueue{}, rateLimiter, logger)
+	queueClient.Close()
+}
+
+func (s *rateLimitedClientSuite) TestBackpressureEvents() {
+	timeSource := clock.NewEventTimeSource().Update(time.Now())
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 2)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithBackpressureEvents(10*time.Second, time.Second, 0.5),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+	s.mockExecutionStore.EXPECT().Close()
+	events := client.(RateLimitedClient).Subscribe()
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetWorkflowExecution(context.Background(), request)
+		s.NoError(err)
+	}
+	s.Empty(events)
+
+	// sustained rejections cross the threshold once
+	for i := 0; i < 10; i++ {
+		_, err := client.GetWorkflowExecution(context.Background(), request)
+		s.Equal(ErrPersistenceLimitExceeded, err)
+	}
+	event := <-events
+	s.True(event.Rising)
+	s.Equal(0.5, event.Threshold)
+	s.Equal(0.5, event.RejectionRate)
+	s.Empty(events)
+
+	client.Close()
+	_, ok := <-events
+	s.False(ok)
+}
+
+func (s *rateLimitedClientSuite) TestThrottlingNotifications() {
+	// without monotonic clock reading nor location, to compare with the decoded notifications
+	now := time.Unix(1700000000, 0).UTC()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	queue := &notificationQueue{messages: make(chan commonpb.DataBlob, 16)}
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 2)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithThrottlingNotifications(queue, 10*time.Second, 0.5),
+	)
+	notifier := client.(*executionRateLimitedPersistenceClient).throttlingNotifier
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+	get := func(count int) {
+		for i := 0; i < count; i++ {
+			_, _ = client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+		}
+	}
+	nextNotification := func() ThrottlingNotification {
+		var blob commonpb.DataBlob
+		select {
+		case blob = <-queue.messages:
+		case <-time.After(time.Second):
+			s.FailNow("throttling notification not enqueued")
+		}
+		s.Equal(enumspb.ENCODING_TYPE_JSON, blob.EncodingType)
+		notification, err := ThrottlingNotificationFromBlob(&blob)
+		s.NoError(err)
+		s.Eventually(func() bool { return !notifier.enqueuing.Load() }, time.Second, time.Millisecond)
+		return notification
+	}
+
+	get(4)
+	timeSource.Update(now.Add(5 * time.Second))
+	get(2)
+	s.Empty(queue.messages)
+
+	// the first request after the window is over notifies it
+	timeSource.Update(now.Add(10 * time.Second))
+	get(1)
+	s.Equal(ThrottlingNotification{
+		StoreName:     "execution",
+		RejectionRate: 4.0 / 6.0,
+		Allowed:       2,
+		Rejected:      4,
+		WindowStart:   now,
+		WindowEnd:     now.Add(10 * time.Second),
+	}, nextNotification())
+
+	// sustained throttling is notified once per window
+	timeSource.Update(now.Add(15 * time.Second))
+	get(5)
+	s.Empty(queue.messages)
+	timeSource.Update(now.Add(20 * time.Second))
+	get(1)
+	notification := nextNotification()
+	s.Equal(int64(6), notification.Rejected)
+	s.Equal(1.0, notification.RejectionRate)
+	s.Empty(queue.messages)
+}
+
+func (s *rateLimitedClientSuite) TestCostBasedLimiting_ConflictResolve() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithCostBasedLimiting(),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().ConflictResolveWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&ConflictResolveWorkflowExecutionResponse{}, nil).Times(2)
+
+	// a small conflict resolve only rewrites the reset workflow
+	_, err := client.ConflictResolveWorkflowExecution(ctx, &ConflictResolveWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	s.Equal(testRateLimitedClientBurst-1, drainTokens(rateLimiter))
+
+	// a large conflict resolve rewrites 3 workflows, with 250 events and 30 tasks
+	rateLimiter = quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	client = NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithCostBasedLimiting(),
+	)
+	_, err = client.ConflictResolveWorkflowExecution(ctx, &ConflictResolveWorkflowExecutionRequest{
+		ShardID: 1,
+		ResetWorkflowSnapshot: WorkflowSnapshot{
+			Tasks: map[tasks.Category][]tasks.Task{tasks.CategoryTransfer: newTestTasks(10)},
+		},
+		ResetWorkflowEvents: []*WorkflowEvents{{Events: make([]*historypb.HistoryEvent, 150)}},
+		NewWorkflowSnapshot: &WorkflowSnapshot{
+			Tasks: map[tasks.Category][]tasks.Task{tasks.CategoryTransfer: newTestTasks(10)},
+		},
+		NewWorkflowEvents: []*WorkflowEvents{{Events: make([]*historypb.HistoryEvent, 50)}},
+		CurrentWorkflowMutation: &WorkflowMutation{
+			Tasks: map[tasks.Category][]tasks.Task{tasks.CategoryTimer: newTestTasks(10)},
+		},
+		CurrentWorkflowEvents: []*WorkflowEvents{{Events: make([]*historypb.HistoryEvent, 50)}},
+	})
+	s.NoError(err)
+	// 3 rows + 250/100 events + 30/10 tasks
+	s.Equal(testRateLimitedClientBurst-8, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestCostBasedLimiting_UpdateWorkflow() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithCostBasedLimiting(),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().UpdateWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&UpdateWorkflowExecutionResponse{}, nil).Times(2)
+
+	// a trivial update only writes the mutable state with a handful of events and tasks
+	_, err := client.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{
+		ShardID: 1,
+		UpdateWorkflowMutation: WorkflowMutation{
+			Tasks: map[tasks.Category][]tasks.Task{tasks.CategoryTransfer: newTestTasks(2)},
+		},
+		UpdateWorkflowEvents: []*WorkflowEvents{{Events: make([]*historypb.HistoryEvent, 3)}},
+	})
+	s.NoError(err)
+	s.Equal(testRateLimitedClientBurst-1, drainTokens(rateLimiter))
+
+	// a heavy update continues as new, with 150 events, 40 tasks across categories and 20 buffered events
+	rateLimiter = quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	client = NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithCostBasedLimiting(),
+	)
+	_, err = client.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{
+		ShardID: 1,
+		UpdateWorkflowMutation: WorkflowMutation{
+			Tasks: map[tasks.Category][]tasks.Task{
+				tasks.CategoryTransfer:    newTestTasks(10),
+				tasks.CategoryTimer:       newTestTasks(10),
+				tasks.CategoryVisibility:  newTestTasks(5),
+				tasks.CategoryReplication: newTestTasks(5),
+			},
+			NewBufferedEvents: make([]*historypb.HistoryEvent, 20),
+		},
+		UpdateWorkflowEvents: []*WorkflowEvents{{Events: make([]*historypb.HistoryEvent, 100)}},
+		NewWorkflowSnapshot: &WorkflowSnapshot{
+			Tasks: map[tasks.Category][]tasks.Task{tasks.CategoryTransfer: newTestTasks(10)},
+		},
+		NewWorkflowEvents: []*WorkflowEvents{{Events: make([]*historypb.HistoryEvent, 50)}},
+	})
+	s.NoError(err)
+	// 2 rows + 150/100 events + 40/10 tasks + 20/20 buffered events
+	s.Equal(testRateLimitedClientBurst-8, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestCostBasedLimiting_ReplicationDLQPageSize() {
+	newRequest := func(batchSize int) *GetReplicationTasksFromDLQRequest {
+		return &GetReplicationTasksFromDLQRequest{
+			GetHistoryTasksRequest: GetHistoryTasksRequest{ShardID: 1, BatchSize: batchSize},
+		}
+	}
+	s.mockExecutionStore.EXPECT().GetReplicationTasksFromDLQ(gomock.Any(), gomock.Any()).
+		Return(&GetHistoryTasksResponse{}, nil).Times(3)
+
+	for _, tc := range []struct {
+		batchSize      int
+		expectedTokens int
+	}{
+		{batchSize: 10, expectedTokens: 1},
+		{batchSize: 1000, expectedTokens: 11},
+		// huge pages are clamped to the maximum cost
+		{batchSize: 1000000, expectedTokens: maxOperationTokens},
+	} {
+		rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, maxOperationTokens)
+		client := NewExecutionPersistenceRateLimitedClient(
+			s.mockExecutionStore,
+			quotas.NewRequestRateLimiterAdapter(rateLimiter),
+			log.NewNoopLogger(),
+			WithCostBasedLimiting(),
+		)
+		_, err := client.GetReplicationTasksFromDLQ(context.Background(), newRequest(tc.batchSize))
+		s.NoError(err)
+		s.Equal(maxOperationTokens-tc.expectedTokens, drainTokens(rateLimiter))
+	}
+}
+
+func (s *rateLimitedClientSuite) TestCostBasedLimiting_HistoryNodeSize() {
+	smallEvent := &historypb.HistoryEvent{EventId: 1}
+	largeEvent := &historypb.HistoryEvent{
+		EventId: 2,
+		Attributes: &historypb.HistoryEvent_WorkflowExecutionStartedEventAttributes{
+			WorkflowExecutionStartedEventAttributes: &historypb.WorkflowExecutionStartedEventAttributes{
+				Input: &commonpb.Payloads{Payloads: []*commonpb.Payload{{Data: make([]byte, 200*1024)}}},
+			},
+		},
+	}
+	s.mockExecutionStore.EXPECT().AppendHistoryNodes(gomock.Any(), gomock.Any()).
+		Return(&AppendHistoryNodesResponse{}, nil).Times(3)
+
+	for _, tc := range []struct {
+		event          *historypb.HistoryEvent
+		opts           []RateLimitedClientOption
+		expectedTokens int
+	}{
+		{event: smallEvent, expectedTokens: 1},
+		{event: largeEvent, expectedTokens: 4},
+		// large events are clamped to the maximum cost
+		{event: largeEvent, opts: []RateLimitedClientOption{WithHistoryNodeBytesPerToken(1024)}, expectedTokens: maxOperationTokens},
+	} {
+		rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, maxOperationTokens)
+		client := NewExecutionPersistenceRateLimitedClient(
+			s.mockExecutionStore,
+			quotas.NewRequestRateLimiterAdapter(rateLimiter),
+			log.NewNoopLogger(),
+			append([]RateLimitedClientOption{WithCostBasedLimiting()}, tc.opts...)...,
+		)
+		_, err := client.AppendHistoryNodes(context.Background(), &AppendHistoryNodesRequest{
+			ShardID: 1,
+			Events:  []*historypb.HistoryEvent{tc.event},
+		})
+		s.NoError(err)
+		s.Equal(maxOperationTokens-tc.expectedTokens, drainTokens(rateLimiter))
+	}
+}
+
+func (s *rateLimitedClientSuite) TestMaxRequestSize() {
+	event := &historypb.HistoryEvent{
+		EventId: 1,
+		Attributes: &historypb.HistoryEvent_WorkflowExecutionStartedEventAttributes{
+			WorkflowExecutionStartedEventAttributes: &historypb.WorkflowExecutionStartedEventAttributes{
+				Input: &commonpb.Payloads{Payloads: []*commonpb.Payload{{Data: make([]byte, 1024)}}},
+			},
+		},
+	}
+	eventSize := event.Size()
+	rateLimiter := quotastest.NewCountingRateLimiter(quotastest.Unlimited)
+	s.mockExecutionStore.EXPECT().AppendHistoryNodes(gomock.Any(), gomock.Any()).
+		Return(&AppendHistoryNodesResponse{}, nil).Times(2)
+
+	for _, tc := range []struct {
+		name        string
+		maxBytes    int
+		expectedErr error
+	}{
+		{name: "under", maxBytes: eventSize + 1},
+		{name: "at", maxBytes: eventSize},
+		{name: "over", maxBytes: eventSize - 1, expectedErr: ErrRequestTooLarge},
+	} {
+		rateLimiter.Reset()
+		client := NewExecutionPersistenceRateLimitedClient(
+			s.mockExecutionStore,
+			rateLimiter,
+			log.NewNoopLogger(),
+			WithMetricsHandler(s.metricsHandler),
+			WithMaxRequestSize("AppendHistoryNodes", tc.maxBytes),
+		)
+		_, err := client.AppendHistoryNodes(context.Background(), &AppendHistoryNodesRequest{
+			ShardID: 1,
+			Events:  []*historypb.HistoryEvent{event},
+		})
+		s.Equal(tc.expectedErr, err, tc.name)
+		if tc.expectedErr != nil {
+			// oversized requests fail fast, without consuming a token
+			s.Empty(rateLimiter.Calls(), tc.name)
+		} else {
+			s.Equal(1, rateLimiter.Allowed(), tc.name)
+		}
+	}
+	s.Equal(int64(1), s.metricsHandler.counter(
+		metrics.PersistenceRequestTooLarge.GetMetricName(),
+		metrics.OperationTag("AppendHistoryNodes"),
+	))
+}
+
+func (s *rateLimitedClientSuite) TestMaxRequestSize_Queue() {
+	client := NewQueuePersistenceRateLimitedClient(
+		noopQueue{},
+		nil,
+		log.NewNoopLogger(),
+		WithMaxRequestSize("EnqueueMessage", 10),
+		WithMaxRequestSize("EnqueueMessageToDLQ", 20),
+	)
+	ctx := context.Background()
+
+	s.NoError(client.EnqueueMessage(ctx, commonpb.DataBlob{Data: make([]byte, 10)}))
+	s.Equal(ErrRequestTooLarge, client.EnqueueMessage(ctx, commonpb.DataBlob{Data: make([]byte, 11)}))
+	_, err := client.EnqueueMessageToDLQ(ctx, commonpb.DataBlob{Data: make([]byte, 20)})
+	s.NoError(err)
+	_, err = client.EnqueueMessageToDLQ(ctx, commonpb.DataBlob{Data: make([]byte, 21)})
+	s.Equal(ErrRequestTooLarge, err)
+}
+
+func (s *rateLimitedClientSuite) TestCostBasedLimiting_RawHistorySize() {
+	blob := func(size int) *commonpb.DataBlob {
+		return &commonpb.DataBlob{EncodingType: enumspb.ENCODING_TYPE_PROTO3, Data: make([]byte, size)}
+	}
+
+	for _, tc := range []struct {
+		name           string
+		blobs          []*commonpb.DataBlob
+		opts           []RateLimitedClientOption
+		expectedTokens int
+	}{
+		{name: "small page", blobs: []*commonpb.DataBlob{blob(1024)}, opts: []RateLimitedClientOption{WithCostBasedLimiting()}, expectedTokens: 1},
+		{name: "large page", blobs: []*commonpb.DataBlob{blob(200 * 1024)}, opts: []RateLimitedClientOption{WithCostBasedLimiting()}, expectedTokens: 4},
+		{name: "many blobs", blobs: []*commonpb.DataBlob{blob(100 * 1024), blob(100 * 1024)}, opts: []RateLimitedClientOption{WithCostBasedLimiting()}, expectedTokens: 4},
+		// large pages are clamped to the maximum cost
+		{name: "clamped", blobs: []*commonpb.DataBlob{blob(200 * 1024)}, opts: []RateLimitedClientOption{WithCostBasedLimiting(), WithRawHistoryBytesPerToken(1024)}, expectedTokens: maxOperationTokens + 1},
+		{name: "cost based limiting disabled", blobs: []*commonpb.DataBlob{blob(200 * 1024)}, expectedTokens: 1},
+	} {
+		s.Run(tc.name, func() {
+			s.mockExecutionStore.EXPECT().ReadRawHistoryBranch(gomock.Any(), gomock.Any()).
+				Return(&ReadRawHistoryBranchResponse{HistoryEventBlobs: tc.blobs}, nil)
+			rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 2*maxOperationTokens)
+			client := NewExecutionPersistenceRateLimitedClient(
+				s.mockExecutionStore,
+				quotas.NewRequestRateLimiterAdapter(rateLimiter),
+				log.NewNoopLogger(),
+				tc.opts...,
+			)
+			_, err := client.ReadRawHistoryBranch(context.Background(), &ReadHistoryBranchRequest{ShardID: 1})
+			s.NoError(err)
+			s.Equal(2*maxOperationTokens-tc.expectedTokens, drainTokens(rateLimiter))
+		})
+	}
+}
+
+func (s *rateLimitedClientSuite) TestCostBasedLimiting_HistoryTaskRange() {
+	now := time.Now()
+	s.mockExecutionStore.EXPECT().GetHistoryTasks(gomock.Any(), gomock.Any()).
+		Return(&GetHistoryTasksResponse{}, nil).Times(9)
+
+	for _, tc := range []struct {
+		name           string
+		request        *GetHistoryTasksRequest
+		expectedTokens int
+	}{
+		{
+			name: "narrow immediate range",
+			request: &GetHistoryTasksRequest{
+				TaskCategory:        tasks.CategoryTransfer,
+				InclusiveMinTaskKey: tasks.NewImmediateKey(1000),
+				ExclusiveMaxTaskKey: tasks.NewImmediateKey(1050),
+				BatchSize:           1000,
+			},
+			expectedTokens: 1,
+		},
+		{
+			name: "wide immediate range",
+			request: &GetHistoryTasksRequest{
+				TaskCategory:        tasks.CategoryTransfer,
+				InclusiveMinTaskKey: tasks.NewImmediateKey(1000),
+				ExclusiveMaxTaskKey: tasks.NewImmediateKey(1000000),
+				BatchSize:           1000,
+			},
+			expectedTokens: 11,
+		},
+		{
+			name: "immediate range open towards the minimum key",
+			request: &GetHistoryTasksRequest{
+				TaskCategory:        tasks.CategoryTransfer,
+				InclusiveMinTaskKey: tasks.NewImmediateKey(math.MinInt64),
+				ExclusiveMaxTaskKey: tasks.NewImmediateKey(1000),
+				BatchSize:           500,
+			},
+			expectedTokens: 6,
+		},
+		{
+			name: "narrow scheduled range",
+			request: &GetHistoryTasksRequest{
+				TaskCategory:        tasks.CategoryTimer,
+				InclusiveMinTaskKey: tasks.NewKey(now, 0),
+				ExclusiveMaxTaskKey: tasks.NewKey(now.Add(time.Minute), 0),
+				BatchSize:           100,
+			},
+			expectedTokens: 2,
+		},
+		{
+			name: "wide scheduled range",
+			request: &GetHistoryTasksRequest{
+				TaskCategory:        tasks.CategoryTimer,
+				InclusiveMinTaskKey: tasks.NewKey(now, 0),
+				ExclusiveMaxTaskKey: tasks.NewKey(now.Add(24*time.Hour), 0),
+				BatchSize:           100,
+			},
+			expectedTokens: 26,
+		},
+		{
+			// huge ranges are clamped to the maximum cost
+			name: "unbounded scheduled range",
+			request: &GetHistoryTasksRequest{
+				TaskCategory:        tasks.CategoryTimer,
+				InclusiveMinTaskKey: tasks.NewKey(now, 0),
+				ExclusiveMaxTaskKey: tasks.MaximumKey,
+				BatchSize:           100,
+			},
+			expectedTokens: maxOperationTokens,
+		},
+		{
+			// malformed requests are charged as if their range was empty
+			name: "inverted scheduled range",
+			request: &GetHistoryTasksRequest{
+				TaskCategory:        tasks.CategoryTimer,
+				InclusiveMinTaskKey: tasks.NewKey(now.Add(24*time.Hour), 0),
+				ExclusiveMaxTaskKey: tasks.NewKey(now, 0),
+				BatchSize:           100,
+			},
+			expectedTokens: 2,
+		},
+		{
+			name: "scheduled range up to the zero time",
+			request: &GetHistoryTasksRequest{
+				TaskCategory:        tasks.CategoryTimer,
+				InclusiveMinTaskKey: tasks.NewKey(now, 0),
+				ExclusiveMaxTaskKey: tasks.NewKey(time.Time{}, 0),
+				BatchSize:           100,
+			},
+			expectedTokens: 2,
+		},
+		{
+			name: "negative batch size",
+			request: &GetHistoryTasksRequest{
+				TaskCategory:        tasks.CategoryTransfer,
+				InclusiveMinTaskKey: tasks.NewImmediateKey(1000),
+				ExclusiveMaxTaskKey: tasks.NewImmediateKey(1000000),
+				BatchSize:           -1000,
+			},
+			expectedTokens: 1,
+		},
+	} {
+		s.Run(tc.name, func() {
+			rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, maxOperationTokens)
+			client := NewExecutionPersistenceRateLimitedClient(
+				s.mockExecutionStore,
+				quotas.NewRequestRateLimiterAdapter(rateLimiter),
+				log.NewNoopLogger(),
+				WithCostBasedLimiting(),
+			)
+			_, err := client.GetHistoryTasks(context.Background(), tc.request)
+			s.NoError(err)
+			s.Equal(maxOperationTokens-tc.expectedTokens, drainTokens(rateLimiter))
+		})
+	}
+}
+
+func (s *rateLimitedClientSuite) TestCostBasedLimiting_ClampedToBurst() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 10)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithCostBasedLimiting(),
+	)
+	s.mockExecutionStore.EXPECT().GetHistoryTasks(gomock.Any(), gomock.Any()).Return(&GetHistoryTasksResponse{}, nil)
+	now := time.Now()
+
+	// a timer read up to the maximum key costs maxOperationTokens, beyond the burst of the rate
+	// limiter, so it is charged the whole burst instead of never being admitted
+	_, err := client.GetHistoryTasks(context.Background(), &GetHistoryTasksRequest{
+		TaskCategory:        tasks.CategoryTimer,
+		InclusiveMinTaskKey: tasks.NewKey(now, 0),
+		ExclusiveMaxTaskKey: tasks.MaximumKey,
+		BatchSize:           100,
+	})
+	s.NoError(err)
+	s.Equal(0, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestCostBasedLimiting_Disabled() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+	)
+	s.mockExecutionStore.EXPECT().ConflictResolveWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&ConflictResolveWorkflowExecutionResponse{}, nil)
+
+	_, err := client.ConflictResolveWorkflowExecution(context.Background(), &ConflictResolveWorkflowExecutionRequest{
+		ShardID:                 1,
+		ResetWorkflowEvents:     []*WorkflowEvents{{Events: make([]*historypb.HistoryEvent, 1000)}},
+		NewWorkflowSnapshot:     &WorkflowSnapshot{},
+		CurrentWorkflowMutation: &WorkflowMutation{},
+	})
+	s.NoError(err)
+	s.Equal(testRateLimitedClientBurst-1, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestStoreIdentity() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0))
+	defaultClient := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+	)
+	identifiedClient := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithStoreIdentity("keyspace-2"),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+
+	_, err := defaultClient.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = identifiedClient.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = identifiedClient.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	rejections := metrics.PersistenceRateLimitRejections.GetMetricName()
+	s.Equal(int64(1), s.metricsHandler.counter(rejections, metrics.StoreTag("execution")))
+	s.Equal(int64(2), s.metricsHandler.counter(rejections, metrics.StoreTag("keyspace-2")))
+}
+
+func (s *rateLimitedClientSuite) TestSLORejections_DefaultClassification() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0))
+	logger := log.NewNoopLogger()
+	executionClient := NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, rateLimiter, logger, WithMetricsHandler(s.metricsHandler))
+	taskClient := NewTaskPersistenceRateLimitedClient(s.mockTaskStore, rateLimiter, logger, WithMetricsHandler(s.metricsHandler))
+	queueClient := NewQueuePersistenceRateLimitedClient(noopQueue{}, rateLimiter, logger, WithMetricsHandler(s.metricsHandler))
+	ctx := context.Background()
+
+	_, err := executionClient.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = executionClient.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Equal(ErrPersistenceLimitExceeded, taskClient.CompleteTask(ctx, &CompleteTaskRequest{TaskQueue: &TaskQueueKey{}}))
+	_, err = taskClient.CompleteTasksLessThan(ctx, &CompleteTasksLessThanRequest{})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Equal(ErrPersistenceLimitExceeded, queueClient.DeleteMessagesBefore(ctx, 1))
+	s.Equal(ErrPersistenceLimitExceeded, queueClient.RangeDeleteMessagesFromDLQ(ctx, 1, 2))
+
+	sloRejections := metrics.PersistenceRateLimitSLORejections.GetMetricName()
+	impacting := metrics.StringTag(availabilityImpactingTagName, "true")
+	notImpacting := metrics.StringTag(availabilityImpactingTagName, "false")
+	for operation, tag := range map[string]metrics.Tag{
+		"GetWorkflowExecution":       impacting,
+		"UpdateWorkflowExecution":    impacting,
+		"CompleteTask":               impacting,
+		"CompleteTasksLessThan":      notImpacting,
+		"DeleteMessagesBefore":       notImpacting,
+		"RangeDeleteMessagesFromDLQ": notImpacting,
+	} {
+		s.Equal(int64(1), s.metricsHandler.counter(sloRejections, metrics.OperationTag(operation), tag), operation)
+	}
+	s.Equal(int64(3), s.metricsHandler.counter(sloRejections, impacting))
+	s.Equal(int64(3), s.metricsHandler.counter(sloRejections, notImpacting))
+}
+
+func (s *rateLimitedClientSuite) TestSLORejections_CustomClassification() {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0))
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithAvailabilityImpactClassification(func(operation string) bool {
+			return operation != "ListConcreteExecutions"
+		}),
+	)
+	ctx := context.Background()
+
+	_, err := client.ListConcreteExecutions(ctx, &ListConcreteExecutionsRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	sloRejections := metrics.PersistenceRateLimitSLORejections.GetMetricName()
+	s.Equal(int64(1), s.metricsHandler.counter(
+		sloRejections,
+		metrics.OperationTag("ListConcreteExecutions"),
+		metrics.StringTag(availabilityImpactingTagName, "false"),
+	))
+	s.Equal(int64(1), s.metricsHandler.counter(
+		sloRejections,
+		metrics.OperationTag("GetWorkflowExecution"),
+		metrics.StringTag(availabilityImpactingTagName, "true"),
+	))
+}
+
+func (s *rateLimitedClientSuite) TestFailOpen() {
+	for _, failOpen := range []bool{false, true} {
+		rateLimiter := &fallibleRateLimiter{
+			RequestRateLimiter: quotas.NewRequestRateLimiterAdapter(
+				quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst),
+			),
+		}
+		metricsHandler := newCapturingMetricsHandler()
+		opts := []RateLimitedClientOption{WithMetricsHandler(metricsHandler)}
+		if failOpen {
+			opts = append(opts, WithFailOpen(true))
+		}
+		client := NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, rateLimiter, log.NewNoopLogger(), opts...)
+		ctx := context.Background()
+		s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).
+			Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+
+		_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+		s.NoError(err)
+
+		rateLimiter.err = errors.New("shared counter unavailable")
+		_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+		if failOpen {
+			s.NoError(err)
+		} else {
+			s.Equal(ErrPersistenceLimitExceeded, err)
+		}
+		s.Equal(int64(1), metricsHandler.counter(
+			metrics.PersistenceRateLimiterErrors.GetMetricName(),
+			metrics.OperationTag("GetWorkflowExecution"),
+		))
+	}
+}
+
+func (s *rateLimitedClientSuite) TestRejectionError() {
+	scannerUnavailable := serviceerror.NewUnavailable("scanner rate limited")
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0)),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithRejectionError(func(operation string) error {
+			if operation == "ListConcreteExecutions" {
+				return scannerUnavailable
+			}
+			return nil
+		}),
+	)
+	ctx := context.Background()
+
+	_, err := client.ListConcreteExecutions(ctx, &ListConcreteExecutionsRequest{ShardID: 1})
+	s.Equal(scannerUnavailable, err)
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	// custom errors are still accounted as rejections
+	rejections := metrics.PersistenceRateLimitRejections.GetMetricName()
+	s.Equal(int64(1), s.metricsHandler.counter(rejections, metrics.OperationTag("ListConcreteExecutions")))
+	s.Equal(int64(2), client.(RateLimitedClient).RateLimitStats().Rejections)
+}
+
+func (s *rateLimitedClientSuite) TestHistoryForkLimits_Rate() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	forkRateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 2)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithHistoryForkLimits(quotas.NewRequestRateLimiterAdapter(forkRateLimiter), 0),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().ForkHistoryBranch(gomock.Any(), gomock.Any()).Return(&ForkHistoryBranchResponse{}, nil)
+	s.mockExecutionStore.EXPECT().TrimHistoryBranch(gomock.Any(), gomock.Any()).Return(&TrimHistoryBranchResponse{}, nil)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&GetWorkflowExecutionResponse{}, nil).Times(3)
+
+	_, err := client.ForkHistoryBranch(ctx, &ForkHistoryBranchRequest{ShardID: 1})
+	s.NoError(err)
+	_, err = client.TrimHistoryBranch(ctx, &TrimHistoryBranchRequest{ShardID: 1})
+	s.NoError(err)
+	_, err = client.ForkHistoryBranch(ctx, &ForkHistoryBranchRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	// normal reads continue on the main rate limiter
+	for i := 0; i < 3; i++ {
+		_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+		s.NoError(err)
+	}
+	s.Equal(testRateLimitedClientBurst-3, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestHistoryForkLimits_Concurrency() {
+	const maxConcurrency = 2
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1000, 1000)),
+		log.NewNoopLogger(),
+		WithHistoryForkLimits(nil, maxConcurrency),
+	)
+	ctx := context.Background()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s.mockExecutionStore.EXPECT().ForkHistoryBranch(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, *ForkHistoryBranchRequest) (*ForkHistoryBranchResponse, error) {
+			started <- struct{}{}
+			<-release
+			return &ForkHistoryBranchResponse{}, nil
+		}).Times(maxConcurrency + 1)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&GetWorkflowExecutionResponse{}, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < maxConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.ForkHistoryBranch(ctx, &ForkHistoryBranchRequest{ShardID: 1})
+			s.NoError(err)
+		}()
+		<-started
+	}
+
+	// the forks in flight saturate the concurrency limit, reads are not affected
+	_, err := client.TrimHistoryBranch(ctx, &TrimHistoryBranchRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+
+	close(release)
+	wg.Wait()
+	go func() { <-started }()
+	_, err = client.ForkHistoryBranch(ctx, &ForkHistoryBranchRequest{ShardID: 1})
+	s.NoError(err)
+}
+
+func (s *rateLimitedClientSuite) TestConcurrencyAwareAdmission() {
+	const lowConcurrency = 2
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, lowConcurrency+1)),
+		log.NewNoopLogger(),
+		WithConcurrencyAwareAdmission(lowConcurrency),
+	)
+	ctx := context.Background()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, *GetWorkflowExecutionRequest) (*GetWorkflowExecutionResponse, error) {
+			started <- struct{}{}
+			<-release
+			return &GetWorkflowExecutionResponse{}, nil
+		}).Times(lowConcurrency + 2)
+
+	var wg sync.WaitGroup
+	get := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+			s.NoError(err)
+		}()
+		<-started
+	}
+	// rate allowed at low concurrency
+	for i := 0; i < lowConcurrency; i++ {
+		get()
+	}
+	// rate allowed at high concurrency
+	get()
+
+	// rate denied at high concurrency
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	close(release)
+	wg.Wait()
+
+	// rate denied at low concurrency, as the requests in flight completed
+	go func() { <-started }()
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+}
+
+func (s *rateLimitedClientSuite) TestConcurrencyAwareAdmission_NamespaceLimits() {
+	rateLimiter := quotastest.NewCountingRateLimiter(0)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithTimeSource(clock.NewEventTimeSource().Update(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))),
+		WithConcurrencyAwareAdmission(2),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+	client.(NamespaceLimiter).SetNamespaceLimits(map[string]float64{"ns-1": 1})
+	request := &GetWorkflowExecutionRequest{NamespaceID: "ns-1"}
+
+	// denials of the main rate limiter are overridden at low concurrency
+	_, err := client.GetWorkflowExecution(context.Background(), request)
+	s.NoError(err)
+	_, err = client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{NamespaceID: "ns-2"})
+	s.NoError(err)
+
+	// but not the ones of the namespace limits, whatever the concurrency
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Len(rateLimiter.Calls(), 2)
+}
+
+func (s *rateLimitedClientSuite) TestSnapshot() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	cleanupRateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 1)
+	client := NewTaskPersistenceRateLimitedClient(
+		s.mockTaskStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithCleanupRateLimiter(quotas.NewRequestRateLimiterAdapter(cleanupRateLimiter)),
+	).(RateLimitedClient)
+	ctx := context.Background()
+	s.mockTaskStore.EXPECT().CompleteTasksLessThan(gomock.Any(), gomock.Any()).Return(0, nil)
+	s.mockTaskStore.EXPECT().CompleteTask(gomock.Any(), gomock.Any()).Return(nil)
+
+	_, err := client.(TaskManager).CompleteTasksLessThan(ctx, &CompleteTasksLessThanRequest{NamespaceID: "ns-1"})
+	s.NoError(err)
+	s.NoError(client.(TaskManager).CompleteTask(ctx, &CompleteTaskRequest{TaskQueue: &TaskQueueKey{NamespaceID: "ns-1"}}))
+	timeSource.Update(now.Add(time.Second))
+	_, err = client.(TaskManager).CompleteTasksLessThan(ctx, &CompleteTasksLessThanRequest{NamespaceID: "ns-1"})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	snapshots := client.Snapshot()
+	s.Len(snapshots, 2)
+	s.Equal(mainRateLimiterName, snapshots[0].Name)
+	s.Empty(snapshots[0].Operations)
+	s.True(snapshots[0].StateKnown)
+	s.Equal(testRateLimitedClientRate, snapshots[0].Rate)
+	s.Equal(testRateLimitedClientBurst, snapshots[0].Burst)
+	s.InDelta(testRateLimitedClientBurst-1, snapshots[0].TokensAvailable, 0.01)
+	s.Zero(snapshots[0].Rejections)
+	s.True(snapshots[0].LastRejectionTime.IsZero())
+
+	s.Equal(cleanupRateLimiterName, snapshots[1].Name)
+	s.Equal([]string{"CompleteTasksLessThan", "DeleteMessagesBefore", "RangeDeleteMessagesFromDLQ"}, snapshots[1].Operations)
+	s.True(snapshots[1].StateKnown)
+	s.Equal(1, snapshots[1].Burst)
+	s.InDelta(0, snapshots[1].TokensAvailable, 0.01)
+	s.Equal(int64(1), snapshots[1].Rejections)
+	s.True(now.Add(time.Second).Equal(snapshots[1].LastRejectionTime))
+
+	client.ResetRateLimitStats()
+	s.Zero(client.Snapshot()[1].Rejections)
+}
+
+func (s *rateLimitedClientSuite) TestNamespaceQPS() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithNamespaceQPSReporting(10*time.Second),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	getWorkflowExecution := func(namespaceID string) {
+		_, _ = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: namespaceID})
+	}
+
+	for i := 0; i < 20; i++ {
+		getWorkflowExecution("ns-1")
+	}
+	for i := 0; i < 5; i++ {
+		getWorkflowExecution("ns-2")
+	}
+	// nothing is reported until the first interval is over
+	s.Empty(client.(NamespaceQPSReporter).NamespaceQPS())
+
+	// rejected requests count towards the QPS as well
+	timeSource.Update(now.Add(10 * time.Second))
+	s.Equal(map[string]float64{"ns-1": 2, "ns-2": 0.5}, client.(NamespaceQPSReporter).NamespaceQPS())
+
+	// the QPS of the last interval is reported until the next one is over
+	for i := 0; i < 10; i++ {
+		getWorkflowExecution("ns-2")
+	}
+	timeSource.Update(now.Add(15 * time.Second))
+	s.Equal(map[string]float64{"ns-1": 2, "ns-2": 0.5}, client.(NamespaceQPSReporter).NamespaceQPS())
+	timeSource.Update(now.Add(20 * time.Second))
+	s.Equal(map[string]float64{"ns-2": 1}, client.(NamespaceQPSReporter).NamespaceQPS())
+}
+
+func (s *rateLimitedClientSuite) TestNamespaceQPS_Disabled() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)),
+		log.NewNoopLogger(),
+	)
+	s.Nil(client.(NamespaceQPSReporter).NamespaceQPS())
+}
+
+func (s *rateLimitedClientSuite) TestHotShards() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 40)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithHotShardTracking(time.Minute),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	getWorkflowExecution := func(shardID int32, count int) {
+		for i := 0; i < count; i++ {
+			_, _ = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: shardID})
+		}
+	}
+	reporter := client.(HotShardReporter)
+
+	getWorkflowExecution(1, 30)
+	getWorkflowExecution(2, 10)
+	getWorkflowExecution(3, 5)
+	getWorkflowExecution(4, 1)
+	s.Equal([]ShardStat{{ShardID: 1, Score: 30}, {ShardID: 2, Score: 10}}, reporter.HotShards(2))
+	s.Len(reporter.HotShards(10), 4)
+	s.Empty(reporter.HotShards(0))
+
+	// recent requests outweigh older ones, rejected requests count as well
+	timeSource.Update(now.Add(time.Minute))
+	getWorkflowExecution(3, 20)
+	s.Equal([]ShardStat{{ShardID: 3, Score: 22.5}, {ShardID: 1, Score: 15}, {ShardID: 2, Score: 5}}, reporter.HotShards(3))
+
+	// shards are forgotten once their counts decayed away
+	timeSource.Update(now.Add(time.Hour))
+	s.Empty(reporter.HotShards(10))
+}
+
+func (s *rateLimitedClientSuite) TestHotShards_Disabled() {
+	mockShardStore := NewMockShardManager(s.controller)
+	mockShardStore.EXPECT().GetName().Return("shard")
+	client := NewShardPersistenceRateLimitedClient(
+		mockShardStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)),
+		log.NewNoopLogger(),
+	)
+	s.Nil(client.(HotShardReporter).HotShards(10))
+}
+
+func (s *rateLimitedClientSuite) TestRateLimitTier_SingleChargePerTier() {
+	globalRateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	namespaceRateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	global := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(globalRateLimiter),
+		log.NewNoopLogger(),
+		WithRateLimitTier("global"),
+	)
+	// the global tier is mistakenly applied twice
+	misconfigured := NewExecutionPersistenceRateLimitedClient(
+		global,
+		quotas.NewRequestRateLimiterAdapter(globalRateLimiter),
+		log.NewNoopLogger(),
+		WithRateLimitTier("global"),
+	)
+	client := NewExecutionPersistenceRateLimitedClient(
+		misconfigured,
+		quotas.NewRequestRateLimiterAdapter(namespaceRateLimiter),
+		log.NewNoopLogger(),
+		WithRateLimitTier("namespace"),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&GetWorkflowExecutionResponse{}, nil).Times(3)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+		s.NoError(err)
+	}
+	s.Equal(testRateLimitedClientBurst-3, drainTokens(namespaceRateLimiter))
+	s.Equal(testRateLimitedClientBurst-3, drainTokens(globalRateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestRateLimitTier_DisabledOuterClient() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	inner := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithRateLimitTier("global"),
+	)
+	outer := NewExecutionPersistenceRateLimitedClient(
+		inner,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithRateLimitTier("global"),
+	)
+	outer.(RateLimitedClient).SetRateLimitEnabled(false)
+	drainTokens(rateLimiter)
+
+	// the outer client did not rate limit the request, so the inner one still does
+	_, err := outer.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+}
+
+func (s *rateLimitedClientSuite) TestTaskQueueTypePriority() {
+	workflowRateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	activityRateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	client := NewTaskPersistenceRateLimitedClient(
+		s.mockTaskStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0)),
+		log.NewNoopLogger(),
+		WithTaskQueueTypePriority(
+			map[enumspb.TaskQueueType]int{
+				enumspb.TASK_QUEUE_TYPE_WORKFLOW: 0,
+				enumspb.TASK_QUEUE_TYPE_ACTIVITY: 1,
+			},
+			map[int]quotas.RequestRateLimiter{
+				0: quotas.NewRequestRateLimiterAdapter(workflowRateLimiter),
+				1: quotas.NewRequestRateLimiterAdapter(activityRateLimiter),
+			},
+		),
+	)
+	ctx := context.Background()
+	s.mockTaskStore.EXPECT().CreateTasks(gomock.Any(), gomock.Any()).Return(&CreateTasksResponse{}, nil).AnyTimes()
+	s.mockTaskStore.EXPECT().GetTasks(gomock.Any(), gomock.Any()).Return(&GetTasksResponse{}, nil).AnyTimes()
+	createTasks := func(taskType enumspb.TaskQueueType) error {
+		_, err := client.CreateTasks(ctx, &CreateTasksRequest{
+			TaskQueueInfo: &PersistedTaskQueueInfo{Data: &persistencespb.TaskQueueInfo{TaskType: taskType}},
+		})
+		return err
+	}
+	getTasks := func(taskType enumspb.TaskQueueType) error {
+		_, err := client.GetTasks(ctx, &GetTasksRequest{TaskType: taskType})
+		return err
+	}
+
+	// saturate the limiters with a mix of workflow and activity task operations
+	admitted := map[enumspb.TaskQueueType]int{}
+	for i := 0; i < testRateLimitedClientBurst; i++ {
+		for _, taskType := range []enumspb.TaskQueueType{enumspb.TASK_QUEUE_TYPE_WORKFLOW, enumspb.TASK_QUEUE_TYPE_ACTIVITY} {
+			if createTasks(taskType) == nil {
+				admitted[taskType]++
+			}
+			if getTasks(taskType) == nil {
+				admitted[taskType]++
+			}
+		}
+	}
+	// workflow task operations consume the activity capacity as well, and keep being admitted
+	// once it is exhausted
+	s.Equal(testRateLimitedClientBurst, admitted[enumspb.TASK_QUEUE_TYPE_WORKFLOW])
+	s.Equal(testRateLimitedClientBurst/2, admitted[enumspb.TASK_QUEUE_TYPE_ACTIVITY])
+	s.Equal(ErrPersistenceLimitExceeded, createTasks(enumspb.TASK_QUEUE_TYPE_WORKFLOW))
+	// unmapped task queue types get the lowest priority
+	s.Equal(ErrPersistenceLimitExceeded, getTasks(enumspb.TASK_QUEUE_TYPE_UNSPECIFIED))
+}
+
+func (s *rateLimitedClientSuite) TestTaskQueueRateLimiter() {
+	const budget = 3
+	client := NewTaskPersistenceRateLimitedClient(
+		s.mockTaskStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 1)),
+		log.NewNoopLogger(),
+		WithTaskQueueRateLimiter(quotas.NewTaskQueueRequestRateLimiter(func(quotas.Request) quotas.RequestRateLimiter {
+			return quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, budget))
+		})),
+	)
+	ctx := context.Background()
+	s.mockTaskStore.EXPECT().CreateTasks(gomock.Any(), gomock.Any()).Return(&CreateTasksResponse{}, nil).AnyTimes()
+	s.mockTaskStore.EXPECT().GetTasks(gomock.Any(), gomock.Any()).Return(&GetTasksResponse{}, nil).AnyTimes()
+	createTasks := func(taskQueue string) error {
+		_, err := client.CreateTasks(ctx, &CreateTasksRequest{
+			TaskQueueInfo: &PersistedTaskQueueInfo{Data: &persistencespb.TaskQueueInfo{
+				NamespaceId: "ns-1",
+				Name:        taskQueue,
+				TaskType:    enumspb.TASK_QUEUE_TYPE_WORKFLOW,
+			}},
+		})
+		return err
+	}
+	getTasks := func(taskQueue string, taskType enumspb.TaskQueueType) error {
+		_, err := client.GetTasks(ctx, &GetTasksRequest{NamespaceID: "ns-1", TaskQueue: taskQueue, TaskType: taskType})
+		return err
+	}
+
+	// the busy task queue exhausts its own budget
+	for i := 0; i < budget; i++ {
+		s.NoError(createTasks("busy"))
+	}
+	s.Equal(ErrPersistenceLimitExceeded, createTasks("busy"))
+	s.Equal(ErrPersistenceLimitExceeded, getTasks("busy", enumspb.TASK_QUEUE_TYPE_WORKFLOW))
+
+	// other task queues, including the activity one of the same name, keep their budgets
+	for i := 0; i < budget; i++ {
+		s.NoError(getTasks("quiet", enumspb.TASK_QUEUE_TYPE_WORKFLOW))
+		s.NoError(getTasks("busy", enumspb.TASK_QUEUE_TYPE_ACTIVITY))
+	}
+	s.Equal(ErrPersistenceLimitExceeded, getTasks("quiet", enumspb.TASK_QUEUE_TYPE_WORKFLOW))
+}
+
+func (s *rateLimitedClientSuite) TestReplicationBypass() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0)),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithReplicationBypass(nil),
+	)
+	ctx := context.Background()
+	replicationCtx := WithReplicationApply(ctx)
+	s.mockExecutionStore.EXPECT().AppendHistoryNodes(gomock.Any(), gomock.Any()).
+		Return(&AppendHistoryNodesResponse{}, nil).Times(2)
+	s.mockExecutionStore.EXPECT().ConflictResolveWorkflowExecution(gomock.Any(), gomock.Any()).
+		Return(&ConflictResolveWorkflowExecutionResponse{}, nil)
+
+	_, err := client.AppendHistoryNodes(ctx, &AppendHistoryNodesRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = client.ConflictResolveWorkflowExecution(ctx, &ConflictResolveWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = client.AppendHistoryNodes(replicationCtx, &AppendHistoryNodesRequest{ShardID: 1})
+		s.NoError(err)
+	}
+	_, err = client.ConflictResolveWorkflowExecution(replicationCtx, &ConflictResolveWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	// other operations of replication are still rate limited
+	_, err = client.GetWorkflowExecution(replicationCtx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	bypasses := metrics.PersistenceRateLimitReplicationBypass.GetMetricName()
+	s.Equal(int64(2), s.metricsHandler.counter(bypasses, metrics.OperationTag("AppendHistoryNodes")))
+	s.Equal(int64(1), s.metricsHandler.counter(bypasses, metrics.OperationTag("ConflictResolveWorkflowExecution")))
+}
+
+func (s *rateLimitedClientSuite) TestReplicationBypass_ReplicationRateLimiter() {
+	replicationRateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 1)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0)),
+		log.NewNoopLogger(),
+		WithReplicationBypass(quotas.NewRequestRateLimiterAdapter(replicationRateLimiter)),
+	)
+	replicationCtx := WithReplicationApply(context.Background())
+	s.mockExecutionStore.EXPECT().AppendHistoryNodes(gomock.Any(), gomock.Any()).Return(&AppendHistoryNodesResponse{}, nil)
+
+	_, err := client.AppendHistoryNodes(replicationCtx, &AppendHistoryNodesRequest{ShardID: 1})
+	s.NoError(err)
+	_, err = client.AppendHistoryNodes(replicationCtx, &AppendHistoryNodesRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+}
+
+func (s *rateLimitedClientSuite) TestReplicationBypass_Disabled() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0)),
+		log.NewNoopLogger(),
+	)
+	_, err := client.AppendHistoryNodes(WithReplicationApply(context.Background()), &AppendHistoryNodesRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+}
+
+func (s *rateLimitedClientSuite) TestNotFoundCache() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithNotFoundCache(time.Second, 100),
+	)
+	ctx := context.Background()
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1", WorkflowID: "wf-1", RunID: "run-1"}
+	notFound := serviceerror.NewNotFound("workflow execution not found")
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(nil, notFound).Times(2)
+
+	_, err := client.GetWorkflowExecution(ctx, request)
+	s.Equal(notFound, err)
+	// the second read within the TTL is served from the cache
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.Equal(notFound, err)
+	s.Equal(testRateLimitedClientBurst-1, int(rateLimiter.TokensAt(now)))
+
+	timeSource.Update(now.Add(time.Second))
+	_, err = client.GetWorkflowExecution(ctx, request)
+	s.Equal(notFound, err)
+	s.Equal(testRateLimitedClientBurst-2, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestNotFoundCache_OnlyStableResults() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)),
+		log.NewNoopLogger(),
+		WithNotFoundCache(time.Minute, 100),
+	)
+	ctx := context.Background()
+	currentRun := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1", WorkflowID: "wf-1"}
+	run := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1", WorkflowID: "wf-1", RunID: "run-1"}
+	notFound := serviceerror.NewNotFound("workflow execution not found")
+	unavailable := serviceerror.NewUnavailable("store unavailable")
+
+	// reads of the current run are never cached
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), currentRun).Return(nil, notFound).Times(2)
+	for i := 0; i < 2; i++ {
+		_, err := client.GetWorkflowExecution(ctx, currentRun)
+		s.Equal(notFound, err)
+	}
+
+	// neither are other errors
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), run).Return(nil, unavailable).Times(2)
+	for i := 0; i < 2; i++ {
+		_, err := client.GetWorkflowExecution(ctx, run)
+		s.Equal(unavailable, err)
+	}
+
+	// and creating the run invalidates its cached NotFound
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), run).Return(nil, notFound)
+	_, err := client.GetWorkflowExecution(ctx, run)
+	s.Equal(notFound, err)
+	s.mockExecutionStore.EXPECT().CreateWorkflowExecution(gomock.Any(), gomock.Any()).Return(&CreateWorkflowExecutionResponse{}, nil)
+	_, err = client.CreateWorkflowExecution(ctx, &CreateWorkflowExecutionRequest{
+		ShardID: 1,
+		NewWorkflowSnapshot: WorkflowSnapshot{
+			ExecutionInfo:  &persistencespb.WorkflowExecutionInfo{NamespaceId: "ns-1", WorkflowId: "wf-1"},
+			ExecutionState: &persistencespb.WorkflowExecutionState{RunId: "run-1"},
+		},
+	})
+	s.NoError(err)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), run).Return(&GetWorkflowExecutionResponse{}, nil)
+	_, err = client.GetWorkflowExecution(ctx, run)
+	s.NoError(err)
+}
+
+func (s *rateLimitedClientSuite) TestCreateSerialization_SameWorkflow() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithCreateSerialization(),
+	)
+	var inFlight atomic.Int64
+	s.mockExecutionStore.EXPECT().CreateWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, *CreateWorkflowExecutionRequest) (*CreateWorkflowExecutionResponse, error) {
+			// each run is created, one at a time
+			s.Equal(int64(1), inFlight.Add(1))
+			defer inFlight.Add(-1)
+			time.Sleep(5 * time.Millisecond)
+			return &CreateWorkflowExecutionResponse{}, nil
+		}).Times(5)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(runID string) {
+			defer wg.Done()
+			_, err := client.CreateWorkflowExecution(context.Background(), newCreateWorkflowExecutionRequest("wf-1", runID))
+			s.NoError(err)
+		}(fmt.Sprintf("run-%d", i))
+	}
+	wg.Wait()
+}
+
+func (s *rateLimitedClientSuite) TestCreateSerialization_SameRun() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithCreateSerialization(),
+	)
+	serializer := client.(*executionRateLimitedPersistenceClient).createSerializer
+	refs := func() int {
+		serializer.Lock()
+		defer serializer.Unlock()
+		if flight, ok := serializer.workflows[workflowCreateKey{namespaceID: "ns-1", workflowID: "wf-1"}]; ok {
+			return flight.refs
+		}
+		return 0
+	}
+	release := make(chan struct{})
+	expected := &CreateWorkflowExecutionResponse{}
+	s.mockExecutionStore.EXPECT().CreateWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, *CreateWorkflowExecutionRequest) (*CreateWorkflowExecutionResponse, error) {
+			<-release
+			return expected, nil
+		})
+
+	var wg sync.WaitGroup
+	responses := make([]*CreateWorkflowExecutionResponse, 5)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			response, err := client.CreateWorkflowExecution(context.Background(), newCreateWorkflowExecutionRequest("wf-1", "run-1"))
+			s.NoError(err)
+			responses[i] = response
+		}(i)
+	}
+	s.Eventually(func() bool { return refs() == len(responses) }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// the retries of the create observe its result rather than reaching persistence
+	for _, response := range responses {
+		s.Same(expected, response)
+	}
+	s.Zero(refs())
+}
+
+func (s *rateLimitedClientSuite) TestCreateSerialization_OtherWorkflows() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithCreateSerialization(),
+	)
+	var inFlight atomic.Int64
+	s.mockExecutionStore.EXPECT().CreateWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, *CreateWorkflowExecutionRequest) (*CreateWorkflowExecutionResponse, error) {
+			inFlight.Add(1)
+			// the creates of other workflows are in flight at the same time
+			s.Eventually(func() bool { return inFlight.Load() == 2 }, time.Second, time.Millisecond)
+			return &CreateWorkflowExecutionResponse{}, nil
+		}).Times(2)
+
+	var wg sync.WaitGroup
+	for _, workflowID := range []string{"wf-1", "wf-2"} {
+		wg.Add(1)
+		go func(workflowID string) {
+			defer wg.Done()
+			_, err := client.CreateWorkflowExecution(context.Background(), newCreateWorkflowExecutionRequest(workflowID, "run-1"))
+			s.NoError(err)
+		}(workflowID)
+	}
+	wg.Wait()
+}
+
+func (s *rateLimitedClientSuite) TestCreateSerialization_ContextDone() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithCreateSerialization(),
+	)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s.mockExecutionStore.EXPECT().CreateWorkflowExecution(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, *CreateWorkflowExecutionRequest) (*CreateWorkflowExecutionResponse, error) {
+			close(started)
+			<-release
+			return &CreateWorkflowExecutionResponse{}, nil
+		})
+
+	done := make(chan error)
+	go func() {
+		_, err := client.CreateWorkflowExecution(context.Background(), newCreateWorkflowExecutionRequest("wf-1", "run-1"))
+		done <- err
+	}()
+	<-started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.CreateWorkflowExecution(ctx, newCreateWorkflowExecutionRequest("wf-1", "run-2"))
+	s.Equal(context.Canceled, err)
+
+	close(release)
+	s.NoError(<-done)
+}
+
+func (s *rateLimitedClientSuite) TestHistoryTasksDedup() {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	timeSource := clock.NewEventTimeSource().Update(now)
+	rateLimiter := quotastest.NewCountingRateLimiter(quotastest.Unlimited)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithMetricsHandler(s.metricsHandler),
+		WithHistoryTasksDedup(time.Minute, 10),
+	)
+	newRequest := func(rangeID int64, taskIDs ...int64) *AddHistoryTasksRequest {
+		request := &AddHistoryTasksRequest{
+			ShardID:     1,
+			RangeID:     rangeID,
+			NamespaceID: "ns-1",
+			WorkflowID:  "wf-1",
+			RunID:       "run-1",
+			Tasks:       map[tasks.Category][]tasks.Task{},
+		}
+		for _, taskID := range taskIDs {
+			request.Tasks[tasks.CategoryTransfer] = append(request.Tasks[tasks.CategoryTransfer], &tasks.ActivityTask{
+				WorkflowKey:         definition.NewWorkflowKey("ns-1", "wf-1", "run-1"),
+				VisibilityTimestamp: now,
+				TaskID:              taskID,
+				TaskQueue:           "tq-1",
+			})
+		}
+		return request
+	}
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().AddHistoryTasks(gomock.Any(), gomock.Any()).Return(nil).Times(4)
+	s.NoError(client.AddHistoryTasks(ctx, newRequest(1, 1, 2)))
+
+	// a retry of the exact same request is deduplicated
+	s.NoError(client.AddHistoryTasks(ctx, newRequest(1, 1, 2)))
+	s.Len(rateLimiter.Calls(), 1)
+	s.Equal(int64(1), s.metricsHandler.counter(
+		metrics.PersistenceDeduplicatedRequests.GetMetricName(),
+		metrics.OperationTag("AddHistoryTasks"),
+	))
+
+	// requests differing in any way are not
+	s.NoError(client.AddHistoryTasks(ctx, newRequest(1, 1, 3)))
+	s.NoError(client.AddHistoryTasks(ctx, newRequest(2, 1, 2)))
+	s.NoError(client.AddHistoryTasks(ctx, newRequest(1, 2, 1)))
+	s.Len(rateLimiter.Calls(), 4)
+
+	// nor are requests once the TTL passed
+	timeSource.Update(now.Add(time.Minute))
+	s.mockExecutionStore.EXPECT().AddHistoryTasks(gomock.Any(), gomock.Any()).Return(nil)
+	s.NoError(client.AddHistoryTasks(ctx, newRequest(1, 1, 2)))
+}
+
+func (s *rateLimitedClientSuite) TestHistoryTasksDedup_FailedRequests() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithHistoryTasksDedup(time.Minute, 10),
+	)
+	request := &AddHistoryTasksRequest{ShardID: 1, NamespaceID: "ns-1", WorkflowID: "wf-1"}
+	unavailable := serviceerror.NewUnavailable("unavailable")
+	gomock.InOrder(
+		s.mockExecutionStore.EXPECT().AddHistoryTasks(gomock.Any(), request).Return(unavailable),
+		s.mockExecutionStore.EXPECT().AddHistoryTasks(gomock.Any(), request).Return(nil),
+	)
+
+	// the retry of a failed request reaches persistence
+	s.Equal(unavailable, client.AddHistoryTasks(context.Background(), request))
+	s.NoError(client.AddHistoryTasks(context.Background(), request))
+	s.NoError(client.AddHistoryTasks(context.Background(), request))
+}
+
+func (s *rateLimitedClientSuite) TestHistoryTasksDedup_Disabled() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+	)
+	request := &AddHistoryTasksRequest{ShardID: 1, NamespaceID: "ns-1", WorkflowID: "wf-1"}
+	s.mockExecutionStore.EXPECT().AddHistoryTasks(gomock.Any(), request).Return(nil).Times(2)
+
+	s.NoError(client.AddHistoryTasks(context.Background(), request))
+	s.NoError(client.AddHistoryTasks(context.Background(), request))
+}
+
+func (s *rateLimitedClientSuite) TestSetOperationEnabled() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil)
+	s.mockExecutionStore.EXPECT().GetAllHistoryTreeBranches(gomock.Any(), gomock.Any()).Return(&GetAllHistoryTreeBranchesResponse{}, nil)
+
+	client.(RateLimitedClient).SetOperationEnabled("GetAllHistoryTreeBranches", false)
+	_, err := client.GetAllHistoryTreeBranches(ctx, &GetAllHistoryTreeBranchesRequest{})
+	s.Equal(ErrPersistenceOperationDisabled, err)
+	// other operations remain enabled
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	// the disabled operation consumed no token
+	s.Equal(testRateLimitedClientBurst-1, int(rateLimiter.TokensAt(time.Now())))
+
+	client.(RateLimitedClient).SetOperationEnabled("GetAllHistoryTreeBranches", true)
+	_, err = client.GetAllHistoryTreeBranches(ctx, &GetAllHistoryTreeBranchesRequest{})
+	s.NoError(err)
+}
+
+func (s *rateLimitedClientSuite) TestSetDrainMode() {
+	rateLimiter := quotastest.NewCountingRateLimiter(2)
+	client := NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, rateLimiter, log.NewNoopLogger())
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil)
+	s.mockExecutionStore.EXPECT().GetHistoryTasks(gomock.Any(), gomock.Any()).Return(&GetHistoryTasksResponse{}, nil)
+	s.mockExecutionStore.EXPECT().UpdateWorkflowExecution(gomock.Any(), gomock.Any()).Return(&UpdateWorkflowExecutionResponse{}, nil)
+
+	client.(RateLimitedClient).SetDrainMode(true)
+	_, err := client.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceDraining, err)
+	s.Equal(ErrPersistenceDraining, client.DeleteWorkflowExecution(ctx, &DeleteWorkflowExecutionRequest{ShardID: 1}))
+	s.Equal(ErrPersistenceDraining, client.CompleteHistoryTask(ctx, &CompleteHistoryTaskRequest{ShardID: 1, TaskCategory: tasks.CategoryTransfer}))
+	// the writes held back consumed no token
+	s.Zero(len(rateLimiter.Calls()))
+
+	// reads proceed, subject to the rate limits as usual
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	_, err = client.GetHistoryTasks(ctx, &GetHistoryTasksRequest{ShardID: 1, TaskCategory: tasks.CategoryTransfer})
+	s.NoError(err)
+	_, err = client.GetCurrentExecution(ctx, &GetCurrentExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	client.(RateLimitedClient).SetDrainMode(false)
+	rateLimiter.SetAllows(1)
+	_, err = client.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+}
+
+func (s *rateLimitedClientSuite) TestSetDrainMode_Admin() {
+	client := NewMetadataPersistenceRateLimitedClient(s.mockMetadataStore, quotas.NoopRequestRateLimiter, log.NewNoopLogger())
+	ctx := context.Background()
+	s.mockMetadataStore.EXPECT().ListNamespaces(gomock.Any(), gomock.Any()).Return(&ListNamespacesResponse{}, nil)
+
+	client.(RateLimitedClient).SetDrainMode(true)
+	_, err := client.ListNamespaces(ctx, &ListNamespacesRequest{})
+	s.NoError(err)
+	_, err = client.CreateNamespace(ctx, &CreateNamespaceRequest{})
+	s.Equal(ErrPersistenceDraining, err)
+}
+
+func (s *rateLimitedClientSuite) TestSetDrainMode_Exemptions() {
+	shardStore := NewMockShardManager(s.controller)
+	clusterMetadataStore := NewMockClusterMetadataManager(s.controller)
+	shardStore.EXPECT().GetName().Return("shard").AnyTimes()
+	clusterMetadataStore.EXPECT().GetName().Return("cluster-metadata").AnyTimes()
+	shardClient := NewShardPersistenceRateLimitedClient(shardStore, quotas.NoopRequestRateLimiter, log.NewNoopLogger())
+	clusterMetadataClient := NewClusterMetadataPersistenceRateLimitedClient(clusterMetadataStore, quotas.NoopRequestRateLimiter, log.NewNoopLogger())
+	ctx := context.Background()
+	shardStore.EXPECT().GetOrCreateShard(gomock.Any(), gomock.Any()).Return(&GetOrCreateShardResponse{}, nil)
+	clusterMetadataStore.EXPECT().UpsertClusterMembership(gomock.Any(), gomock.Any()).Return(nil)
+
+	shardClient.(RateLimitedClient).SetDrainMode(true)
+	clusterMetadataClient.(RateLimitedClient).SetDrainMode(true)
+
+	// shards are still acquired, and hosts keep their membership heartbeats
+	_, err := shardClient.GetOrCreateShard(ctx, &GetOrCreateShardRequest{ShardID: 1})
+	s.NoError(err)
+	s.NoError(clusterMetadataClient.UpsertClusterMembership(ctx, &UpsertClusterMembershipRequest{}))
+
+	// while their other writes are held back
+	s.Equal(ErrPersistenceDraining, shardClient.UpdateShard(ctx, &UpdateShardRequest{ShardInfo: &persistencespb.ShardInfo{ShardId: 1}}))
+	s.Equal(ErrPersistenceDraining, clusterMetadataClient.PruneClusterMembership(ctx, &PruneClusterMembershipRequest{}))
+}
+
+func (s *rateLimitedClientSuite) TestIsWriteOperation() {
+	s.False(isWriteOperation("GetWorkflowExecution"))
+	s.False(isWriteOperation("ListConcreteExecutions"))
+	s.False(isWriteOperation("GetClusterMembers"))
+	s.False(isWriteOperation(ConstructHistoryTaskAPI("GetHistoryTasks", tasks.CategoryTimer)))
+	s.True(isWriteOperation("AppendHistoryNodes"))
+	s.True(isWriteOperation("TrimHistoryBranch"))
+	s.True(isWriteOperation("UpsertClusterMembership"))
+	s.True(isWriteOperation("GetOrCreateShard"))
+	s.True(isWriteOperation(ConstructHistoryTaskAPI("RangeCompleteHistoryTasks", tasks.CategoryTimer)))
+	s.True(isWriteOperation("NotAnOperation"))
+}
+
+func (s *rateLimitedClientSuite) TestUtilizationMetrics() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1, 100)),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithTimeSource(timeSource),
+		WithScanRateLimiter(quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1, 100))),
+		WithUtilizationMetrics(),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	s.mockExecutionStore.EXPECT().ListConcreteExecutions(gomock.Any(), gomock.Any()).Return(&ListConcreteExecutionsResponse{}, nil).AnyTimes()
+	consumed := metrics.PersistenceRateLimitTokensConsumed.GetMetricName()
+	utilization := metrics.PersistenceRateLimitUtilization.GetMetricName()
+
+	// 30 reads and 6 scans spread over the minute
+	for i := 0; i < 30; i++ {
+		timeSource.Update(now.Add(time.Duration(i) * 2 * time.Second))
+		_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+		s.NoError(err)
+		if i%5 == 0 {
+			_, err = client.ListConcreteExecutions(ctx, &ListConcreteExecutionsRequest{ShardID: 1})
+			s.NoError(err)
+		}
+	}
+	_, ok := s.metricsHandler.gauge(utilization)
+	s.False(ok)
+
+	// the first request after the minute samples the utilization
+	timeSource.Update(now.Add(time.Minute))
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	mainTag := metrics.StringTag(rateLimiterTagName, mainRateLimiterName)
+	scanTag := metrics.StringTag(rateLimiterTagName, scanRateLimiterName)
+	value, _ := s.metricsHandler.gauge(consumed, mainTag)
+	s.Equal(float64(30), value)
+	value, _ = s.metricsHandler.gauge(utilization, mainTag)
+	s.InDelta(0.5, value, 0.001)
+	value, _ = s.metricsHandler.gauge(consumed, scanTag)
+	s.Equal(float64(6), value)
+	value, _ = s.metricsHandler.gauge(utilization, scanTag)
+	s.InDelta(0.1, value, 0.001)
+}
+
+func (s *rateLimitedClientSuite) TestBootstrapSafetyValve() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	client := NewMetadataPersistenceRateLimitedClient(
+		s.mockMetadataStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithBootstrapSafetyValve(10*time.Second, 3),
+	)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.GetMetadata(ctx)
+		s.Equal(ErrPersistenceLimitExceeded, err)
+	}
+
+	// the valve opened for the bootstrap operations only
+	s.mockMetadataStore.EXPECT().GetMetadata(gomock.Any()).Return(&GetMetadataResponse{}, nil)
+	s.mockMetadataStore.EXPECT().InitializeSystemNamespaces(gomock.Any(), "active").Return(nil)
+	_, err := client.GetMetadata(ctx)
+	s.NoError(err)
+	s.NoError(client.InitializeSystemNamespaces(ctx, "active"))
+	_, err = client.GetNamespace(ctx, &GetNamespaceRequest{ID: "ns-1"})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	// and closes again after a while
+	timeSource.Update(now.Add(10 * time.Second))
+	_, err = client.GetMetadata(ctx)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+}
+
+func (s *rateLimitedClientSuite) TestBootstrapSafetyValve_OnlyDuringBootstrap() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	client := NewMetadataPersistenceRateLimitedClient(
+		s.mockMetadataStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithBootstrapSafetyValve(10*time.Second, 3),
+	)
+	ctx := context.Background()
+
+	timeSource.Update(now.Add(10 * time.Second))
+	for i := 0; i < 4; i++ {
+		_, err := client.GetMetadata(ctx)
+		s.Equal(ErrPersistenceLimitExceeded, err)
+	}
+}
+
+func (s *rateLimitedClientSuite) TestRateLimitConfigProvider() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	provider := NewStaticRateLimitConfigProvider(5, map[string]float64{"ns-1": 2})
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		nil,
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithRateLimitConfigProvider(provider, time.Minute),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	admitted := func(namespace string) int {
+		ctx := headers.SetCallerName(context.Background(), namespace)
+		count := 0
+		for i := 0; i < 100; i++ {
+			if _, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1}); err == nil {
+				count++
+			}
+		}
+		return count
+	}
+
+	s.Equal(2, admitted("ns-1"))
+	s.Equal(3, admitted("ns-2"))
+
+	// the new limits are only picked up once the refresh interval is over
+	provider.SetGlobalRPS(10)
+	provider.SetNamespaceRPS("ns-1", 0)
+	provider.SetNamespaceRPS("ns-2", 4)
+	s.Equal(0, admitted("ns-1"))
+	timeSource.Update(now.Add(time.Minute))
+	s.Equal(4, admitted("ns-2"))
+	// the global rate limiter carries over the tokens it accumulated at the previous limits
+	s.Equal(1, admitted("ns-1"))
+	timeSource.Update(now.Add(time.Minute + time.Second))
+	s.Equal(10, admitted("ns-1"))
+}
+
+func (s *rateLimitedClientSuite) TestStarvationTracking() {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	timeSource := clock.NewEventTimeSource().Update(now)
+	rateLimiter := quotastest.NewCountingRateLimiter(1)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithMetricsHandler(s.metricsHandler),
+		WithStarvationTracking(),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	s.mockExecutionStore.EXPECT().UpdateWorkflowExecution(gomock.Any(), gomock.Any()).Return(&UpdateWorkflowExecutionResponse{}, nil).AnyTimes()
+	get := func(offset time.Duration) error {
+		timeSource.Update(now.Add(offset))
+		_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+		return err
+	}
+	update := func(offset time.Duration) error {
+		timeSource.Update(now.Add(offset))
+		_, err := client.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{ShardID: 1})
+		return err
+	}
+	starvation := metrics.PersistenceRateLimitStarvation.GetMetricName()
+	reads := metrics.StringTag(operationClassTagName, operationClassReads)
+	writes := metrics.StringTag(operationClassTagName, operationClassWrites)
+
+	s.NoError(get(0))
+	s.Empty(client.(StarvationReporter).StarvationDurations())
+
+	// the reads starve from their first rejection until one of them is admitted again
+	s.Error(get(time.Second))
+	s.Error(get(2 * time.Second))
+	s.Error(update(3 * time.Second))
+	timeSource.Update(now.Add(4 * time.Second))
+	s.Equal(map[string]time.Duration{
+		operationClassReads:  3 * time.Second,
+		operationClassWrites: time.Second,
+	}, client.(StarvationReporter).StarvationDurations())
+	s.Empty(s.metricsHandler.timer(starvation))
+
+	rateLimiter.SetAllows(quotastest.Unlimited)
+	s.NoError(get(5 * time.Second))
+	s.Equal([]time.Duration{4 * time.Second}, s.metricsHandler.timer(starvation, reads, metrics.StoreTag("execution")))
+	s.Empty(s.metricsHandler.timer(starvation, writes))
+
+	// the starvations add up, the ongoing one of the writes included
+	rateLimiter.SetAllows(0)
+	s.Error(get(10 * time.Second))
+	rateLimiter.SetAllows(quotastest.Unlimited)
+	s.NoError(get(12 * time.Second))
+	timeSource.Update(now.Add(13 * time.Second))
+	s.Equal(map[string]time.Duration{
+		operationClassReads:  6 * time.Second,
+		operationClassWrites: 10 * time.Second,
+	}, client.(StarvationReporter).StarvationDurations())
+	s.NoError(update(13 * time.Second))
+	s.Equal([]time.Duration{10 * time.Second}, s.metricsHandler.timer(starvation, writes))
+	s.ElementsMatch([]time.Duration{4 * time.Second, 2 * time.Second}, s.metricsHandler.timer(starvation, reads))
+}
+
+func (s *rateLimitedClientSuite) TestStarvationTracking_Disabled() {
+	client := NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, quotastest.NewCountingRateLimiter(0), log.NewNoopLogger())
+
+	_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Error(err)
+	s.Nil(client.(StarvationReporter).StarvationDurations())
+}
+
+func (s *rateLimitedClientSuite) TestSpillover() {
+	spillover := NewMockExecutionManager(s.controller)
+	client := NewExecutionPersistenceRateLimitedClientWithSpillover(
+		s.mockExecutionStore,
+		spillover,
+		nil,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 1)),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+	)
+	ctx := context.Background()
+	primaryResponse := &GetWorkflowExecutionResponse{DBRecordVersion: 1}
+	spilloverResponse := &GetWorkflowExecutionResponse{DBRecordVersion: 2}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(primaryResponse, nil)
+	spillover.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(spilloverResponse, nil)
+	spillover.EXPECT().GetCurrentExecution(gomock.Any(), gomock.Any()).Return(&GetCurrentExecutionResponse{RunID: "run-1"}, nil)
+	spillover.EXPECT().ReadHistoryBranch(gomock.Any(), gomock.Any()).Return(&ReadHistoryBranchResponse{Size: 10}, nil)
+
+	response, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	s.Equal(primaryResponse, response)
+
+	// the reads rejected by the rate limiter are served by the spillover
+	response, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	s.Equal(spilloverResponse, response)
+	currentResponse, err := client.GetCurrentExecution(ctx, &GetCurrentExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	s.Equal("run-1", currentResponse.RunID)
+	historyResponse, err := client.ReadHistoryBranch(ctx, &ReadHistoryBranchRequest{ShardID: 1})
+	s.NoError(err)
+	s.Equal(10, historyResponse.Size)
+
+	// writes never spill over
+	_, err = client.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = client.CreateWorkflowExecution(ctx, newCreateWorkflowExecutionRequest("workflow-1", "run-1"))
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	spillovers := metrics.PersistenceSpilloverRequests.GetMetricName()
+	s.Equal(int64(3), s.metricsHandler.counter(spillovers))
+	s.Equal(int64(1), s.metricsHandler.counter(spillovers, metrics.OperationTag("GetWorkflowExecution")))
+	// spilled over reads are still metered as rejections of the rate limiter
+	s.Equal(int64(5), client.(RateLimitedClient).RateLimitStats().Rejections)
+
+	s.mockExecutionStore.EXPECT().Close()
+	spillover.EXPECT().Close()
+	client.Close()
+}
+
+func (s *rateLimitedClientSuite) TestSpillover_RateLimiter() {
+	spillover := NewMockExecutionManager(s.controller)
+	rateLimiter := quotastest.NewCountingRateLimiter(0)
+	spilloverRateLimiter := quotastest.NewCountingRateLimiter(1)
+	client := NewExecutionPersistenceRateLimitedClientWithSpillover(
+		s.mockExecutionStore,
+		spillover,
+		nil,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithSpilloverRateLimiter(spilloverRateLimiter),
+		WithResponseSizeCharging(10),
+		WithDecisionLog(10),
+	)
+	ctx := context.Background()
+	spillover.EXPECT().ReadHistoryBranchByBatch(gomock.Any(), gomock.Any()).Return(&ReadHistoryBranchByBatchResponse{Size: 100}, nil)
+
+	_, err := client.ReadHistoryBranchByBatch(ctx, &ReadHistoryBranchRequest{ShardID: 1})
+	s.NoError(err)
+	// the reads the spillover rate limiter denies fail with the rejection
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "namespace-1"})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	// the response size of spilled over reads is charged to the spillover rate limiter
+	calls := spilloverRateLimiter.Calls()
+	s.Len(calls, 3)
+	s.Equal("Reserve", calls[1].Method)
+	s.Equal("ReadHistoryBranchByBatch", calls[1].Request.API)
+	s.Equal(10, calls[1].Request.Token)
+	for _, call := range rateLimiter.Calls() {
+		s.NotEqual("Reserve", call.Method)
+	}
+
+	decisions := client.(AdmissionDecisionReporter).RecentDecisions()
+	s.Len(decisions, 4)
+	s.False(decisions[0].Allowed)
+	s.True(decisions[1].SpilledOver)
+	s.True(decisions[1].Allowed)
+	s.True(decisions[3].SpilledOver)
+	s.False(decisions[3].Allowed)
+	s.Equal("namespace-1", decisions[3].NamespaceID)
+	s.Equal(int64(2), client.(RateLimitedClient).RateLimitStats().Rejections)
+	// spilled over reads are counted as rejections, not as served by persistence
+	s.Equal(OperationOutcomes{Rejected: 1}, client.(RateLimitedClient).RateLimitStats().OutcomesByOperation["ReadHistoryBranchByBatch"])
+}
+
+func (s *rateLimitedClientSuite) TestSpillover_Accounting() {
+	spillover := NewMockExecutionManager(s.controller)
+	client := NewExecutionPersistenceRateLimitedClientWithSpillover(
+		s.mockExecutionStore,
+		spillover,
+		nil,
+		quotastest.NewCountingRateLimiter(0),
+		log.NewNoopLogger(),
+		WithNotFoundCache(time.Minute, 10),
+		WithNamespaceIOAccounting(10),
+	)
+	ctx := context.Background()
+	spillover.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{
+		MutableStateStats: MutableStateStatistics{TotalSize: 100},
+	}, nil)
+	spillover.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(nil, serviceerror.NewNotFound("workflow execution not found")).Times(1)
+
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "namespace-1", WorkflowID: "workflow-1", RunID: "run-1"})
+	s.NoError(err)
+	s.Equal(IOStats{Requests: 1, ResponseBytes: 100}, client.(NamespaceIOReporter).NamespaceIOBytes()["namespace-1"])
+
+	// the runs the spillover does not find are cached as any other
+	for i := 0; i < 2; i++ {
+		_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "namespace-1", WorkflowID: "workflow-1", RunID: "run-2"})
+		s.IsType(&serviceerror.NotFound{}, err)
+	}
+}
+
+func (s *rateLimitedClientSuite) TestSpillover_ReadClassification() {
+	spillover := NewMockExecutionManager(s.controller)
+	client := NewExecutionPersistenceRateLimitedClientWithSpillover(
+		s.mockExecutionStore,
+		spillover,
+		func(operation string) bool {
+			return operation == "GetWorkflowExecution" || operation == "UpdateWorkflowExecution"
+		},
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0)),
+		log.NewNoopLogger(),
+	)
+	ctx := context.Background()
+	spillover.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil)
+
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	_, err = client.GetCurrentExecution(ctx, &GetCurrentExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	// writes never spill over, even if classified as reads
+	_, err = client.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	// only the rejections of the rate limiter spill over
+	client.(RateLimitedClient).SetOperationEnabled("GetWorkflowExecution", false)
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceOperationDisabled, err)
+}
+
+func (s *rateLimitedClientSuite) TestDegradedPages() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 1)),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithRejectionDetails(),
+		WithDegradedPages("ListConcreteExecutions", "GetAllHistoryTreeBranches"),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().ListConcreteExecutions(gomock.Any(), gomock.Any()).Return(&ListConcreteExecutionsResponse{
+		States:    []*persistencespb.WorkflowMutableState{{}},
+		PageToken: []byte("page-2"),
+	}, nil)
+
+	response, err := client.ListConcreteExecutions(ctx, &ListConcreteExecutionsRequest{ShardID: 1, PageToken: []byte("page-1")})
+	s.NoError(err)
+	s.Len(response.States, 1)
+
+	// the rejected page is empty, and continues at itself
+	response, err = client.ListConcreteExecutions(ctx, &ListConcreteExecutionsRequest{ShardID: 1, PageToken: response.PageToken})
+	s.NoError(err)
+	s.Empty(response.States)
+	s.Equal([]byte("page-2"), response.PageToken)
+	branchesResponse, err := client.GetAllHistoryTreeBranches(ctx, &GetAllHistoryTreeBranchesRequest{NextPageToken: []byte("branches-2")})
+	s.NoError(err)
+	s.Empty(branchesResponse.Branches)
+	s.Equal([]byte("branches-2"), branchesResponse.NextPageToken)
+
+	// the first page of a scan is not degraded, as an empty page of no token would end the scan
+	_, err = client.ListConcreteExecutions(ctx, &ListConcreteExecutionsRequest{ShardID: 1})
+	s.ErrorAs(err, new(*serviceerror.ResourceExhausted))
+
+	// degraded pages are metered as rejections
+	s.Equal(int64(3), client.(RateLimitedClient).RateLimitStats().Rejections)
+	rejected := metrics.PersistenceOperationRejected.GetMetricName()
+	degraded := metrics.PersistenceDegradedResponses.GetMetricName()
+	s.Equal(int64(2), s.metricsHandler.counter(rejected, metrics.OperationTag("ListConcreteExecutions")))
+	s.Equal(int64(1), s.metricsHandler.counter(rejected, metrics.OperationTag("GetAllHistoryTreeBranches")))
+	s.Equal(int64(1), s.metricsHandler.counter(degraded, metrics.OperationTag("ListConcreteExecutions")))
+	s.Equal(int64(1), s.metricsHandler.counter(degraded, metrics.OperationTag("GetAllHistoryTreeBranches")))
+}
+
+func (s *rateLimitedClientSuite) TestDegradedPages_OtherOperations() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 1)),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithDegradedPages("ListConcreteExecutions"),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().GetAllHistoryTreeBranches(gomock.Any(), gomock.Any()).Return(&GetAllHistoryTreeBranchesResponse{}, nil)
+
+	_, err := client.GetAllHistoryTreeBranches(ctx, &GetAllHistoryTreeBranchesRequest{NextPageToken: []byte("branches-1")})
+	s.NoError(err)
+	_, err = client.GetAllHistoryTreeBranches(ctx, &GetAllHistoryTreeBranchesRequest{NextPageToken: []byte("branches-2")})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Equal(int64(0), s.metricsHandler.counter(metrics.PersistenceDegradedResponses.GetMetricName()))
+}
+
+func (s *rateLimitedClientSuite) TestOperationTimeout() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithOperationTimeout("GetWorkflowExecution", 20*time.Millisecond),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, _ *GetWorkflowExecutionRequest) (*GetWorkflowExecutionResponse, error) {
+			// a slow downstream only giving up once its context is done
+			<-ctx.Done()
+			return nil, serviceerror.NewUnavailable(ctx.Err().Error())
+		},
+	)
+
+	start := time.Now()
+	_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.ErrorIs(err, context.DeadlineExceeded)
+	s.Contains(err.Error(), "GetWorkflowExecution")
+	s.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+	s.Less(time.Since(start), 5*time.Second)
+	s.Equal(int64(1), s.metricsHandler.counter(
+		metrics.PersistenceOperationTimeouts.GetMetricName(),
+		metrics.OperationTag("GetWorkflowExecution"),
+	))
+}
+
+func (s *rateLimitedClientSuite) TestOperationTimeout_NotExceeded() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithOperationTimeout("GetWorkflowExecution", time.Minute),
+	)
+	persistenceErr := serviceerror.NewUnavailable("persistence unavailable")
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, _ *GetWorkflowExecutionRequest) (*GetWorkflowExecutionResponse, error) {
+			deadline, ok := ctx.Deadline()
+			s.True(ok)
+			s.WithinDuration(time.Now().Add(time.Minute), deadline, time.Second)
+			return &GetWorkflowExecutionResponse{}, nil
+		},
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(nil, persistenceErr)
+	s.mockExecutionStore.EXPECT().GetCurrentExecution(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, _ *GetCurrentExecutionRequest) (*GetCurrentExecutionResponse, error) {
+			// operations without a timeout are not bounded
+			_, ok := ctx.Deadline()
+			s.False(ok)
+			return &GetCurrentExecutionResponse{}, nil
+		},
+	)
+
+	_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	_, err = client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(persistenceErr, err)
+	_, err = client.GetCurrentExecution(context.Background(), &GetCurrentExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	s.Equal(int64(0), s.metricsHandler.counter(metrics.PersistenceOperationTimeouts.GetMetricName()))
+}
+
+func (s *rateLimitedClientSuite) TestOperationTimeout_CallerDeadline() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithOperationTimeout("GetWorkflowExecution", time.Minute),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, _ *GetWorkflowExecutionRequest) (*GetWorkflowExecutionResponse, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	)
+
+	// the deadline of the caller passing first is not reported as the operation timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(context.DeadlineExceeded, err)
+	s.Equal(int64(0), s.metricsHandler.counter(metrics.PersistenceOperationTimeouts.GetMetricName()))
+}
+
+func (s *rateLimitedClientSuite) TestDLQBacklogFeedback() {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	timeSource := clock.NewEventTimeSource().Update(now)
+	source := &fakeDLQBacklogSource{}
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1, 20)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithDLQBacklogFeedback(
+			source,
+			time.Hour,
+			DLQBacklogThreshold{Backlog: 1000, WriteTokenFactor: 5},
+			DLQBacklogThreshold{Backlog: 100, WriteTokenFactor: 2},
+		),
+	)
+	defer client.Close()
+	s.mockExecutionStore.EXPECT().Close().AnyTimes()
+	s.mockExecutionStore.EXPECT().UpdateWorkflowExecution(gomock.Any(), gomock.Any()).Return(&UpdateWorkflowExecutionResponse{}, nil).AnyTimes()
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	feedback := client.(*executionRateLimitedPersistenceClient).dlqBacklog
+	admitted := func(call func() error) int {
+		// every phase starts at the full burst of the rate limiter
+		now = now.Add(time.Minute)
+		timeSource.Update(now)
+		count := 0
+		for i := 0; i < 30; i++ {
+			if call() == nil {
+				count++
+			}
+		}
+		return count
+	}
+	update := func() error {
+		_, err := client.UpdateWorkflowExecution(context.Background(), &UpdateWorkflowExecutionRequest{ShardID: 1})
+		return err
+	}
+	get := func() error {
+		_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+		return err
+	}
+	poll := func(backlog int64, err error) {
+		source.set(backlog, err)
+		feedback.poll(context.Background())
+	}
+
+	poll(10, nil)
+	s.Equal(20, admitted(update))
+	// the limiter tightens as the backlog grows
+	poll(100, nil)
+	s.Equal(10, admitted(update))
+	poll(5000, nil)
+	s.Equal(4, admitted(update))
+	s.Equal(20, admitted(get))
+	// the limiter stays tight while the backlog cannot be read
+	poll(0, serviceerror.NewUnavailable("unavailable"))
+	s.Equal(4, admitted(update))
+	poll(999, nil)
+	s.Equal(10, admitted(update))
+	poll(0, nil)
+	s.Equal(20, admitted(update))
+}
+
+func (s *rateLimitedClientSuite) TestDLQBacklogFeedback_Background() {
+	source := &fakeDLQBacklogSource{}
+	source.set(100, nil)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithDLQBacklogFeedback(source, 5*time.Millisecond, DLQBacklogThreshold{Backlog: 100, WriteTokenFactor: 3}),
+	)
+	s.mockExecutionStore.EXPECT().Close().AnyTimes()
+	feedback := client.(*executionRateLimitedPersistenceClient).dlqBacklog
+	s.Equal(1, feedback.writeTokens(1))
+
+	s.Eventually(func() bool { return feedback.writeTokens(1) == 3 }, 5*time.Second, time.Millisecond)
+	source.set(99, nil)
+	s.Eventually(func() bool { return feedback.writeTokens(1) == 1 }, 5*time.Second, time.Millisecond)
+
+	// closing the client stops the polling
+	client.Close()
+	client.Close()
+}
+
+func (s *rateLimitedClientSuite) TestDLQBacklogFeedback_Disabled() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithDLQBacklogFeedback(&fakeDLQBacklogSource{}, time.Minute),
+	)
+	s.Nil(client.(*executionRateLimitedPersistenceClient).dlqBacklog)
+}
+
+func (s *rateLimitedClientSuite) TestNamespaceIOAccounting() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithNamespaceIOAccounting(10),
+	)
+	ctx := context.Background()
+	createRequest := newCreateWorkflowExecutionRequest("workflow-1", "run-1")
+	createRequest.NewWorkflowSnapshot.ExecutionInfo.NamespaceId = "ns-1"
+	s.mockExecutionStore.EXPECT().CreateWorkflowExecution(gomock.Any(), createRequest).Return(&CreateWorkflowExecutionResponse{
+		NewMutableStateStats: MutableStateStatistics{TotalSize: 100, HistoryStatistics: &HistoryStatistics{SizeDiff: 50}},
+	}, nil)
+	s.mockExecutionStore.EXPECT().UpdateWorkflowExecution(gomock.Any(), gomock.Any()).Return(&UpdateWorkflowExecutionResponse{
+		UpdateMutableStateStats: MutableStateStatistics{TotalSize: 20, HistoryStatistics: &HistoryStatistics{SizeDiff: 10}},
+		NewMutableStateStats:    &MutableStateStatistics{TotalSize: 5},
+	}, nil).Times(2)
+	s.mockExecutionStore.EXPECT().ConflictResolveWorkflowExecution(gomock.Any(), gomock.Any()).Return(&ConflictResolveWorkflowExecutionResponse{
+		ResetMutableStateStats:   MutableStateStatistics{TotalSize: 7},
+		CurrentMutableStateStats: &MutableStateStatistics{TotalSize: 3},
+	}, nil)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{
+		MutableStateStats: MutableStateStatistics{TotalSize: 300},
+	}, nil).Times(3)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(nil, serviceerror.NewUnavailable("unavailable"))
+
+	s.Empty(client.(NamespaceIOReporter).NamespaceIOBytes())
+	_, err := client.CreateWorkflowExecution(ctx, createRequest)
+	s.NoError(err)
+	for i := 0; i < 2; i++ {
+		_, err = client.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{
+			UpdateWorkflowMutation: WorkflowMutation{ExecutionInfo: &persistencespb.WorkflowExecutionInfo{NamespaceId: "ns-1"}},
+		})
+		s.NoError(err)
+	}
+	_, err = client.ConflictResolveWorkflowExecution(ctx, &ConflictResolveWorkflowExecutionRequest{
+		ResetWorkflowSnapshot: WorkflowSnapshot{ExecutionInfo: &persistencespb.WorkflowExecutionInfo{NamespaceId: "ns-2"}},
+	})
+	s.NoError(err)
+	for i := 0; i < 3; i++ {
+		_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{NamespaceID: "ns-2"})
+		s.NoError(err)
+	}
+	// failed requests are not accounted
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{NamespaceID: "ns-2"})
+	s.Error(err)
+
+	s.Equal(map[string]IOStats{
+		"ns-1": {Requests: 3, RequestBytes: 150 + 2*35},
+		"ns-2": {Requests: 4, RequestBytes: 10, ResponseBytes: 900},
+	}, client.(NamespaceIOReporter).NamespaceIOBytes())
+}
+
+func (s *rateLimitedClientSuite) TestNamespaceIOAccounting_Overflow() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithNamespaceIOAccounting(2),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{
+		MutableStateStats: MutableStateStatistics{TotalSize: 10},
+	}, nil).AnyTimes()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		namespaceID := fmt.Sprintf("ns-%d", i)
+		if i >= 2 {
+			// the first namespaces are accounted on their own regardless of the order of requests
+			wg.Wait()
+		}
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{NamespaceID: namespaceID})
+				s.NoError(err)
+			}()
+		}
+	}
+	wg.Wait()
+
+	s.Equal(map[string]IOStats{
+		"ns-0":              {Requests: 10, ResponseBytes: 100},
+		"ns-1":              {Requests: 10, ResponseBytes: 100},
+		NamespaceIOOverflow: {Requests: 20, ResponseBytes: 200},
+	}, client.(NamespaceIOReporter).NamespaceIOBytes())
+}
+
+func (s *rateLimitedClientSuite) TestNamespaceIOAccounting_Disabled() {
+	client := NewExecutionPersistenceRateLimitedClient(s.mockExecutionStore, quotas.NoopRequestRateLimiter, log.NewNoopLogger())
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+	_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{NamespaceID: "ns-1"})
+	s.NoError(err)
+	s.Nil(client.(NamespaceIOReporter).NamespaceIOBytes())
+}
+
+func (s *rateLimitedClientSuite) TestRateLimitConfigProvider_NamespaceBuckets() {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	provider := NewStaticRateLimitConfigProvider(100, map[string]float64{"ns-1": 2})
+	rateLimiter := newConfigProviderRateLimiter(provider, time.Minute, nil, now)
+
+	// the combined rate limiter of a namespace is built once, not on every request
+	combined := rateLimiter.rateLimiter(now, "ns-1")
+	s.Same(combined, rateLimiter.rateLimiter(now, "ns-1"))
+	s.Zero(testing.AllocsPerRun(100, func() { rateLimiter.rateLimiter(now, "ns-1") }))
+
+	// namespaces only limited globally hold no bucket
+	s.Same(rateLimiter.global, rateLimiter.rateLimiter(now, "ns-2"))
+	s.NotContains(rateLimiter.namespaces, "ns-2")
+
+	// the limits are refreshed in place, and namespaces which became limited get a bucket
+	provider.SetNamespaceRPS("ns-1", 4)
+	provider.SetNamespaceRPS("ns-2", 3)
+	now = now.Add(time.Minute)
+	s.Same(combined, rateLimiter.rateLimiter(now, "ns-1"))
+	s.Equal(4.0, rateLimiter.namespaces["ns-1"].rateLimiter.Rate())
+	s.NotSame(rateLimiter.global, rateLimiter.rateLimiter(now, "ns-2"))
+	s.Equal(3.0, rateLimiter.namespaces["ns-2"].rateLimiter.Rate())
+
+	// and namespaces which are only limited globally again lose theirs
+	provider.SetNamespaceRPS("ns-1", 0)
+	now = now.Add(time.Minute)
+	s.Same(rateLimiter.global, rateLimiter.rateLimiter(now, "ns-1"))
+	s.NotContains(rateLimiter.namespaces, "ns-1")
+}
+
+func (s *rateLimitedClientSuite) TestNamespaceBucketEviction() {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	timeSource := clock.NewEventTimeSource().Update(now)
+	provider := NewStaticRateLimitConfigProvider(100, map[string]float64{"ns-1": 2, "ns-2": 2})
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		nil,
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithRateLimitConfigProvider(provider, time.Hour),
+		WithNamespaceBucketEviction(time.Hour),
+	)
+	defer client.Close()
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	s.mockExecutionStore.EXPECT().Close().AnyTimes()
+	enforcer := client.(*executionRateLimitedPersistenceClient).rateLimitEnforcer
+	get := func(namespace string) error {
+		ctx := headers.SetCallerName(context.Background(), namespace)
+		_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+		return err
+	}
+
+	s.Equal(0, client.(NamespaceBucketReporter).ActiveNamespaceBucketCount())
+	s.NoError(get("ns-1"))
+	s.NoError(get("ns-2"))
+	// namespaces only limited globally hold no bucket of their own
+	s.NoError(get("ns-3"))
+	s.Equal(2, client.(NamespaceBucketReporter).ActiveNamespaceBucketCount())
+
+	timeSource.Update(now.Add(30 * time.Minute))
+	s.NoError(get("ns-1"))
+	s.NoError(get("ns-1"))
+	s.Error(get("ns-1"))
+	timeSource.Update(now.Add(time.Hour))
+	s.Equal(1, enforcer.bucketEvictor.evict())
+	s.Equal(1, client.(NamespaceBucketReporter).ActiveNamespaceBucketCount())
+	s.NotContains(enforcer.rateLimiter.(*configProviderRateLimiter).namespaces, "ns-3")
+
+	// the bucket of an evicted namespace is created again at its full burst
+	s.NoError(get("ns-2"))
+	s.NoError(get("ns-2"))
+	s.Equal(2, client.(NamespaceBucketReporter).ActiveNamespaceBucketCount())
+	timeSource.Update(now.Add(90 * time.Minute))
+	s.Equal(1, enforcer.bucketEvictor.evict())
+	s.Equal(1, client.(NamespaceBucketReporter).ActiveNamespaceBucketCount())
+	s.Contains(enforcer.rateLimiter.(*configProviderRateLimiter).namespaces, "ns-2")
+}
+
+func (s *rateLimitedClientSuite) TestNamespaceBucketEviction_Background() {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	timeSource := clock.NewEventTimeSource().Update(now)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		nil,
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithRateLimitConfigProvider(NewStaticRateLimitConfigProvider(100, map[string]float64{"ns-1": 2}), time.Hour),
+		WithNamespaceBucketEviction(10*time.Millisecond),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	s.mockExecutionStore.EXPECT().Close().AnyTimes()
+
+	ctx := headers.SetCallerName(context.Background(), "ns-1")
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	s.Equal(1, client.(NamespaceBucketReporter).ActiveNamespaceBucketCount())
+
+	timeSource.Update(now.Add(time.Minute))
+	s.Eventually(func() bool {
+		return client.(NamespaceBucketReporter).ActiveNamespaceBucketCount() == 0
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// closing the client stops the eviction
+	client.Close()
+	client.Close()
+}
+
+func (s *rateLimitedClientSuite) TestNamespaceBucketEviction_WithoutConfigProvider() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)),
+		log.NewNoopLogger(),
+		WithNamespaceBucketEviction(time.Minute),
+	)
+	s.Nil(client.(*executionRateLimitedPersistenceClient).bucketEvictor)
+	s.Equal(0, client.(NamespaceBucketReporter).ActiveNamespaceBucketCount())
+}
+
+func (s *rateLimitedClientSuite) TestWarmNamespace() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	provider := NewStaticRateLimitConfigProvider(100, map[string]float64{"ns-1": 2, "ns-2": 2})
+	namespaceRateLimiter := quotas.NewNamespaceRequestRateLimiter(func(quotas.Request) quotas.RequestRateLimiter {
+		return quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst))
+	})
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		nil,
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithRateLimitConfigProvider(provider, time.Minute),
+		withOperationRateLimiter("namespace", namespaceRateLimiter, "UpdateWorkflowExecution"),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	s.mockExecutionStore.EXPECT().UpdateWorkflowExecution(gomock.Any(), gomock.Any()).Return(&UpdateWorkflowExecutionResponse{}, nil).AnyTimes()
+	configProvider := client.(*executionRateLimitedPersistenceClient).rateLimiter.(*configProviderRateLimiter)
+	admitted := func(namespace string, call func(ctx context.Context) error) int {
+		ctx := headers.SetCallerName(context.Background(), namespace)
+		count := 0
+		for i := 0; i < 100; i++ {
+			if err := call(ctx); err == nil {
+				count++
+			}
+		}
+		return count
+	}
+	get := func(ctx context.Context) error {
+		_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+		return err
+	}
+	update := func(ctx context.Context) error {
+		_, err := client.UpdateWorkflowExecution(ctx, &UpdateWorkflowExecutionRequest{ShardID: 1})
+		return err
+	}
+
+	client.(NamespaceRateLimitedClient).WarmNamespace("ns-1")
+	s.Contains(configProvider.namespaceRates(), "ns-1")
+	s.NotContains(configProvider.namespaceRates(), "ns-2")
+	s.Equal(float64(configProviderBurst(2)), configProvider.namespaces["ns-1"].rateLimiter.TokensAt(now))
+	s.Equal(configProviderBurst(2), admitted("ns-1", get))
+	s.Equal(testRateLimitedClientBurst, admitted("ns-1", update))
+
+	// the buckets of namespaces which were not warmed are created at their full burst on first request
+	s.Equal(configProviderBurst(2), admitted("ns-2", get))
+	s.Equal(testRateLimitedClientBurst, admitted("ns-2", update))
+}
+
+func (s *rateLimitedClientSuite) TestSwapPersistence_UnderLoad() {
+	const (
+		burst       = 25
+		goroutines  = 4
+		callsPerGo  = 5
+		callsAfter  = 5
+		inFlightRun = "in-flight"
+	)
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, burst)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+	)
+	newExecutionStore := NewMockExecutionManager(s.controller)
+	newExecutionStore.EXPECT().GetName().Return("new-execution").AnyTimes()
+
+	var oldCalls, newCalls int32
+	inFlightStarted := make(chan struct{})
+	releaseInFlight := make(chan struct{})
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, request *GetWorkflowExecutionRequest) (*GetWorkflowExecutionResponse, error) {
+			if request.RunID == inFlightRun {
+				close(inFlightStarted)
+				<-releaseInFlight
+			}
+			atomic.AddInt32(&oldCalls, 1)
+			return &GetWorkflowExecutionResponse{DBRecordVersion: 1}, nil
+		},
+	).AnyTimes()
+	newExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ *GetWorkflowExecutionRequest) (*GetWorkflowExecutionResponse, error) {
+			atomic.AddInt32(&newCalls, 1)
+			return &GetWorkflowExecutionResponse{DBRecordVersion: 2}, nil
+		},
+	).AnyTimes()
+
+	inFlightDone := make(chan *GetWorkflowExecutionResponse)
+	go func() {
+		response, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1, RunID: inFlightRun})
+		s.NoError(err)
+		inFlightDone <- response
+	}()
+	<-inFlightStarted
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < callsPerGo; j++ {
+				_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+				s.NoError(err)
+			}
+		}()
+	}
+	client.(PersistenceSwapper).SwapPersistence(newExecutionStore)
+	wg.Wait()
+
+	// the in-flight call completes against the manager it started on
+	close(releaseInFlight)
+	s.Equal(int64(1), (<-inFlightDone).DBRecordVersion)
+	s.Equal(int32(1+goroutines*callsPerGo), atomic.LoadInt32(&oldCalls)+atomic.LoadInt32(&newCalls))
+	s.Equal("new-execution", client.GetName())
+
+	// the swap neither refills the rate limiter nor resets the stats
+	newCallsBefore := atomic.LoadInt32(&newCalls)
+	oldCallsBefore := atomic.LoadInt32(&oldCalls)
+	rejections := 0
+	for i := 0; i < callsAfter; i++ {
+		if _, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1}); err != nil {
+			rejections++
+		}
+	}
+	s.Equal(1, rejections)
+	s.Equal(newCallsBefore+callsAfter-1, atomic.LoadInt32(&newCalls))
+	s.Equal(oldCallsBefore, atomic.LoadInt32(&oldCalls))
+	s.Equal(int64(1), client.(RateLimitedClient).RateLimitStats().Rejections)
+}
+
+func (s *rateLimitedClientSuite) TestDownstreamResourceExhausted_CountedApartFromRejections() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 1)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+	)
+	downstreamErr := serviceerror.NewResourceExhausted(enumspb.RESOURCE_EXHAUSTED_CAUSE_SYSTEM_OVERLOADED, "database overloaded")
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(nil, downstreamErr)
+	downstream := metrics.PersistenceDownstreamResourceExhausted.GetMetricName()
+	rejections := metrics.PersistenceRateLimitRejections.GetMetricName()
+
+	// passed through from persistence
+	_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(downstreamErr, err)
+	s.Equal(int64(1), s.metricsHandler.counter(downstream, metrics.OperationTag("GetWorkflowExecution"), metrics.StoreTag("execution")))
+	s.Equal(int64(0), s.metricsHandler.counter(rejections, metrics.OperationTag("GetWorkflowExecution")))
+
+	// rejected by the rate limiter
+	_, err = client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Equal(int64(1), s.metricsHandler.counter(downstream, metrics.OperationTag("GetWorkflowExecution"), metrics.StoreTag("execution")))
+	s.Equal(int64(1), s.metricsHandler.counter(rejections, metrics.OperationTag("GetWorkflowExecution")))
+}
+
+func (s *rateLimitedClientSuite) TestReserveBatch() {
+	timeSource := clock.NewEventTimeSource().Update(time.Now())
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 10)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+	).(RateLimitedClient)
+	executionClient := client.(ExecutionManager)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).Times(8)
+	request := &GetWorkflowExecutionRequest{ShardID: 1}
+
+	batch, err := client.ReserveBatch("GetWorkflowExecution", 5)
+	s.NoError(err)
+	s.Equal(5, batch.Remaining())
+
+	// online traffic can only use the tokens not held by the batch
+	for i := 0; i < 5; i++ {
+		_, err := executionClient.GetWorkflowExecution(context.Background(), request)
+		s.NoError(err)
+	}
+	_, err = executionClient.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	// each drawn token admits exactly one request
+	for i := 0; i < 3; i++ {
+		ctx, err := batch.Use(context.Background())
+		s.NoError(err)
+		_, err = executionClient.GetWorkflowExecution(ctx, request)
+		s.NoError(err)
+		_, err = executionClient.GetWorkflowExecution(ctx, request)
+		s.Equal(ErrPersistenceLimitExceeded, err)
+	}
+	s.Equal(2, batch.Remaining())
+
+	// the tokens not drawn go back to the rate limiter
+	batch.Release()
+	s.Equal(0, batch.Remaining())
+	_, err = batch.Use(context.Background())
+	s.Equal(ErrBatchReservationExhausted, err)
+	s.Equal(2, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestReserveBatch_NotEnoughTokens() {
+	timeSource := clock.NewEventTimeSource().Update(time.Now())
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 10)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+	).(RateLimitedClient)
+
+	_, err := client.ReserveBatch("GetWorkflowExecution", 11)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = client.ReserveBatch("GetWorkflowExecution", 0)
+	s.Error(err)
+	s.Equal(10, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestReserveBatch_NamespacePaused() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 10)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+	)
+	request := &UpdateWorkflowExecutionRequest{
+		ShardID: 1,
+		UpdateWorkflowMutation: WorkflowMutation{
+			ExecutionInfo: &persistencespb.WorkflowExecutionInfo{NamespaceId: "ns-1"},
+		},
+	}
+	batch, err := client.(RateLimitedClient).ReserveBatch("UpdateWorkflowExecution", 5)
+	s.NoError(err)
+
+	// a pause stops the writes of the namespace, including those of a batch reserved before it
+	client.(NamespaceRateLimitedClient).PauseNamespace("ns-1", time.Hour)
+	ctx, err := batch.Use(context.Background())
+	s.NoError(err)
+	_, err = client.UpdateWorkflowExecution(ctx, request)
+	s.Equal(ErrNamespacePaused, err)
+
+	client.(NamespaceRateLimitedClient).ResumeNamespace("ns-1")
+	s.mockExecutionStore.EXPECT().UpdateWorkflowExecution(gomock.Any(), gomock.Any()).Return(&UpdateWorkflowExecutionResponse{}, nil)
+	_, err = client.UpdateWorkflowExecution(ctx, request)
+	s.NoError(err)
+	s.Equal(5, drainTokens(rateLimiter))
+}
+
+func (s *rateLimitedClientSuite) TestRejectionMetricSampling() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 1)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+		WithRejectionMetricSampling(3),
+	)
+	s.Equal(1, drainTokens(rateLimiter))
+	for i := 0; i < 7; i++ {
+		_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"})
+		s.Equal(ErrPersistenceLimitExceeded, err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-2"})
+		s.Equal(ErrPersistenceLimitExceeded, err)
+	}
+
+	rejections := metrics.PersistenceRateLimitRejections.GetMetricName()
+	s.Equal(int64(6), s.metricsHandler.counter(rejections, metrics.NamespaceIDTag("ns-1")))
+	s.Equal(int64(0), s.metricsHandler.counter(rejections, metrics.NamespaceIDTag("ns-2")))
+	s.Equal(int64(9), s.metricsHandler.counter(metrics.PersistenceRateLimitRejectionsTotal.GetMetricName(), metrics.StoreTag("execution")))
+	s.Equal(int64(9), client.(RateLimitedClient).RateLimitStats().Rejections)
+}
+
+func (s *rateLimitedClientSuite) TestPriorityBoost() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	rateLimiter := quotas.NewPriorityRateLimiter(
+		func(request quotas.Request) int {
+			if request.Boosted {
+				return 0
+			}
+			return 1
+		},
+		map[int]quotas.RequestRateLimiter{
+			0: quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1, 20)),
+			1: quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1, 10)),
+		},
+	)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithPriorityBoostBudget(quotas.NewRateLimiter(testRateLimitedClientRate, 3)),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	admitted := func(ctx context.Context) int {
+		count := 0
+		for i := 0; i < 20; i++ {
+			if _, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1}); err == nil {
+				count++
+			}
+		}
+		return count
+	}
+	boostCtx := WithPriorityBoostHint(context.Background())
+
+	// once normal traffic saturated its priority, boosted requests still get through,
+	// but only within the budget, although the highest priority has capacity left
+	s.Equal(10, admitted(context.Background()))
+	s.Equal(3, admitted(boostCtx))
+
+	// the boosted requests were charged to the normal priority as well, but only the budget
+	// of them, so normal traffic keeps the rest of the capacity once the rate limiter refilled
+	timeSource.Update(now.Add(10 * time.Second))
+	s.Equal(7, admitted(context.Background()))
+}
+
+func (s *rateLimitedClientSuite) TestPriorityInheritance() {
+	var enabled atomic.Bool
+	enabled.Store(true)
+	priorityFn := func(request quotas.Request) int {
+		priority := 1
+		if request.API == "GetWorkflowExecution" {
+			priority = 0
+		}
+		if request.InheritsPriority && request.InheritedPriority < priority {
+			return request.InheritedPriority
+		}
+		return priority
+	}
+	rateLimiter := quotas.NewPriorityRateLimiter(
+		priorityFn,
+		map[int]quotas.RequestRateLimiter{
+			0: quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 20)),
+			1: quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 2)),
+		},
+	)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithPriorityInheritance(enabled.Load, priorityFn),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	s.mockExecutionStore.EXPECT().ConflictResolveWorkflowExecution(gomock.Any(), gomock.Any()).Return(&ConflictResolveWorkflowExecutionResponse{}, nil).AnyTimes()
+	getThenConflictResolve := func(ctx context.Context) error {
+		if _, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1}); err != nil {
+			return err
+		}
+		_, err := client.ConflictResolveWorkflowExecution(ctx, &ConflictResolveWorkflowExecutionRequest{ShardID: 1})
+		return err
+	}
+	conflictResolve := func(ctx context.Context) error {
+		_, err := client.ConflictResolveWorkflowExecution(ctx, &ConflictResolveWorkflowExecutionRequest{ShardID: 1})
+		return err
+	}
+
+	// saturate the lower priority
+	for i := 0; i < 2; i++ {
+		s.NoError(conflictResolve(context.Background()))
+	}
+	s.Equal(ErrPersistenceLimitExceeded, conflictResolve(context.Background()))
+
+	// the conflict resolution following a read inherits the priority of the read
+	s.NoError(getThenConflictResolve(WithPriorityInheritanceScope(context.Background())))
+	s.Equal(ErrPersistenceLimitExceeded, getThenConflictResolve(context.Background()))
+
+	// nested scopes share the priority of the outermost one
+	ctx := WithPriorityInheritanceScope(context.Background())
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	s.NoError(conflictResolve(WithPriorityInheritanceScope(ctx)))
+
+	// a scope started by a request of the lower priority does not raise its follow-ups
+	ctx = WithPriorityInheritanceScope(context.Background())
+	s.Equal(ErrPersistenceLimitExceeded, conflictResolve(ctx))
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	s.Equal(ErrPersistenceLimitExceeded, conflictResolve(ctx))
+
+	// inheritance is turned off at runtime
+	enabled.Store(false)
+	s.Equal(ErrPersistenceLimitExceeded, getThenConflictResolve(WithPriorityInheritanceScope(context.Background())))
+	enabled.Store(true)
+	s.NoError(getThenConflictResolve(WithPriorityInheritanceScope(context.Background())))
+}
+
+func (s *rateLimitedClientSuite) TestAdmissionHook() {
+	errMigrating := serviceerror.NewUnavailable("workflow type is migrating")
+	var vetoed []string
+	rateLimiter := quotastest.NewCountingRateLimiter(1)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithAdmissionHook(func(_ context.Context, operation string, request any) error {
+			create, ok := request.(*CreateWorkflowExecutionRequest)
+			if !ok || create.NewWorkflowSnapshot.ExecutionInfo.GetWorkflowTypeName() != "migrating-type" {
+				return nil
+			}
+			vetoed = append(vetoed, operation)
+			return errMigrating
+		}),
+	)
+	createOf := func(workflowType string) *CreateWorkflowExecutionRequest {
+		return &CreateWorkflowExecutionRequest{
+			ShardID: 1,
+			NewWorkflowSnapshot: WorkflowSnapshot{
+				ExecutionInfo: &persistencespb.WorkflowExecutionInfo{NamespaceId: "ns-1", WorkflowTypeName: workflowType},
+			},
+		}
+	}
+	ctx := context.Background()
+
+	// the vetoed request fails with the error of the hook, without consuming a token
+	_, err := client.CreateWorkflowExecution(ctx, createOf("migrating-type"))
+	s.Equal(errMigrating, err)
+	s.Equal([]string{"CreateWorkflowExecution"}, vetoed)
+	s.Empty(rateLimiter.Calls())
+
+	// requests of other workflow types are rate limited as usual
+	s.mockExecutionStore.EXPECT().CreateWorkflowExecution(gomock.Any(), gomock.Any()).Return(&CreateWorkflowExecutionResponse{}, nil)
+	_, err = client.CreateWorkflowExecution(ctx, createOf("other-type"))
+	s.NoError(err)
+	_, err = client.CreateWorkflowExecution(ctx, createOf("other-type"))
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Equal(1, rateLimiter.Allowed())
+	s.Equal(1, rateLimiter.Denied())
+	s.Equal([]string{"CreateWorkflowExecution"}, vetoed)
+}
+
+func (s *rateLimitedClientSuite) TestAdmissionHook_WithoutRequest() {
+	type hookCall struct {
+		operation string
+		request   any
+	}
+	var calls []hookCall
+	client := NewQueuePersistenceRateLimitedClient(
+		noopQueue{},
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithAdmissionHook(func(_ context.Context, operation string, request any) error {
+			calls = append(calls, hookCall{operation: operation, request: request})
+			return nil
+		}),
+	)
+
+	// operations which take no request are vetted with a nil one
+	s.NoError(client.DeleteMessagesBefore(context.Background(), 1))
+	s.Equal([]hookCall{{operation: "DeleteMessagesBefore"}}, calls)
+}
+
+func (s *rateLimitedClientSuite) TestMiddleware() {
+	var calls []string
+	first := &recordingMiddleware{name: "first", calls: &calls}
+	second := &recordingMiddleware{name: "second", calls: &calls}
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 2)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithMiddleware(first),
+		WithMiddleware(second),
+	)
+	persistenceErr := serviceerror.NewUnavailable("persistence unavailable")
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, _ *GetWorkflowExecutionRequest) (*GetWorkflowExecutionResponse, error) {
+			s.Equal("second", ctx.Value(recordingMiddlewareContextKey{}))
+			calls = append(calls, "persistence")
+			return nil, persistenceErr
+		},
+	)
+
+	_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(persistenceErr, err)
+	s.Equal([]string{
+		"first before GetWorkflowExecution",
+		"second before GetWorkflowExecution",
+		"persistence",
+		"second after GetWorkflowExecution: persistence unavailable",
+		"first after GetWorkflowExecution: persistence unavailable",
+	}, calls)
+
+	// a failing middleware fails the request without calling persistence,
+	// unwinding the middlewares entered before it
+	calls = nil
+	second.err = errors.New("circuit open")
+	_, err = client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(second.err, err)
+	s.Equal([]string{
+		"first before GetWorkflowExecution",
+		"second before GetWorkflowExecution",
+		"first after GetWorkflowExecution: circuit open",
+	}, calls)
+
+	// requests rejected by the rate limiter never reach the middlewares
+	calls = nil
+	_, err = client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Empty(calls)
+}
+
+func (s *rateLimitedClientSuite) TestLimiterName() {
+	rateLimiter := quotas.NewRateLimiter(1000, 1000)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithScanRateLimiter(quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(10, 10))),
+	).(RateLimitedClient)
+	s.Equal("execution[main=1000rps,scan=10rps]", client.LimiterName())
+
+	// the name follows the configuration
+	rateLimiter.SetRateBurst(0.5, 1)
+	s.Equal("execution[main=0.5rps,scan=10rps]", client.LimiterName())
+
+	taskClient := NewTaskPersistenceRateLimitedClient(
+		s.mockTaskStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+	).(RateLimitedClient)
+	s.Equal("task[main=unknown]", taskClient.LimiterName())
+}
+
+func (s *rateLimitedClientSuite) TestLimiterName_ConfigProvider() {
+	provider := NewStaticRateLimitConfigProvider(1000, map[string]float64{"ns-1": 200})
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		nil,
+		log.NewNoopLogger(),
+		WithRateLimitConfigProvider(provider, 0),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+	for _, namespace := range []string{"ns-1", "ns-2"} {
+		_, err := client.GetWorkflowExecution(headers.SetCallerName(context.Background(), namespace), &GetWorkflowExecutionRequest{ShardID: 1})
+		s.NoError(err)
+	}
+	s.Equal("execution[main=1000rps,ns:ns-1=200rps]", client.(RateLimitedClient).LimiterName())
+}
+
+func (s *rateLimitedClientSuite) TestThrottleAwareExecutionIterator() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1, 2)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+	)
+	states := []*persistencespb.WorkflowMutableState{
+		{ExecutionInfo: &persistencespb.WorkflowExecutionInfo{WorkflowId: "workflow-1"}},
+		{ExecutionInfo: &persistencespb.WorkflowExecutionInfo{WorkflowId: "workflow-2"}},
+		{ExecutionInfo: &persistencespb.WorkflowExecutionInfo{WorkflowId: "workflow-3"}},
+	}
+	pageTokens := [][]byte{nil, []byte("page-2"), []byte("page-3"), nil}
+	for i := range states {
+		s.mockExecutionStore.EXPECT().ListConcreteExecutions(gomock.Any(), &ListConcreteExecutionsRequest{
+			ShardID:   1,
+			PageSize:  1,
+			PageToken: pageTokens[i],
+		}).Return(&ListConcreteExecutionsResponse{
+			States:    states[i : i+1],
+			PageToken: pageTokens[i+1],
+		}, nil)
+	}
+
+	// the rate limiter rejects the third page
+	iter := NewThrottleAwareExecutionIterator(client, 1, 1, nil)
+	pages, err := iter.Read(context.Background(), 0)
+	s.NoError(err)
+	s.Equal(ExecutionPages{States: states[:2], PageToken: []byte("page-3"), Throttled: true}, pages)
+	s.True(iter.HasNext())
+
+	// the iteration is resumed from the checkpointed page token once the rate limiter refilled
+	timeSource.Update(now.Add(time.Minute))
+	iter = NewThrottleAwareExecutionIterator(client, 1, 1, pages.PageToken)
+	pages, err = iter.Read(context.Background(), 0)
+	s.NoError(err)
+	s.Equal(ExecutionPages{States: states[2:]}, pages)
+	s.False(iter.HasNext())
+}
+
+func (s *rateLimitedClientSuite) TestThrottleAwareExecutionIterator_MaxPages() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 1)),
+		log.NewNoopLogger(),
+	)
+	state := &persistencespb.WorkflowMutableState{ExecutionInfo: &persistencespb.WorkflowExecutionInfo{WorkflowId: "workflow-1"}}
+	s.mockExecutionStore.EXPECT().ListConcreteExecutions(gomock.Any(), gomock.Any()).Return(&ListConcreteExecutionsResponse{
+		States:    []*persistencespb.WorkflowMutableState{state},
+		PageToken: []byte("page-2"),
+	}, nil)
+
+	iter := NewThrottleAwareExecutionIterator(client, 1, 1, nil)
+	pages, err := iter.Read(context.Background(), 1)
+	s.NoError(err)
+	s.Equal(ExecutionPages{States: []*persistencespb.WorkflowMutableState{state}, PageToken: []byte("page-2")}, pages)
+
+	// a rejected first page leaves the page token where it was
+	pages, err = iter.Read(context.Background(), 1)
+	s.NoError(err)
+	s.Equal(ExecutionPages{PageToken: []byte("page-2"), Throttled: true}, pages)
+}
+
+func (s *rateLimitedClientSuite) TestOperationClassRejections() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 1)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+	)
+	s.Equal(1, drainTokens(rateLimiter))
+
+	_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, err = client.GetHistoryTasks(context.Background(), &GetHistoryTasksRequest{ShardID: 1, TaskCategory: tasks.CategoryTimer})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Equal(ErrPersistenceLimitExceeded, client.DeleteWorkflowExecution(context.Background(), &DeleteWorkflowExecutionRequest{ShardID: 1}))
+
+	rejections := metrics.PersistenceRateLimitRejections.GetMetricName()
+	classRejections := metrics.PersistenceRateLimitClassRejections.GetMetricName()
+	s.Equal(int64(1), s.metricsHandler.counter(rejections, metrics.OperationTag("GetWorkflowExecution")))
+	s.Equal(int64(2), s.metricsHandler.counter(classRejections, metrics.StringTag(operationClassTagName, operationClassReads)))
+	s.Equal(int64(1), s.metricsHandler.counter(classRejections, metrics.StringTag(operationClassTagName, operationClassDeletes)))
+	s.Equal(int64(0), s.metricsHandler.counter(classRejections, metrics.StringTag(operationClassTagName, operationClassWrites)))
+}
+
+func (s *rateLimitedClientSuite) TestOperationClass() {
+	s.Equal(operationClassReads, operationClass(ConstructHistoryTaskAPI("GetHistoryTasks", tasks.CategoryTransfer)))
+	s.Equal(operationClassDeletes, operationClass(ConstructHistoryTaskAPI("RangeCompleteHistoryTasks", tasks.CategoryTimer)))
+	s.Equal(operationClassUnknown, operationClass("NotAnOperation"))
+	// the scan rate limiter throttles the scans class
+	s.Equal([]string{"GetAllHistoryTreeBranches", "ListConcreteExecutions"}, scanOperations)
+}
+
+func (s *rateLimitedClientSuite) TestRefillPhaseShift() {
+	period := time.Second
+	refill := time.Unix(100, 0).Add(quotas.PhaseOffset("host-1", period))
+	timeSource := clock.NewEventTimeSource().Update(refill)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1, 1)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithRefillPhaseShift("host-1", period),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).Times(2)
+	request := &GetWorkflowExecutionRequest{ShardID: 1}
+
+	_, err := client.GetWorkflowExecution(context.Background(), request)
+	s.NoError(err)
+
+	// the token is only refilled at the next phase shifted refill of the host
+	timeSource.Update(refill.Add(period - time.Millisecond))
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	timeSource.Update(refill.Add(period))
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.NoError(err)
+}
+
+func (s *rateLimitedClientSuite) TestRecentDecisions() {
+	now := time.Unix(100, 0).UTC()
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 1)),
+		log.NewNoopLogger(),
+		WithTimeSource(clock.NewEventTimeSource().Update(now)),
+		WithDecisionLog(10),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil)
+	request := &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: "ns-1"}
+
+	_, err := client.GetWorkflowExecution(context.Background(), request)
+	s.NoError(err)
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	s.Equal([]AdmissionDecision{
+		{Time: now, Operation: "GetWorkflowExecution", NamespaceID: "ns-1", Allowed: true, Tokens: 1},
+		{Time: now, Operation: "GetWorkflowExecution", NamespaceID: "ns-1", Allowed: false, Tokens: 1},
+	}, client.(AdmissionDecisionReporter).RecentDecisions())
+}
+
+func (s *rateLimitedClientSuite) TestQueueRateLimiters() {
+	newRateLimiter := func() quotas.RequestRateLimiter {
+		return quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 1))
+	}
+	enqueue := func(client Queue) error {
+		_, err := client.EnqueueMessageToDLQ(context.Background(), commonpb.DataBlob{})
+		return err
+	}
+	read := func(client Queue) error {
+		_, _, err := client.ReadMessagesFromDLQ(context.Background(), 0, 1, 1, nil)
+		return err
+	}
+
+	// by default the reads and writes share the main rate limiter
+	sharedClient := NewQueuePersistenceRateLimitedClient(noopQueue{}, newRateLimiter(), log.NewNoopLogger())
+	s.NoError(enqueue(sharedClient))
+	s.Equal(ErrPersistenceLimitExceeded, read(sharedClient))
+
+	// separate rate limiters throttle the reads and writes independently
+	client := NewQueuePersistenceRateLimitedClient(
+		noopQueue{},
+		newRateLimiter(),
+		log.NewNoopLogger(),
+		WithQueueRateLimiters(newRateLimiter(), newRateLimiter()),
+	)
+	s.NoError(enqueue(client))
+	s.Equal(ErrPersistenceLimitExceeded, enqueue(client))
+	s.NoError(read(client))
+	s.Equal(ErrPersistenceLimitExceeded, read(client))
+	s.Equal(ErrPersistenceLimitExceeded, client.UpdateDLQAckLevel(context.Background(), &InternalQueueMetadata{}))
+	s.Equal(ErrPersistenceLimitExceeded, client.UpdateAckLevel(context.Background(), &InternalQueueMetadata{}))
+}
+
+func (s *rateLimitedClientSuite) TestMetadataRateLimiters() {
+	newRateLimiter := func() quotas.RequestRateLimiter {
+		return quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 1))
+	}
+	s.mockMetadataStore.EXPECT().GetNamespace(gomock.Any(), gomock.Any()).Return(&GetNamespaceResponse{}, nil).AnyTimes()
+	s.mockMetadataStore.EXPECT().RenameNamespace(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	s.mockMetadataStore.EXPECT().DeleteNamespace(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	rename := func(client MetadataManager) error {
+		return client.RenameNamespace(context.Background(), &RenameNamespaceRequest{PreviousName: "ns-1", NewName: "ns-2"})
+	}
+	get := func(client MetadataManager) error {
+		_, err := client.GetNamespace(context.Background(), &GetNamespaceRequest{Name: "ns-1"})
+		return err
+	}
+
+	// by default the reads and admin mutations share the main rate limiter
+	sharedClient := NewMetadataPersistenceRateLimitedClient(s.mockMetadataStore, newRateLimiter(), log.NewNoopLogger())
+	s.NoError(rename(sharedClient))
+	s.Equal(ErrPersistenceLimitExceeded, get(sharedClient))
+
+	// separate rate limiters throttle the admin mutations independently of the reads
+	client := NewMetadataPersistenceRateLimitedClient(
+		s.mockMetadataStore,
+		newRateLimiter(),
+		log.NewNoopLogger(),
+		WithMetadataRateLimiters(newRateLimiter(), newRateLimiter()),
+	)
+	s.NoError(rename(client))
+	s.Equal(ErrPersistenceLimitExceeded, rename(client))
+	s.Equal(ErrPersistenceLimitExceeded, client.DeleteNamespace(context.Background(), &DeleteNamespaceRequest{ID: "ns-1-id"}))
+	s.NoError(get(client))
+	s.Equal(ErrPersistenceLimitExceeded, get(client))
+	s.Equal(metadataAdminRateLimiterName, client.(*metadataRateLimitedPersistenceClient).rateLimiterNameFor("DeleteNamespaceByName"))
+	s.Equal(mainRateLimiterName, client.(*metadataRateLimitedPersistenceClient).rateLimiterNameFor("InitializeSystemNamespaces"))
+}
+
+func (s *rateLimitedClientSuite) TestUpdateRateLimit_UnderLoad() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	client := NewQueuePersistenceRateLimitedClient(
+		noopQueue{},
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(100, 100)),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+	)
+	allowed := func() int {
+		count := 0
+		for client.EnqueueMessage(context.Background(), commonpb.DataBlob{}) == nil {
+			count++
+		}
+		return count
+	}
+	s.Equal(100, allowed())
+
+	// lowering the rate keeps the tokens accumulated so far, up to the new burst,
+	// so requests neither stall nor spike beyond what the new rate allows
+	now = now.Add(500 * time.Millisecond)
+	timeSource.Update(now)
+	s.NoError(client.(RateLimitedClient).UpdateRateLimit(10, 10))
+	s.Equal(10, allowed())
+	now = now.Add(time.Second)
+	timeSource.Update(now)
+	s.Equal(10, allowed())
+
+	// raising the rate does not refill the rate limiter to the new burst
+	s.NoError(client.(RateLimitedClient).UpdateRateLimit(1000, 1000))
+	s.Equal(0, allowed())
+	timeSource.Update(now.Add(10 * time.Millisecond))
+	s.Equal(10, allowed())
+}
+
+func (s *rateLimitedClientSuite) TestUpdateRateLimit_NotAdjustable() {
+	client := NewQueuePersistenceRateLimitedClient(
+		noopQueue{},
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+	)
+	var invalidArgument *serviceerror.InvalidArgument
+	s.ErrorAs(client.(RateLimitedClient).UpdateRateLimit(10, 10), &invalidArgument)
+}
+
+func (s *rateLimitedClientSuite) TestMultiRateLimiter() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	// at most 10 requests per second, and 20 requests per minute
+	perSecond := quotas.NewRateLimiter(10, 10)
+	perMinute := quotas.NewRateLimiter(20.0/60, 20)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewMultiRateLimiter([]quotas.RateLimiter{perSecond, perMinute})),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithCostBasedLimiting(),
+	)
+	s.mockExecutionStore.EXPECT().GetReplicationTasksFromDLQ(gomock.Any(), gomock.Any()).
+		Return(&GetHistoryTasksResponse{}, nil).Times(3)
+	// a page of 500 tasks costs 6 tokens
+	readPage := func() error {
+		_, err := client.GetReplicationTasksFromDLQ(context.Background(), &GetReplicationTasksFromDLQRequest{
+			GetHistoryTasksRequest: GetHistoryTasksRequest{ShardID: 1, BatchSize: 500},
+		})
+		return err
+	}
+
+	s.NoError(readPage())
+	// rejected by the per second limit, without consuming from the per minute one
+	s.Equal(ErrPersistenceLimitExceeded, readPage())
+	s.InDelta(14, perMinute.TokensAt(now), 0.001)
+
+	snapshot := client.(RateLimitedClient).Snapshot()[0]
+	s.True(snapshot.StateKnown)
+	s.InDelta(20.0/60, snapshot.Rate, 0.001)
+	s.Equal(10, snapshot.Burst)
+	s.InDelta(4, snapshot.TokensAvailable, 0.001)
+
+	timeSource.Update(now.Add(time.Second))
+	s.NoError(readPage())
+	timeSource.Update(now.Add(2 * time.Second))
+	s.NoError(readPage())
+	// rejected by the per minute limit, though the per second one refilled
+	timeSource.Update(now.Add(3 * time.Second))
+	s.Equal(ErrPersistenceLimitExceeded, readPage())
+	s.InDelta(10, perSecond.TokensAt(now.Add(3*time.Second)), 0.001)
+}
+
+func (s *rateLimitedClientSuite) TestOperationOutcomes() {
+	rateLimiter := quotas.NewRateLimiter(testRateLimitedClientRate, 2)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+	)
+	request := &GetWorkflowExecutionRequest{ShardID: 1}
+	downstreamErr := serviceerror.NewUnavailable("persistence unavailable")
+	gomock.InOrder(
+		s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil),
+		s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(nil, downstreamErr),
+	)
+
+	_, err := client.GetWorkflowExecution(context.Background(), request)
+	s.NoError(err)
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(downstreamErr, err)
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	s.Equal(
+		map[string]OperationOutcomes{"GetWorkflowExecution": {Succeeded: 1, Rejected: 1, DownstreamFailed: 1}},
+		client.(RateLimitedClient).RateLimitStats().OutcomesByOperation,
+	)
+	operationTag := metrics.OperationTag("GetWorkflowExecution")
+	s.Equal(int64(1), s.metricsHandler.counter(metrics.PersistenceOperationSucceeded.GetMetricName(), operationTag))
+	s.Equal(int64(1), s.metricsHandler.counter(metrics.PersistenceOperationRejected.GetMetricName(), operationTag))
+	s.Equal(int64(1), s.metricsHandler.counter(metrics.PersistenceOperationDownstreamFailed.GetMetricName(), operationTag))
+
+	client.(RateLimitedClient).ResetRateLimitStats()
+	s.Empty(client.(RateLimitedClient).RateLimitStats().OutcomesByOperation)
+}
+
+func (s *rateLimitedClientSuite) TestOperationOutcomes_NotSerialized() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)),
+		log.NewNoopLogger(),
+		WithMetricsHandler(s.metricsHandler),
+	)
+	enforcer := client.(*executionRateLimitedPersistenceClient).rateLimitEnforcer
+	request := &GetWorkflowExecutionRequest{ShardID: 1}
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), request).Return(&GetWorkflowExecutionResponse{}, nil)
+
+	// admitted requests complete while the stats of the client are locked
+	enforcer.statsLock.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := client.GetWorkflowExecution(context.Background(), request)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		s.NoError(err)
+	case <-time.After(time.Second):
+		s.Fail("request blocked on the stats lock")
+	}
+	enforcer.statsLock.Unlock()
+
+	s.Equal(
+		map[string]OperationOutcomes{"GetWorkflowExecution": {Succeeded: 1}},
+		client.(RateLimitedClient).RateLimitStats().OutcomesByOperation,
+	)
+}
+
+func (s *rateLimitedClientSuite) TestIsNamespaceThrottled() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		nil,
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithRateLimitConfigProvider(NewStaticRateLimitConfigProvider(100, map[string]float64{"ns-1": 1}), time.Minute),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).Times(3)
+	getWorkflowExecution := func(namespace string) error {
+		ctx := headers.SetCallerName(context.Background(), namespace)
+		_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1, NamespaceID: namespace})
+		return err
+	}
+	throttled := client.(NamespaceRateLimitedClient).IsNamespaceThrottled
+
+	s.NoError(getWorkflowExecution("ns-1"))
+	s.NoError(getWorkflowExecution("ns-2"))
+	s.False(throttled("ns-1"))
+
+	// the namespace exceeding its limit is throttled, the others are not
+	s.Equal(ErrPersistenceLimitExceeded, getWorkflowExecution("ns-1"))
+	s.True(throttled("ns-1"))
+	s.False(throttled("ns-2"))
+
+	// the namespace is no longer reported once its rejections are not recent anymore
+	timeSource.Update(now.Add(namespaceThrottledWindow))
+	s.False(throttled("ns-1"))
+	s.NoError(getWorkflowExecution("ns-1"))
+}
+
+func (s *rateLimitedClientSuite) TestNamespaceRejectionStats() {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	timeSource := clock.NewEventTimeSource().Update(now)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotastest.NewCountingRateLimiter(0),
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithNamespaceRejectionStats(time.Minute, 2),
+	)
+	getWorkflowExecution := func(namespaceID string, count int) {
+		for i := 0; i < count; i++ {
+			_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{NamespaceID: namespaceID})
+			s.Equal(ErrPersistenceLimitExceeded, err)
+		}
+	}
+	stats := client.(NamespaceRejectionReporter).NamespaceRejectionStats
+
+	getWorkflowExecution("ns-1", 4)
+	getWorkflowExecution("ns-2", 2)
+	s.Equal(RejectionStats{Rejections: 4, LastRejectionTime: now}, stats("ns-1"))
+	s.Equal(RejectionStats{Rejections: 2, LastRejectionTime: now}, stats("ns-2"))
+	s.Equal(RejectionStats{}, stats("ns-3"))
+
+	// recent rejections outweigh older ones
+	timeSource.Update(now.Add(time.Minute))
+	getWorkflowExecution("ns-2", 2)
+	s.Equal(RejectionStats{Rejections: 2, LastRejectionTime: now}, stats("ns-1"))
+	s.Equal(RejectionStats{Rejections: 3, LastRejectionTime: now.Add(time.Minute)}, stats("ns-2"))
+
+	// the namespace of the fewest recent rejections makes room for a new one
+	timeSource.Update(now.Add(2 * time.Minute))
+	getWorkflowExecution("ns-3", 1)
+	s.Equal(RejectionStats{}, stats("ns-1"))
+	s.Equal(1.5, stats("ns-2").Rejections)
+	s.Equal(float64(1), stats("ns-3").Rejections)
+
+	// and namespaces are forgotten once their rejections decayed away
+	timeSource.Update(now.Add(time.Hour))
+	s.Equal(RejectionStats{}, stats("ns-2"))
+	s.Equal(RejectionStats{}, stats("ns-3"))
+}
+
+func (s *rateLimitedClientSuite) TestNamespaceRejectionStats_Disabled() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotastest.NewCountingRateLimiter(0),
+		log.NewNoopLogger(),
+	)
+	_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{NamespaceID: "ns-1"})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Equal(RejectionStats{}, client.(NamespaceRejectionReporter).NamespaceRejectionStats("ns-1"))
+}
+
+func (s *rateLimitedClientSuite) TestSetNamespaceLimits() {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+		WithTimeSource(clock.NewEventTimeSource().Update(now)),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	admitted := func(namespaceID string) int {
+		count := 0
+		for i := 0; i < 10; i++ {
+			if _, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{NamespaceID: namespaceID}); err == nil {
+				count++
+			}
+		}
+		return count
+	}
+	limiter := client.(NamespaceLimiter)
+
+	limiter.SetNamespaceLimits(map[string]float64{"ns-1": 1, "ns-2": 2, "ns-3": 0})
+	s.Equal(map[string]float64{"ns-1": 1, "ns-2": 2}, limiter.NamespaceLimits())
+	s.Equal(1, admitted("ns-1"))
+	s.Equal(2, admitted("ns-2"))
+	s.Equal(10, admitted("ns-3"))
+
+	// the namespaces left out are no longer limited, the ones of an unchanged
+	// rate keep their tokens, and new or changed ones start at their full burst
+	limiter.SetNamespaceLimits(map[string]float64{"ns-2": 2, "ns-3": 3})
+	s.Equal(map[string]float64{"ns-2": 2, "ns-3": 3}, limiter.NamespaceLimits())
+	s.Equal(10, admitted("ns-1"))
+	s.Equal(0, admitted("ns-2"))
+	s.Equal(3, admitted("ns-3"))
+
+	limiter.SetNamespaceLimits(nil)
+	s.Empty(limiter.NamespaceLimits())
+	s.Equal(10, admitted("ns-2"))
+}
+
+func (s *rateLimitedClientSuite) TestSetNamespaceLimits_RejectedByOtherLimiters() {
+	rateLimiter := quotastest.NewCountingRateLimiter(0)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		rateLimiter,
+		log.NewNoopLogger(),
+		WithTimeSource(clock.NewEventTimeSource().Update(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))),
+		WithMetricsHandler(s.metricsHandler),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil)
+	client.(NamespaceLimiter).SetNamespaceLimits(map[string]float64{"ns-1": 1})
+	request := &GetWorkflowExecutionRequest{NamespaceID: "ns-1"}
+
+	// the token taken from the namespace is given back when the main rate limiter denies the request
+	_, err := client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	rateLimiter.SetAllows(quotastest.Unlimited)
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.NoError(err)
+
+	// and the main rate limiter is not charged for requests denied by the namespace
+	_, err = client.GetWorkflowExecution(context.Background(), request)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Len(rateLimiter.Calls(), 2)
+	s.Equal(int64(2), s.metricsHandler.counter(
+		metrics.PersistenceOperationRejected.GetMetricName(),
+		metrics.OperationTag("GetWorkflowExecution"),
+	))
+}
+
+func (s *rateLimitedClientSuite) TestSetNamespaceLimits_ConcurrentLoad() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NoopRequestRateLimiter,
+		log.NewNoopLogger(),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	limiter := client.(NamespaceLimiter)
+	enforcer := client.(*executionRateLimitedPersistenceClient).rateLimitEnforcer
+	configs := []map[string]float64{
+		{"ns-1": 1e6, "ns-2": 1e6},
+		{"ns-2": 1e6, "ns-3": 1e6},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, namespaceID := range []string{"ns-1", "ns-2", "ns-3"} {
+		wg.Add(1)
+		go func(namespaceID string) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{NamespaceID: namespaceID})
+				s.NoError(err)
+			}
+		}(namespaceID)
+	}
+	for i := 0; i < 100; i++ {
+		config := configs[i%len(configs)]
+		previous := enforcer.namespaceLimits.Load()
+		previousRates := limiter.NamespaceLimits()
+		limiter.SetNamespaceLimits(config)
+		s.Equal(config, limiter.NamespaceLimits())
+		// the limits requests in flight may still be seeing are left as they were
+		if previous != nil {
+			s.Equal(previousRates, previous.rates)
+			s.Len(previous.rateLimiters, len(previousRates))
+		}
+	}
+	cancel()
+	wg.Wait()
+	s.Equal(configs[1], limiter.NamespaceLimits())
+}
+
+func (s *rateLimitedClientSuite) TestLeakyBucketShaping() {
+	client := NewQueuePersistenceRateLimitedClient(
+		noopQueue{},
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, testRateLimitedClientBurst)),
+		log.NewNoopLogger(),
+		// the time source is frozen, so that the queue does not drain between the requests
+		WithTimeSource(clock.NewEventTimeSource().Update(time.Now())),
+		WithLeakyBucketShaping(func() float64 { return 1000 }, 2),
+	)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		s.NoError(client.EnqueueMessage(context.Background(), commonpb.DataBlob{}))
+	}
+	// the burst is released at the steady rate rather than all at once
+	s.GreaterOrEqual(time.Since(start), 2*time.Millisecond)
+	// and requests beyond the bound of the queue fail fast
+	s.Equal(ErrPersistenceLimitExceeded, client.EnqueueMessage(context.Background(), commonpb.DataBlob{}))
+}
+
+func (s *rateLimitedClientSuite) TestRateSchedule() {
+	timeSource := clock.NewEventTimeSource().Update(time.Date(2023, 6, 1, 8, 59, 0, 0, time.UTC))
+	client := NewQueuePersistenceRateLimitedClient(
+		noopQueue{},
+		nil,
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithRateSchedule(
+			quotas.RateProfile{Rate: 100, Burst: 3},
+			time.UTC,
+			quotas.ScheduleWindow{Start: 9 * time.Hour, End: 17 * time.Hour, Profile: quotas.RateProfile{Rate: 1, Burst: 1}},
+		),
+	).(RateLimitedClient)
+	enqueue := func() error {
+		return client.(Queue).EnqueueMessage(context.Background(), commonpb.DataBlob{})
+	}
+	mainRateLimiter := func() LimiterSnapshot {
+		return client.Snapshot()[0]
+	}
+
+	// the default profile applies before the window
+	s.Equal(float64(100), mainRateLimiter().Rate)
+	for i := 0; i < 3; i++ {
+		s.NoError(enqueue())
+	}
+	s.Equal(ErrPersistenceLimitExceeded, enqueue())
+
+	// the tokens refilled at the default rate by the start of the window carry over,
+	// capped to the burst of the window, after which the rate of the window applies
+	timeSource.Update(time.Date(2023, 6, 1, 9, 0, 0, 0, time.UTC))
+	s.Equal(float64(1), mainRateLimiter().Rate)
+	s.NoError(enqueue())
+	s.Equal(ErrPersistenceLimitExceeded, enqueue())
+	timeSource.Update(time.Date(2023, 6, 1, 9, 0, 0, int(500*time.Millisecond), time.UTC))
+	s.Equal(ErrPersistenceLimitExceeded, enqueue())
+	timeSource.Update(time.Date(2023, 6, 1, 9, 0, 1, 0, time.UTC))
+	s.NoError(enqueue())
+	s.Equal("queue[main=1rps]", client.LimiterName())
+
+	// and the default profile applies again once the window ends, starting
+	// from the tokens accumulated within the burst of the window
+	timeSource.Update(time.Date(2023, 6, 1, 17, 0, 0, 0, time.UTC))
+	s.Equal(float64(100), mainRateLimiter().Rate)
+	s.NoError(enqueue())
+	s.Equal(ErrPersistenceLimitExceeded, enqueue())
+	timeSource.Update(time.Date(2023, 6, 1, 17, 0, 0, int(30*time.Millisecond), time.UTC))
+	for i := 0; i < 3; i++ {
+		s.NoError(enqueue())
+	}
+	s.Equal(ErrPersistenceLimitExceeded, enqueue())
+}
+
+func (s *rateLimitedClientSuite) TestRequestTimeoutBudget() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(20, 1)),
+		log.NewNoopLogger(),
+		WithDeadlineAwareWait(),
+		WithRequestTimeoutBudget(time.Second),
+	)
+	var remaining []time.Duration
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, _ *GetWorkflowExecutionRequest) (*GetWorkflowExecutionResponse, error) {
+			deadline, ok := ctx.Deadline()
+			s.True(ok)
+			remaining = append(remaining, time.Until(deadline))
+			return &GetWorkflowExecutionResponse{}, nil
+		},
+	).Times(2)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+		s.NoError(err)
+	}
+	s.Len(remaining, 2)
+	s.Greater(remaining[0], 900*time.Millisecond)
+	// the second request waited 50ms for a token, which is subtracted from its budget
+	s.LessOrEqual(remaining[1], 950*time.Millisecond)
+	s.Greater(remaining[1], 500*time.Millisecond)
+}
+
+func (s *rateLimitedClientSuite) TestRequestTimeoutBudget_ExceededWhileWaiting() {
+	// the time source lags behind, so that the wait is not rejected up front
+	now := time.Now().Add(-time.Hour)
+	rateLimiter := quotas.NewRateLimiter(20, 1)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(rateLimiter),
+		log.NewNoopLogger(),
+		WithTimeSource(clock.NewEventTimeSource().Update(now)),
+		WithDeadlineAwareWait(),
+		WithRequestTimeoutBudget(10*time.Millisecond),
+	)
+	s.True(rateLimiter.AllowN(now, 1))
+
+	// persistence is not called once the budget ran out
+	_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func (s *rateLimitedClientSuite) TestNamespaceWeightProvider() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	weights := &staticNamespaceWeights{weights: map[string]float64{"ns-1": 3, "ns-2": 1}}
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		nil,
+		log.NewNoopLogger(),
+		WithTimeSource(timeSource),
+		WithRateLimitConfigProvider(NewStaticRateLimitConfigProvider(8, nil), time.Minute),
+		WithNamespaceWeightProvider(weights),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).AnyTimes()
+	admitted := func(namespace string) int {
+		ctx := headers.SetCallerName(context.Background(), namespace)
+		count := 0
+		for i := 0; i < 100; i++ {
+			if _, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1}); err == nil {
+				count++
+			}
+		}
+		return count
+	}
+
+	// the global limit is shared 3:1
+	s.Equal(6, admitted("ns-1"))
+	s.Equal(2, admitted("ns-2"))
+
+	// the new weights are picked up with the limits, and share the global limit evenly
+	weights.set(map[string]float64{"ns-1": 1, "ns-2": 1})
+	timeSource.Update(now.Add(time.Minute))
+	admitted("ns-1")
+	admitted("ns-2")
+	timeSource.Update(now.Add(time.Minute + time.Second))
+	s.Equal(4, admitted("ns-1"))
+	s.Equal(4, admitted("ns-2"))
+}
+
+func (s *rateLimitedClientSuite) TestRejectionDetails_OperationRateLimiters() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 1)),
+		log.NewNoopLogger(),
+		WithHistoryForkLimits(quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(2*testRateLimitedClientRate, 1)), 0),
+		WithRejectionDetails(),
+	)
+	ctx := context.Background()
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil)
+	s.mockExecutionStore.EXPECT().ForkHistoryBranch(gomock.Any(), gomock.Any()).Return(&ForkHistoryBranchResponse{}, nil)
+
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+	_, err = client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	details, ok := RejectionDetailsFromError(err)
+	s.True(ok)
+	s.Equal(RateLimitRejectionDetails{Tier: mainRateLimiterName, Limit: testRateLimitedClientRate}, details)
+
+	// the error is classified as before, and keeps its details over gRPC
+	var resourceExhausted *serviceerror.ResourceExhausted
+	s.ErrorAs(err, &resourceExhausted)
+	s.Equal(enumspb.RESOURCE_EXHAUSTED_CAUSE_PERSISTENCE_LIMIT, resourceExhausted.Cause)
+	s.Equal("Persistence Max QPS Reached. Denied by the main limiter of 0.001rps.", err.Error())
+	details, ok = RejectionDetailsFromError(serviceerror.FromStatus(serviceerror.ToStatus(err)))
+	s.True(ok)
+	s.Equal(mainRateLimiterName, details.Tier)
+
+	_, err = client.ForkHistoryBranch(ctx, &ForkHistoryBranchRequest{ShardID: 1})
+	s.NoError(err)
+	_, err = client.ForkHistoryBranch(ctx, &ForkHistoryBranchRequest{ShardID: 1})
+	details, ok = RejectionDetailsFromError(err)
+	s.True(ok)
+	s.Equal(RateLimitRejectionDetails{Tier: historyForkRateLimiterName, Limit: 2 * testRateLimitedClientRate}, details)
+}
+
+func (s *rateLimitedClientSuite) TestRejectionDetails_Concurrency() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(1000, 1000)),
+		log.NewNoopLogger(),
+		WithHistoryForkLimits(nil, 1),
+		WithRejectionDetails(),
+	)
+	ctx := context.Background()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s.mockExecutionStore.EXPECT().ForkHistoryBranch(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, *ForkHistoryBranchRequest) (*ForkHistoryBranchResponse, error) {
+			close(started)
+			<-release
+			return &ForkHistoryBranchResponse{}, nil
+		})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := client.ForkHistoryBranch(ctx, &ForkHistoryBranchRequest{ShardID: 1})
+		s.NoError(err)
+	}()
+	<-started
+
+	_, err := client.ForkHistoryBranch(ctx, &ForkHistoryBranchRequest{ShardID: 1})
+	details, ok := RejectionDetailsFromError(err)
+	s.True(ok)
+	s.Equal(RateLimitRejectionDetails{Tier: RateLimitTierConcurrency, Limit: 1}, details)
+	s.Equal("Persistence Max QPS Reached. Denied by the concurrency limiter of 1 requests in flight.", err.Error())
+
+	close(release)
+	<-done
+}
+
+func (s *rateLimitedClientSuite) TestRejectionDetails_ConfigProvider() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		nil,
+		log.NewNoopLogger(),
+		WithTimeSource(clock.NewEventTimeSource().Update(time.Now())),
+		WithRateLimitConfigProvider(NewStaticRateLimitConfigProvider(5, map[string]float64{"ns-1": 2}), time.Minute),
+		WithRejectionDetails(),
+	)
+	s.mockExecutionStore.EXPECT().GetWorkflowExecution(gomock.Any(), gomock.Any()).Return(&GetWorkflowExecutionResponse{}, nil).Times(5)
+	rejection := func(namespace string, admitted int) RateLimitRejectionDetails {
+		ctx := headers.SetCallerName(context.Background(), namespace)
+		for i := 0; i < admitted; i++ {
+			_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+			s.NoError(err)
+		}
+		_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+		details, ok := RejectionDetailsFromError(err)
+		s.True(ok)
+		return details
+	}
+
+	s.Equal(RateLimitRejectionDetails{Tier: RateLimitTierNamespace, Limit: 2}, rejection("ns-1", 2))
+	s.Equal(RateLimitRejectionDetails{Tier: RateLimitTierGlobal, Limit: 5}, rejection("ns-2", 3))
+}
+
+func (s *rateLimitedClientSuite) TestRejectionDetails_Disabled() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0)),
+		log.NewNoopLogger(),
+	)
+
+	_, err := client.GetWorkflowExecution(context.Background(), &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	_, ok := RejectionDetailsFromError(err)
+	s.False(ok)
+}
+
+func (s *rateLimitedClientSuite) TestGetName_Cached() {
+	downstream := &blockingNameExecutionManager{name: "execution", block: make(chan struct{})}
+	defer close(downstream.block)
+	client := NewExecutionPersistenceRateLimitedClient(downstream, nil, log.NewNoopLogger())
+	downstream.blocking.Store(true)
+
+	name := make(chan string)
+	go func() { name <- client.GetName() }()
+	select {
+	case n := <-name:
+		s.Equal("execution", n)
+	case <-time.After(time.Second):
+		s.Fail("GetName blocked on the downstream store")
+	}
+	s.Equal(int32(1), downstream.calls.Load())
+}
+
+func (s *rateLimitedClientSuite) TestGetName_LookedUpIfUnset() {
+	downstream := &blockingNameExecutionManager{}
+	client := NewExecutionPersistenceRateLimitedClient(downstream, quotas.NoopRequestRateLimiter, log.NewNoopLogger())
+
+	downstream.name = "execution"
+	calls := downstream.calls.Load()
+	s.Equal("execution", client.GetName())
+	s.Equal(calls+1, downstream.calls.Load())
+
+	// swapping the store refreshes the cached name
+	client.(PersistenceSwapper).SwapPersistence(&blockingNameExecutionManager{name: "swapped"})
+	s.Equal("swapped", client.GetName())
+}
+
+func (s *rateLimitedClientSuite) TestForcedAdmission() {
+	logger := log.NewMockLogger(s.controller)
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0)),
+		logger,
+		WithMetricsHandler(s.metricsHandler),
+		WithForcedAdmissionAllowed(),
+	)
+	ctx := headers.SetCallerName(context.Background(), "admin-cli")
+	s.mockExecutionStore.EXPECT().DeleteWorkflowExecution(gomock.Any(), gomock.Any()).Return(nil)
+
+	_, err := client.GetWorkflowExecution(ctx, &GetWorkflowExecutionRequest{ShardID: 1})
+	s.Equal(ErrPersistenceLimitExceeded, err)
+
+	var auditTags []tag.Tag
+	logger.EXPECT().Warn(gomock.Any(), gomock.Any()).Do(func(_ string, tags ...tag.Tag) {
+		auditTags = tags
+	})
+	err = client.DeleteWorkflowExecution(WithForcedAdmission(ctx, "emergency cleanup"), &DeleteWorkflowExecutionRequest{ShardID: 1})
+	s.NoError(err)
+
+	s.Equal(int64(1), s.metricsHandler.counter(
+		metrics.PersistenceRateLimitForcedAdmissions.GetMetricName(),
+		metrics.OperationTag("DeleteWorkflowExecution"),
+		metrics.StringTag(callerTagName, "admin-cli"),
+	))
+	s.Contains(auditTags, tag.Operation("DeleteWorkflowExecution"))
+	s.Contains(auditTags, tag.NewStringTag(callerTagName, "admin-cli"))
+	s.Contains(auditTags, tag.NewStringTag("reason", "emergency cleanup"))
+}
+
+func (s *rateLimitedClientSuite) TestForcedAdmission_NotAllowed() {
+	client := NewExecutionPersistenceRateLimitedClient(
+		s.mockExecutionStore,
+		quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0)),
+		log.NewMockLogger(s.controller),
+		WithMetricsHandler(s.metricsHandler),
+	)
+
+	err := client.DeleteWorkflowExecution(
+		WithForcedAdmission(context.Background(), "emergency cleanup"),
+		&DeleteWorkflowExecutionRequest{ShardID: 1},
+	)
+	s.Equal(ErrPersistenceLimitExceeded, err)
+	s.Zero(s.metricsHandler.counter(metrics.PersistenceRateLimitForcedAdmissions.GetMetricName()))
+}
+
+// drainTokens consumes and returns all tokens currently available in the rate limiter
+func drainTokens(rateLimiter quotas.RateLimiter) int {
+	now := time.Now()
+	tokens := 0
+	for rateLimiter.AllowN(now, 1) {
+		tokens++
+	}
+	return tokens
+}
+
+func newCapturingMetricsHandler() *capturingMetricsHandler {
+	return &capturingMetricsHandler{
+		counters:  make(map[string]int64),
+		gauges:    make(map[string]float64),
+		timers:    make(map[string][]time.Duration),
+		exemplars: make(map[string][]map[string]string),
+	}
+}
+
+func (h *capturingMetricsHandler) WithTags(tags ...metrics.Tag) metrics.Handler {
+	return &capturingMetricsHandler{
+		tags:      append(append([]metrics.Tag{}, h.tags...), tags...),
+		counters:  h.counters,
+		gauges:    h.gauges,
+		timers:    h.timers,
+		exemplars: h.exemplars,
+	}
+}
+
+func (h *capturingMetricsHandler) Counter(name string) metrics.CounterIface {
+	return &capturingCounter{handler: h, name: name}
+}
+
+func (c *capturingCounter) Record(value int64, tags ...metrics.Tag) {
+	c.handler.Lock()
+	defer c.handler.Unlock()
+	c.handler.counters[metricKey(c.name, append(append([]metrics.Tag{}, c.handler.tags...), tags...))] += value
+}
+
+func (c *capturingCounter) RecordWithExemplar(value int64, exemplar map[string]string, tags ...metrics.Tag) {
+	c.Record(value, tags...)
+	c.handler.Lock()
+	defer c.handler.Unlock()
+	c.handler.exemplars[c.name] = append(c.handler.exemplars[c.name], exemplar)
+}
+
+func (h *capturingMetricsHandler) Gauge(name string) metrics.GaugeIface {
+	return metrics.GaugeFunc(func(value float64, tags ...metrics.Tag) {
+		h.Lock()
+		defer h.Unlock()
+		h.gauges[metricKey(name, append(append([]metrics.Tag{}, h.tags...), tags...))] = value
+	})
+}
+
+func (h *capturingMetricsHandler) Timer(name string) metrics.TimerIface {
+	return metrics.TimerFunc(func(value time.Duration, tags ...metrics.Tag) {
+		h.Lock()
+		defer h.Unlock()
+		key := metricKey(name, append(append([]metrics.Tag{}, h.tags...), tags...))
+		h.timers[key] = append(h.timers[key], value)
+	})
+}
+
+func (h *capturingMetricsHandler) Histogram(string, metrics.MetricUnit) metrics.HistogramIface {
+	return metrics.NoopHistogramMetricFunc
+}
+
+func (h *capturingMetricsHandler) Stop(log.Logger) {}
+
+// counter returns the sum of the counter over all recorded tag sets including the given tags
+func (h *capturingMetricsHandler) counter(name string, tags ...metrics.Tag) int64 {
+	h.Lock()
+	defer h.Unlock()
+
+	var sum int64
+	for key, value := range h.counters {
+		if metricKeyIncludes(key, name, tags) {
+			sum += value
+		}
+	}
+	return sum
+}
+
+// gauge returns the last value of the gauge recorded with a tag set including the given tags
+func (h *capturingMetricsHandler) gauge(name string, tags ...metrics.Tag) (float64, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	for key, value := range h.gauges {
+		if metricKeyIncludes(key, name, tags) {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// timer returns the values of the timer recorded over all tag sets including the given tags
+func (h *capturingMetricsHandler) timer(name string, tags ...metrics.Tag) []time.Duration {
+	h.Lock()
+	defer h.Unlock()
+
+	var values []time.Duration
+	for key, recorded := range h.timers {
+		if metricKeyIncludes(key, name, tags) {
+			values = append(values, recorded...)
+		}
+	}
+	return values
+}
+
+// exemplarsOf returns the exemplars attached to the counter
+func (h *capturingMetricsHandler) exemplarsOf(name string) []map[string]string {
+	h.Lock()
+	defer h.Unlock()
+	return h.exemplars[name]
+}
+
+func metricKey(name string, tags []metrics.Tag) string {
+	pairs := make([]string, 0, len(tags))
+	for _, t := range tags {
+		pairs = append(pairs, t.Key()+"="+t.Value())
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func metricKeyIncludes(key string, name string, tags []metrics.Tag) bool {
+	prefix := name + "{"
+	if !strings.HasPrefix(key, prefix) {
+		return false
+	}
+	pairs := make(map[string]struct{})
+	for _, pair := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(key, prefix), "}"), ",") {
+		pairs[pair] = struct{}{}
+	}
+	for _, t := range tags {
+		if _, ok := pairs[t.Key()+"="+t.Value()]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// newTestTasks returns the given number of tasks
+func newTestTasks(count int) []tasks.Task {
+	result := make([]tasks.Task, count)
+	for i := range result {
+		result[i] = &tasks.ActivityTask{}
+	}
+	return result
+}
+
+// attributesByKey indexes the span attributes by their key
+func attributesByKey(attributes []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	result := make(map[attribute.Key]attribute.Value, len(attributes))
+	for _, kv := range attributes {
+		result[kv.Key] = kv.Value
+	}
+	return result
+}
+
+// expectAnyCalls allows any number of calls to every method of the gomock mock,
+// each returning zero values
+func expectAnyCalls(mock interface{}) {
+	recorder := reflect.ValueOf(mock).MethodByName("EXPECT").Call(nil)[0]
+	for i := 0; i < recorder.NumMethod(); i++ {
+		method := recorder.Method(i)
+		args := make([]reflect.Value, method.Type().NumIn())
+		for j := range args {
+			args[j] = reflect.ValueOf(gomock.Any())
+		}
+		method.Call(args)[0].Interface().(*gomock.Call).AnyTimes()
+	}
+}
+
+// fallibleRateLimiter is a quotas.FallibleRequestRateLimiter failing with err, if set
+type fallibleRateLimiter struct {
+	quotas.RequestRateLimiter
+	err error
+}
+
+func (r *fallibleRateLimiter) TryAllow(now time.Time, request quotas.Request) (bool, error) {
+	if r.err != nil {
+		return false, r.err
+	}
+	return r.Allow(now, request), nil
+}
+
+type recordingMiddlewareContextKey struct{}
+
+// recordingMiddleware records its calls, failing Before with err if set
+type recordingMiddleware struct {
+	name  string
+	calls *[]string
+	err   error
+}
+
+func (m *recordingMiddleware) Before(ctx context.Context, operation string) (context.Context, error) {
+	*m.calls = append(*m.calls, fmt.Sprintf("%s before %s", m.name, operation))
+	if m.err != nil {
+		return nil, m.err
+	}
+	return context.WithValue(ctx, recordingMiddlewareContextKey{}, m.name), nil
+}
+
+func (m *recordingMiddleware) After(_ context.Context, operation string, err error) {
+	*m.calls = append(*m.calls, fmt.Sprintf("%s after %s: %v", m.name, operation, err))
+}
+
+// staticNamespaceWeights is a NamespaceWeightProvider of weights set by the test
+type staticNamespaceWeights struct {
+	sync.Mutex
+	weights map[string]float64
+}
+
+func (w *staticNamespaceWeights) NamespaceWeights() map[string]float64 {
+	w.Lock()
+	defer w.Unlock()
+	return w.weights
+}
+
+func (w *staticNamespaceWeights) set(weights map[string]float64) {
+	w.Lock()
+	defer w.Unlock()
+	w.weights = weights
+}
+
+// blockingNameExecutionManager is an ExecutionManager counting GetName calls, which
+// block once blocking is set until block is closed
+type blockingNameExecutionManager struct {
+	ExecutionManager
+	name     string
+	calls    atomic.Int32
+	blocking atomic.Bool
+	block    chan struct{}
+}
+
+func (m *blockingNameExecutionManager) GetName() string {
+	m.calls.Add(1)
+	if m.blocking.Load() {
+		<-m.block
+	}
+	return m.name
+}
+
+// notificationQueue is a Queue which only records the messages enqueued
+type notificationQueue struct {
+	noopQueue
+	messages chan commonpb.DataBlob
+}
+
+func (q *notificationQueue) EnqueueMessage(_ context.Context, blob commonpb.DataBlob) error {
+	q.messages <- blob
+	return nil
+}
+
+// fakeDLQBacklogSource is a DLQBacklogSource of the backlog set by the test
+type fakeDLQBacklogSource struct {
+	sync.Mutex
+	backlog int64
+	err     error
+}
+
+func (f *fakeDLQBacklogSource) DLQBacklog(context.Context) (int64, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.backlog, f.err
+}
+
+func (f *fakeDLQBacklogSource) set(backlog int64, err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.backlog = backlog
+	f.err = err
+}
+
+// noopQueue is a Queue which does nothing
+type noopQueue struct{}
+
+var _ Queue = noopQueue{}
+
+func (noopQueue) Close() {}
+func (noopQueue) Init(context.Context, *commonpb.DataBlob) error {
+	return nil
+}
+func (noopQueue) EnqueueMessage(context.Context, commonpb.DataBlob) error {
+	return nil
+}
+func (noopQueue) ReadMessages(context.Context, int64, int) ([]*QueueMessage, error) {
+	return nil, nil
+}
+func (noopQueue) DeleteMessagesBefore(context.Context, int64) error {
+	return nil
+}
+func (noopQueue) UpdateAckLevel(context.Context, *InternalQueueMetadata) error {
+	return nil
+}
+func (noopQueue) GetAckLevels(context.Context) (*InternalQueueMetadata, error) {
+	return nil, nil
+}
+func (noopQueue) EnqueueMessageToDLQ(context.Context, commonpb.DataBlob) (int64, error) {
+	return 0, nil
+}
+func (noopQueue) ReadMessagesFromDLQ(context.Context, int64, int64, int, []byte) ([]*QueueMessage, []byte, error) {
+	return nil, nil, nil
+}
+func (noopQueue) DeleteMessageFromDLQ(context.Context, int64) error {
+	return nil
+}
+func (noopQueue) RangeDeleteMessagesFromDLQ(context.Context, int64, int64) error {
+	return nil
+}
+func (noopQueue) UpdateDLQAckLevel(context.Context, *InternalQueueMetadata) error {
+	return nil
+}
+func (noopQueue) GetDLQAckLevels(context.Context) (*InternalQueueMetadata, error) {
+	return nil, nil
+}
+
+// BenchmarkRateLimitEnforcer_Reject measures rejections, which pile up when persistence is
+// overloaded, see TestRejectionCache_RejectWithoutAllocations
+func BenchmarkRateLimitEnforcer_Reject(b *testing.B) {
+	rateLimiter := quotas.NewRequestRateLimiterAdapter(quotas.NewRateLimiter(testRateLimitedClientRate, 0))
+	enforcer := newRateLimitEnforcer(rateLimiter, func() string { return "bench" }, log.NewNoopLogger(), nil)
+	ctx := context.Background()
+	reject := func() {
+		if _, _, err := enforcer.admit(ctx, "GetWorkflowExecution", nil, 1, "ns-1"); err != ErrPersistenceLimitExceeded {
+			b.Fatalf("expected a rejection, got %v", err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reject()
+	}
+}
+
+func newCreateWorkflowExecutionRequest(workflowID string, runID string) *CreateWorkflowExecutionRequest {
+	return &CreateWorkflowExecutionRequest{
+		ShardID: 1,
+		NewWorkflowSnapshot: WorkflowSnapshot{
+			ExecutionInfo:  &persistencespb.WorkflowExecutionInfo{NamespaceId: "ns-1", WorkflowId: workflowID},
+			ExecutionState: &persistencespb.WorkflowExecutionState{RunId: runID},
+		},
+	}
+}
@@ -0,0 +1,1332 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package persistence
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+
+	"go.temporal.io/server/common/clock"
+	"go.temporal.io/server/common/dynamicconfig"
+	"go.temporal.io/server/common/metrics"
+	"go.temporal.io/server/common/quotas"
+	"go.temporal.io/server/service/history/tasks"
+)
+
+type (
+	// RateLimitedClientOption is used to enable optional behavior of the
+	// rate limited persistence clients
+	RateLimitedClientOption func(options *rateLimitedClientOptions)
+
+	rateLimitedClientOptions struct {
+		// metricsHandler is used to emit rate limiting metrics
+		metricsHandler metrics.Handler
+		// storeIdentity labels the metrics and logs of the client instead of the store name
+		storeIdentity string
+		// timeSource is used for every time read by the client
+		timeSource clock.TimeSource
+		// responseBytesPerToken is the number of response bytes charged as one
+		// additional token after a read returns. Zero disables response size charging.
+		responseBytesPerToken int
+		// operationRateLimiters overrides the main rate limiter for specific operations
+		operationRateLimiters map[string]quotas.RequestRateLimiter
+		// operationRateLimiterNames identifies the rate limiter of each operation in snapshots
+		operationRateLimiterNames map[string]string
+		// rateFn returns the configured rate, which is checked against minSafeRate
+		rateFn      quotas.RateFn
+		minSafeRate float64
+		// lowRateWarningQuietPeriod is the minimum time between two warnings of a rate below minSafeRate
+		lowRateWarningQuietPeriod time.Duration
+		// waitForToken blocks requests until a token is available instead of failing fast
+		waitForToken bool
+		// maxWait caps how long requests wait for a token, if positive
+		maxWait time.Duration
+		// degradedPageOperations are the paginated reads whose rejected pages are degraded to empty ones
+		degradedPageOperations map[string]struct{}
+		// starvationTracking measures how long each operation class starved for tokens
+		starvationTracking bool
+		// operationTimeouts bound the persistence calls of the operations, after their admission
+		operationTimeouts map[string]time.Duration
+		// requestTimeoutBudget bounds each request, the wait for a token included, if positive
+		requestTimeoutBudget time.Duration
+		// maxWaiters caps the number of requests waiting for a token at the same time, if positive
+		maxWaiters int
+		// lowConcurrency is the number of requests in flight or waiting below which requests
+		// denied by the rate limiters are admitted anyway, if positive
+		lowConcurrency int
+		// spanAttributes records the rate limiting decision on the tracing span of the request
+		spanAttributes bool
+		// headroomSignal populates the RateLimitHeadroom of allowed requests
+		headroomSignal bool
+		// tokensRemaining reports the tokens remaining in the rate limiter, if known
+		tokensRemaining func(now time.Time) float64
+		// backpressureWindow is the rolling window over which the rejection rate is tracked
+		backpressureWindow time.Duration
+		// backpressureDebounce is the minimum time between two BackpressureEvents
+		backpressureDebounce time.Duration
+		// backpressureThresholds are the rejection rates at which BackpressureEvents are published
+		backpressureThresholds []float64
+		// throttlingNotificationQueue receives the ThrottlingNotifications, if set
+		throttlingNotificationQueue Queue
+		// throttlingNotificationWindow is the window over which the rejection rate is notified
+		throttlingNotificationWindow time.Duration
+		// throttlingNotificationThreshold is the rejection rate of a window which is notified
+		throttlingNotificationThreshold float64
+		// costBasedLimiting charges heavy operations by their estimated cost instead of a single token
+		costBasedLimiting bool
+		// historyNodeBytesPerToken is the number of serialized history event bytes charged as one
+		// additional token for AppendHistoryNodes by cost based limiting
+		historyNodeBytesPerToken int
+		// rawHistoryBytesPerToken is the number of raw history blob bytes charged as one
+		// additional token for ReadRawHistoryBranch by cost based limiting
+		rawHistoryBytesPerToken int
+		// isAvailabilityImpacting classifies rejections of an operation as counting against the availability SLO
+		isAvailabilityImpacting func(operation string) bool
+		// maxRequestSizes are the sizes in bytes beyond which requests of an operation fail fast
+		maxRequestSizes map[string]int
+		// errorFactory returns the error rejected requests of an operation fail with
+		errorFactory func(operation string) error
+		// rejectionDetails attaches the denying limiter tier to the rejection errors
+		rejectionDetails bool
+		// historyForkConcurrency limits the concurrent history fork operations, if positive
+		historyForkConcurrency int
+		// replicationBypass lets requests applying replicated events skip the rate limiters
+		replicationBypass bool
+		// forcedAdmissionAllowed lets requests made WithForcedAdmission through when denied
+		forcedAdmissionAllowed bool
+		// priorityBoostBudget caps the requests admitted with a boosted priority, boosting is off if nil
+		priorityBoostBudget quotas.RateLimiter
+		// priorityInheritanceFn computes the priority inherited within a scope, inheritance is off if nil
+		priorityInheritanceFn quotas.RequestPriorityFn
+		// priorityInheritanceEnabled turns inheritance on and off per request
+		priorityInheritanceEnabled dynamicconfig.BoolPropertyFn
+		// replicationRateLimiter throttles the requests bypassing the rate limiters for replication, if set
+		replicationRateLimiter quotas.RequestRateLimiter
+		// spilloverRateLimiter throttles the reads served by the spillover, if set
+		spilloverRateLimiter quotas.RequestRateLimiter
+		// leakyBucketRateFn is the release rate of the leaky bucket replacing the main rate limiter, if set
+		leakyBucketRateFn quotas.RateFn
+		// leakyBucketMaxQueued is the number of tokens requests queue up to in the leaky bucket
+		leakyBucketMaxQueued int
+		// rateSchedule is the default rate profile of the schedule replacing the main rate limiter, if set
+		rateSchedule *quotas.RateProfile
+		// rateScheduleLocation is the location of the times of day of the rateScheduleWindows
+		rateScheduleLocation *time.Location
+		// rateScheduleWindows are the windows of the schedule with a rate profile of their own
+		rateScheduleWindows []quotas.ScheduleWindow
+		// configProvider supplies the limits of the main rate limiter, if set
+		configProvider RateLimitConfigProvider
+		// configRefreshInterval is how often the limits are polled from the configProvider
+		configRefreshInterval time.Duration
+		// namespaceWeightProvider shares the global limit of the configProvider among namespaces, if set
+		namespaceWeightProvider NamespaceWeightProvider
+		// dlqBacklogSource is polled every dlqBacklogPollInterval for the DLQ backlog, if set
+		dlqBacklogSource       DLQBacklogSource
+		dlqBacklogPollInterval time.Duration
+		// dlqBacklogThresholds scale the tokens of write requests by the DLQ backlog
+		dlqBacklogThresholds []DLQBacklogThreshold
+		// namespaceBucketIdleTTL is how long the namespace rate limiters of the configProvider are
+		// kept without requests, zero to keep them forever
+		namespaceBucketIdleTTL time.Duration
+		// bootstrapWindow is how long after creation the bootstrap safety valve can open, and stays open
+		bootstrapWindow time.Duration
+		// bootstrapMaxRejections is the number of consecutive rejections opening the bootstrap safety valve
+		bootstrapMaxRejections int
+		// rejectionSampling is n if only every nth rejection of each tag set is emitted
+		rejectionSampling int
+		// utilizationMetrics emits the utilization of the rate limiters every minute
+		utilizationMetrics bool
+		// notFoundCacheTTL is how long GetWorkflowExecution NotFound results are cached, if positive
+		notFoundCacheTTL time.Duration
+		// notFoundCacheSize is the maximum number of cached NotFound results
+		notFoundCacheSize int
+		// createSerialization serializes the concurrent creates of the same workflow
+		createSerialization bool
+		// historyTasksDedupTTL is how long succeeded AddHistoryTasks requests are remembered, if positive
+		historyTasksDedupTTL time.Duration
+		// historyTasksDedupSize is the maximum number of remembered AddHistoryTasks requests
+		historyTasksDedupSize int
+		// waitLatencyWindow is the rolling window of the wait latency histogram, if positive
+		waitLatencyWindow time.Duration
+		// namespaceQPSInterval is the interval over which per namespace QPS is aggregated, if positive
+		namespaceQPSInterval time.Duration
+		// namespaceIOMaxNamespaces is the maximum number of namespaces whose I/O bytes are accounted, if positive
+		namespaceIOMaxNamespaces int
+		// hotShardHalfLife is the half life of the decaying per shard request counts, if positive
+		hotShardHalfLife time.Duration
+		// namespaceRejectionHalfLife is the half life of the decaying per namespace rejection counts, if positive
+		namespaceRejectionHalfLife time.Duration
+		// namespaceRejectionMaxNamespaces is the maximum number of namespaces whose rejections are counted
+		namespaceRejectionMaxNamespaces int
+		// taskQueueTypePriorities are the priorities of task queue operations by task queue type
+		taskQueueTypePriorities map[enumspb.TaskQueueType]int
+		// priorityRateLimiters are the rate limiters of task queue operations by priority
+		priorityRateLimiters map[int]quotas.RequestRateLimiter
+		// failOpen admits requests when a quotas.FallibleRequestRateLimiter fails to decide
+		failOpen bool
+		// tier identifies the rate limiting tier of the client when chaining clients
+		tier string
+		// middlewares hook into the persistence calls, in order
+		middlewares []PersistenceMiddleware
+		// admissionHook vetoes requests before they are rate limited, if set
+		admissionHook AdmissionHook
+		// refundableErrors identify persistence errors for which the consumed token is given back
+		refundableErrors []func(error) bool
+		// decisionLogSize is the number of admission decisions kept for RecentDecisions, if positive
+		decisionLogSize int
+		// refillPhasePeriod is the period at which the main rate limiter is refilled, if positive
+		refillPhasePeriod time.Duration
+		// refillPhaseSeed derives the phase offset of the refills within the refillPhasePeriod
+		refillPhaseSeed string
+		// downstreamLatencyByAdmission emits the latency of admitted requests partitioned by whether they waited
+		downstreamLatencyByAdmission bool
+	}
+)
+
+var (
+	// scanOperations are the operations iterating over the entire store, which are
+	// throttled by the scan rate limiter when one is configured
+	scanOperations = operationsOfClass(operationClassScans)
+
+	// burstyWriteOperations are the creation operations, whose traffic naturally
+	// arrives in spikes
+	burstyWriteOperations = []string{
+		"CreateWorkflowExecution",
+		"CreateTasks",
+	}
+
+	// steadyReadOperations are the point and history reads, whose traffic is
+	// naturally steady
+	steadyReadOperations = []string{
+		"GetWorkflowExecution",
+		"GetCurrentExecution",
+		"ReadHistoryBranch",
+		"ReadHistoryBranchReverse",
+		"ReadHistoryBranchByBatch",
+		"ReadRawHistoryBranch",
+		"GetHistoryTree",
+		"GetTasks",
+		"GetTaskQueue",
+		"GetNamespace",
+	}
+
+	// historyForkOperations are the history branch operations invoked by workflow resets
+	historyForkOperations = []string{
+		"ForkHistoryBranch",
+		"TrimHistoryBranch",
+	}
+
+	// cleanupOperations are the bulk deletions of already processed tasks and messages
+	cleanupOperations = []string{
+		"CompleteTasksLessThan",
+		"DeleteMessagesBefore",
+		"RangeDeleteMessagesFromDLQ",
+	}
+
+	// retentionDeleteOperations are the deletions of workflow executions issued in
+	// bursts by namespace retention sweeps
+	retentionDeleteOperations = []string{
+		"DeleteWorkflowExecution",
+		"DeleteCurrentWorkflowExecution",
+	}
+
+	// taskQueueOperations are the task operations scoped to a single task queue
+	taskQueueOperations = []string{
+		"CreateTasks",
+		"GetTasks",
+	}
+
+	// queueReadOperations are the reads of the queue and its DLQ
+	queueReadOperations = []string{
+		"ReadMessages",
+		"GetAckLevels",
+		"ReadMessagesFromDLQ",
+		"GetDLQAckLevels",
+	}
+
+	// queueWriteOperations are the enqueues, ack level updates and deletions of the queue and its DLQ
+	queueWriteOperations = []string{
+		"EnqueueMessage",
+		"UpdateAckLevel",
+		"DeleteMessagesBefore",
+		"EnqueueMessageToDLQ",
+		"RangeDeleteMessagesFromDLQ",
+		"UpdateDLQAckLevel",
+		"DeleteMessageFromDLQ",
+	}
+
+	// metadataReadOperations are the routine reads of namespaces and cluster metadata
+	metadataReadOperations = []string{
+		"GetNamespace",
+		"ListNamespaces",
+		"GetMetadata",
+	}
+
+	// metadataAdminOperations are the namespace mutations, which are rare but heavy
+	metadataAdminOperations = []string{
+		"CreateNamespace",
+		"UpdateNamespace",
+		"RenameNamespace",
+		"DeleteNamespace",
+		"DeleteNamespaceByName",
+	}
+)
+
+// The names identifying the rate limiters of a client in snapshots
+const (
+	mainRateLimiterName            = "main"
+	scanRateLimiterName            = "scan"
+	burstyWriteRateLimiterName     = "bursty-write"
+	steadyReadRateLimiterName      = "steady-read"
+	cleanupRateLimiterName         = "cleanup"
+	retentionDeleteRateLimiterName = "retention-delete"
+	historyForkRateLimiterName     = "history-fork"
+	queueReadRateLimiterName       = "queue-read"
+	queueWriteRateLimiterName      = "queue-write"
+	metadataReadRateLimiterName    = "metadata-read"
+	metadataAdminRateLimiterName   = "metadata-admin"
+	replicationRateLimiterName     = "replication"
+	taskQueueRateLimiterName       = "task-queue"
+	// taskQueueTypeRateLimiterName is suffixed with the priority of the rate limiter
+	taskQueueTypeRateLimiterName = "task-queue-type"
+)
+
+const (
+	// maxResponseSizeTokens caps the number of tokens charged for a single response,
+	// so that one huge read cannot drain the limiter for an extended period of time.
+	maxResponseSizeTokens = 100
+
+	// The default weights of cost based limiting for ConflictResolveWorkflowExecution, which
+	// rewrites the reset workflow and possibly a new and the current workflow in one go:
+	// one token per workflow row written, plus one token per conflictResolveEventsPerToken
+	// history events and per conflictResolveTasksPerToken tasks in the request.
+	conflictResolveEventsPerToken = 100
+	conflictResolveTasksPerToken  = 10
+	// The default weights of cost based limiting for UpdateWorkflowExecution, which writes the
+	// mutable state of the workflow and possibly the snapshot of a new one together with their
+	// history events and tasks: one token per workflow row written, plus one token per
+	// updateWorkflowEventsPerToken history events, per updateWorkflowTasksPerToken tasks of any
+	// category and per updateWorkflowBufferedEventsPerToken buffered events. Buffered events
+	// weigh more than history events as they are rewritten with the mutable state until flushed.
+	updateWorkflowEventsPerToken         = 100
+	updateWorkflowTasksPerToken          = 10
+	updateWorkflowBufferedEventsPerToken = 20
+	// The default weight of cost based limiting for GetReplicationTasksFromDLQ: one token per
+	// request, plus one token per replicationDLQTasksPerToken tasks of the requested page size,
+	// so that DLQ drains cannot evade the limiter with huge pages.
+	replicationDLQTasksPerToken = 100
+	// The default weights of cost based limiting for GetHistoryTasks: one token per request, plus
+	// one token per historyTasksPerToken tasks the range can hold, which is bounded by the batch
+	// size and, for immediate categories, by the number of task IDs in the range. The tasks of
+	// scheduled categories are keyed by fire time, so their range is additionally charged one
+	// token per historyTaskFireTimeRangePerToken of fire time it spans, as queue processors
+	// scanning far ahead read through timers which are not due anyway.
+	historyTasksPerToken             = 100
+	historyTaskFireTimeRangePerToken = time.Hour
+	// The default weight of cost based limiting for AppendHistoryNodes: one token per request, plus
+	// one token per historyNodeBytesPerToken bytes of serialized history events appended, as single
+	// events (e.g. with large payloads) can be hundreds of KB.
+	defaultHistoryNodeBytesPerToken = 64 * 1024
+	// The default weight of cost based limiting for ReadRawHistoryBranch: one token per request, plus
+	// one token per rawHistoryBytesPerToken bytes of history blobs read, charged once the page is read.
+	// Blobs are counted as stored, i.e. possibly compressed, as that is what the store transfers.
+	defaultRawHistoryBytesPerToken = 64 * 1024
+	// maxOperationTokens caps the number of tokens charged for a single request by cost
+	// based limiting. Requests are further capped to the burst of their rate limiter.
+	maxOperationTokens = 100
+
+	// namespaceThrottledWindow is how long after its last rejected request a namespace is
+	// reported as throttled by IsNamespaceThrottled
+	namespaceThrottledWindow = 10 * time.Second
+
+	// defaultLowRateWarningQuietPeriod is the default minimum time between two warnings of a
+	// rate below the safe minimum, so that repeated reconfigurations do not spam warnings
+	defaultLowRateWarningQuietPeriod = 5 * time.Minute
+)
+
+// WithResponseSizeCharging charges reads whose size is only known after the fact
+// (e.g. ReadHistoryBranchByBatch) one additional token for every bytesPerToken
+// bytes returned by persistence. The additional tokens are reserved after the call
+// returns, so they never fail the call itself but reduce the budget left for
+// subsequent requests.
+func WithResponseSizeCharging(bytesPerToken int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.responseBytesPerToken = bytesPerToken
+	}
+}
+
+// WithMetricsHandler emits rate limiting metrics, such as rejections, to the given handler
+func WithMetricsHandler(metricsHandler metrics.Handler) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.metricsHandler = metricsHandler
+	}
+}
+
+// WithStoreIdentity labels the metrics and logs of the client with the given identity
+// instead of the name of the store, to distinguish otherwise identical stores hosted
+// together, e.g. multiple keyspaces of the same database.
+func WithStoreIdentity(storeIdentity string) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.storeIdentity = storeIdentity
+	}
+}
+
+// WithTimeSource reads the current time, for rate limiting, pauses and stats,
+// from the given time source instead of the system clock
+func WithTimeSource(timeSource clock.TimeSource) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.timeSource = timeSource
+	}
+}
+
+// WithScanRateLimiter throttles full store scans (ListConcreteExecutions and
+// GetAllHistoryTreeBranches) by the given rate limiter instead of the main one,
+// so that scanners cannot starve online traffic.
+func WithScanRateLimiter(rateLimiter quotas.RequestRateLimiter) RateLimitedClientOption {
+	return withOperationRateLimiter(scanRateLimiterName, rateLimiter, scanOperations...)
+}
+
+// WithBurstyWriteRateLimiter throttles creation operations (CreateWorkflowExecution and
+// CreateTasks) by the given rate limiter instead of the main one.
+//
+// Creation traffic arrives in spikes, so this limiter is typically configured with a
+// large burst relative to its rate. The tradeoff is that a large burst lets a spike
+// through to persistence all at once, so the burst should stay within what the
+// store can absorb.
+func WithBurstyWriteRateLimiter(rateLimiter quotas.RequestRateLimiter) RateLimitedClientOption {
+	return withOperationRateLimiter(burstyWriteRateLimiterName, rateLimiter, burstyWriteOperations...)
+}
+
+// WithSteadyReadRateLimiter throttles point and history reads by the given rate
+// limiter instead of the main one.
+//
+// Read traffic is steady, so this limiter is typically configured with a burst
+// close to its rate. The tradeoff is that a small burst rejects even short lived
+// spikes of reads which the store could have served.
+func WithSteadyReadRateLimiter(rateLimiter quotas.RequestRateLimiter) RateLimitedClientOption {
+	return withOperationRateLimiter(steadyReadRateLimiterName, rateLimiter, steadyReadOperations...)
+}
+
+// WithCleanupRateLimiter throttles bulk cleanups (CompleteTasksLessThan, DeleteMessagesBefore
+// and RangeDeleteMessagesFromDLQ) by the given rate limiter instead of the main one, so that
+// cleanup storms cannot impact live traffic.
+func WithCleanupRateLimiter(rateLimiter quotas.RequestRateLimiter) RateLimitedClientOption {
+	return withOperationRateLimiter(cleanupRateLimiterName, rateLimiter, cleanupOperations...)
+}
+
+// WithRetentionDeleteRateLimiter throttles DeleteWorkflowExecution and DeleteCurrentWorkflowExecution,
+// which namespace retention sweeps issue in bursts, by the given rate limiter instead of the main
+// one, so that retention deletes can be throttled independently of live traffic.
+func WithRetentionDeleteRateLimiter(rateLimiter quotas.RequestRateLimiter) RateLimitedClientOption {
+	return withOperationRateLimiter(retentionDeleteRateLimiterName, rateLimiter, retentionDeleteOperations...)
+}
+
+// WithTaskQueueRateLimiter throttles CreateTasks and GetTasks by the given rate limiter instead
+// of the main one. Their requests carry the task queue they are scoped to, so that a rate limiter
+// keyed by task queue, see quotas.NewTaskQueueRequestRateLimiter, gives every task queue its own
+// budget and a single busy task queue cannot starve the others of the matching host. The priority
+// rate limiters of WithTaskQueueTypePriority take precedence over it.
+func WithTaskQueueRateLimiter(rateLimiter quotas.RequestRateLimiter) RateLimitedClientOption {
+	return withOperationRateLimiter(taskQueueRateLimiterName, rateLimiter, taskQueueOperations...)
+}
+
+// WithQueueRateLimiters throttles the reads of the queue client (e.g. ReadMessagesFromDLQ) and its
+// writes (e.g. EnqueueMessageToDLQ) by separate rate limiters instead of the main one, as they have
+// very different costs and urgency. A nil rate limiter keeps the main one for its side.
+func WithQueueRateLimiters(readRateLimiter quotas.RequestRateLimiter, writeRateLimiter quotas.RequestRateLimiter) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		if readRateLimiter != nil {
+			withOperationRateLimiter(queueReadRateLimiterName, readRateLimiter, queueReadOperations...)(options)
+		}
+		if writeRateLimiter != nil {
+			withOperationRateLimiter(queueWriteRateLimiterName, writeRateLimiter, queueWriteOperations...)(options)
+		}
+	}
+}
+
+// WithMetadataRateLimiters throttles the routine reads of the metadata client (e.g. GetNamespace)
+// and its namespace mutations (e.g. RenameNamespace and DeleteNamespace) by separate rate limiters
+// instead of the main one, so that the rare but heavy mutations can be held to a strict admin limit
+// without affecting reads. A nil rate limiter keeps the main one for its side. InitializeSystemNamespaces
+// is left on the main rate limiter, as the server cannot start without it.
+func WithMetadataRateLimiters(readRateLimiter quotas.RequestRateLimiter, adminRateLimiter quotas.RequestRateLimiter) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		if readRateLimiter != nil {
+			withOperationRateLimiter(metadataReadRateLimiterName, readRateLimiter, metadataReadOperations...)(options)
+		}
+		if adminRateLimiter != nil {
+			withOperationRateLimiter(metadataAdminRateLimiterName, adminRateLimiter, metadataAdminOperations...)(options)
+		}
+	}
+}
+
+// WithHistoryForkLimits throttles ForkHistoryBranch and TrimHistoryBranch, which are invoked by
+// workflow resets, by the given rate limiter instead of the main one, and allows at most
+// maxConcurrency of them in flight at any time, so that reset storms cannot overwhelm the store.
+// A nil rate limiter keeps the main one, a non positive maxConcurrency disables the concurrency limit.
+func WithHistoryForkLimits(rateLimiter quotas.RequestRateLimiter, maxConcurrency int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		if rateLimiter != nil {
+			withOperationRateLimiter(historyForkRateLimiterName, rateLimiter, historyForkOperations...)(options)
+		}
+		options.historyForkConcurrency = maxConcurrency
+	}
+}
+
+// WithLowRateWarning logs a warning when the client is created with a rate, as returned by
+// rateFn, below minSafeRate, or when its rate is updated below it through UpdateRateLimit.
+// The warning is logged at most once per quiet period, see WithLowRateWarningQuietPeriod.
+func WithLowRateWarning(rateFn quotas.RateFn, minSafeRate float64) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.rateFn = rateFn
+		options.minSafeRate = minSafeRate
+	}
+}
+
+// WithLowRateWarningQuietPeriod overrides the minimum time between two warnings of a rate below
+// the safe minimum, which defaults to 5 minutes, however often the rate is reconfigured meanwhile.
+func WithLowRateWarningQuietPeriod(quietPeriod time.Duration) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.lowRateWarningQuietPeriod = quietPeriod
+	}
+}
+
+// WithDeadlineAwareWait makes requests wait for a token instead of failing fast
+// when the rate limit is exceeded. Requests which would only get a token after
+// the deadline of their context are still rejected right away.
+func WithDeadlineAwareWait() RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.waitForToken = true
+	}
+}
+
+// WithRequestTimeoutBudget bounds each request to the given budget, the time spent waiting for a
+// token included: the context of the request gets a deadline of at most budget after the request
+// is made, before waiting for a token, so the time spent waiting is subtracted from the deadline
+// of the persistence call. Requests whose deadline passes while waiting for a token fail with the
+// error of their context without calling persistence, whether or not a budget is set.
+func WithRequestTimeoutBudget(budget time.Duration) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.requestTimeoutBudget = budget
+	}
+}
+
+// WithStarvationTracking measures how long the rate limiters of the client stay out of tokens for
+// the requests of each operation class, which quantifies sustained overload better than counting
+// rejections. A class starves from the first of its requests to be rejected until the next of its
+// requests is admitted, as told by the time source of the client. The duration of every starvation
+// is emitted once it ends, tagged by operation class, and the cumulative durations are reported
+// through StarvationDurations.
+func WithStarvationTracking() RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.starvationTracking = true
+	}
+}
+
+// WithOperationTimeout bounds the persistence calls of the operation to the given timeout, as a hard
+// backstop against slow calls holding on to resources such as concurrency slots, independently of
+// the deadline of the caller. The persistence call gets a context of the timeout derived from the
+// context of the request once it is admitted, so the time spent waiting for a token does not count.
+// A call failing once the timeout cut it off fails with an error wrapping context.DeadlineExceeded.
+func WithOperationTimeout(operation string, timeout time.Duration) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		if options.operationTimeouts == nil {
+			options.operationTimeouts = make(map[string]time.Duration)
+		}
+		options.operationTimeouts[operation] = timeout
+	}
+}
+
+// WithMaxWait makes requests wait for a token instead of failing fast when the rate limit is
+// exceeded, but only up to maxWait: requests which would only get a token later are rejected
+// right away, as are requests which would only get it after the deadline of their context.
+func WithMaxWait(maxWait time.Duration) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.waitForToken = true
+		options.maxWait = maxWait
+	}
+}
+
+// WithConcurrencyAwareAdmission admits requests by both the rate limiters and the concurrency of the
+// client, i.e. the number of its requests in flight to persistence or waiting for a token: requests
+// are only rejected when the rate limiters deny them while lowConcurrency or more requests are in
+// flight or waiting. Below that, persistence evidently keeps up, so requests are admitted even at
+// the edge of the rate, without consuming tokens. Requests allowed by the rate limiters are always
+// admitted. Concurrency limits, e.g. of WithHistoryForkLimits, and the limits of SetNamespaceLimits
+// are still enforced.
+func WithConcurrencyAwareAdmission(lowConcurrency int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.lowConcurrency = lowConcurrency
+	}
+}
+
+// WithMaxWaiters caps the number of requests blocked waiting for a token at the same time,
+// see WithDeadlineAwareWait and WithMaxWait, so that a stalling persistence cannot park an
+// unbounded number of goroutines on the rate limiter. Requests which would have to wait
+// while the cap is reached are rejected right away.
+func WithMaxWaiters(maxWaiters int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.maxWaiters = maxWaiters
+	}
+}
+
+// WithReplicationBypass lets AppendHistoryNodes and ConflictResolveWorkflowExecution requests
+// made under a context marked WithReplicationApply, i.e. applying replicated events, skip the rate
+// limiters of the client, as throttling them can stall replication and grow its lag unboundedly.
+// If rateLimiter is not nil, these requests are throttled by it instead. Every bypass is counted
+// by the replication bypass metric, so that it can be audited.
+func WithReplicationBypass(rateLimiter quotas.RequestRateLimiter) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.replicationBypass = true
+		options.replicationRateLimiter = rateLimiter
+	}
+}
+
+// WithForcedAdmissionAllowed lets requests made under a context returned by WithForcedAdmission
+// through when the rate limiters deny them, auditing each override in a metric and a warning log
+// naming the operation and the caller. Clients ignore forced admission otherwise. Only denials of
+// the rate and concurrency limiters are overridden, not paused namespaces or disabled operations.
+func WithForcedAdmissionAllowed() RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.forcedAdmissionAllowed = true
+	}
+}
+
+// WithLeakyBucketShaping replaces the main rate limiter of the client with a leaky bucket, see
+// quotas.LeakyBucketRateLimiterImpl, for stores preferring steady traffic over bursts: requests are
+// released to persistence at a steady rate of rateFn tokens per second, waiting for their turn in
+// a queue of up to maxQueued tokens. Requests are rejected right away if the queue is full, or if
+// their turn would only come after the deadline of their context.
+func WithLeakyBucketShaping(rateFn quotas.RateFn, maxQueued int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.leakyBucketRateFn = rateFn
+		options.leakyBucketMaxQueued = maxQueued
+		options.waitForToken = true
+	}
+}
+
+// WithRateSchedule replaces the main rate limiter of the client with one switching between rate
+// profiles by the wall-clock time of day, read from the time source of the client, e.g. to limit
+// requests more tightly during peak hours than overnight when batch jobs run. See
+// quotas.ScheduledRequestRateLimiterImpl for how windows apply, defaultProfile applies outside of
+// all of them. The times of day of the windows are in the given location, or in UTC if it is nil.
+func WithRateSchedule(
+	defaultProfile quotas.RateProfile,
+	location *time.Location,
+	windows ...quotas.ScheduleWindow,
+) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.rateSchedule = &defaultProfile
+		options.rateScheduleLocation = location
+		options.rateScheduleWindows = windows
+	}
+}
+
+// WithRateLimitConfigProvider replaces the main rate limiter of the client with one following the
+// limits of the provider: requests are limited to its global RPS, and to the RPS of their namespace
+// if it has one. The limits are polled when requests are admitted, at most once per refreshInterval,
+// and the burst of each limit is one second of its rate. Changed limits carry over the tokens
+// accumulated so far, see quotas.RateLimiterImpl.SetRateBurstAt.
+func WithRateLimitConfigProvider(provider RateLimitConfigProvider, refreshInterval time.Duration) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.configProvider = provider
+		options.configRefreshInterval = refreshInterval
+	}
+}
+
+// WithNamespaceWeightProvider shares the global RPS of the RateLimitConfigProvider set through
+// WithRateLimitConfigProvider among the namespaces weighted by the provider, proportionally to
+// their weights, e.g. their active execution counts. The share of a weighted namespace replaces
+// its NamespaceRPS, unweighted namespaces keep theirs. The weights are polled together with the
+// limits. It has no effect without a RateLimitConfigProvider.
+func WithNamespaceWeightProvider(provider NamespaceWeightProvider) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.namespaceWeightProvider = provider
+	}
+}
+
+// WithNamespaceBucketEviction bounds the memory of the namespace rate limiters of the
+// RateLimitConfigProvider set through WithRateLimitConfigProvider, by forgetting the ones without
+// requests for idleTTL in the background. Idle rate limiters are looked for every half idleTTL,
+// and the next request of a forgotten namespace starts a new one at its full burst. It has no
+// effect without a RateLimitConfigProvider.
+func WithNamespaceBucketEviction(idleTTL time.Duration) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.namespaceBucketIdleTTL = idleTTL
+	}
+}
+
+// WithDLQBacklogFeedback tightens the write limiter of the client while a replication DLQ is
+// backing up, so that heavy new writes do not make it worse. The backlog of the source is polled
+// in the background every pollInterval, and once it reaches the Backlog of a threshold, write
+// requests cost the WriteTokenFactor of the highest threshold reached times their tokens, so that
+// fewer of them are admitted by the rate limiter. Reads are not affected. The thresholds are kept
+// while the backlog cannot be read, and are released once it drops below them again.
+func WithDLQBacklogFeedback(
+	source DLQBacklogSource,
+	pollInterval time.Duration,
+	thresholds ...DLQBacklogThreshold,
+) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.dlqBacklogSource = source
+		options.dlqBacklogPollInterval = pollInterval
+		options.dlqBacklogThresholds = thresholds
+	}
+}
+
+// WithBootstrapSafetyValve guards against rate limiting deadlocking the startup of the server.
+// If InitializeSystemNamespaces or GetMetadata, without which the server cannot start, are
+// rejected maxRejections times in a row within the given window after the client is created,
+// an error is logged and they are exempted from rate limiting for another window.
+func WithBootstrapSafetyValve(window time.Duration, maxRejections int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.bootstrapWindow = window
+		options.bootstrapMaxRejections = maxRejections
+	}
+}
+
+// WithRejectionMetricSampling bounds the cardinality of the rejection metrics during broad
+// incidents, by emitting the rejections of each operation and namespace only every nth time,
+// with a value of n. The aggregate rejection metrics of the store and of each operation class
+// are always emitted exactly.
+func WithRejectionMetricSampling(n int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.rejectionSampling = n
+	}
+}
+
+// WithMiddleware adds middlewares hooking into the persistence calls of the client, see
+// PersistenceMiddleware. Their Before hooks are called in the order they are added, after the
+// request is admitted by the rate limiter, and their After hooks in reverse order.
+func WithMiddleware(middlewares ...PersistenceMiddleware) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.middlewares = append(options.middlewares, middlewares...)
+	}
+}
+
+// WithAdmissionHook sets a hook called with every request of the client before it consumes a
+// token, for custom gating such as blocking a workflow type during a migration. A request the
+// hook returns an error for is failed with that error, without being rate limited or metered.
+func WithAdmissionHook(hook AdmissionHook) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.admissionHook = hook
+	}
+}
+
+// WithPriorityBoostBudget honors the priority boost hints of requests, see WithPriorityBoostHint,
+// by marking their quotas.Request as Boosted for the priority function of the rate limiter to admit
+// them with the highest priority. To guard against abuse, boosted requests are capped by the given
+// budget, spending one token per token of the request. Requests beyond the budget are not rejected,
+// but admitted with their usual priority, so boosting can never claim more than the budget of the
+// capacity of the rate limiter from the rest of the traffic.
+func WithPriorityBoostBudget(budget quotas.RateLimiter) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.priorityBoostBudget = budget
+	}
+}
+
+// WithPriorityInheritance has the requests made under a context scoped WithPriorityInheritanceScope
+// inherit the priority of the first request of the scope, as computed by the priorityFn of the
+// priority rate limiter of the client, e.g. for the conflict resolution following a read to share
+// the priority of the read. The follow-ups carry the inherited priority in their quotas.Request,
+// for the priority function of the rate limiter to admit them with it unless theirs is higher.
+// enabled is checked on every request, so that inheritance can be turned on and off at runtime.
+func WithPriorityInheritance(enabled dynamicconfig.BoolPropertyFn, priorityFn quotas.RequestPriorityFn) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.priorityInheritanceEnabled = enabled
+		options.priorityInheritanceFn = priorityFn
+	}
+}
+
+// WithUtilizationMetrics samples the utilization of each rate limiter of the client every minute,
+// for capacity planning. The tokens consumed from a rate limiter over the minute are emitted as a
+// gauge, together with their ratio to the tokens the rate limiter made available, i.e. its rate
+// times 60. The utilization is only emitted for rate limiters whose rate is known, see
+// LimiterSnapshot. Samples are taken when requests are admitted, so the minute of a sample
+// can stretch while no request is.
+func WithUtilizationMetrics() RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.utilizationMetrics = true
+	}
+}
+
+// WithNotFoundCache caches NotFound results of GetWorkflowExecution for a specific run for the
+// given TTL, so that reads of the run repeated within the TTL fail right away, without consuming
+// a token or reaching persistence. Reads of the current run of a workflow are never cached, as it
+// can be started at any time. The TTL should be short, as the run can still be created through
+// other means than CreateWorkflowExecution of this client, e.g. by replication from another cluster.
+func WithNotFoundCache(ttl time.Duration, maxSize int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.notFoundCacheTTL = ttl
+		options.notFoundCacheSize = maxSize
+	}
+}
+
+// WithCreateSerialization serializes the concurrent CreateWorkflowExecution requests of the same
+// workflow ID in the execution client, as they would otherwise conflict in persistence and be
+// retried, amplifying the load. Requests of the same run ID as a concurrent one which reached
+// persistence, i.e. retries of the same create, return its result instead of creating the run
+// again. Requests of other runs are made in turn, and fail with the error of their context if it
+// is done while they wait. Only creates made through this client are serialized.
+func WithCreateSerialization() RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.createSerialization = true
+	}
+}
+
+// WithHistoryTasksDedup remembers the AddHistoryTasks requests which succeeded for the given TTL,
+// up to maxSize of them, so that retries submitting the exact same request within the TTL succeed
+// right away, without consuming a token or reaching persistence. Requests are only duplicates if
+// all of their fields and all the fields of their tasks are equal, so repeated tasks which differ
+// in any way, e.g. by task ID, are added again. Tasks holding pointers are compared by address.
+func WithHistoryTasksDedup(ttl time.Duration, maxSize int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.historyTasksDedupTTL = ttl
+		options.historyTasksDedupSize = maxSize
+	}
+}
+
+// WithWaitLatencyHistogram tracks the distribution of how long requests of each operation waited
+// for a token when waiting is enabled through WithDeadlineAwareWait, so that the tail latency
+// introduced by throttling can be read through WaitLatencyPercentile, e.g. the rolling p99.
+// The histogram covers between one and two windows of history. Wait durations are recorded by
+// the wait latency timer metric regardless.
+func WithWaitLatencyHistogram(window time.Duration) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.waitLatencyWindow = window
+	}
+}
+
+// WithSpanAttributes annotates the tracing span of every rate limited request, if
+// one is being recorded, with whether the request was rate limited. If tokensRemaining
+// is not nil, the tokens remaining in the rate limiter are recorded as well, e.g. by
+// passing the TokensAt method of a quotas.RateLimiterImpl.
+func WithSpanAttributes(tokensRemaining func(now time.Time) float64) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.spanAttributes = true
+		options.tokensRemaining = tokensRemaining
+	}
+}
+
+// WithHeadroomSignal populates the RateLimitHeadroom of the context, if any, with the
+// tokens remaining in the rate limiter after a request is allowed, e.g. by passing the
+// TokensAt method of a quotas.RateLimiterImpl. The signal is purely advisory.
+func WithHeadroomSignal(tokensRemaining func(now time.Time) float64) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.headroomSignal = true
+		options.tokensRemaining = tokensRemaining
+	}
+}
+
+// WithBackpressureEvents publishes a BackpressureEvent to the subscribers of the client whenever
+// the rejection rate over the rolling window crosses one of the thresholds, each a fraction of
+// requests between 0 and 1. Events are published at most once per debounce period.
+func WithBackpressureEvents(window time.Duration, debounce time.Duration, thresholds ...float64) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.backpressureWindow = window
+		options.backpressureDebounce = debounce
+		options.backpressureThresholds = thresholds
+	}
+}
+
+// WithThrottlingNotifications enqueues a ThrottlingNotification to the queue for every window in
+// which the rejection rate reached the threshold, a fraction of requests between 0 and 1, so that
+// other components can react to sustained throttling asynchronously. A window is only notified
+// with the first request after it is over, so at most once per window. Notifications are enqueued
+// in the background and dropped while the previous one is still being enqueued; the queue should
+// not be rate limited by the client itself, as its throttling would otherwise amplify.
+func WithThrottlingNotifications(queue Queue, window time.Duration, threshold float64) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.throttlingNotificationQueue = queue
+		options.throttlingNotificationWindow = window
+		options.throttlingNotificationThreshold = threshold
+	}
+}
+
+// WithCostBasedLimiting charges heavy operations more than a single token, by their estimated
+// cost to persistence. ConflictResolveWorkflowExecution and UpdateWorkflowExecution are charged
+// by their write amplification, derived from the number of workflows, history events, buffered
+// events and tasks they write. GetReplicationTasksFromDLQ is charged by its page size,
+// GetHistoryTasks by the number of tasks and, for scheduled tasks, the fire time its range spans,
+// AppendHistoryNodes by the serialized size of the history events it appends, and
+// ReadRawHistoryBranch by the raw size of the history blobs it read.
+func WithCostBasedLimiting() RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.costBasedLimiting = true
+	}
+}
+
+// WithHistoryNodeBytesPerToken overrides the number of serialized history event bytes for which
+// cost based limiting charges AppendHistoryNodes one additional token. It has no effect unless
+// cost based limiting is enabled.
+func WithHistoryNodeBytesPerToken(bytesPerToken int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.historyNodeBytesPerToken = bytesPerToken
+	}
+}
+
+// WithRawHistoryBytesPerToken overrides the number of raw history blob bytes for which cost
+// based limiting charges ReadRawHistoryBranch one additional token. It has no effect unless
+// cost based limiting is enabled.
+func WithRawHistoryBytesPerToken(bytesPerToken int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.rawHistoryBytesPerToken = bytesPerToken
+	}
+}
+
+// WithAvailabilityImpactClassification overrides which rejections are counted as availability
+// impacting by the SLO rejection metric. By default, rejections of all operations count against
+// the availability SLO, except for best-effort bulk cleanups.
+func WithAvailabilityImpactClassification(isAvailabilityImpacting func(operation string) bool) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.isAvailabilityImpacting = isAvailabilityImpacting
+	}
+}
+
+// WithNamespaceQPSReporting counts the requests of each namespace and reports them as QPS through
+// NamespaceQPS, e.g. for an autoscaler of the per namespace persistence limits. The counts are
+// aggregated into QPS when polled, at most once per interval, so the reported QPS is the average
+// over the last completed interval, of at least the given length. Rejected requests are counted
+// as well, so the QPS reflects the demand of the namespace rather than what was served.
+func WithNamespaceQPSReporting(interval time.Duration) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.namespaceQPSInterval = interval
+	}
+}
+
+// WithHotShardTracking counts the requests of each shard in decaying counters, whose count
+// halves every halfLife, and reports the shards of the highest counts through HotShards, e.g.
+// to target shard rebalancing. Like for WithNamespaceQPSReporting, rejected requests are counted
+// as well, and requests of operations which are not shard scoped are left out.
+func WithHotShardTracking(halfLife time.Duration) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.hotShardHalfLife = halfLife
+	}
+}
+
+// WithNamespaceIOAccounting accumulates the bytes each namespace writes to and reads from
+// persistence through the execution client, e.g. for usage based billing, and reports them
+// through NamespaceIOBytes. The bytes are the sizes of the mutable state and history events
+// measured by persistence, of successful workflow execution creations, updates, conflict
+// resolutions and reads. The first maxNamespaces namespaces are accounted on their own, the
+// bytes of any further namespace are accumulated under NamespaceIOOverflow.
+func WithNamespaceIOAccounting(maxNamespaces int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.namespaceIOMaxNamespaces = maxNamespaces
+	}
+}
+
+// WithNamespaceRejectionStats counts the rejected requests of each namespace in decaying counters,
+// whose count halves every halfLife, and reports them through NamespaceRejectionStats, e.g. for the
+// frontend to throttle a namespace itself before its requests are rejected. At most maxNamespaces
+// namespaces are counted at a time, the namespace of the fewest recent rejections is forgotten first.
+func WithNamespaceRejectionStats(halfLife time.Duration, maxNamespaces int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.namespaceRejectionHalfLife = halfLife
+		options.namespaceRejectionMaxNamespaces = maxNamespaces
+	}
+}
+
+// WithTaskQueueTypePriority throttles CreateTasks and GetTasks by the priority of their task
+// queue type, e.g. to have workflow tasks outrank activity tasks under load. Priority 0 is the
+// highest, as in quotas.NewPriorityRateLimiter: a request is admitted by the rate limiter of its
+// priority and consumes the capacity of the rate limiters of all lower priorities, so that once
+// the limiters saturate, requests of higher priority keep being admitted over lower ones.
+// Task queue types without a priority, or with a priority without a rate limiter, are given the
+// lowest priority. The priority rate limiters replace the main rate limiter for these operations.
+func WithTaskQueueTypePriority(
+	priorities map[enumspb.TaskQueueType]int,
+	rateLimiters map[int]quotas.RequestRateLimiter,
+) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.taskQueueTypePriorities = priorities
+		options.priorityRateLimiters = rateLimiters
+	}
+}
+
+// WithFailOpen controls what happens to requests when a quotas.FallibleRequestRateLimiter fails
+// to decide whether to allow them: they are let through if failOpen, favoring availability, and
+// rejected otherwise, favoring the safety of persistence. Clients fail closed by default.
+// Limiter errors are only observed when requests are neither waited for nor reserved, see
+// WithDeadlineAwareWait and WithTokenRefund.
+func WithFailOpen(failOpen bool) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.failOpen = failOpen
+	}
+}
+
+// WithRateLimitTier assigns the client to a rate limiting tier, e.g. "namespace" or "global".
+// Requests admitted by the client are marked as rate limited for its tier, and a client of
+// the same tier further down the chain lets marked requests through without charging its rate
+// limiter, pausing or counting them again. Chained clients are meant to be of distinct tiers,
+// the outermost one being the most specific, e.g. a namespace tier client wrapping a global tier
+// one, so that requests rejected for their namespace never consume global capacity. Assigning
+// the same tier to chained clients guards against charging the limiters of a tier twice when the
+// same client is mistakenly applied at several layers.
+func WithRateLimitTier(tier string) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.tier = tier
+	}
+}
+
+// WithMaxRequestSize fails requests of the operation larger than maxBytes with ErrRequestTooLarge,
+// before they consume a token or reach persistence, as they would fail there anyway. Requests of
+// AppendHistoryNodes are measured by the serialized size of their history events, requests of
+// AppendRawHistoryNodes, EnqueueMessage and EnqueueMessageToDLQ by the size of their blob. Other
+// operations are not measured. It can be set once for each operation.
+func WithMaxRequestSize(operation string, maxBytes int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		if options.maxRequestSizes == nil {
+			options.maxRequestSizes = make(map[string]int)
+		}
+		options.maxRequestSizes[operation] = maxBytes
+	}
+}
+
+// WithDownstreamLatencyByAdmission emits the latency of the persistence calls of admitted requests,
+// from their admission to their completion, tagged by how they were admitted: "waited" for the
+// requests which waited for a token, see WithDeadlineAwareWait, and "immediate" for all others.
+// Comparing both tells whether blocking on the rate limiter actually protects persistence latency.
+func WithDownstreamLatencyByAdmission() RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.downstreamLatencyByAdmission = true
+	}
+}
+
+// WithDegradedPages has the paginated reads of the given operations, ListConcreteExecutions and
+// GetAllHistoryTreeBranches, succeed with an empty page instead of failing when they are rejected,
+// e.g. for scanners preferring to back off on their next iteration over erroring out during an
+// overload. The empty page carries the page token of the request, so that the scan continues at
+// the rejected page. The first page of a scan, of no page token, still fails, as an empty page
+// without a token would end the scan. Degraded pages are metered as rejections, and counted apart.
+func WithDegradedPages(operations ...string) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		if options.degradedPageOperations == nil {
+			options.degradedPageOperations = make(map[string]struct{}, len(operations))
+		}
+		for _, operation := range operations {
+			options.degradedPageOperations[operation] = struct{}{}
+		}
+	}
+}
+
+// WithRejectionError customizes the error rejected requests fail with per operation, e.g. a
+// retryable Unavailable for internal scanners instead of ResourceExhausted. Operations for
+// which errorFactory returns nil fail with ErrPersistenceLimitExceeded. errorFactory is called
+// once per operation, when the client is created or on the first rejection of operations named
+// at runtime such as the per task category history task ones, and its errors are reused for every
+// rejection.
+func WithRejectionError(errorFactory func(operation string) error) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.errorFactory = errorFactory
+	}
+}
+
+// WithRejectionDetails attaches RateLimitRejectionDetails to the errors rejected requests
+// fail with, naming the limiter tier which denied them and its configured limit, to be read
+// through RejectionDetailsFromError. The errors stay ResourceExhausted of the same cause, but
+// are created per rejection and so no longer equal ErrPersistenceLimitExceeded.
+func WithRejectionDetails() RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.rejectionDetails = true
+	}
+}
+
+// WithTokenRefund gives the token consumed by a request back to the rate limiter if
+// the persistence call fails with an error for which isRefundable returns true.
+// isRefundable should only match errors which clearly failed before persistence did
+// any work (e.g. connection errors), not legitimate business errors.
+func WithTokenRefund(isRefundable func(err error) bool) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.refundableErrors = append(options.refundableErrors, isRefundable)
+	}
+}
+
+// WithContextErrorRefund gives the token consumed by a request back to the rate limiter if
+// the persistence call fails with context.Canceled or context.DeadlineExceeded, i.e. because
+// the caller went away after the request was admitted, rather than because persistence failed.
+// It is opt-in, as callers which cancel their requests early could otherwise exceed the rate.
+func WithContextErrorRefund() RateLimitedClientOption {
+	return WithTokenRefund(func(err error) bool {
+		return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+	})
+}
+
+// WithSpilloverRateLimiter throttles the reads spilling over to the spillover of an execution
+// client, see NewExecutionPersistenceRateLimitedClientWithSpillover, so that a read heavy
+// overload is shed rather than moved to the spillover. Reads spilling over which the rate
+// limiter denies fail with the rejection of the client.
+func WithSpilloverRateLimiter(rateLimiter quotas.RequestRateLimiter) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.spilloverRateLimiter = rateLimiter
+	}
+}
+
+// WithDecisionLog keeps the last size admission decisions of the client in memory, to be read
+// through RecentDecisions, e.g. by a debug endpoint while investigating throttling incidents.
+// Every request reaching the rate limiters is recorded, whether it was allowed or rejected.
+func WithDecisionLog(size int) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.decisionLogSize = size
+	}
+}
+
+// WithRefillPhaseShift refills the tokens of the main rate limiter once per period, at an
+// offset within the period derived from hostIdentity. This keeps the rate limiters of hosts
+// restarted together from refilling, and bursting against the shared persistence, in sync.
+func WithRefillPhaseShift(hostIdentity string, period time.Duration) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		options.refillPhaseSeed = hostIdentity
+		options.refillPhasePeriod = period
+	}
+}
+
+func withOperationRateLimiter(name string, rateLimiter quotas.RequestRateLimiter, operations ...string) RateLimitedClientOption {
+	return func(options *rateLimitedClientOptions) {
+		if options.operationRateLimiters == nil {
+			options.operationRateLimiters = make(map[string]quotas.RequestRateLimiter)
+			options.operationRateLimiterNames = make(map[string]string)
+		}
+		for _, operation := range operations {
+			options.operationRateLimiters[operation] = rateLimiter
+			options.operationRateLimiterNames[operation] = name
+		}
+	}
+}
+
+func newRateLimitedClientOptions(opts []RateLimitedClientOption) rateLimitedClientOptions {
+	options := rateLimitedClientOptions{
+		metricsHandler:            metrics.NoopMetricsHandler,
+		timeSource:                clock.NewRealTimeSource(),
+		isAvailabilityImpacting:   isAvailabilityImpactingByDefault,
+		historyNodeBytesPerToken:  defaultHistoryNodeBytesPerToken,
+		rawHistoryBytesPerToken:   defaultRawHistoryBytesPerToken,
+		lowRateWarningQuietPeriod: defaultLowRateWarningQuietPeriod,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// responseSizeTokens returns the number of additional tokens to charge for a response
+// of the given size, or 0 if response size charging is disabled.
+func (o *rateLimitedClientOptions) responseSizeTokens(size int) int {
+	if o.responseBytesPerToken <= 0 || size <= 0 {
+		return 0
+	}
+	tokens := size / o.responseBytesPerToken
+	if tokens > maxResponseSizeTokens {
+		tokens = maxResponseSizeTokens
+	}
+	return tokens
+}
+
+// isAvailabilityImpactingByDefault classifies rejections of all operations except for
+// best-effort bulk cleanups as availability impacting
+func isAvailabilityImpactingByDefault(operation string) bool {
+	for _, cleanupOperation := range cleanupOperations {
+		if operation == cleanupOperation {
+			return false
+		}
+	}
+	return true
+}
+
+// conflictResolveTokens returns the number of tokens to charge for the request, which is
+// a single token unless cost based limiting is enabled
+func (o *rateLimitedClientOptions) conflictResolveTokens(request *ConflictResolveWorkflowExecutionRequest) int {
+	if !o.costBasedLimiting {
+		return RateLimitDefaultToken
+	}
+
+	rows := 1
+	eventCount := countEvents(request.ResetWorkflowEvents)
+	taskCount := countTasks(request.ResetWorkflowSnapshot.Tasks)
+	if request.NewWorkflowSnapshot != nil {
+		rows++
+		eventCount += countEvents(request.NewWorkflowEvents)
+		taskCount += countTasks(request.NewWorkflowSnapshot.Tasks)
+	}
+	if request.CurrentWorkflowMutation != nil {
+		rows++
+		eventCount += countEvents(request.CurrentWorkflowEvents)
+		taskCount += countTasks(request.CurrentWorkflowMutation.Tasks)
+	}
+
+	tokens := rows + eventCount/conflictResolveEventsPerToken + taskCount/conflictResolveTasksPerToken
+	if tokens > maxOperationTokens {
+		tokens = maxOperationTokens
+	}
+	return tokens
+}
+
+// updateWorkflowTokens returns the number of tokens to charge for the request, which is
+// a single token unless cost based limiting is enabled
+func (o *rateLimitedClientOptions) updateWorkflowTokens(request *UpdateWorkflowExecutionRequest) int {
+	if !o.costBasedLimiting {
+		return RateLimitDefaultToken
+	}
+
+	rows := 1
+	eventCount := countEvents(request.UpdateWorkflowEvents)
+	taskCount := countTasks(request.UpdateWorkflowMutation.Tasks)
+	bufferedEventCount := len(request.UpdateWorkflowMutation.NewBufferedEvents)
+	if request.NewWorkflowSnapshot != nil {
+		rows++
+		eventCount += countEvents(request.NewWorkflowEvents)
+		taskCount += countTasks(request.NewWorkflowSnapshot.Tasks)
+	}
+
+	tokens := rows +
+		eventCount/updateWorkflowEventsPerToken +
+		taskCount/updateWorkflowTasksPerToken +
+		bufferedEventCount/updateWorkflowBufferedEventsPerToken
+	if tokens > maxOperationTokens {
+		tokens = maxOperationTokens
+	}
+	return tokens
+}
+
+// replicationDLQPageTokens returns the number of tokens to charge for reading a page of the
+// replication DLQ, which is a single token unless cost based limiting is enabled
+func (o *rateLimitedClientOptions) replicationDLQPageTokens(request *GetReplicationTasksFromDLQRequest) int {
+	if !o.costBasedLimiting {
+		return RateLimitDefaultToken
+	}
+
+	tokens := RateLimitDefaultToken + request.BatchSize/replicationDLQTasksPerToken
+	if tokens > maxOperationTokens {
+		tokens = maxOperationTokens
+	}
+	return tokens
+}
+
+// historyTaskRangeTokens returns the number of tokens to charge for reading a range of history
+// tasks, which is a single token unless cost based limiting is enabled
+func (o *rateLimitedClientOptions) historyTaskRangeTokens(request *GetHistoryTasksRequest) int {
+	if !o.costBasedLimiting {
+		return RateLimitDefaultToken
+	}
+
+	taskCount := int64(request.BatchSize)
+	var fireTimeRange time.Duration
+	switch request.TaskCategory.Type() {
+	case tasks.CategoryTypeImmediate:
+		// the range size is negative if the subtraction overflows, for ranges
+		// open towards the minimum key, which are only bounded by the batch size
+		rangeSize := request.ExclusiveMaxTaskKey.TaskID - request.InclusiveMinTaskKey.TaskID
+		if rangeSize >= 0 && rangeSize < taskCount {
+			taskCount = rangeSize
+		}
+	case tasks.CategoryTypeScheduled:
+		fireTimeRange = request.ExclusiveMaxTaskKey.FireTime.Sub(request.InclusiveMinTaskKey.FireTime)
+	}
+	// malformed requests, e.g. a negative batch size or a range ending before it starts, must not
+	// be charged a negative cost, which would give tokens to the rate limiter instead of taking them
+	if taskCount < 0 {
+		taskCount = 0
+	}
+	if fireTimeRange < 0 {
+		fireTimeRange = 0
+	}
+
+	tokens := int64(RateLimitDefaultToken) + taskCount/historyTasksPerToken + int64(fireTimeRange/historyTaskFireTimeRangePerToken)
+	if tokens < RateLimitDefaultToken {
+		tokens = RateLimitDefaultToken
+	}
+	if tokens > maxOperationTokens {
+		tokens = maxOperationTokens
+	}
+	return int(tokens)
+}
+
+// historyNodeTokens returns the number of tokens to charge for appending history nodes,
+// which is a single token unless cost based limiting is enabled
+func (o *rateLimitedClientOptions) historyNodeTokens(request *AppendHistoryNodesRequest) int {
+	if !o.costBasedLimiting || o.historyNodeBytesPerToken <= 0 {
+		return RateLimitDefaultToken
+	}
+
+	tokens := RateLimitDefaultToken + historyEventsSize(request.Events)/o.historyNodeBytesPerToken
+	if tokens > maxOperationTokens {
+		tokens = maxOperationTokens
+	}
+	return tokens
+}
+
+// rawHistoryTokens returns the number of additional tokens to charge for the history blobs read
+// by ReadRawHistoryBranch, by their raw size, or 0 unless cost based limiting is enabled
+func (o *rateLimitedClientOptions) rawHistoryTokens(response *ReadRawHistoryBranchResponse) int {
+	if !o.costBasedLimiting || o.rawHistoryBytesPerToken <= 0 {
+		return 0
+	}
+
+	size := 0
+	for _, blob := range response.HistoryEventBlobs {
+		size += len(blob.GetData())
+	}
+
+	tokens := size / o.rawHistoryBytesPerToken
+	if tokens > maxOperationTokens {
+		tokens = maxOperationTokens
+	}
+	return tokens
+}
+
+// taskQueueTypePriority returns the priority of operations of the task queue type
+func (o *rateLimitedClientOptions) taskQueueTypePriority(taskType enumspb.TaskQueueType) int {
+	if priority, ok := o.taskQueueTypePriorities[taskType]; ok {
+		if _, ok := o.priorityRateLimiters[priority]; ok {
+			return priority
+		}
+	}
+	lowest := 0
+	for priority := range o.priorityRateLimiters {
+		if priority > lowest {
+			lowest = priority
+		}
+	}
+	return lowest
+}
+
+func countEvents(workflowEvents []*WorkflowEvents) int {
+	count := 0
+	for _, events := range workflowEvents {
+		count += len(events.Events)
+	}
+	return count
+}
+
+func countTasks(tasksByCategory map[tasks.Category][]tasks.Task) int {
+	count := 0
+	for _, categoryTasks := range tasksByCategory {
+		count += len(categoryTasks)
+	}
+	return count
+}
@@ -25,6 +25,7 @@
 package quotas
 
 import (
+	"fmt"
 	"testing"
 	"time"
 )
@@ -58,3 +59,38 @@ func BenchmarkDynamicRateLimiter(b *testing.B) {
 		limiter.Allow()
 	}
 }
+
+// The namespace rate limiter benchmarks compare the lock contention of a single map of per
+// namespace rate limiters with the sharded one, when many goroutines hit many namespaces. The
+// sharded one only pays off with several cores contending, e.g. with -cpu 16 on a 16 core host;
+// on a single core it is slower by the cost of hashing the namespace.
+
+const benchNamespaces = 1024
+
+func BenchmarkNamespaceRequestRateLimiter_Parallel(b *testing.B) {
+	benchmarkNamespaceRequestRateLimiter(b, NewNamespaceRequestRateLimiter(benchRateLimiterFn))
+}
+
+func BenchmarkShardedNamespaceRequestRateLimiter_Parallel(b *testing.B) {
+	benchmarkNamespaceRequestRateLimiter(b, NewShardedNamespaceRequestRateLimiter(benchRateLimiterFn, 64))
+}
+
+func benchRateLimiterFn(Request) RequestRateLimiter {
+	return NewRequestRateLimiterAdapter(NewRateLimiter(testRate, testBurst))
+}
+
+func benchmarkNamespaceRequestRateLimiter(b *testing.B, rateLimiter RequestRateLimiter) {
+	requests := make([]Request, benchNamespaces)
+	for i := range requests {
+		requests[i] = NewRequest("", 1, fmt.Sprintf("namespace-%d", i), "", 0, "")
+	}
+	now := time.Now()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			rateLimiter.Allow(now, requests[i%benchNamespaces])
+			i++
+		}
+	})
+}
@@ -0,0 +1,226 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type (
+	// CreditRateLimiterImpl is a rate limiter decorator letting the capacity left unused while idle
+	// accumulate into a bounded pool of credits, which requests beyond the burst of the decorated
+	// rate limiter can spend. It suits workloads with idle gaps followed by bursts, e.g. cron
+	// triggered workflows, without raising the burst, and so the peak load, of busy periods.
+	CreditRateLimiterImpl struct {
+		rateLimiter *RateLimiterImpl
+		accrualRate float64
+		maxCredits  float64
+
+		sync.Mutex
+		credits float64
+		// observedAt and observedTokens are the time and the tokens of the decorated
+		// rate limiter when it was last used
+		observedAt     time.Time
+		observedTokens float64
+	}
+
+	// creditReservationImpl is a reservation paid with credits
+	creditReservationImpl struct {
+		limiter *CreditRateLimiterImpl
+		credits float64
+		// canceled is guarded by the lock of the limiter
+		canceled bool
+	}
+)
+
+var _ RateLimiter = (*CreditRateLimiterImpl)(nil)
+var _ Reservation = (*creditReservationImpl)(nil)
+
+// NewCreditRateLimiter returns a rate limiter decorating the given one with a pool of credits.
+// The decorated rate limiter is idle while its bucket is full, as the tokens it refills are lost,
+// and the pool accrues accrualRate credits per second for as long as it is, up to maxCredits.
+// Requests denied by the decorated rate limiter are admitted if the pool has credits for all
+// of their tokens. The decorated rate limiter must not be used other than through the returned one.
+//
+// The result can be used as a RequestRateLimiter through NewRequestRateLimiterAdapter.
+func NewCreditRateLimiter(
+	rateLimiter *RateLimiterImpl,
+	accrualRate float64,
+	maxCredits int,
+) *CreditRateLimiterImpl {
+	return &CreditRateLimiterImpl{
+		rateLimiter: rateLimiter,
+		accrualRate: math.Max(0, accrualRate),
+		maxCredits:  math.Max(0, float64(maxCredits)),
+	}
+}
+
+// Allow immediately returns with true or false indicating if a rate limit
+// token is available or not
+func (r *CreditRateLimiterImpl) Allow() bool {
+	return r.AllowN(time.Now(), 1)
+}
+
+// AllowN immediately returns with true or false indicating if n rate limit
+// token is available or not
+func (r *CreditRateLimiterImpl) AllowN(now time.Time, numToken int) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	r.accrueLocked(now)
+	allowed := r.rateLimiter.AllowN(now, numToken)
+	if !allowed && r.credits >= float64(numToken) {
+		r.credits -= float64(numToken)
+		allowed = true
+	}
+	r.observeLocked(now)
+	return allowed
+}
+
+// Reserve reserves a rate limit token
+func (r *CreditRateLimiterImpl) Reserve() Reservation {
+	return r.ReserveN(time.Now(), 1)
+}
+
+// ReserveN reserves n rate limit token. Reservations which would be delayed by the decorated
+// rate limiter are paid with credits instead if there are enough, and are not delayed then.
+func (r *CreditRateLimiterImpl) ReserveN(now time.Time, numToken int) Reservation {
+	r.Lock()
+	defer r.Unlock()
+
+	r.accrueLocked(now)
+	defer r.observeLocked(now)
+	reservation := r.rateLimiter.ReserveN(now, numToken)
+	if reservation.OK() && reservation.DelayFrom(now) == 0 {
+		return reservation
+	}
+	if r.credits < float64(numToken) {
+		return reservation
+	}
+	reservation.CancelAt(now)
+	r.credits -= float64(numToken)
+	return &creditReservationImpl{limiter: r, credits: float64(numToken)}
+}
+
+// Wait waits up till deadline for a rate limit token
+func (r *CreditRateLimiterImpl) Wait(ctx context.Context) error {
+	return r.WaitN(ctx, 1)
+}
+
+// WaitN waits up till deadline for n rate limit token, which are paid with
+// credits right away if the decorated rate limiter has not enough of them
+func (r *CreditRateLimiterImpl) WaitN(ctx context.Context, numToken int) error {
+	if r.AllowN(time.Now(), numToken) {
+		return nil
+	}
+	if err := r.rateLimiter.WaitN(ctx, numToken); err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.observeLocked(time.Now())
+	return nil
+}
+
+// Rate returns the rate per second for this rate limiter
+func (r *CreditRateLimiterImpl) Rate() float64 {
+	return r.rateLimiter.Rate()
+}
+
+// Burst returns the burst for this rate limiter
+func (r *CreditRateLimiterImpl) Burst() int {
+	return r.rateLimiter.Burst()
+}
+
+// CreditsAt returns the credits available at the given time
+func (r *CreditRateLimiterImpl) CreditsAt(now time.Time) float64 {
+	r.Lock()
+	defer r.Unlock()
+
+	r.accrueLocked(now)
+	r.observeLocked(now)
+	return r.credits
+}
+
+// accrueLocked adds the credits accrued while the decorated rate limiter was idle since it was last used
+func (r *CreditRateLimiterImpl) accrueLocked(now time.Time) {
+	rate := r.rateLimiter.Rate()
+	if r.observedAt.IsZero() || !now.After(r.observedAt) || rate <= 0 {
+		return
+	}
+	missing := math.Max(0, float64(r.rateLimiter.Burst())-r.observedTokens)
+	fullAt := r.observedAt.Add(time.Duration(missing / rate * float64(time.Second)))
+	if !now.After(fullAt) {
+		return
+	}
+	r.credits = math.Min(r.maxCredits, r.credits+r.accrualRate*now.Sub(fullAt).Seconds())
+}
+
+func (r *CreditRateLimiterImpl) observeLocked(now time.Time) {
+	if now.Before(r.observedAt) {
+		return
+	}
+	r.observedAt = now
+	r.observedTokens = r.rateLimiter.TokensAt(now)
+}
+
+// OK returns whether the limiter can provide the requested number of tokens
+func (r *creditReservationImpl) OK() bool {
+	return true
+}
+
+// Cancel indicates that the reservation holder will not perform the reserved action
+// and gives the credits of the reservation back
+func (r *creditReservationImpl) Cancel() {
+	r.CancelAt(time.Now())
+}
+
+// CancelAt indicates that the reservation holder will not perform the reserved action
+// and gives the credits of the reservation back
+func (r *creditReservationImpl) CancelAt(_ time.Time) {
+	r.limiter.Lock()
+	defer r.limiter.Unlock()
+
+	if r.canceled {
+		return
+	}
+	r.canceled = true
+	r.limiter.credits = math.Min(r.limiter.maxCredits, r.limiter.credits+r.credits)
+}
+
+// Delay returns the duration for which the reservation holder must wait
+// before taking the reserved action, which is none for credits
+func (r *creditReservationImpl) Delay() time.Duration {
+	return 0
+}
+
+// DelayFrom returns the duration for which the reservation holder must wait
+// before taking the reserved action, which is none for credits
+func (r *creditReservationImpl) DelayFrom(_ time.Time) time.Duration {
+	return 0
+}
@@ -0,0 +1,101 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreditRateLimiter_AccruesWhileIdle(t *testing.T) {
+	start := time.Unix(100, 0)
+	rateLimiter := NewCreditRateLimiter(NewRateLimiter(10, 10), 5, 20)
+
+	// a fresh rate limiter has its burst but no credits
+	require.Equal(t, 10, allowAll(rateLimiter, start))
+	require.Zero(t, rateLimiter.CreditsAt(start))
+
+	// the bucket refills within a second, credits only accrue once it is full
+	require.Zero(t, rateLimiter.CreditsAt(start.Add(time.Second)))
+	require.InDelta(t, 10, rateLimiter.CreditsAt(start.Add(3*time.Second)), 0.001)
+
+	// the burst is served by the bucket first, then by the credits, until both are exhausted
+	burst := start.Add(3 * time.Second)
+	require.Equal(t, 20, allowAll(rateLimiter, burst))
+	require.Zero(t, rateLimiter.CreditsAt(burst))
+	require.False(t, rateLimiter.AllowN(burst, 1))
+}
+
+func TestCreditRateLimiter_CappedCredits(t *testing.T) {
+	start := time.Unix(100, 0)
+	rateLimiter := NewCreditRateLimiter(NewRateLimiter(10, 10), 5, 20)
+	require.Equal(t, 10, allowAll(rateLimiter, start))
+
+	later := start.Add(time.Hour)
+	require.InDelta(t, 20, rateLimiter.CreditsAt(later), 0.001)
+	require.Equal(t, 30, allowAll(rateLimiter, later))
+	require.False(t, rateLimiter.AllowN(later, 1))
+}
+
+func TestCreditRateLimiter_NoCreditsWhileBusy(t *testing.T) {
+	start := time.Unix(100, 0)
+	rateLimiter := NewCreditRateLimiter(NewRateLimiter(10, 10), 5, 20)
+
+	// the refilled tokens are consumed as fast as they come, none is left unused
+	now := start
+	for i := 0; i < 100; i++ {
+		require.True(t, rateLimiter.AllowN(now, 1))
+		now = now.Add(100 * time.Millisecond)
+	}
+	require.Zero(t, rateLimiter.CreditsAt(now))
+}
+
+func TestCreditRateLimiter_Reserve(t *testing.T) {
+	start := time.Unix(100, 0)
+	rateLimiter := NewCreditRateLimiter(NewRateLimiter(10, 1), 1, 2)
+	require.True(t, rateLimiter.AllowN(start, 1))
+
+	later := start.Add(time.Minute)
+	require.True(t, rateLimiter.AllowN(later, 1))
+	require.InDelta(t, 2, rateLimiter.CreditsAt(later), 0.001)
+
+	// the reservations the bucket would delay are paid with credits, and are not delayed
+	reservation := rateLimiter.ReserveN(later, 2)
+	require.True(t, reservation.OK())
+	require.Zero(t, reservation.DelayFrom(later))
+	require.Zero(t, rateLimiter.CreditsAt(later))
+
+	// canceling gives the credits back, once
+	reservation.CancelAt(later)
+	reservation.CancelAt(later)
+	require.InDelta(t, 2, rateLimiter.CreditsAt(later), 0.001)
+
+	// without enough credits, the reservation of the bucket is returned
+	reservation = rateLimiter.ReserveN(later, 3)
+	require.False(t, reservation.OK())
+	require.InDelta(t, 2, rateLimiter.CreditsAt(later), 0.001)
+}
@@ -0,0 +1,50 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"math"
+	"time"
+)
+
+// NewDualWindowRateLimiter returns a rate limiter enforcing both a short-term and a long-term rate.
+// A request has to pass a fast bucket, which refills at burstRate and holds up to burst tokens,
+// and a slow bucket, which refills at sustainedRate and holds the tokens of one sustainedWindow.
+// This tolerates spikes of up to burstRate, while limiting any sustainedWindow to sustainedRate
+// on average, e.g. burst to 500 for a few seconds but sustain only 100.
+//
+// The result can be used as a RequestRateLimiter through NewRequestRateLimiterAdapter.
+func NewDualWindowRateLimiter(
+	burstRate float64,
+	burst int,
+	sustainedRate float64,
+	sustainedWindow time.Duration,
+) *MultiRateLimiterImpl {
+	sustainedBurst := int(math.Max(1, math.Floor(sustainedRate*sustainedWindow.Seconds())))
+	return NewMultiRateLimiter([]RateLimiter{
+		NewRateLimiter(burstRate, burst),
+		NewRateLimiter(sustainedRate, sustainedBurst),
+	})
+}
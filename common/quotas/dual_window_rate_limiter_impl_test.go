@@ -0,0 +1,68 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDualWindowRateLimiter_BurstThenSustained(t *testing.T) {
+	// burst up to 10 per second, but sustain only 1 per second over 20 seconds
+	rateLimiter := NewDualWindowRateLimiter(10, 10, 1, 20*time.Second)
+	now := time.Now()
+
+	// the initial spike is limited by the burst
+	require.Equal(t, 10, allowAll(rateLimiter, now))
+
+	// the fast bucket refills after a second, and the slow bucket still has 20 - 10 + 1 tokens
+	now = now.Add(time.Second)
+	require.Equal(t, 10, allowAll(rateLimiter, now))
+
+	// the fast bucket refills again, but the slow bucket only has 1 + 1 tokens
+	now = now.Add(time.Second)
+	require.Equal(t, 2, allowAll(rateLimiter, now))
+
+	// from here on, requests are throttled to the sustained rate
+	now = now.Add(time.Second)
+	require.Equal(t, 1, allowAll(rateLimiter, now))
+}
+
+func TestDualWindowRateLimiter_MinimumSustainedBurst(t *testing.T) {
+	rateLimiter := NewDualWindowRateLimiter(10, 10, 1, time.Millisecond)
+
+	require.Equal(t, 1, allowAll(rateLimiter, time.Now()))
+}
+
+// allowAll returns the number of single token requests allowed at the given time
+func allowAll(rateLimiter RateLimiter, now time.Time) int {
+	allowed := 0
+	for rateLimiter.AllowN(now, 1) {
+		allowed++
+	}
+	return allowed
+}
@@ -0,0 +1,335 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// globalRateLimiterCoordinationTimeout bounds every call to the shared counter,
+	// the rate limiter degrades to local limiting if the counter does not answer in time
+	globalRateLimiterCoordinationTimeout = 100 * time.Millisecond
+)
+
+type (
+	// SharedCounter is a counter shared by all hosts of a cluster, e.g. backed by the
+	// database or an external store, used to coordinate a cluster wide budget
+	SharedCounter interface {
+		// Add atomically adds delta to the counter of the given window and returns the new value
+		Add(ctx context.Context, window int64, delta int64) (int64, error)
+	}
+
+	// GlobalRateLimiterImpl enforces a cluster wide rate by leasing tokens from a SharedCounter
+	// in batches, one fixed window at a time. Tokens leased but not used by the end of a window
+	// are lost, so the cluster as a whole never exceeds its budget.
+	//
+	// If the shared counter is unavailable, the rate limiter degrades to the local rate limiter,
+	// which should be configured with the share of the budget of this host, for the rest of the
+	// window. The counter is only called by one request at a time, and never with the lock held,
+	// so that a slow counter does not hold up requests served by a lease or the local limiter.
+	// Leases running low are refilled in the background, so that requests only wait for the
+	// counter when a lease runs out before it is refilled, e.g. at the start of every window.
+	GlobalRateLimiterImpl struct {
+		counter   SharedCounter
+		rateFn    RateFn
+		window    time.Duration
+		leaseSize int
+		local     RateLimiter
+
+		sync.Mutex
+		// leaseDone is signaled whenever a lease from the shared counter completes
+		leaseDone   *sync.Cond
+		leaseWindow int64
+		// leased is the number of tokens remaining in the lease of leaseWindow
+		leased int
+		// exhausted is set once the budget of leaseWindow is used up by the cluster
+		exhausted bool
+		// leasing is set while a request leases tokens from the shared counter
+		leasing bool
+		// refilling is set while a refill of the lease is scheduled in the background
+		refilling bool
+		// degraded is set once the shared counter failed during leaseWindow, whose
+		// requests are served by the local rate limiter from then on
+		degraded bool
+	}
+
+	// globalReservation is a reservation of tokens leased from the shared counter
+	globalReservation struct {
+		ok          bool
+		rateLimiter *GlobalRateLimiterImpl
+		window      int64
+		numToken    int
+		cancelled   bool
+	}
+)
+
+var _ RateLimiter = (*GlobalRateLimiterImpl)(nil)
+var _ Reservation = (*globalReservation)(nil)
+
+// NewGlobalRateLimiter returns a rate limiter enforcing the cluster wide rate returned by rateFn.
+// Tokens are leased from the counter leaseSize at a time, trading precision of the cluster wide
+// rate for fewer calls to the counter.
+func NewGlobalRateLimiter(
+	counter SharedCounter,
+	rateFn RateFn,
+	window time.Duration,
+	leaseSize int,
+	local RateLimiter,
+) *GlobalRateLimiterImpl {
+	rl := &GlobalRateLimiterImpl{
+		counter:   counter,
+		rateFn:    rateFn,
+		window:    window,
+		leaseSize: leaseSize,
+		local:     local,
+	}
+	rl.leaseDone = sync.NewCond(&rl.Mutex)
+	return rl
+}
+
+// Allow returns with true or false indicating if a rate limit token is available or not.
+// It may block for up to globalRateLimiterCoordinationTimeout if the lease is empty and
+// tokens have to be leased from the shared counter, see ReserveN.
+func (rl *GlobalRateLimiterImpl) Allow() bool {
+	return rl.AllowN(time.Now(), 1)
+}
+
+// AllowN returns with true or false indicating if n rate limit token is available or not.
+// It may block for up to globalRateLimiterCoordinationTimeout if the lease does not hold n
+// tokens and they have to be leased from the shared counter, see ReserveN.
+func (rl *GlobalRateLimiterImpl) AllowN(now time.Time, numToken int) bool {
+	reservation := rl.ReserveN(now, numToken)
+	if !reservation.OK() {
+		return false
+	}
+	if reservation.DelayFrom(now) > 0 {
+		// only possible while degraded to the local rate limiter
+		reservation.CancelAt(now)
+		return false
+	}
+	return true
+}
+
+// Reserve reserves a rate limit token
+func (rl *GlobalRateLimiterImpl) Reserve() Reservation {
+	return rl.ReserveN(time.Now(), 1)
+}
+
+// ReserveN reserves n rate limit token. Tokens of the cluster wide budget are either
+// available right away, or the reservation is not OK, since tokens of future windows
+// cannot be leased ahead of time. Requests for more tokens than the budget of a window
+// are never OK. If the lease does not hold n tokens, the call blocks while they are
+// leased from the shared counter, for up to globalRateLimiterCoordinationTimeout.
+func (rl *GlobalRateLimiterImpl) ReserveN(now time.Time, numToken int) Reservation {
+	if numToken > rl.budget() {
+		// such requests could never be granted, and leasing them would exhaust the window
+		return &globalReservation{ok: false}
+	}
+
+	rl.Lock()
+	defer rl.Unlock()
+
+	window := now.UnixNano() / rl.window.Nanoseconds()
+	for {
+		if window > rl.leaseWindow {
+			rl.leaseWindow = window
+			rl.leased = 0
+			rl.exhausted = false
+			rl.degraded = false
+		}
+		if rl.degraded {
+			return rl.local.ReserveN(now, numToken)
+		}
+		if rl.leased >= numToken {
+			break
+		}
+		if rl.exhausted {
+			return &globalReservation{ok: false}
+		}
+		if rl.leasing {
+			// share the outcome of the lease in flight rather than leasing once per request
+			rl.leaseDone.Wait()
+			continue
+		}
+		rl.lease(rl.leaseWindow, numToken)
+	}
+	rl.leased -= numToken
+	if rl.leased < rl.leaseSize/2 && !rl.leasing && !rl.refilling && !rl.exhausted {
+		rl.refilling = true
+		go rl.refill(rl.leaseWindow)
+	}
+	return &globalReservation{
+		ok:          true,
+		rateLimiter: rl,
+		window:      rl.leaseWindow,
+		numToken:    numToken,
+	}
+}
+
+// Wait waits up till deadline for a rate limit token
+func (rl *GlobalRateLimiterImpl) Wait(ctx context.Context) error {
+	return rl.WaitN(ctx, 1)
+}
+
+// WaitN waits up till deadline for n rate limit token, retrying at the start
+// of every window
+func (rl *GlobalRateLimiterImpl) WaitN(ctx context.Context, numToken int) error {
+	for {
+		now := time.Now()
+		reservation := rl.ReserveN(now, numToken)
+		if reservation.OK() {
+			delay := reservation.DelayFrom(now)
+			if delay == 0 {
+				return nil
+			}
+			// degraded to the local rate limiter, which reserved the tokens ahead of time
+			t := time.NewTimer(delay)
+			select {
+			case <-t.C:
+				return nil
+			case <-ctx.Done():
+				t.Stop()
+				reservation.CancelAt(time.Now())
+				return ctx.Err()
+			}
+		}
+
+		nextWindow := time.Unix(0, (now.UnixNano()/rl.window.Nanoseconds()+1)*rl.window.Nanoseconds())
+		t := time.NewTimer(nextWindow.Sub(now))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Rate returns the cluster wide rate per second for this rate limiter
+func (rl *GlobalRateLimiterImpl) Rate() float64 {
+	return rl.rateFn()
+}
+
+// Burst returns the cluster wide budget of a single window
+func (rl *GlobalRateLimiterImpl) Burst() int {
+	return rl.budget()
+}
+
+// lease adds tokens of the window from the shared counter, and marks the window as exhausted
+// if the counter could not grant all of them, or as degraded if the counter failed. The lock
+// must be held, it is released while the counter is called.
+func (rl *GlobalRateLimiterImpl) lease(window int64, numToken int) {
+	request := rl.leaseSize
+	if missing := numToken - rl.leased; missing > request {
+		request = missing
+	}
+
+	rl.leasing = true
+	rl.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), globalRateLimiterCoordinationTimeout)
+	total, err := rl.counter.Add(ctx, window, int64(request))
+	cancel()
+	rl.Lock()
+	rl.leasing = false
+	rl.leaseDone.Broadcast()
+
+	if window != rl.leaseWindow {
+		// the window ended meanwhile, its tokens are lost
+		return
+	}
+	if err != nil {
+		rl.degraded = true
+		return
+	}
+	granted := request
+	if overdraft := int(total) - rl.budget(); overdraft > 0 {
+		granted -= overdraft
+		rl.exhausted = true
+	}
+	if granted > 0 {
+		rl.leased += granted
+	}
+}
+
+// refill leases tokens of the window from the shared counter in the background, unless
+// the lease was refilled or the window ended meanwhile
+func (rl *GlobalRateLimiterImpl) refill(window int64) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	rl.refilling = false
+	if window != rl.leaseWindow || rl.leasing || rl.exhausted || rl.degraded || rl.leased >= rl.leaseSize/2 {
+		return
+	}
+	rl.lease(window, 0)
+}
+
+func (rl *GlobalRateLimiterImpl) budget() int {
+	return int(rl.rateFn() * rl.window.Seconds())
+}
+
+// OK returns whether the limiter can provide the requested number of tokens
+func (r *globalReservation) OK() bool {
+	return r.ok
+}
+
+// Cancel indicates that the reservation holder will not perform the reserved action
+// and reverses the effects of this Reservation on the rate limit as much as possible
+func (r *globalReservation) Cancel() {
+	r.CancelAt(time.Now())
+}
+
+// CancelAt indicates that the reservation holder will not perform the reserved action
+// and reverses the effects of this Reservation on the rate limit as much as possible.
+// The tokens are returned to the lease, if it is still for the window of the reservation.
+func (r *globalReservation) CancelAt(_ time.Time) {
+	if !r.ok {
+		return
+	}
+	r.rateLimiter.Lock()
+	defer r.rateLimiter.Unlock()
+	if r.cancelled {
+		return
+	}
+	r.cancelled = true
+	if r.rateLimiter.leaseWindow == r.window {
+		r.rateLimiter.leased += r.numToken
+	}
+}
+
+// Delay returns the duration for which the reservation holder must wait
+// before taking the reserved action.  Zero duration means act immediately.
+func (r *globalReservation) Delay() time.Duration {
+	return 0
+}
+
+// DelayFrom returns the duration for which the reservation holder must wait
+// before taking the reserved action.  Zero duration means act immediately.
+func (r *globalReservation) DelayFrom(_ time.Time) time.Duration {
+	return 0
+}
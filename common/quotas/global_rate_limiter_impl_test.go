@@ -0,0 +1,223 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	globalRateLimiterSuite struct {
+		suite.Suite
+		*require.Assertions
+
+		counter *fakeSharedCounter
+		now     time.Time
+	}
+
+	// fakeSharedCounter is an in memory SharedCounter which can be made unavailable
+	fakeSharedCounter struct {
+		sync.Mutex
+		counts      map[int64]int64
+		calls       int
+		attempts    int
+		unavailable bool
+		// latency delays every call, before the counter is locked
+		latency time.Duration
+	}
+)
+
+func TestGlobalRateLimiterSuite(t *testing.T) {
+	s := new(globalRateLimiterSuite)
+	suite.Run(t, s)
+}
+
+func (s *globalRateLimiterSuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+
+	s.counter = &fakeSharedCounter{counts: make(map[int64]int64)}
+	s.now = time.Unix(1000, 0)
+}
+
+func (s *globalRateLimiterSuite) TestClusterWideBudget() {
+	host1 := s.newRateLimiter(NewRateLimiter(0.001, 100))
+	host2 := s.newRateLimiter(NewRateLimiter(0.001, 100))
+
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if host1.AllowN(s.now, 1) {
+			allowed++
+		}
+		if host2.AllowN(s.now, 1) {
+			allowed++
+		}
+	}
+	s.Equal(10, allowed)
+
+	// tokens are leased in batches, not per request
+	s.LessOrEqual(s.counter.calls, 10/2+2)
+
+	// the budget is renewed with the next window
+	s.True(host1.AllowN(s.now.Add(time.Second), 1))
+}
+
+func (s *globalRateLimiterSuite) TestDegradesToLocalLimiting() {
+	rateLimiter := s.newRateLimiter(NewRateLimiter(0.001, 3))
+	s.counter.setUnavailable(true)
+
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if rateLimiter.AllowN(s.now, 1) {
+			allowed++
+		}
+	}
+	s.Equal(3, allowed)
+
+	// coordination resumes once the counter is available again
+	s.counter.setUnavailable(false)
+	s.True(rateLimiter.AllowN(s.now.Add(time.Second), 1))
+}
+
+func (s *globalRateLimiterSuite) TestDegradesToLocalLimiting_SlowCounter() {
+	rateLimiter := s.newRateLimiter(NewRateLimiter(0.001, 30))
+	s.counter.setUnavailable(true)
+	s.counter.latency = 50 * time.Millisecond
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rateLimiter.AllowN(s.now, 1) {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// a single request waited for the failing counter, the others shared its outcome
+	// and were served by the local rate limiter
+	s.Equal(1, s.counter.attempts)
+	s.Less(time.Since(start), 10*s.counter.latency)
+	s.Equal(int32(30), allowed.Load())
+
+	// the rest of the window is served by the local rate limiter right away
+	s.False(rateLimiter.AllowN(s.now, 1))
+	s.Equal(1, s.counter.attempts)
+}
+
+func (s *globalRateLimiterSuite) TestReservationCancel() {
+	rateLimiter := s.newRateLimiter(NewRateLimiter(0.001, 100))
+	for i := 0; i < 9; i++ {
+		s.True(rateLimiter.AllowN(s.now, 1))
+	}
+
+	reservation := rateLimiter.ReserveN(s.now, 1)
+	s.True(reservation.OK())
+	s.Zero(reservation.DelayFrom(s.now))
+	s.False(rateLimiter.AllowN(s.now, 1))
+
+	reservation.CancelAt(s.now)
+	reservation.CancelAt(s.now)
+	s.True(rateLimiter.AllowN(s.now, 1))
+	s.False(rateLimiter.AllowN(s.now, 1))
+}
+
+func (s *globalRateLimiterSuite) TestReserveN_ExceedsBudget() {
+	rateLimiter := s.newRateLimiter(NewRateLimiter(0.001, 100))
+
+	s.False(rateLimiter.ReserveN(s.now, 11).OK())
+	s.False(rateLimiter.AllowN(s.now, 11))
+	s.Zero(s.counter.attempts)
+
+	// the window is not exhausted by the requests exceeding the budget
+	s.True(rateLimiter.AllowN(s.now, 10))
+}
+
+func (s *globalRateLimiterSuite) TestRefillsLeaseInBackground() {
+	rateLimiter := NewGlobalRateLimiter(s.counter, func() float64 { return 100 }, time.Second, 4, NewRateLimiter(0.001, 100))
+	s.True(rateLimiter.AllowN(s.now, 1))
+	s.counter.latency = 50 * time.Millisecond
+
+	// the lease runs low and is refilled without holding up the request
+	start := time.Now()
+	s.True(rateLimiter.AllowN(s.now, 2))
+	s.Less(time.Since(start), s.counter.latency)
+	s.Eventually(func() bool {
+		rateLimiter.Lock()
+		defer rateLimiter.Unlock()
+		return !rateLimiter.leasing && rateLimiter.leased == 5
+	}, time.Second, time.Millisecond)
+
+	start = time.Now()
+	s.True(rateLimiter.AllowN(s.now, 4))
+	s.Less(time.Since(start), s.counter.latency)
+}
+
+func (s *globalRateLimiterSuite) TestWaitN_ContextDone() {
+	rateLimiter := s.newRateLimiter(NewRateLimiter(0.001, 100))
+	for rateLimiter.AllowN(time.Now(), 1) {
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	s.ErrorIs(rateLimiter.WaitN(ctx, 11), context.DeadlineExceeded)
+}
+
+// newRateLimiter returns a rate limiter with a cluster wide budget of 10 per second,
+// leasing 2 tokens at a time
+func (s *globalRateLimiterSuite) newRateLimiter(local RateLimiter) *GlobalRateLimiterImpl {
+	return NewGlobalRateLimiter(s.counter, func() float64 { return 10 }, time.Second, 2, local)
+}
+
+func (c *fakeSharedCounter) Add(_ context.Context, window int64, delta int64) (int64, error) {
+	time.Sleep(c.latency)
+	c.Lock()
+	defer c.Unlock()
+
+	c.attempts++
+	if c.unavailable {
+		return 0, errors.New("shared counter unavailable")
+	}
+	c.calls++
+	c.counts[window] += delta
+	return c.counts[window], nil
+}
+
+func (c *fakeSharedCounter) setUnavailable(unavailable bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.unavailable = unavailable
+}
@@ -0,0 +1,220 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	// NamespaceRateLimiterFn generates a rate limiter for the given namespace
+	NamespaceRateLimiterFn func(namespace string) RateLimiter
+
+	// HierarchicalRateLimiterImpl is a rate limiter where each namespace has a reserved
+	// minimum, and can borrow unused tokens from a shared pool once its reserve is used up.
+	//
+	// Requests served from the reserve of a namespace are accounted against the pool as well,
+	// so the capacity lent to other namespaces shrinks as soon as the owning namespace needs
+	// its reserve. Reserves themselves are never lent. Borrowing is capped per namespace, so a
+	// single busy namespace cannot starve the others of the pool.
+	HierarchicalRateLimiterImpl struct {
+		pool        RateLimiter
+		reserveFn   NamespaceRateLimiterFn
+		borrowCapFn NamespaceRateLimiterFn
+
+		sync.RWMutex
+		namespaces map[string]*namespaceBuckets
+	}
+
+	namespaceBuckets struct {
+		reserve   RateLimiter
+		borrowCap RateLimiter
+	}
+)
+
+var _ RequestRateLimiter = (*HierarchicalRateLimiterImpl)(nil)
+
+// NewHierarchicalRateLimiter returns a rate limiter keyed by the namespace (caller) of the request.
+// reserveFn generates the reserved minimum of a namespace, borrowCapFn generates the cap on the
+// tokens a namespace can borrow from the shared pool.
+func NewHierarchicalRateLimiter(
+	pool RateLimiter,
+	reserveFn NamespaceRateLimiterFn,
+	borrowCapFn NamespaceRateLimiterFn,
+) *HierarchicalRateLimiterImpl {
+	return &HierarchicalRateLimiterImpl{
+		pool:        pool,
+		reserveFn:   reserveFn,
+		borrowCapFn: borrowCapFn,
+		namespaces:  make(map[string]*namespaceBuckets),
+	}
+}
+
+// Allow attempts to allow a request to go through. The method returns
+// immediately with a true or false indicating if the request can make
+// progress
+func (r *HierarchicalRateLimiterImpl) Allow(
+	now time.Time,
+	request Request,
+) bool {
+	reservation := r.Reserve(now, request)
+	if !reservation.OK() {
+		return false
+	}
+	if reservation.DelayFrom(now) > 0 {
+		reservation.CancelAt(now)
+		return false
+	}
+	return true
+}
+
+// Reserve returns a Reservation that indicates how long the caller
+// must wait before event happen. The reserve of the namespace is used first, then
+// tokens are borrowed from the pool. If neither has a token available right away,
+// the caller has to wait for the reserve of its namespace.
+func (r *HierarchicalRateLimiterImpl) Reserve(
+	now time.Time,
+	request Request,
+) Reservation {
+	buckets := r.getOrInitBuckets(request)
+
+	reservation := buckets.reserve.ReserveN(now, request.Token)
+	if reservation.OK() && reservation.DelayFrom(now) == 0 {
+		// best effort, the reserve is guaranteed regardless of the pool
+		_ = r.pool.AllowN(now, request.Token)
+		return reservation
+	}
+
+	if borrowed, ok := r.borrow(now, buckets, request.Token); ok {
+		if reservation.OK() {
+			reservation.CancelAt(now)
+		}
+		return borrowed
+	}
+	return reservation
+}
+
+// Wait waits till the deadline for a rate limit token to allow the request
+// to go through.
+func (r *HierarchicalRateLimiterImpl) Wait(
+	ctx context.Context,
+	request Request,
+) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	now := time.Now().UTC()
+	reservation := r.Reserve(now, request)
+	if !reservation.OK() {
+		return fmt.Errorf("rate: Wait(n=%d) would exceed context deadline", request.Token)
+	}
+
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return nil
+	}
+	waitLimit := InfDuration
+	if deadline, ok := ctx.Deadline(); ok {
+		waitLimit = deadline.Sub(now)
+	}
+	if waitLimit < delay {
+		reservation.CancelAt(now)
+		return fmt.Errorf("rate: Wait(n=%d) would exceed context deadline", request.Token)
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+
+	case <-ctx.Done():
+		reservation.CancelAt(time.Now())
+		return ctx.Err()
+	}
+}
+
+// borrow takes tokens from the pool, if both the pool and the borrow cap of the
+// namespace have them available right away
+func (r *HierarchicalRateLimiterImpl) borrow(
+	now time.Time,
+	buckets *namespaceBuckets,
+	numToken int,
+) (Reservation, bool) {
+	capReservation := buckets.borrowCap.ReserveN(now, numToken)
+	if !capReservation.OK() {
+		return nil, false
+	}
+	if capReservation.DelayFrom(now) > 0 {
+		capReservation.CancelAt(now)
+		return nil, false
+	}
+
+	poolReservation := r.pool.ReserveN(now, numToken)
+	if !poolReservation.OK() {
+		capReservation.CancelAt(now)
+		return nil, false
+	}
+	if poolReservation.DelayFrom(now) > 0 {
+		poolReservation.CancelAt(now)
+		capReservation.CancelAt(now)
+		return nil, false
+	}
+	return NewMultiReservation(true, []Reservation{capReservation, poolReservation}), true
+}
+
+func (r *HierarchicalRateLimiterImpl) getOrInitBuckets(
+	req Request,
+) *namespaceBuckets {
+	namespace := namespaceRequestRateLimiterKeyFn(req)
+
+	r.RLock()
+	buckets, ok := r.namespaces[namespace]
+	r.RUnlock()
+	if ok {
+		return buckets
+	}
+
+	newBuckets := &namespaceBuckets{
+		reserve:   r.reserveFn(namespace),
+		borrowCap: r.borrowCapFn(namespace),
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	buckets, ok = r.namespaces[namespace]
+	if ok {
+		return buckets
+	}
+
+	r.namespaces[namespace] = newBuckets
+	return newBuckets
+}
@@ -0,0 +1,144 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	// hierarchicalTestRate is low enough for buckets not to refill during a test
+	hierarchicalTestRate           = 0.001
+	hierarchicalTestPoolBurst      = 10
+	hierarchicalTestReserveBurst   = 2
+	hierarchicalTestBorrowCapBurst = 5
+)
+
+type (
+	hierarchicalRateLimiterSuite struct {
+		suite.Suite
+		*require.Assertions
+
+		rateLimiter *HierarchicalRateLimiterImpl
+	}
+)
+
+func TestHierarchicalRateLimiterSuite(t *testing.T) {
+	s := new(hierarchicalRateLimiterSuite)
+	suite.Run(t, s)
+}
+
+func (s *hierarchicalRateLimiterSuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+
+	s.rateLimiter = NewHierarchicalRateLimiter(
+		NewRateLimiter(hierarchicalTestRate, hierarchicalTestPoolBurst),
+		func(namespace string) RateLimiter {
+			return NewRateLimiter(hierarchicalTestRate, hierarchicalTestReserveBurst)
+		},
+		func(namespace string) RateLimiter {
+			return NewRateLimiter(hierarchicalTestRate, hierarchicalTestBorrowCapBurst)
+		},
+	)
+}
+
+func (s *hierarchicalRateLimiterSuite) TestBorrowFromIdleNamespaces() {
+	// with every other namespace idle, the busy namespace gets its reserve and borrows up to its cap
+	s.Equal(hierarchicalTestReserveBurst+hierarchicalTestBorrowCapBurst, s.drain("busy"))
+}
+
+func (s *hierarchicalRateLimiterSuite) TestBorrowIsFair() {
+	// the first borrower cannot take the whole pool
+	s.Equal(hierarchicalTestReserveBurst+hierarchicalTestBorrowCapBurst, s.drain("busy-1"))
+	// pool: 10 - 2 (reserve of busy-1) - 5 (borrowed by busy-1) - 2 (reserve of busy-2) = 1 left to borrow
+	s.Equal(hierarchicalTestReserveBurst+1, s.drain("busy-2"))
+}
+
+func (s *hierarchicalRateLimiterSuite) TestReserveIsReclaimed() {
+	// borrowers exhaust the pool
+	s.drain("busy-1")
+	s.drain("busy-2")
+	s.Equal(hierarchicalTestReserveBurst, s.drain("busy-3"))
+
+	// the owning namespace still gets its full reserve
+	s.Equal(hierarchicalTestReserveBurst, s.drain("owner"))
+}
+
+func (s *hierarchicalRateLimiterSuite) TestOwnerUsageShrinksBorrowing() {
+	// the owner uses its reserve first, which is accounted against the pool
+	s.Equal(hierarchicalTestReserveBurst, s.drainReserve("owner-1"))
+	s.Equal(hierarchicalTestReserveBurst, s.drainReserve("owner-2"))
+	s.Equal(hierarchicalTestReserveBurst, s.drainReserve("owner-3"))
+
+	// pool: 10 - 3*2 (reserves of owners) - 2 (reserve of busy) = 2 left to borrow, below the cap of 5
+	s.Equal(hierarchicalTestReserveBurst+2, s.drain("busy"))
+}
+
+func (s *hierarchicalRateLimiterSuite) TestReserve_WaitsForReserve() {
+	s.drain("busy")
+
+	now := time.Now()
+	reservation := s.rateLimiter.Reserve(now, s.request("busy"))
+	s.True(reservation.OK())
+	s.True(reservation.DelayFrom(now) > 0)
+	reservation.CancelAt(now)
+}
+
+func (s *hierarchicalRateLimiterSuite) TestWait_DeadlineExceeded() {
+	s.drain("busy")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.Error(s.rateLimiter.Wait(ctx, s.request("busy")))
+}
+
+// drain returns the number of requests of the namespace allowed until it is rate limited
+func (s *hierarchicalRateLimiterSuite) drain(namespace string) int {
+	allowed := 0
+	for s.rateLimiter.Allow(time.Now(), s.request(namespace)) {
+		allowed++
+	}
+	return allowed
+}
+
+// drainReserve returns the number of requests of the namespace allowed from its reserve
+func (s *hierarchicalRateLimiterSuite) drainReserve(namespace string) int {
+	allowed := 0
+	for i := 0; i < hierarchicalTestReserveBurst; i++ {
+		if s.rateLimiter.Allow(time.Now(), s.request(namespace)) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func (s *hierarchicalRateLimiterSuite) request(namespace string) Request {
+	return NewRequest("api", 1, namespace, "", 0, "")
+}
@@ -0,0 +1,221 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	// LeakyBucketRateLimiterImpl is a RequestRateLimiter shaping requests into a steady rate
+	// rather than letting bursts through: requests are released one after the other, each one
+	// its number of tokens worth of time after the previous one. Requests which cannot be
+	// released right away queue up to maxQueued tokens worth of time, beyond which they are
+	// rejected right away. Allow only admits requests which can be released right away, so
+	// queueing requires Reserve or Wait.
+	LeakyBucketRateLimiterImpl struct {
+		rateFn    RateFn
+		maxQueued int
+
+		sync.Mutex
+		// next is the time the next request can be released at
+		next time.Time
+	}
+
+	// leakyBucketReservationImpl is the release time of a request reserved
+	// from a LeakyBucketRateLimiterImpl
+	leakyBucketReservationImpl struct {
+		limiter *LeakyBucketRateLimiterImpl
+		ok      bool
+		// releaseAt is the time the request is released at, and releaseEnd the time the next one can be
+		releaseAt  time.Time
+		releaseEnd time.Time
+	}
+)
+
+var _ RequestRateLimiter = (*LeakyBucketRateLimiterImpl)(nil)
+var _ Reservation = (*leakyBucketReservationImpl)(nil)
+
+// NewLeakyBucketRateLimiter creates a LeakyBucketRateLimiterImpl releasing rateFn tokens per
+// second, and queueing requests up to maxQueued tokens
+func NewLeakyBucketRateLimiter(
+	rateFn RateFn,
+	maxQueued int,
+) *LeakyBucketRateLimiterImpl {
+	return &LeakyBucketRateLimiterImpl{
+		rateFn:    rateFn,
+		maxQueued: maxQueued,
+	}
+}
+
+// Allow attempts to allow a request to go through. The method returns
+// immediately with a true or false indicating if the request can be
+// released right away, it never queues the request
+func (r *LeakyBucketRateLimiterImpl) Allow(
+	now time.Time,
+	request Request,
+) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	releaseAt, releaseEnd, ok := r.releaseLocked(now, request.Token, 0)
+	if !ok || releaseAt.After(now) {
+		return false
+	}
+	r.next = releaseEnd
+	return true
+}
+
+// Reserve returns a Reservation that indicates how long the caller
+// must wait before the request is released. The reservation is not OK
+// if the request would queue beyond maxQueued tokens.
+func (r *LeakyBucketRateLimiterImpl) Reserve(
+	now time.Time,
+	request Request,
+) Reservation {
+	r.Lock()
+	defer r.Unlock()
+
+	releaseAt, releaseEnd, ok := r.releaseLocked(now, request.Token, r.maxQueued)
+	if !ok {
+		return &leakyBucketReservationImpl{limiter: r}
+	}
+	r.next = releaseEnd
+	return &leakyBucketReservationImpl{
+		limiter:    r,
+		ok:         true,
+		releaseAt:  releaseAt,
+		releaseEnd: releaseEnd,
+	}
+}
+
+// Wait waits till the deadline for the request to be released. It returns
+// an error right away if the request would queue beyond maxQueued tokens,
+// or would not be released before the deadline of the context.
+func (r *LeakyBucketRateLimiterImpl) Wait(
+	ctx context.Context,
+	request Request,
+) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	now := time.Now().UTC()
+	reservation := r.Reserve(now, request)
+	if !reservation.OK() {
+		return fmt.Errorf("rate: Wait(n=%d) would exceed the queue of the leaky bucket", request.Token)
+	}
+
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(now) < delay {
+		reservation.CancelAt(now)
+		return fmt.Errorf("rate: Wait(n=%d) would exceed context deadline", request.Token)
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		reservation.CancelAt(time.Now())
+		return ctx.Err()
+	}
+}
+
+// releaseLocked returns when a request of the given tokens would be released, and when
+// the next request could be released after it, if it queues up to maxQueued tokens
+func (r *LeakyBucketRateLimiterImpl) releaseLocked(
+	now time.Time,
+	token int,
+	maxQueued int,
+) (releaseAt time.Time, releaseEnd time.Time, ok bool) {
+	rate := r.rateFn()
+	if rate <= 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	tokenInterval := time.Duration(float64(time.Second) / rate)
+
+	releaseAt = r.next
+	if releaseAt.Before(now) {
+		releaseAt = now
+	}
+	if releaseAt.Sub(now) > time.Duration(maxQueued)*tokenInterval {
+		return time.Time{}, time.Time{}, false
+	}
+	return releaseAt, releaseAt.Add(time.Duration(token) * tokenInterval), true
+}
+
+// OK returns whether the request could be queued within maxQueued tokens
+func (r *leakyBucketReservationImpl) OK() bool {
+	return r.ok
+}
+
+// Cancel indicates that the reservation holder will not perform the reserved action
+// and reverses the effects of this Reservation on the rate limit as much as possible
+func (r *leakyBucketReservationImpl) Cancel() {
+	r.CancelAt(time.Now())
+}
+
+// CancelAt gives the release time of the request back, which is only
+// possible if it was not released yet and no request queued after it
+func (r *leakyBucketReservationImpl) CancelAt(now time.Time) {
+	if !r.ok || r.releaseAt.Before(now) {
+		return
+	}
+
+	r.limiter.Lock()
+	defer r.limiter.Unlock()
+	if r.limiter.next.Equal(r.releaseEnd) {
+		r.limiter.next = r.releaseAt
+	}
+}
+
+// Delay returns the duration for which the reservation holder must wait
+// before taking the reserved action.  Zero duration means act immediately.
+func (r *leakyBucketReservationImpl) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// DelayFrom returns the duration for which the reservation holder must wait
+// before taking the reserved action.  Zero duration means act immediately.
+// It is InfDuration if the reservation is not OK.
+func (r *leakyBucketReservationImpl) DelayFrom(now time.Time) time.Duration {
+	if !r.ok {
+		return InfDuration
+	}
+	if delay := r.releaseAt.Sub(now); delay > 0 {
+		return delay
+	}
+	return 0
+}
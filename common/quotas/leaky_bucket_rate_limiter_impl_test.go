@@ -0,0 +1,116 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeakyBucketRateLimiter_SmoothsBursts(t *testing.T) {
+	now := time.Now()
+	rateLimiter := NewLeakyBucketRateLimiter(func() float64 { return 10 }, 5)
+	request := NewRequest("", 1, "", "", 0, "")
+
+	// a burst of requests is released one every 100ms, up to the bound of the queue
+	var delays []time.Duration
+	for i := 0; i < 10; i++ {
+		if reservation := rateLimiter.Reserve(now, request); reservation.OK() {
+			delays = append(delays, reservation.DelayFrom(now))
+		}
+	}
+	require.Equal(t, []time.Duration{
+		0,
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		300 * time.Millisecond,
+		400 * time.Millisecond,
+		500 * time.Millisecond,
+	}, delays)
+
+	// the queue drains at the steady rate
+	require.False(t, rateLimiter.Reserve(now.Add(50*time.Millisecond), request).OK())
+	require.True(t, rateLimiter.Reserve(now.Add(100*time.Millisecond), request).OK())
+}
+
+func TestLeakyBucketRateLimiter_Allow(t *testing.T) {
+	now := time.Now()
+	rateLimiter := NewLeakyBucketRateLimiter(func() float64 { return 10 }, 5)
+	request := NewRequest("", 1, "", "", 0, "")
+
+	// requests are only allowed if they can be released right away, so bursts are rejected
+	require.True(t, rateLimiter.Allow(now, request))
+	require.False(t, rateLimiter.Allow(now, request))
+	require.False(t, rateLimiter.Allow(now.Add(99*time.Millisecond), request))
+	require.True(t, rateLimiter.Allow(now.Add(100*time.Millisecond), request))
+
+	// requests of several tokens hold back the next request accordingly
+	require.True(t, rateLimiter.Allow(now.Add(200*time.Millisecond), NewRequest("", 3, "", "", 0, "")))
+	require.False(t, rateLimiter.Allow(now.Add(400*time.Millisecond), request))
+	require.True(t, rateLimiter.Allow(now.Add(500*time.Millisecond), request))
+}
+
+func TestLeakyBucketRateLimiter_Cancel(t *testing.T) {
+	now := time.Now()
+	rateLimiter := NewLeakyBucketRateLimiter(func() float64 { return 10 }, 1)
+	request := NewRequest("", 1, "", "", 0, "")
+
+	require.True(t, rateLimiter.Reserve(now, request).OK())
+	reservation := rateLimiter.Reserve(now, request)
+	require.True(t, reservation.OK())
+	require.False(t, rateLimiter.Reserve(now, request).OK())
+
+	// the canceled request frees its place in the queue
+	reservation.CancelAt(now)
+	require.True(t, rateLimiter.Reserve(now, request).OK())
+}
+
+func TestLeakyBucketRateLimiter_Wait(t *testing.T) {
+	rateLimiter := NewLeakyBucketRateLimiter(func() float64 { return 100 }, 10)
+	request := NewRequest("", 1, "", "", 0, "")
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, rateLimiter.Wait(context.Background(), request))
+		}()
+	}
+	wg.Wait()
+	// the burst is released over 4 intervals of 10ms
+	require.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	// requests which would only be released after their deadline fail right away
+	require.NoError(t, rateLimiter.Wait(context.Background(), request))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	require.Error(t, rateLimiter.Wait(ctx, request))
+}
@@ -66,6 +66,18 @@ func NewNamespaceRequestRateLimiter(
 	return NewMapRequestRateLimiter[string](rateLimiterGenFn, namespaceRequestRateLimiterKeyFn)
 }
 
+func taskQueueRequestRateLimiterKeyFn(req Request) string {
+	return req.TaskQueue
+}
+
+// NewTaskQueueRequestRateLimiter creates a rate limiter keeping an independent budget per
+// task queue, as identified by the TaskQueue of requests. Requests of no task queue share one.
+func NewTaskQueueRequestRateLimiter(
+	rateLimiterGenFn RequestRateLimiterFn,
+) *MapRequestRateLimiterImpl[string] {
+	return NewMapRequestRateLimiter[string](rateLimiterGenFn, taskQueueRequestRateLimiterKeyFn)
+}
+
 // Allow attempts to allow a request to go through. The method returns
 // immediately with a true or false indicating if the request can make
 // progress
@@ -97,6 +109,14 @@ func (r *MapRequestRateLimiterImpl[_]) Wait(
 	return rateLimiter.Wait(ctx, request)
 }
 
+// Warm creates the rate limiter of the request ahead of its first request, e.g. when
+// a namespace is activated, so that its budget is available from the start
+func (r *MapRequestRateLimiterImpl[_]) Warm(
+	request Request,
+) {
+	r.getOrInitRateLimiter(request)
+}
+
 func (r *MapRequestRateLimiterImpl[_]) getOrInitRateLimiter(
 	req Request,
 ) RequestRateLimiter {
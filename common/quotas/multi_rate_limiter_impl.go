@@ -159,6 +159,11 @@ func (rl *MultiRateLimiterImpl) WaitN(ctx context.Context, numToken int) error {
 	}
 }
 
+// RateLimiters returns the rate limiters all requests have to be allowed by
+func (rl *MultiRateLimiterImpl) RateLimiters() []RateLimiter {
+	return rl.rateLimiters
+}
+
 // Rate returns the rate per second for this rate limiter
 func (rl *MultiRateLimiterImpl) Rate() float64 {
 	result := rl.rateLimiters[0].Rate()
@@ -0,0 +1,181 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+type (
+	// PhaseShiftedRequestRateLimiterImpl is a RequestRateLimiter decorator refilling the tokens of
+	// the decorated rate limiter once per period, at a phase offset within the period derived from
+	// a seed, e.g. the identity of the host. Rate limiters of hosts started at the same time would
+	// otherwise refill in lockstep, producing synchronized bursts on a shared backend.
+	PhaseShiftedRequestRateLimiterImpl struct {
+		rateLimiter RequestRateLimiter
+		period      time.Duration
+		offset      time.Duration
+	}
+
+	phaseShiftedReservationImpl struct {
+		reservation Reservation
+		limiter     *PhaseShiftedRequestRateLimiterImpl
+		reservedAt  time.Time
+	}
+)
+
+var _ RequestRateLimiter = (*PhaseShiftedRequestRateLimiterImpl)(nil)
+var _ Reservation = (*phaseShiftedReservationImpl)(nil)
+
+// NewPhaseShiftedRequestRateLimiter creates a PhaseShiftedRequestRateLimiterImpl. The decorated
+// rate limiter must not be used other than through the returned one, as it only ever sees the
+// refill times of the period.
+func NewPhaseShiftedRequestRateLimiter(
+	rateLimiter RequestRateLimiter,
+	period time.Duration,
+	seed string,
+) *PhaseShiftedRequestRateLimiterImpl {
+	return &PhaseShiftedRequestRateLimiterImpl{
+		rateLimiter: rateLimiter,
+		period:      period,
+		offset:      PhaseOffset(seed, period),
+	}
+}
+
+// PhaseOffset returns the offset within the period derived from the seed, which is
+// the same for the same seed and spread uniformly over the period across seeds
+func PhaseOffset(seed string, period time.Duration) time.Duration {
+	if period <= 0 {
+		return 0
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(seed))
+	return time.Duration(hash.Sum64() % uint64(period))
+}
+
+// Offset returns the phase offset of the refills within the period
+func (r *PhaseShiftedRequestRateLimiterImpl) Offset() time.Duration {
+	return r.offset
+}
+
+func (r *PhaseShiftedRequestRateLimiterImpl) Allow(
+	now time.Time,
+	request Request,
+) bool {
+	return r.rateLimiter.Allow(r.refillTime(now), request)
+}
+
+func (r *PhaseShiftedRequestRateLimiterImpl) Reserve(
+	now time.Time,
+	request Request,
+) Reservation {
+	refillTime := r.refillTime(now)
+	return &phaseShiftedReservationImpl{
+		reservation: r.rateLimiter.Reserve(refillTime, request),
+		limiter:     r,
+		reservedAt:  refillTime,
+	}
+}
+
+func (r *PhaseShiftedRequestRateLimiterImpl) Wait(
+	ctx context.Context,
+	request Request,
+) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	// waiting is implemented on top of Reserve, as the Wait of the decorated
+	// rate limiter would use the current time rather than the refill time
+	now := time.Now().UTC()
+	reservation := r.Reserve(now, request)
+	if !reservation.OK() {
+		return fmt.Errorf("rate: Wait(n=%d) would exceed context deadline", request.Token)
+	}
+
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(now) < delay {
+		reservation.CancelAt(now)
+		return fmt.Errorf("rate: Wait(n=%d) would exceed context deadline", request.Token)
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		reservation.CancelAt(time.Now())
+		return ctx.Err()
+	}
+}
+
+// refillTime returns the time of the last refill at or before now
+func (r *PhaseShiftedRequestRateLimiterImpl) refillTime(now time.Time) time.Time {
+	if r.period <= 0 {
+		return now
+	}
+	return now.Add(-r.offset).Truncate(r.period).Add(r.offset)
+}
+
+func (r *phaseShiftedReservationImpl) OK() bool {
+	return r.reservation.OK()
+}
+
+func (r *phaseShiftedReservationImpl) Cancel() {
+	r.CancelAt(time.Now())
+}
+
+func (r *phaseShiftedReservationImpl) CancelAt(now time.Time) {
+	r.reservation.CancelAt(r.limiter.refillTime(now))
+}
+
+func (r *phaseShiftedReservationImpl) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// DelayFrom returns the delay until the first refill at which the
+// decorated rate limiter has the reserved tokens available
+func (r *phaseShiftedReservationImpl) DelayFrom(now time.Time) time.Duration {
+	delay := r.reservation.DelayFrom(r.reservedAt)
+	if delay == 0 {
+		return 0
+	}
+	if period := r.limiter.period; period > 0 {
+		delay = (delay + period - 1) / period * period
+	}
+	if delay = r.reservedAt.Add(delay).Sub(now); delay < 0 {
+		return 0
+	}
+	return delay
+}
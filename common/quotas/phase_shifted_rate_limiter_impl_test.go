@@ -0,0 +1,90 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPhaseOffset_DeterministicPerSeed(t *testing.T) {
+	period := time.Second
+
+	offset := PhaseOffset("host-1", period)
+	require.Equal(t, offset, PhaseOffset("host-1", period))
+	require.GreaterOrEqual(t, offset, time.Duration(0))
+	require.Less(t, offset, period)
+	require.NotEqual(t, offset, PhaseOffset("host-2", period))
+
+	require.Equal(t, time.Duration(0), PhaseOffset("host-1", 0))
+}
+
+func TestPhaseShiftedRequestRateLimiter_RefillsAtOffset(t *testing.T) {
+	period := time.Second
+	rateLimiter := NewPhaseShiftedRequestRateLimiter(
+		NewRequestRateLimiterAdapter(NewRateLimiter(10, 10)),
+		period,
+		"host-1",
+	)
+	offset := rateLimiter.Offset()
+	require.Equal(t, PhaseOffset("host-1", period), offset)
+
+	refill := time.Unix(100, 0).Add(offset)
+	request := NewRequest("", 1, "", "", 0, "")
+	for i := 0; i < 10; i++ {
+		require.True(t, rateLimiter.Allow(refill, request))
+	}
+	require.False(t, rateLimiter.Allow(refill, request))
+
+	// no tokens are refilled until the next phase shifted refill
+	require.False(t, rateLimiter.Allow(refill.Add(period-time.Nanosecond), request))
+	reservation := rateLimiter.Reserve(refill.Add(period/2), request)
+	require.True(t, reservation.OK())
+	require.Equal(t, period/2, reservation.DelayFrom(refill.Add(period/2)))
+	reservation.CancelAt(refill.Add(period / 2))
+
+	for i := 0; i < 10; i++ {
+		require.True(t, rateLimiter.Allow(refill.Add(period), request))
+	}
+	require.False(t, rateLimiter.Allow(refill.Add(period), request))
+}
+
+func TestPhaseShiftedRequestRateLimiter_Wait(t *testing.T) {
+	rateLimiter := NewPhaseShiftedRequestRateLimiter(
+		NewRequestRateLimiterAdapter(NewRateLimiter(1, 1)),
+		24*time.Hour,
+		"host-1",
+	)
+	request := NewRequest("", 1, "", "", 0, "")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(t, rateLimiter.Wait(ctx, request))
+	// the next refill is up to a day away
+	require.Error(t, rateLimiter.Wait(ctx, request))
+}
@@ -0,0 +1,207 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotastest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.temporal.io/server/common/quotas"
+)
+
+type (
+	// CountingRateLimiter is a quotas.RequestRateLimiter recording every request it is asked to
+	// rate limit, which allows a configurable number of requests and denies all further ones
+	CountingRateLimiter struct {
+		lock sync.Mutex
+		// allows is the number of requests still allowed, negative if unlimited
+		allows   int
+		calls    []Call
+		allowed  int
+		denied   int
+		canceled int
+	}
+
+	// Call is a request made to a CountingRateLimiter
+	Call struct {
+		// Method is the method of the rate limiter called, i.e. Allow, Reserve or Wait
+		Method  string
+		Request quotas.Request
+		Allowed bool
+	}
+
+	countingReservation struct {
+		rateLimiter *CountingRateLimiter
+		ok          bool
+		once        sync.Once
+	}
+)
+
+// Unlimited makes a CountingRateLimiter allow all requests
+const Unlimited = -1
+
+// ErrDenied is returned by Wait of a CountingRateLimiter denying the request
+var ErrDenied = errors.New("request denied by CountingRateLimiter")
+
+var _ quotas.RequestRateLimiter = (*CountingRateLimiter)(nil)
+var _ quotas.Reservation = (*countingReservation)(nil)
+
+// NewCountingRateLimiter creates a CountingRateLimiter allowing the given number
+// of requests before denying all further ones, or all requests if Unlimited
+func NewCountingRateLimiter(allows int) *CountingRateLimiter {
+	return &CountingRateLimiter{allows: allows}
+}
+
+func (r *CountingRateLimiter) Allow(_ time.Time, request quotas.Request) bool {
+	return r.record("Allow", request)
+}
+
+// Reserve reserves the request without any delay if it is allowed, canceling the
+// reservation gives the allowed request back
+func (r *CountingRateLimiter) Reserve(_ time.Time, request quotas.Request) quotas.Reservation {
+	return &countingReservation{
+		rateLimiter: r,
+		ok:          r.record("Reserve", request),
+	}
+}
+
+// Wait returns right away, with ErrDenied if the request is denied
+func (r *CountingRateLimiter) Wait(_ context.Context, request quotas.Request) error {
+	if !r.record("Wait", request) {
+		return ErrDenied
+	}
+	return nil
+}
+
+// SetAllows changes the number of requests allowed from now on, or Unlimited
+func (r *CountingRateLimiter) SetAllows(allows int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.allows = allows
+}
+
+// Calls returns the requests made to the rate limiter, in order
+func (r *CountingRateLimiter) Calls() []Call {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// CallsOf returns the number of requests made to the rate limiter for the given API
+func (r *CountingRateLimiter) CallsOf(api string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	count := 0
+	for _, call := range r.calls {
+		if call.Request.API == api {
+			count++
+		}
+	}
+	return count
+}
+
+// Allowed returns the number of requests allowed, not counting canceled reservations
+func (r *CountingRateLimiter) Allowed() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.allowed
+}
+
+// Denied returns the number of requests denied
+func (r *CountingRateLimiter) Denied() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.denied
+}
+
+// Canceled returns the number of allowed reservations which were canceled
+func (r *CountingRateLimiter) Canceled() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.canceled
+}
+
+// Reset forgets the recorded requests, leaving the number of requests still allowed as it is
+func (r *CountingRateLimiter) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls = nil
+	r.allowed = 0
+	r.denied = 0
+	r.canceled = 0
+}
+
+func (r *CountingRateLimiter) record(method string, request quotas.Request) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	allowed := r.allows != 0
+	if allowed {
+		r.allowed++
+		if r.allows > 0 {
+			r.allows--
+		}
+	} else {
+		r.denied++
+	}
+	r.calls = append(r.calls, Call{Method: method, Request: request, Allowed: allowed})
+	return allowed
+}
+
+// refund gives back a request allowed by a canceled reservation
+func (r *CountingRateLimiter) refund() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.allowed--
+	r.canceled++
+	if r.allows >= 0 {
+		r.allows++
+	}
+}
+
+func (r *countingReservation) OK() bool {
+	return r.ok
+}
+
+func (r *countingReservation) Cancel() {
+	r.CancelAt(time.Now())
+}
+
+// CancelAt gives the request back to the rate limiter if it was allowed, only the first time
+func (r *countingReservation) CancelAt(_ time.Time) {
+	if !r.ok {
+		return
+	}
+	r.once.Do(r.rateLimiter.refund)
+}
+
+func (r *countingReservation) Delay() time.Duration {
+	return 0
+}
+
+func (r *countingReservation) DelayFrom(_ time.Time) time.Duration {
+	return 0
+}
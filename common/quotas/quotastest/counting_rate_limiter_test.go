@@ -0,0 +1,100 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotastest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.temporal.io/server/common/quotas"
+)
+
+func TestCountingRateLimiter_DeniesAfterAllows(t *testing.T) {
+	now := time.Now()
+	rateLimiter := NewCountingRateLimiter(2)
+
+	require.True(t, rateLimiter.Allow(now, quotas.NewRequest("A", 1, "", "", 0, "")))
+	require.True(t, rateLimiter.Reserve(now, quotas.NewRequest("B", 1, "", "", 0, "")).OK())
+	require.ErrorIs(t, rateLimiter.Wait(context.Background(), quotas.NewRequest("A", 1, "", "", 0, "")), ErrDenied)
+	require.False(t, rateLimiter.Allow(now, quotas.NewRequest("A", 1, "", "", 0, "")))
+
+	require.Equal(t, 2, rateLimiter.Allowed())
+	require.Equal(t, 2, rateLimiter.Denied())
+	require.Equal(t, 3, rateLimiter.CallsOf("A"))
+	require.Equal(t, []string{"Allow", "Reserve", "Wait", "Allow"}, callMethods(rateLimiter.Calls()))
+
+	rateLimiter.SetAllows(Unlimited)
+	for i := 0; i < 10; i++ {
+		require.True(t, rateLimiter.Allow(now, quotas.NewRequest("A", 1, "", "", 0, "")))
+	}
+}
+
+func TestCountingRateLimiter_CancelRefunds(t *testing.T) {
+	now := time.Now()
+	rateLimiter := NewCountingRateLimiter(1)
+	request := quotas.NewRequest("A", 1, "", "", 0, "")
+
+	reservation := rateLimiter.Reserve(now, request)
+	require.True(t, reservation.OK())
+	require.False(t, rateLimiter.Allow(now, request))
+
+	// canceling gives the request back, only once
+	reservation.CancelAt(now)
+	reservation.CancelAt(now)
+	require.Equal(t, 0, rateLimiter.Allowed())
+	require.Equal(t, 1, rateLimiter.Canceled())
+	require.True(t, rateLimiter.Allow(now, request))
+	require.False(t, rateLimiter.Allow(now, request))
+
+	// canceling a denied reservation gives nothing back
+	rateLimiter.Reserve(now, request).CancelAt(now)
+	require.False(t, rateLimiter.Allow(now, request))
+}
+
+func TestCountingRateLimiter_Reset(t *testing.T) {
+	now := time.Now()
+	rateLimiter := NewCountingRateLimiter(1)
+	request := quotas.NewRequest("A", 1, "", "", 0, "")
+	rateLimiter.Allow(now, request)
+	rateLimiter.Allow(now, request)
+
+	rateLimiter.Reset()
+	require.Empty(t, rateLimiter.Calls())
+	require.Zero(t, rateLimiter.Allowed())
+	require.Zero(t, rateLimiter.Denied())
+	// the allowance is left as it is
+	require.False(t, rateLimiter.Allow(now, request))
+}
+
+func callMethods(calls []Call) []string {
+	methods := make([]string, len(calls))
+	for i, call := range calls {
+		methods[i] = call.Method
+	}
+	return methods
+}
@@ -0,0 +1,109 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotastest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// requestNestingDepth is how deep nested struct pointers of requests are allocated,
+// so that wrappers reading e.g. request.ShardInfo.ShardId do not dereference nil
+const requestNestingDepth = 3
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// CallAllMethods calls every method of the interface iface points to, e.g.
+// (*persistence.ShardManager)(nil), on target, failing t for every method which panics.
+// Context arguments are set to ctx, pointer arguments to newly allocated values with their
+// nested struct pointers allocated as well, and all other arguments to their zero value.
+// It returns the names of the methods called, in the order of the interface.
+func CallAllMethods(t testing.TB, ctx context.Context, iface interface{}, target interface{}) []string {
+	t.Helper()
+	ifaceType := reflect.TypeOf(iface)
+	if ifaceType == nil || ifaceType.Kind() != reflect.Ptr || ifaceType.Elem().Kind() != reflect.Interface {
+		t.Fatalf("CallAllMethods: %T is not a pointer to an interface", iface)
+		return nil
+	}
+	ifaceType = ifaceType.Elem()
+	targetValue := reflect.ValueOf(target)
+	if target == nil || !targetValue.Type().Implements(ifaceType) {
+		t.Fatalf("CallAllMethods: %T does not implement %v", target, ifaceType)
+		return nil
+	}
+
+	methods := make([]string, 0, ifaceType.NumMethod())
+	for i := 0; i < ifaceType.NumMethod(); i++ {
+		method := ifaceType.Method(i)
+		args := make([]reflect.Value, method.Type.NumIn())
+		for j := range args {
+			args[j] = newArg(ctx, method.Type.In(j))
+		}
+		if err := call(targetValue.MethodByName(method.Name), args); err != nil {
+			t.Errorf("%v.%v panicked: %v", ifaceType.Name(), method.Name, err)
+		}
+		methods = append(methods, method.Name)
+	}
+	return methods
+}
+
+func call(method reflect.Value, args []reflect.Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	method.Call(args)
+	return nil
+}
+
+func newArg(ctx context.Context, argType reflect.Type) reflect.Value {
+	switch {
+	case argType == contextType:
+		return reflect.ValueOf(ctx)
+	case argType.Kind() == reflect.Ptr:
+		return newNestedValue(argType.Elem(), requestNestingDepth)
+	default:
+		return reflect.Zero(argType)
+	}
+}
+
+// newNestedValue returns a pointer to a new value of the given type, with nested
+// struct pointers allocated up to the given depth
+func newNestedValue(valueType reflect.Type, depth int) reflect.Value {
+	value := reflect.New(valueType)
+	if depth == 0 || valueType.Kind() != reflect.Struct {
+		return value
+	}
+	for i := 0; i < valueType.NumField(); i++ {
+		field := value.Elem().Field(i)
+		if field.CanSet() && field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct {
+			field.Set(newNestedValue(field.Type().Elem(), depth-1))
+		}
+	}
+	return value
+}
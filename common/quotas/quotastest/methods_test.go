@@ -0,0 +1,117 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotastest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	testManager interface {
+		Get(ctx context.Context, request *testRequest) (*testResponse, error)
+		Put(ctx context.Context, request *testRequest, force bool) error
+		Close()
+	}
+
+	testRequest struct {
+		Info *testInfo
+	}
+
+	testInfo struct {
+		Inner *testInfo
+		ID    int32
+	}
+
+	testResponse struct{}
+
+	// recordingManager records the methods called and the arguments they were called with
+	recordingManager struct {
+		calls   []string
+		ctxs    []context.Context
+		panicOn string
+	}
+
+	// recordingT records the errors reported by CallAllMethods
+	recordingT struct {
+		testing.TB
+		errors []string
+	}
+)
+
+func (m *recordingManager) Get(ctx context.Context, request *testRequest) (*testResponse, error) {
+	m.record("Get", ctx)
+	// nested request fields are allocated
+	_ = request.Info.Inner.ID
+	return nil, nil
+}
+
+func (m *recordingManager) Put(ctx context.Context, request *testRequest, force bool) error {
+	m.record("Put", ctx)
+	return nil
+}
+
+func (m *recordingManager) Close() {
+	m.record("Close", nil)
+}
+
+func (m *recordingManager) record(method string, ctx context.Context) {
+	m.calls = append(m.calls, method)
+	m.ctxs = append(m.ctxs, ctx)
+	if method == m.panicOn {
+		panic("boom")
+	}
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+type testContextKey struct{}
+
+func TestCallAllMethods(t *testing.T) {
+	ctx := context.WithValue(context.Background(), testContextKey{}, "value")
+	manager := &recordingManager{}
+
+	methods := CallAllMethods(t, ctx, (*testManager)(nil), manager)
+	require.Equal(t, []string{"Close", "Get", "Put"}, methods)
+	require.Equal(t, methods, manager.calls)
+	require.Equal(t, []context.Context{nil, ctx, ctx}, manager.ctxs)
+}
+
+func TestCallAllMethods_Panic(t *testing.T) {
+	recorder := &recordingT{TB: t}
+	manager := &recordingManager{panicOn: "Get"}
+
+	// the remaining methods are still called
+	methods := CallAllMethods(recorder, context.Background(), (*testManager)(nil), manager)
+	require.Equal(t, []string{"Close", "Get", "Put"}, methods)
+	require.Equal(t, []string{"testManager.Get panicked: boom"}, recorder.errors)
+}
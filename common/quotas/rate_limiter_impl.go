@@ -71,6 +71,16 @@ func (rl *RateLimiterImpl) SetRateBurst(rate float64, burst int) {
 	rl.refreshInternalRateLimiterImpl(&rate, &burst)
 }
 
+// SetRateBurstAt sets the rate & burst of the rate limiter as of the given time, which
+// should be the time the rate limiter is used with. The rate limiter keeps its state
+// through the transition: the tokens accumulated by then at the previous rate carry
+// over, capped to the new burst, and tokens reserved in advance remain owed, so the
+// transition neither admits a spike of requests nor stalls them. Tokens are refilled
+// at the new rate from then on.
+func (rl *RateLimiterImpl) SetRateBurstAt(now time.Time, rate float64, burst int) {
+	rl.refreshInternalRateLimiterImplAt(now, &rate, &burst)
+}
+
 // Allow immediately returns with true or false indicating if a rate limit
 // token is available or not
 func (rl *RateLimiterImpl) Allow() bool {
@@ -103,6 +113,11 @@ func (rl *RateLimiterImpl) WaitN(ctx context.Context, numToken int) error {
 	return rl.goRateLimiter.WaitN(ctx, numToken)
 }
 
+// TokensAt returns the number of tokens available at the given time
+func (rl *RateLimiterImpl) TokensAt(now time.Time) float64 {
+	return rl.goRateLimiter.TokensAt(now)
+}
+
 // Rate returns the rate per second for this rate limiter
 func (rl *RateLimiterImpl) Rate() float64 {
 	rl.Lock()
@@ -120,6 +135,14 @@ func (rl *RateLimiterImpl) Burst() int {
 func (rl *RateLimiterImpl) refreshInternalRateLimiterImpl(
 	newRate *float64,
 	newBurst *int,
+) {
+	rl.refreshInternalRateLimiterImplAt(time.Now(), newRate, newBurst)
+}
+
+func (rl *RateLimiterImpl) refreshInternalRateLimiterImplAt(
+	now time.Time,
+	newRate *float64,
+	newBurst *int,
 ) {
 	rl.Lock()
 	defer rl.Unlock()
@@ -135,7 +158,6 @@ func (rl *RateLimiterImpl) refreshInternalRateLimiterImpl(
 	}
 
 	if refresh {
-		now := time.Now()
 		rl.goRateLimiter.SetLimitAt(now, rate.Limit(rl.rate))
 		rl.goRateLimiter.SetBurstAt(now, rl.burst)
 	}
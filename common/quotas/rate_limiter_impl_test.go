@@ -26,6 +26,7 @@ package quotas
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -131,3 +132,21 @@ func (s *rateLimiterSuite) TestSetRateBurst_Diff() {
 	s.Equal(newRate, rateLimiter.Rate())
 	s.Equal(newBurst, rateLimiter.Burst())
 }
+
+func (s *rateLimiterSuite) TestSetRateBurstAt_CarriesTokensOver() {
+	now := time.Now()
+	rateLimiter := NewRateLimiter(100, 100)
+	s.True(rateLimiter.AllowN(now, 100))
+
+	// half a second of tokens at the previous rate carries over, capped to the new burst
+	now = now.Add(500 * time.Millisecond)
+	rateLimiter.SetRateBurstAt(now, 10, 10)
+	s.Equal(10, rateLimiter.Burst())
+	s.InDelta(10, rateLimiter.TokensAt(now), 0.001)
+
+	// an empty rate limiter stays empty, rather than refilling to the new burst
+	s.True(rateLimiter.AllowN(now, 10))
+	rateLimiter.SetRateBurstAt(now, 1000, 1000)
+	s.InDelta(0, rateLimiter.TokensAt(now), 0.001)
+	s.InDelta(10, rateLimiter.TokensAt(now.Add(10*time.Millisecond)), 0.001)
+}
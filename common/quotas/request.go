@@ -32,6 +32,17 @@ type (
 		CallerType    string
 		CallerSegment int32
 		Initiation    string
+		// Boosted requests ask for the highest priority for this one call,
+		// which priority functions can honor on top of the usual priorities
+		Boosted bool
+		// InheritsPriority requests are follow-ups of an operation whose first request had the
+		// InheritedPriority, which priority functions can honor so that the follow-ups are not
+		// admitted with a lower priority than the operation they are part of
+		InheritsPriority  bool
+		InheritedPriority int
+		// TaskQueue identifies the task queue of requests scoped to one, for rate
+		// limiters keeping an independent budget per task queue, and is empty otherwise
+		TaskQueue string
 	}
 )
 
@@ -65,3 +65,8 @@ func (r *RequestRateLimiterAdapterImpl) Wait(
 ) error {
 	return r.rateLimiter.WaitN(ctx, request.Token)
 }
+
+// RateLimiter returns the adapted rate limiter
+func (r *RequestRateLimiterAdapterImpl) RateLimiter() RateLimiter {
+	return r.rateLimiter
+}
@@ -0,0 +1,141 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// RateProfile is the rate and burst a ScheduledRequestRateLimiterImpl limits requests to
+	RateProfile struct {
+		Rate  float64
+		Burst int
+	}
+
+	// ScheduleWindow is a daily window of the wall-clock time of day during which a
+	// ScheduledRequestRateLimiterImpl follows the rate profile of the window. Start and End
+	// are offsets from midnight, Start included and End excluded. A window ending before it
+	// starts wraps around midnight, e.g. from 22h to 6h.
+	ScheduleWindow struct {
+		Start   time.Duration
+		End     time.Duration
+		Profile RateProfile
+	}
+
+	// ScheduledRequestRateLimiterImpl is a RequestRateLimiter switching between rate profiles by the
+	// time of day, e.g. to limit requests more tightly during peak hours than overnight when batch
+	// jobs run. The profile of the first window containing the time requests are admitted at applies,
+	// or the default profile outside of all windows. Switching profiles carries the tokens accumulated
+	// so far over, see RateLimiterImpl.SetRateBurstAt.
+	ScheduledRequestRateLimiterImpl struct {
+		windows        []ScheduleWindow
+		defaultProfile RateProfile
+		location       *time.Location
+
+		sync.Mutex
+		rateLimiter *RateLimiterImpl
+		profile     RateProfile
+	}
+)
+
+var _ RequestRateLimiter = (*ScheduledRequestRateLimiterImpl)(nil)
+
+// NewScheduledRequestRateLimiter creates a ScheduledRequestRateLimiterImpl following the profiles
+// of the windows, whose times of day are in the given location, or in UTC if it is nil
+func NewScheduledRequestRateLimiter(
+	defaultProfile RateProfile,
+	location *time.Location,
+	windows ...ScheduleWindow,
+) *ScheduledRequestRateLimiterImpl {
+	if location == nil {
+		location = time.UTC
+	}
+	return &ScheduledRequestRateLimiterImpl{
+		windows:        windows,
+		defaultProfile: defaultProfile,
+		location:       location,
+		rateLimiter:    NewRateLimiter(defaultProfile.Rate, defaultProfile.Burst),
+		profile:        defaultProfile,
+	}
+}
+
+func (r *ScheduledRequestRateLimiterImpl) Allow(
+	now time.Time,
+	request Request,
+) bool {
+	return r.RateLimiterAt(now).AllowN(now, request.Token)
+}
+
+func (r *ScheduledRequestRateLimiterImpl) Reserve(
+	now time.Time,
+	request Request,
+) Reservation {
+	return r.RateLimiterAt(now).ReserveN(now, request.Token)
+}
+
+func (r *ScheduledRequestRateLimiterImpl) Wait(
+	ctx context.Context,
+	request Request,
+) error {
+	return r.RateLimiterAt(time.Now()).WaitN(ctx, request.Token)
+}
+
+// ProfileAt returns the rate profile in effect at the given time
+func (r *ScheduledRequestRateLimiterImpl) ProfileAt(now time.Time) RateProfile {
+	// the wall-clock time of day, which is not the time since midnight on DST transition days
+	hour, minute, second := now.In(r.location).Clock()
+	timeOfDay := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(second)*time.Second + time.Duration(now.Nanosecond())
+	for _, window := range r.windows {
+		if window.contains(timeOfDay) {
+			return window.Profile
+		}
+	}
+	return r.defaultProfile
+}
+
+// RateLimiterAt switches the rate limiter to the profile in effect at the given time, if
+// it is not already, and returns it
+func (r *ScheduledRequestRateLimiterImpl) RateLimiterAt(now time.Time) *RateLimiterImpl {
+	profile := r.ProfileAt(now)
+
+	r.Lock()
+	defer r.Unlock()
+	if profile != r.profile {
+		r.rateLimiter.SetRateBurstAt(now, profile.Rate, profile.Burst)
+		r.profile = profile
+	}
+	return r.rateLimiter
+}
+
+func (w ScheduleWindow) contains(timeOfDay time.Duration) bool {
+	if w.Start <= w.End {
+		return timeOfDay >= w.Start && timeOfDay < w.End
+	}
+	return timeOfDay >= w.Start || timeOfDay < w.End
+}
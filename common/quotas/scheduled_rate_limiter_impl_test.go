@@ -0,0 +1,106 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduledRequestRateLimiter_ProfileAt(t *testing.T) {
+	peak := RateProfile{Rate: 10, Burst: 10}
+	overnight := RateProfile{Rate: 1000, Burst: 1000}
+	defaultProfile := RateProfile{Rate: 100, Burst: 100}
+	rateLimiter := NewScheduledRequestRateLimiter(
+		defaultProfile,
+		time.UTC,
+		ScheduleWindow{Start: 9 * time.Hour, End: 17 * time.Hour, Profile: peak},
+		// wraps around midnight
+		ScheduleWindow{Start: 22 * time.Hour, End: 6 * time.Hour, Profile: overnight},
+	)
+
+	day := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	for timeOfDay, expected := range map[time.Duration]RateProfile{
+		0:                              overnight,
+		6*time.Hour - time.Nanosecond:  overnight,
+		6 * time.Hour:                  defaultProfile,
+		9*time.Hour - time.Nanosecond:  defaultProfile,
+		9 * time.Hour:                  peak,
+		17*time.Hour - time.Nanosecond: peak,
+		17 * time.Hour:                 defaultProfile,
+		22 * time.Hour:                 overnight,
+		24*time.Hour - time.Nanosecond: overnight,
+	} {
+		require.Equal(t, expected, rateLimiter.ProfileAt(day.Add(timeOfDay)), timeOfDay)
+	}
+}
+
+func TestScheduledRequestRateLimiter_Location(t *testing.T) {
+	location := time.FixedZone("UTC+2", 2*60*60)
+	peak := RateProfile{Rate: 10, Burst: 10}
+	rateLimiter := NewScheduledRequestRateLimiter(
+		RateProfile{Rate: 100, Burst: 100},
+		location,
+		ScheduleWindow{Start: 9 * time.Hour, End: 17 * time.Hour, Profile: peak},
+	)
+
+	// 8h UTC is 10h in the location of the schedule
+	require.Equal(t, peak, rateLimiter.ProfileAt(time.Date(2023, 6, 1, 8, 0, 0, 0, time.UTC)))
+	require.NotEqual(t, peak, rateLimiter.ProfileAt(time.Date(2023, 6, 1, 16, 0, 0, 0, time.UTC)))
+}
+
+func TestScheduledRequestRateLimiter_SwitchesRate(t *testing.T) {
+	rateLimiter := NewScheduledRequestRateLimiter(
+		RateProfile{Rate: 100, Burst: 5},
+		time.UTC,
+		ScheduleWindow{Start: 9 * time.Hour, End: 17 * time.Hour, Profile: RateProfile{Rate: 1, Burst: 2}},
+	)
+	request := NewRequest("", 1, "", "", 0, "")
+
+	// the default profile applies before the window
+	now := time.Date(2023, 6, 1, 8, 59, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		require.True(t, rateLimiter.Allow(now, request))
+	}
+	require.False(t, rateLimiter.Allow(now, request))
+	require.Equal(t, float64(100), rateLimiter.RateLimiterAt(now).Rate())
+
+	// the tokens accumulated by the start of the window carry over, capped to its burst
+	now = time.Date(2023, 6, 1, 9, 0, 0, 0, time.UTC)
+	require.Equal(t, float64(1), rateLimiter.RateLimiterAt(now).Rate())
+	require.Equal(t, 2, rateLimiter.RateLimiterAt(now).Burst())
+	require.True(t, rateLimiter.Allow(now, request))
+	require.True(t, rateLimiter.Allow(now, request))
+	require.False(t, rateLimiter.Allow(now, request))
+	require.True(t, rateLimiter.Allow(now.Add(time.Second), request))
+	require.False(t, rateLimiter.Allow(now.Add(time.Second), request))
+
+	// and the default profile applies again after the window
+	now = time.Date(2023, 6, 1, 17, 0, 0, 0, time.UTC)
+	require.True(t, rateLimiter.Reserve(now, NewRequest("", 5, "", "", 0, "")).OK())
+	require.Equal(t, float64(100), rateLimiter.RateLimiterAt(now).Rate())
+}
@@ -0,0 +1,167 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	fnv64aOffset = 14695981039346656037
+	fnv64aPrime  = 1099511628211
+)
+
+type (
+	// RequestRateLimiterHashFn hashes the map key of a request
+	RequestRateLimiterHashFn[K comparable] func(key K) uint64
+
+	// ShardedMapRequestRateLimiterImpl is a MapRequestRateLimiterImpl spreading its rate limiters
+	// over a fixed number of shards, each guarded by its own lock, so that requests of different
+	// keys rarely contend on the same lock. Keys are assigned to shards by consistent hashing,
+	// and keep their own rate limiter within their shard.
+	ShardedMapRequestRateLimiterImpl[K comparable] struct {
+		rateLimiterKeyFn  RequestRateLimiterKeyFn[K]
+		rateLimiterHashFn RequestRateLimiterHashFn[K]
+
+		shards []*MapRequestRateLimiterImpl[K]
+	}
+)
+
+var _ RequestRateLimiter = (*ShardedMapRequestRateLimiterImpl[string])(nil)
+
+// NewShardedMapRequestRateLimiter creates a ShardedMapRequestRateLimiterImpl of the given
+// number of shards, which is at least one
+func NewShardedMapRequestRateLimiter[K comparable](
+	rateLimiterGenFn RequestRateLimiterFn,
+	rateLimiterKeyFn RequestRateLimiterKeyFn[K],
+	rateLimiterHashFn RequestRateLimiterHashFn[K],
+	shardCount int,
+) *ShardedMapRequestRateLimiterImpl[K] {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	shards := make([]*MapRequestRateLimiterImpl[K], shardCount)
+	for i := range shards {
+		shards[i] = NewMapRequestRateLimiter[K](rateLimiterGenFn, rateLimiterKeyFn)
+	}
+	return &ShardedMapRequestRateLimiterImpl[K]{
+		rateLimiterKeyFn:  rateLimiterKeyFn,
+		rateLimiterHashFn: rateLimiterHashFn,
+		shards:            shards,
+	}
+}
+
+// NewShardedNamespaceRequestRateLimiter creates a rate limiter keeping an independent budget per
+// namespace, like NewNamespaceRequestRateLimiter, with the namespaces spread over the given number
+// of shards to reduce lock contention when there are many of them
+func NewShardedNamespaceRequestRateLimiter(
+	rateLimiterGenFn RequestRateLimiterFn,
+	shardCount int,
+) *ShardedMapRequestRateLimiterImpl[string] {
+	return NewShardedMapRequestRateLimiter[string](
+		rateLimiterGenFn,
+		namespaceRequestRateLimiterKeyFn,
+		StringHash,
+		shardCount,
+	)
+}
+
+// StringHash returns the 64-bit FNV-1a hash of the string, without allocating
+func StringHash(s string) uint64 {
+	hash := uint64(fnv64aOffset)
+	for i := 0; i < len(s); i++ {
+		hash ^= uint64(s[i])
+		hash *= fnv64aPrime
+	}
+	return hash
+}
+
+// JumpHash returns the bucket of the key among the given number of buckets, using the jump
+// consistent hash of Lamping and Veach: growing the number of buckets from n to n+1 only moves
+// 1/(n+1) of the keys, all of them to the new bucket
+func JumpHash(key uint64, buckets int) int {
+	b, j := int64(-1), int64(0)
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// ShardCount returns the number of shards the rate limiters are spread over
+func (r *ShardedMapRequestRateLimiterImpl[_]) ShardCount() int {
+	return len(r.shards)
+}
+
+// Shard returns the shard of the request among the shards of the rate limiter
+func (r *ShardedMapRequestRateLimiterImpl[_]) Shard(
+	request Request,
+) int {
+	return JumpHash(r.rateLimiterHashFn(r.rateLimiterKeyFn(request)), len(r.shards))
+}
+
+// Allow attempts to allow a request to go through. The method returns
+// immediately with a true or false indicating if the request can make
+// progress
+func (r *ShardedMapRequestRateLimiterImpl[_]) Allow(
+	now time.Time,
+	request Request,
+) bool {
+	return r.shard(request).Allow(now, request)
+}
+
+// Reserve returns a Reservation that indicates how long the caller
+// must wait before event happen.
+func (r *ShardedMapRequestRateLimiterImpl[_]) Reserve(
+	now time.Time,
+	request Request,
+) Reservation {
+	return r.shard(request).Reserve(now, request)
+}
+
+// Wait waits till the deadline for a rate limit token to allow the request
+// to go through.
+func (r *ShardedMapRequestRateLimiterImpl[_]) Wait(
+	ctx context.Context,
+	request Request,
+) error {
+	return r.shard(request).Wait(ctx, request)
+}
+
+// Warm creates the rate limiter of the request ahead of its first request, e.g. when
+// a namespace is activated, so that its budget is available from the start
+func (r *ShardedMapRequestRateLimiterImpl[_]) Warm(
+	request Request,
+) {
+	r.shard(request).Warm(request)
+}
+
+func (r *ShardedMapRequestRateLimiterImpl[K]) shard(
+	request Request,
+) *MapRequestRateLimiterImpl[K] {
+	return r.shards[r.Shard(request)]
+}
@@ -0,0 +1,89 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"fmt"
+	"hash/fnv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringHash_FNV1a(t *testing.T) {
+	for _, s := range []string{"", "a", "namespace-id"} {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(s))
+		require.Equal(t, hash.Sum64(), StringHash(s))
+	}
+}
+
+func TestJumpHash_Consistent(t *testing.T) {
+	const keys = 10000
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := StringHash(fmt.Sprintf("namespace-%d", i))
+		before, after := JumpHash(key, 10), JumpHash(key, 11)
+		require.GreaterOrEqual(t, before, 0)
+		require.Less(t, before, 10)
+		if before != after {
+			require.Equal(t, 10, after)
+			moved++
+		}
+	}
+	// about 1/11 of the keys move to the new bucket
+	require.InDelta(t, keys/11, moved, keys/50)
+
+	require.Equal(t, 0, JumpHash(StringHash("namespace"), 1))
+}
+
+func TestShardedNamespaceRequestRateLimiter_PerNamespaceBudget(t *testing.T) {
+	rateLimiter := NewShardedNamespaceRequestRateLimiter(func(Request) RequestRateLimiter {
+		return NewRequestRateLimiterAdapter(NewRateLimiter(1, 2))
+	}, 4)
+	require.Equal(t, 4, rateLimiter.ShardCount())
+
+	now := time.Now()
+	for i := 0; i < 16; i++ {
+		request := NewRequest("", 1, fmt.Sprintf("namespace-%d", i), "", 0, "")
+		require.Equal(t, rateLimiter.Shard(request), rateLimiter.Shard(request))
+		require.True(t, rateLimiter.Allow(now, request))
+		require.True(t, rateLimiter.Allow(now, request))
+		require.False(t, rateLimiter.Allow(now, request))
+	}
+}
+
+func TestShardedNamespaceRequestRateLimiter_SingleShard(t *testing.T) {
+	rateLimiter := NewShardedNamespaceRequestRateLimiter(func(Request) RequestRateLimiter {
+		return NewRequestRateLimiterAdapter(NewRateLimiter(1, 1))
+	}, 0)
+	require.Equal(t, 1, rateLimiter.ShardCount())
+
+	request := NewRequest("", 1, "namespace", "", 0, "")
+	require.Equal(t, 0, rateLimiter.Shard(request))
+	rateLimiter.Warm(request)
+	require.True(t, rateLimiter.Allow(time.Now(), request))
+}
@@ -0,0 +1,143 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"go.temporal.io/server/common/clock"
+)
+
+type (
+	// WarmUpRateLimiterImpl is a rate limiter ramping up to its configured rate and burst
+	// after construction, so that a freshly started process, whose caches are still cold,
+	// does not hit its dependencies with the full rate right away
+	WarmUpRateLimiterImpl struct {
+		rateBurst     RateBurst
+		startFraction float64
+		warmUp        time.Duration
+		timeSource    clock.TimeSource
+		start         time.Time
+
+		rateLimiter *RateLimiterImpl
+	}
+)
+
+var _ RateLimiter = (*WarmUpRateLimiterImpl)(nil)
+
+// NewWarmUpRateLimiter returns a rate limiter whose effective rate and burst ramp linearly
+// from startFraction of the configured ones, at construction, to the configured ones after
+// the warm up duration. The configured rate and burst are read from rateBurst on every call,
+// so the rate limiter keeps following dynamic config once warmed up.
+//
+// The result can be used as a RequestRateLimiter through NewRequestRateLimiterAdapter.
+func NewWarmUpRateLimiter(
+	rateBurst RateBurst,
+	startFraction float64,
+	warmUp time.Duration,
+	timeSource clock.TimeSource,
+) *WarmUpRateLimiterImpl {
+	startFraction = math.Max(0, math.Min(1, startFraction))
+	rateLimiter := &WarmUpRateLimiterImpl{
+		rateBurst:     rateBurst,
+		startFraction: startFraction,
+		warmUp:        warmUp,
+		timeSource:    timeSource,
+		start:         timeSource.Now(),
+	}
+	rate, burst := rateLimiter.rateBurstAt(rateLimiter.start)
+	rateLimiter.rateLimiter = NewRateLimiter(rate, burst)
+	return rateLimiter
+}
+
+// Allow immediately returns with true or false indicating if a rate limit
+// token is available or not
+func (w *WarmUpRateLimiterImpl) Allow() bool {
+	return w.AllowN(w.timeSource.Now(), 1)
+}
+
+// AllowN immediately returns with true or false indicating if n rate limit
+// token is available or not
+func (w *WarmUpRateLimiterImpl) AllowN(now time.Time, numToken int) bool {
+	w.refresh(now)
+	return w.rateLimiter.AllowN(now, numToken)
+}
+
+// Reserve reserves a rate limit token
+func (w *WarmUpRateLimiterImpl) Reserve() Reservation {
+	return w.ReserveN(w.timeSource.Now(), 1)
+}
+
+// ReserveN reserves n rate limit token
+func (w *WarmUpRateLimiterImpl) ReserveN(now time.Time, numToken int) Reservation {
+	w.refresh(now)
+	return w.rateLimiter.ReserveN(now, numToken)
+}
+
+// Wait waits up till deadline for a rate limit token
+func (w *WarmUpRateLimiterImpl) Wait(ctx context.Context) error {
+	return w.WaitN(ctx, 1)
+}
+
+// WaitN waits up till deadline for n rate limit token
+func (w *WarmUpRateLimiterImpl) WaitN(ctx context.Context, numToken int) error {
+	w.refresh(w.timeSource.Now())
+	return w.rateLimiter.WaitN(ctx, numToken)
+}
+
+// Rate returns the effective rate per second for this rate limiter
+func (w *WarmUpRateLimiterImpl) Rate() float64 {
+	w.refresh(w.timeSource.Now())
+	return w.rateLimiter.Rate()
+}
+
+// Burst returns the effective burst for this rate limiter
+func (w *WarmUpRateLimiterImpl) Burst() int {
+	w.refresh(w.timeSource.Now())
+	return w.rateLimiter.Burst()
+}
+
+func (w *WarmUpRateLimiterImpl) refresh(now time.Time) {
+	rate, burst := w.rateBurstAt(now)
+	w.rateLimiter.refreshInternalRateLimiterImplAt(now, &rate, &burst)
+}
+
+// rateBurstAt returns the effective rate and burst at the given time
+func (w *WarmUpRateLimiterImpl) rateBurstAt(now time.Time) (float64, int) {
+	fraction := 1.0
+	if elapsed := now.Sub(w.start); elapsed <= 0 {
+		fraction = w.startFraction
+	} else if elapsed < w.warmUp {
+		fraction = w.startFraction + (1-w.startFraction)*float64(elapsed)/float64(w.warmUp)
+	}
+	rate := w.rateBurst.Rate() * fraction
+	burst := w.rateBurst.Burst()
+	if fraction < 1 && burst > 0 {
+		burst = int(math.Max(1, float64(burst)*fraction))
+	}
+	return rate, burst
+}
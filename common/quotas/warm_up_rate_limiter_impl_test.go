@@ -0,0 +1,95 @@
+// The MIT License
+//
+// Copyright (c) 2020 Temporal Technologies Inc.  All rights reserved.
+//
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package quotas
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.temporal.io/server/common/clock"
+)
+
+func TestWarmUpRateLimiter_RampSchedule(t *testing.T) {
+	start := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(start)
+	rateLimiter := NewWarmUpRateLimiter(
+		NewRateBurst(func() float64 { return 100 }, func() int { return 200 }),
+		0.1,
+		10*time.Second,
+		timeSource,
+	)
+
+	for _, tc := range []struct {
+		elapsed time.Duration
+		rate    float64
+		burst   int
+	}{
+		{elapsed: 0, rate: 10, burst: 20},
+		{elapsed: 5 * time.Second, rate: 55, burst: 110},
+		{elapsed: 10 * time.Second, rate: 100, burst: 200},
+		{elapsed: time.Minute, rate: 100, burst: 200},
+	} {
+		timeSource.Update(start.Add(tc.elapsed))
+		require.InDelta(t, tc.rate, rateLimiter.Rate(), 0.001)
+		require.Equal(t, tc.burst, rateLimiter.Burst())
+	}
+}
+
+func TestWarmUpRateLimiter_LimitsRequestsWhileWarmingUp(t *testing.T) {
+	start := time.Now()
+	rateLimiter := NewWarmUpRateLimiter(
+		NewRateBurst(func() float64 { return 100 }, func() int { return 100 }),
+		0.1,
+		10*time.Second,
+		clock.NewEventTimeSource().Update(start),
+	)
+
+	// a cold rate limiter only lets a tenth of the configured burst through
+	require.Equal(t, 10, allowAll(rateLimiter, start))
+	// tokens accrue at the effective rate of the time they are accounted for, so the
+	// first requests after a while are limited by the rate limiter as it was
+	require.Equal(t, 10, allowAll(rateLimiter, start.Add(10*time.Second)))
+	// once warm, the full burst refills within a second at the configured rate
+	require.Equal(t, 100, allowAll(rateLimiter, start.Add(11*time.Second)))
+}
+
+func TestWarmUpRateLimiter_FollowsConfigOnceWarm(t *testing.T) {
+	start := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(start)
+	rate := 100.0
+	rateLimiter := NewWarmUpRateLimiter(
+		NewRateBurst(func() float64 { return rate }, func() int { return 100 }),
+		0,
+		time.Second,
+		timeSource,
+	)
+
+	timeSource.Update(start.Add(time.Second))
+	require.Equal(t, 100.0, rateLimiter.Rate())
+	rate = 50
+	require.Equal(t, 50.0, rateLimiter.Rate())
+}
@@ -404,6 +404,7 @@ func PersistenceRateLimitingParamsProvider(
 		serviceConfig.PersistenceNamespaceMaxQPS,
 		serviceConfig.PersistencePerShardNamespaceMaxQPS,
 		serviceConfig.EnablePersistencePriorityRateLimiting,
+		serviceConfig.EnablePersistencePriorityInheritance,
 		serviceConfig.PersistenceDynamicRateLimitingParams,
 	)
 }
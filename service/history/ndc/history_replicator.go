@@ -393,6 +393,9 @@ func (r *HistoryReplicatorImpl) doApplyEvents(
 	task replicationTask,
 ) (retError error) {
 
+	// the reads of the workflow and its history, and the conflict resolution they can lead to,
+	// share the persistence priority of the first of them, see persistence.WithPriorityInheritance
+	ctx = persistence.WithPriorityInheritanceScope(ctx)
 	context, releaseFn, err := r.workflowCache.GetOrCreateWorkflowExecution(
 		ctx,
 		task.getNamespaceID(),
@@ -26,6 +26,7 @@ package ndc
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -49,6 +50,8 @@ import (
 	"go.temporal.io/server/api/historyservice/v1"
 	persistencespb "go.temporal.io/server/api/persistence/v1"
 
+	"go.temporal.io/server/common/cluster"
+	"go.temporal.io/server/common/definition"
 	"go.temporal.io/server/common/log"
 	"go.temporal.io/server/common/namespace"
 	"go.temporal.io/server/common/persistence"
@@ -381,6 +384,37 @@ func (s *historyReplicatorSuite) Test_ApplyWorkflowState_Ancestors() {
 	s.NoError(err)
 }
 
+func (s *historyReplicatorSuite) Test_ApplyEventBlobs_PriorityInheritanceScope() {
+	namespaceID := uuid.New()
+	errStop := errors.New("stop after loading the workflow")
+	s.mockShard.Resource.ClusterMetadata.EXPECT().ClusterNameForFailoverVersion(true, int64(1)).Return(cluster.TestCurrentClusterName).AnyTimes()
+	var loadCtx context.Context
+	s.mockWorkflowCache.EXPECT().GetOrCreateWorkflowExecution(
+		gomock.Any(),
+		namespace.ID(namespaceID),
+		commonpb.WorkflowExecution{WorkflowId: s.workflowID, RunId: s.runID},
+		workflow.LockPriorityHigh,
+	).DoAndReturn(func(ctx context.Context, _ namespace.ID, _ commonpb.WorkflowExecution, _ workflow.LockPriority) (workflow.Context, wcache.ReleaseCacheFunc, error) {
+		loadCtx = ctx
+		return nil, nil, errStop
+	})
+
+	err := s.historyReplicator.ApplyEventBlobs(
+		context.Background(),
+		definition.NewWorkflowKey(namespaceID, s.workflowID, s.runID),
+		nil,
+		nil,
+		[][]*historypb.HistoryEvent{{{EventId: 1, Version: 1}}},
+		nil,
+	)
+	s.ErrorIs(err, errStop)
+
+	// the reads of the workflow and the conflict resolution they can lead to share the priority
+	// of the first of them, so the context is scoped before the workflow is loaded: scoping a
+	// scoped context returns it as is
+	s.Equal(loadCtx, persistence.WithPriorityInheritanceScope(loadCtx))
+}
+
 func (s *historyReplicatorSuite) Test_ApplyWorkflowState_NoClosedWorkflow_Error() {
 	err := s.historyReplicator.ApplyWorkflowState(context.Background(), &historyservice.ReplicateWorkflowStateRequest{
 		WorkflowState: &persistencespb.WorkflowMutableState{
@@ -123,6 +123,7 @@ func PersistenceRateLimitingParamsProvider(
 		serviceConfig.PersistenceNamespaceMaxQPS,
 		serviceConfig.PersistencePerShardNamespaceMaxQPS,
 		serviceConfig.EnablePersistencePriorityRateLimiting,
+		serviceConfig.EnablePersistencePriorityInheritance,
 		serviceConfig.PersistenceDynamicRateLimitingParams,
 	)
 }
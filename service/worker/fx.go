@@ -78,6 +78,7 @@ func PersistenceRateLimitingParamsProvider(
 		serviceConfig.PersistenceNamespaceMaxQPS,
 		serviceConfig.PersistencePerShardNamespaceMaxQPS,
 		serviceConfig.EnablePersistencePriorityRateLimiting,
+		serviceConfig.EnablePersistencePriorityInheritance,
 		serviceConfig.PersistenceDynamicRateLimitingParams,
 	)
 }